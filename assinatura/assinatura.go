@@ -0,0 +1,98 @@
+// Package assinatura assina as mensagens q passam pelo Redis entre cliente e servidor.
+// Qualquer processo com acesso ao Redis consegue dar RPUSH/LPUSH numa fila, então toda mensagem
+// vai dentro de um models.Envelope com um HMAC-SHA256 feito com a chave da sessão (q só o cliente
+// e os servidores conhecem). O servidor só despacha requisição com assinatura válida e o cliente
+// só aceita resposta assinada. O horário e o nonce no envelope impedem reaproveitar uma msg antiga.
+package assinatura
+
+import (
+	"PlanoZ/models"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// Janela é a idade máxima (pra frente ou pra trás) de uma mensagem. Os relógios do cliente
+	// e do servidor n precisam tar iguais, só perto.
+	Janela = 30 * time.Second
+
+	TamanhoChave = 32 // bytes (a chave vai em hex)
+	TamanhoNonce = 16
+)
+
+var (
+	ErrAssinaturaInvalida = errors.New("assinatura inválida")
+	ErrSemAssinatura      = errors.New("mensagem sem assinatura")
+	ErrForaDaJanela       = errors.New("mensagem fora da janela de tempo")
+)
+
+// NovaChave gera uma chave aleatória (hex), ex: a chave de resposta do login
+func NovaChave() (string, error) {
+	return aleatorio(TamanhoChave)
+}
+
+// Envelopar monta o envelope do corpo. Com chave vazia o envelope vai sem assinatura
+// (e quem recebe descarta).
+func Envelopar(chave, token string, corpo any, agora time.Time) (models.Envelope, error) {
+	dados, err := json.Marshal(corpo)
+	if err != nil {
+		return models.Envelope{}, err
+	}
+	nonce, err := aleatorio(TamanhoNonce)
+	if err != nil {
+		return models.Envelope{}, err
+	}
+	env := models.Envelope{
+		Token:  token,
+		Quando: agora.UnixMilli(),
+		Nonce:  nonce,
+		Corpo:  dados,
+	}
+	if chave != "" {
+		env.Assinatura = calcular(chave, env)
+	}
+	return env, nil
+}
+
+// Verificar confere a assinatura e se a msg ta dentro da janela de tempo.
+// O nonce fica por conta de quem recebe (o server guarda no Redis, o cliente na memória).
+func Verificar(chave string, env models.Envelope, agora time.Time) error {
+	if env.Assinatura == "" {
+		return ErrSemAssinatura
+	}
+	if !hmac.Equal([]byte(env.Assinatura), []byte(calcular(chave, env))) {
+		return ErrAssinaturaInvalida
+	}
+	idade := agora.Sub(time.UnixMilli(env.Quando))
+	if idade > Janela || idade < -Janela {
+		return ErrForaDaJanela
+	}
+	return nil
+}
+
+// calcular faz o HMAC de tudo q importa no envelope (token, horário, nonce e corpo)
+func calcular(chave string, env models.Envelope) string {
+	mac := hmac.New(sha256.New, []byte(chave))
+	mac.Write([]byte(env.Token))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(env.Quando, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(env.Nonce))
+	mac.Write([]byte{'\n'})
+	mac.Write(env.Corpo)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func aleatorio(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,167 @@
+package assinatura
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// --- Credencial da conta e selo pro cluster ---
+
+// A senha não sai do cliente: ele deriva dela o segredo da conta (SegredoDaConta) e é o segredo q vai,
+// selado pra chave pública do cluster (Selar), no Login e no Registrar. O servidor guarda só o bcrypt dele.
+// Junto vai uma chave de resposta aleatória: o servidor assina a resposta com ela e manda a chave da
+// sessão cifrada com ela (Cifrar), então quem lê o Redis não vê nem o segredo nem a chave da sessão.
+const (
+	SenhaMinima    = 6
+	IteracoesSenha = 100_000 // PBKDF2: deixa caro testar senha por força bruta
+	TamanhoSegredo = 32
+)
+
+var (
+	ErrSeloInvalido    = errors.New("selo inválido")
+	ErrCifradoInvalido = errors.New("cifrado inválido")
+)
+
+// SegredoDaConta deriva o segredo da conta a partir do usuário (o sal, em minúsculo) e da senha
+func SegredoDaConta(usuario, senha string) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, senha, []byte("PlanoZ|"+strings.ToLower(usuario)), IteracoesSenha, TamanhoSegredo)
+}
+
+// ChaveDaSessao é a chave q assina os envelopes de uma sessão (nos dois sentidos).
+// O servidor deriva ela de uma chave q so ele tem e do token q ele sorteia no login.
+func ChaveDaSessao(segredo []byte, token string) string {
+	return derivar(segredo, "sessao|"+token)
+}
+
+func derivar(segredo []byte, rotulo string) string {
+	mac := hmac.New(sha256.New, segredo)
+	mac.Write([]byte(rotulo))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Cifrar cifra os dados com uma chave em hex (ex: a chave de resposta do login) usando AES-256-GCM.
+// O contexto vai autenticado junto, então o cifrado n serve em outro lugar. Sai em hex: nonce | cifrado.
+func Cifrar(chave string, dados []byte, contexto string) (string, error) {
+	aead, err := aeadDaChave(chave)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(aead.Seal(nonce, nonce, dados, []byte(contexto))), nil
+}
+
+// Decifrar faz o caminho contrário de Cifrar
+func Decifrar(chave, cifrado, contexto string) ([]byte, error) {
+	aead, err := aeadDaChave(chave)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(cifrado)
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, ErrCifradoInvalido
+	}
+	dados, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(contexto))
+	if err != nil {
+		return nil, ErrCifradoInvalido
+	}
+	return dados, nil
+}
+
+// aeadDaChave deriva a chave AES da chave em hex (separada do uso dela no HMAC)
+func aeadDaChave(chave string) (cipher.AEAD, error) {
+	if chave == "" {
+		return nil, ErrCifradoInvalido
+	}
+	h := sha256.Sum256([]byte("cifra|" + chave))
+	bloco, err := aes.NewCipher(h[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(bloco)
+}
+
+// LerChavePublica interpreta a chave pública do cluster (X25519 em hex, ex: CLUSTER_PUBKEY)
+func LerChavePublica(texto string) (*ecdh.PublicKey, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(texto))
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// Selar cifra os dados pra quem tem a chave privada do cluster:
+// X25519 com uma chave efêmera + AES-256-GCM. Sai em hex: pública efêmera | nonce | cifrado.
+func Selar(publica *ecdh.PublicKey, dados []byte) (string, error) {
+	efemera, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	compartilhado, err := efemera.ECDH(publica)
+	if err != nil {
+		return "", err
+	}
+	aead, err := aeadDoSelo(compartilhado, efemera.PublicKey(), publica)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	selo := append(efemera.PublicKey().Bytes(), nonce...)
+	selo = aead.Seal(selo, nonce, dados, nil)
+	return hex.EncodeToString(selo), nil
+}
+
+// AbrirSelado faz o caminho contrário de Selar (so o servidor, q tem a chave privada)
+func AbrirSelado(privada *ecdh.PrivateKey, selado string) ([]byte, error) {
+	b, err := hex.DecodeString(selado)
+	if err != nil || len(b) < 32 {
+		return nil, ErrSeloInvalido
+	}
+	efemera, err := ecdh.X25519().NewPublicKey(b[:32])
+	if err != nil {
+		return nil, ErrSeloInvalido
+	}
+	compartilhado, err := privada.ECDH(efemera)
+	if err != nil {
+		return nil, ErrSeloInvalido
+	}
+	aead, err := aeadDoSelo(compartilhado, efemera, privada.PublicKey())
+	if err != nil {
+		return nil, ErrSeloInvalido
+	}
+	resto := b[32:]
+	if len(resto) < aead.NonceSize() {
+		return nil, ErrSeloInvalido
+	}
+	dados, err := aead.Open(nil, resto[:aead.NonceSize()], resto[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrSeloInvalido
+	}
+	return dados, nil
+}
+
+// aeadDoSelo deriva a chave AES do segredo compartilhado, amarrada às duas públicas
+func aeadDoSelo(compartilhado []byte, efemera, cluster *ecdh.PublicKey) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(compartilhado)
+	h.Write(efemera.Bytes())
+	h.Write(cluster.Bytes())
+	bloco, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(bloco)
+}
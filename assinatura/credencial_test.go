@@ -0,0 +1,123 @@
+package assinatura
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSegredoDaConta(t *testing.T) {
+	a, err := SegredoDaConta("Joao", "senha123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != TamanhoSegredo {
+		t.Fatalf("segredo devia ter %d bytes, tem %d", TamanhoSegredo, len(a))
+	}
+	// o usuário é o sal e vai em minúsculo: o login n depende de maiúscula
+	b, _ := SegredoDaConta("joao", "senha123")
+	if !bytes.Equal(a, b) {
+		t.Fatal("o msm usuário (com outra caixa) e a msm senha deviam dar o msm segredo")
+	}
+	if c, _ := SegredoDaConta("joao", "senha124"); bytes.Equal(a, c) {
+		t.Fatal("senha diferente deu o msm segredo")
+	}
+	if c, _ := SegredoDaConta("maria", "senha123"); bytes.Equal(a, c) {
+		t.Fatal("usuário diferente deu o msm segredo")
+	}
+}
+
+func TestChaveDaSessao(t *testing.T) {
+	servidor := []byte("chave das sessões do cluster")
+	s1 := ChaveDaSessao(servidor, "aaaa")
+	s2 := ChaveDaSessao(servidor, "bbbb")
+	if s1 == s2 {
+		t.Fatal("cada sessão devia ter uma chave diferente")
+	}
+	if ChaveDaSessao(servidor, "aaaa") != s1 {
+		t.Fatal("a chave da sessão tem q ser a msm em todo servidor")
+	}
+
+	// o q o cliente assina com a chave q recebeu o server confere com a dele
+	env, err := Envelopar(s1, "aaaa", "oi", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verificar(ChaveDaSessao(servidor, "aaaa"), env, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verificar(ChaveDaSessao([]byte("outro cluster"), "aaaa"), env, time.Now()); !errors.Is(err, ErrAssinaturaInvalida) {
+		t.Fatalf("outra chave devia dar assinatura inválida, deu %v", err)
+	}
+}
+
+func TestCifrar(t *testing.T) {
+	chave, err := NovaChave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cifrado, err := Cifrar(chave, []byte("chave da sessão"), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dados, err := Decifrar(chave, cifrado, "token")
+	if err != nil || string(dados) != "chave da sessão" {
+		t.Fatalf("devia decifrar, veio %q (%v)", dados, err)
+	}
+
+	outra, _ := NovaChave()
+	if _, err := Decifrar(outra, cifrado, "token"); !errors.Is(err, ErrCifradoInvalido) {
+		t.Fatalf("outra chave n devia decifrar, deu %v", err)
+	}
+	// o contexto prende o cifrado: a chave de um token n serve pra outro
+	if _, err := Decifrar(chave, cifrado, "outro token"); !errors.Is(err, ErrCifradoInvalido) {
+		t.Fatalf("outro contexto n devia decifrar, deu %v", err)
+	}
+	for _, lixo := range []string{"", "zz", "00"} {
+		if _, err := Decifrar(chave, lixo, "token"); !errors.Is(err, ErrCifradoInvalido) {
+			t.Fatalf("%q devia ser cifrado inválido, deu %v", lixo, err)
+		}
+	}
+}
+
+func TestSelo(t *testing.T) {
+	cluster, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publica, err := LerChavePublica(hex.EncodeToString(cluster.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selado, err := Selar(publica, []byte("segredo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dados, err := AbrirSelado(cluster, selado)
+	if err != nil || string(dados) != "segredo" {
+		t.Fatalf("devia abrir o selo, veio %q (%v)", dados, err)
+	}
+
+	// outra chave privada n abre
+	outra, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if _, err := AbrirSelado(outra, selado); !errors.Is(err, ErrSeloInvalido) {
+		t.Fatalf("outra chave n devia abrir, deu %v", err)
+	}
+
+	// qualquer byte mexido derruba o selo
+	b, _ := hex.DecodeString(selado)
+	b[len(b)-1] ^= 1
+	if _, err := AbrirSelado(cluster, hex.EncodeToString(b)); !errors.Is(err, ErrSeloInvalido) {
+		t.Fatalf("selo adulterado n devia abrir, deu %v", err)
+	}
+	for _, lixo := range []string{"", "zz", "00"} {
+		if _, err := AbrirSelado(cluster, lixo); !errors.Is(err, ErrSeloInvalido) {
+			t.Fatalf("%q devia ser selo inválido, deu %v", lixo, err)
+		}
+	}
+}
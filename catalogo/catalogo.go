@@ -0,0 +1,177 @@
+// Package catalogo tem o catálogo de cartas do jogo (modelo, classe, atributos, raridade e peso no sorteio)
+// e os tipos de pacote à venda (preço, estoque, pool, composição e pity, ver pacote.go).
+// O catálogo é um arquivo JSON, então balancear ou adicionar um tanque não precisa recompilar o servidor.
+// Se nenhum arquivo for passado, usa o padrao.json embutido no binário.
+package catalogo
+
+import (
+	"PlanoZ/models"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+)
+
+// Raridades das cartas
+const (
+	RaridadeComum    = "Comum"
+	RaridadeRaro     = "Raro"
+	RaridadeEpico    = "Epico"
+	RaridadeLendario = "Lendario"
+)
+
+//go:embed padrao.json
+var padraoJSON []byte
+
+// Carta é uma entrada do catálogo (o "molde" das cartas q vão pro inventário)
+type Carta struct {
+	Modelo   string `json:"modelo"`
+	Classe   string `json:"classe"`
+	Vida     int    `json:"vida"`
+	Ataque   int    `json:"ataque"`
+	Raridade string `json:"raridade"`
+	Peso     int    `json:"peso"` // peso no sorteio: quanto maior, mais fácil de sair
+}
+
+// Catalogo é o conjunto de cartas com uma versão.
+// A versão só sobe: o cluster inteiro usa a maior versão publicada no Redis.
+type Catalogo struct {
+	Versao  int64    `json:"versao"`
+	Pacotes []Pacote `json:"pacotes"`
+	Cartas  []Carta  `json:"cartas"`
+}
+
+// Padrao devolve o catálogo embutido no binário
+func Padrao() (*Catalogo, error) {
+	return Ler(padraoJSON)
+}
+
+// Carregar lê o catálogo de um arquivo. Caminho vazio = catálogo padrão.
+func Carregar(caminho string) (*Catalogo, error) {
+	if caminho == "" {
+		return Padrao()
+	}
+	dados, err := os.ReadFile(caminho)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler catálogo %s: %w", caminho, err)
+	}
+	return Ler(dados)
+}
+
+// Ler interpreta e valida um catálogo em JSON (campo desconhecido é erro, pra pegar typo no arquivo)
+func Ler(dados []byte) (*Catalogo, error) {
+	dec := json.NewDecoder(bytes.NewReader(dados))
+	dec.DisallowUnknownFields()
+
+	var c Catalogo
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("catálogo inválido: %w", err)
+	}
+	for i := range c.Pacotes {
+		c.Pacotes[i].aplicarPadroes()
+		c.Pacotes[i].montarPool(c.Cartas)
+	}
+	if err := c.Validar(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validar confere o catálogo inteiro e devolve todos os problemas de uma vez
+func (c *Catalogo) Validar() error {
+	var erros []error
+	if c.Versao <= 0 {
+		erros = append(erros, errors.New("versao precisa ser maior que 0"))
+	}
+	if len(c.Cartas) == 0 {
+		erros = append(erros, errors.New("catálogo sem cartas"))
+	}
+
+	vistos := make(map[string]bool)
+	for i, carta := range c.Cartas {
+		onde := fmt.Sprintf("carta %d (%s)", i+1, carta.Modelo)
+		if carta.Modelo == "" {
+			erros = append(erros, fmt.Errorf("%s: modelo vazio", onde))
+		} else if vistos[carta.Modelo] {
+			erros = append(erros, fmt.Errorf("%s: modelo repetido", onde))
+		}
+		vistos[carta.Modelo] = true
+
+		switch carta.Classe {
+		case models.ClasseLeve, models.ClasseMedio, models.ClassePesado:
+		default:
+			erros = append(erros, fmt.Errorf("%s: classe '%s' inválida", onde, carta.Classe))
+		}
+		switch carta.Raridade {
+		case RaridadeComum, RaridadeRaro, RaridadeEpico, RaridadeLendario:
+		default:
+			erros = append(erros, fmt.Errorf("%s: raridade '%s' inválida", onde, carta.Raridade))
+		}
+		if carta.Vida <= 0 || carta.Ataque <= 0 {
+			erros = append(erros, fmt.Errorf("%s: vida e ataque precisam ser maiores que 0", onde))
+		}
+		if carta.Peso <= 0 {
+			erros = append(erros, fmt.Errorf("%s: peso precisa ser maior que 0", onde))
+		}
+	}
+
+	if len(c.Pacotes) == 0 {
+		erros = append(erros, errors.New("catálogo sem pacotes"))
+	}
+	ids := make(map[string]bool)
+	for i := range c.Pacotes {
+		p := &c.Pacotes[i]
+		if p.Id == "" {
+			erros = append(erros, fmt.Errorf("pacote %d: id vazio", i+1))
+		} else if ids[p.Id] {
+			erros = append(erros, fmt.Errorf("pacote '%s': id repetido", p.Id))
+		}
+		ids[p.Id] = true
+		if err := p.validar(vistos); err != nil {
+			erros = append(erros, err)
+		}
+	}
+	return errors.Join(erros...)
+}
+
+// Sortear escolhe n cartas do catálogo inteiro (com repetição) proporcional ao peso de cada uma
+func (c *Catalogo) Sortear(r *rand.Rand, n int) []Carta {
+	return sortear(r, c.Cartas, n)
+}
+
+// sortear escolhe n cartas da lista (com repetição) pelo peso
+func sortear(r *rand.Rand, cartas []Carta, n int) []Carta {
+	total := 0
+	for _, carta := range cartas {
+		total += carta.Peso
+	}
+
+	sorteadas := make([]Carta, 0, n)
+	for range n {
+		alvo := r.Intn(total)
+		for _, carta := range cartas {
+			alvo -= carta.Peso
+			if alvo < 0 {
+				sorteadas = append(sorteadas, carta)
+				break
+			}
+		}
+	}
+	return sorteadas
+}
+
+// Tanque cria a carta de inventário a partir da entrada do catálogo
+func (c Carta) Tanque(id, dono string) models.Tanque {
+	return models.Tanque{
+		Modelo:     c.Modelo,
+		Classe:     c.Classe,
+		Raridade:   c.Raridade,
+		Id:         id,
+		Id_jogador: dono,
+		Vida:       c.Vida,
+		Ataque:     c.Ataque,
+	}
+}
@@ -0,0 +1,220 @@
+package catalogo
+
+import (
+	"PlanoZ/models"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// --- Tipos de pacote, composição e pity ---
+
+// Padrões de quando o catálogo não fala nada sobre a composição do pacote
+const (
+	CartasPorPacotePadrao = 3
+	GarantiaPadrao        = RaridadeRaro
+)
+
+// ordem das raridades (da mais comum pra mais rara)
+var niveis = map[string]int{
+	RaridadeComum:    0,
+	RaridadeRaro:     1,
+	RaridadeEpico:    2,
+	RaridadeLendario: 3,
+}
+
+// Nivel devolve a posição da raridade na ordem (Comum=0 ... Lendario=3), -1 se não existir
+func Nivel(raridade string) int {
+	if n, ok := niveis[raridade]; ok {
+		return n
+	}
+	return -1
+}
+
+// Pacote é um tipo de booster à venda: cada um tem o seu estoque, preço e pool de cartas.
+//
+// O pool são as cartas do catálogo q podem sair nele: filtradas por Classes e/ou Modelos
+// (vazio = todas). Pacotes sazonais usam Inicio/Fim, fora da janela eles não são vendidos.
+//
+// Pity é o "azar acumulado" do jogador: pra cada raridade do mapa, conta quantos pacotes seguidos
+// ele abriu sem tirar nada daquela raridade (ou melhor). Quando o contador chega no limite,
+// o pacote seguinte garante uma carta dessa raridade. Ex: {"Epico": 10} = no máximo 10 pacotes sem épico.
+type Pacote struct {
+	Id       string         `json:"id"`
+	Nome     string         `json:"nome"`
+	Preco    int            `json:"preco"`
+	Estoque  int            `json:"estoque"`           // estoque inicial (o contador de vdd fica no redis)
+	Classes  []string       `json:"classes,omitempty"` // pool: só cartas dessas classes
+	Modelos  []string       `json:"modelos,omitempty"` // pool: só esses modelos
+	Inicio   time.Time      `json:"inicio,omitzero"`   // sazonal: começa a vender em...
+	Fim      time.Time      `json:"fim,omitzero"`      // sazonal: para de vender em...
+	Cartas   int            `json:"cartas"`            // quantas cartas vêm no pacote
+	Garantia string         `json:"garantia"`          // raridade mínima garantida em todo pacote ("" = sem garantia)
+	Pity     map[string]int `json:"pity"`              // raridade -> limite de pacotes sem ela
+
+	pool []Carta // cartas q podem sair (montado no Ler)
+}
+
+// Abertura é o resultado de abrir um pacote
+type Abertura struct {
+	Cartas []Carta
+	Pity   map[string]int // contadores de pity atualizados do jogador (nesse tipo de pacote)
+}
+
+// Pacote procura um tipo de pacote pelo id
+func (c *Catalogo) Pacote(id string) (*Pacote, bool) {
+	for i := range c.Pacotes {
+		if c.Pacotes[i].Id == id {
+			return &c.Pacotes[i], true
+		}
+	}
+	return nil, false
+}
+
+// Disponivel diz se o pacote ta à venda nesse momento (janela sazonal)
+func (p *Pacote) Disponivel(agora time.Time) bool {
+	if !p.Inicio.IsZero() && agora.Before(p.Inicio) {
+		return false
+	}
+	if !p.Fim.IsZero() && !agora.Before(p.Fim) {
+		return false
+	}
+	return true
+}
+
+// aplicarPadroes preenche o q não veio no arquivo
+func (p *Pacote) aplicarPadroes() {
+	if p.Nome == "" {
+		p.Nome = p.Id
+	}
+	if p.Cartas == 0 {
+		p.Cartas = CartasPorPacotePadrao
+	}
+	if p.Garantia == "" && p.Pity == nil {
+		p.Garantia = GarantiaPadrao
+	}
+}
+
+// montarPool separa as cartas do catálogo q podem sair nesse pacote
+func (p *Pacote) montarPool(cartas []Carta) {
+	p.pool = nil
+	for _, c := range cartas {
+		if len(p.Classes) > 0 && !slices.Contains(p.Classes, c.Classe) {
+			continue
+		}
+		if len(p.Modelos) > 0 && !slices.Contains(p.Modelos, c.Modelo) {
+			continue
+		}
+		p.pool = append(p.pool, c)
+	}
+}
+
+// validar confere o pacote (o pool já tem q estar montado)
+func (p *Pacote) validar(modelos map[string]bool) error {
+	onde := fmt.Sprintf("pacote '%s'", p.Id)
+	var erros []error
+	if p.Preco < 0 {
+		erros = append(erros, fmt.Errorf("%s: preco não pode ser negativo", onde))
+	}
+	if p.Estoque <= 0 {
+		erros = append(erros, fmt.Errorf("%s: estoque precisa ser maior que 0", onde))
+	}
+	if p.Cartas <= 0 {
+		erros = append(erros, fmt.Errorf("%s: cartas precisa ser maior que 0", onde))
+	}
+	if !p.Inicio.IsZero() && !p.Fim.IsZero() && !p.Fim.After(p.Inicio) {
+		erros = append(erros, fmt.Errorf("%s: fim precisa ser depois do inicio", onde))
+	}
+	for _, classe := range p.Classes {
+		switch classe {
+		case models.ClasseLeve, models.ClasseMedio, models.ClassePesado:
+		default:
+			erros = append(erros, fmt.Errorf("%s: classe '%s' inválida", onde, classe))
+		}
+	}
+	for _, modelo := range p.Modelos {
+		if !modelos[modelo] {
+			erros = append(erros, fmt.Errorf("%s: modelo '%s' não existe no catálogo", onde, modelo))
+		}
+	}
+	if len(p.pool) == 0 {
+		erros = append(erros, fmt.Errorf("%s: nenhuma carta do catálogo cabe no pool", onde))
+	}
+
+	// maior raridade q existe no pool (pra n garantir algo q nunca sai)
+	maior := melhorNivel(p.pool)
+	if p.Garantia != "" {
+		if Nivel(p.Garantia) < 0 {
+			erros = append(erros, fmt.Errorf("%s: garantia '%s' inválida", onde, p.Garantia))
+		} else if Nivel(p.Garantia) > maior {
+			erros = append(erros, fmt.Errorf("%s: nenhuma carta com raridade %s ou melhor pra garantir", onde, p.Garantia))
+		}
+	}
+	for raridade, limite := range p.Pity {
+		switch {
+		case Nivel(raridade) < 0:
+			erros = append(erros, fmt.Errorf("%s: pity com raridade '%s' inválida", onde, raridade))
+		case Nivel(raridade) > maior:
+			erros = append(erros, fmt.Errorf("%s: nenhuma carta com raridade %s ou melhor pro pity", onde, raridade))
+		case limite <= 0:
+			erros = append(erros, fmt.Errorf("%s: limite de pity de %s precisa ser maior que 0", onde, raridade))
+		}
+	}
+	return errors.Join(erros...)
+}
+
+// Abrir monta um pacote seguindo a composição dele.
+// 'pity' são os contadores atuais do jogador nesse tipo de pacote (pode ser nil). Com o mesmo
+// *rand.Rand (mesma seed) e os mesmos contadores o resultado é sempre igual, então dá pra
+// conferir as taxas de drop.
+func (p *Pacote) Abrir(r *rand.Rand, pity map[string]int) Abertura {
+	cartas := sortear(r, p.pool, p.Cartas)
+
+	// raridade mínima q esse pacote precisa ter (garantia fixa ou pity estourado).
+	// o contador ja no limite quer dizer q os 'limite' pacotes anteriores vieram sem ela
+	minimo := Nivel(p.Garantia)
+	for raridade, limite := range p.Pity {
+		if pity[raridade] >= limite {
+			minimo = max(minimo, Nivel(raridade))
+		}
+	}
+
+	// se nenhuma carta atingiu o mínimo, a última vira uma sorteada só entre as q atingem
+	if minimo > 0 && melhorNivel(cartas) < minimo {
+		cartas[len(cartas)-1] = p.sortearMinimo(r, minimo)
+	}
+
+	// atualiza o pity: zera o q saiu, soma 1 no q não saiu
+	novoPity := make(map[string]int, len(p.Pity))
+	melhor := melhorNivel(cartas)
+	for raridade := range p.Pity {
+		if melhor >= Nivel(raridade) {
+			novoPity[raridade] = 0
+		} else {
+			novoPity[raridade] = pity[raridade] + 1
+		}
+	}
+	return Abertura{Cartas: cartas, Pity: novoPity}
+}
+
+// sortearMinimo sorteia uma carta do pool (pelo peso) só entre as de raridade >= minimo
+func (p *Pacote) sortearMinimo(r *rand.Rand, minimo int) Carta {
+	var filtrado []Carta
+	for _, carta := range p.pool {
+		if Nivel(carta.Raridade) >= minimo {
+			filtrado = append(filtrado, carta)
+		}
+	}
+	return sortear(r, filtrado, 1)[0]
+}
+
+// melhorNivel devolve o nível da carta mais rara da lista
+func melhorNivel(cartas []Carta) int {
+	melhor := -1
+	for _, c := range cartas {
+		melhor = max(melhor, Nivel(c.Raridade))
+	}
+	return melhor
+}
@@ -0,0 +1,179 @@
+package catalogo
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// pacoteComPity monta um pacote em q o épico quase nunca sai sozinho (peso 1 contra 1.000.000),
+// entao todo épico q aparecer é o pity disparando
+func pacoteComPity(limite int) *Pacote {
+	p := &Pacote{
+		Id:     "teste",
+		Cartas: 3,
+		Pity:   map[string]int{RaridadeEpico: limite},
+	}
+	p.montarPool([]Carta{
+		{Modelo: "Comum", Classe: "Leve", Vida: 1, Ataque: 1, Raridade: RaridadeComum, Peso: 1_000_000},
+		{Modelo: "Epico", Classe: "Pesado", Vida: 1, Ataque: 1, Raridade: RaridadeEpico, Peso: 1},
+	})
+	return p
+}
+
+func temEpico(cartas []Carta) bool {
+	return melhorNivel(cartas) >= Nivel(RaridadeEpico)
+}
+
+// {"Epico": 10} = no máximo 10 pacotes seguidos sem épico: o 11º garante
+func TestPityLimiteDoDoc(t *testing.T) {
+	const limite = 10
+	p := pacoteComPity(limite)
+	r := rand.New(rand.NewSource(1))
+
+	var pity map[string]int
+	for i := 1; i <= limite; i++ {
+		ab := p.Abrir(r, pity)
+		if temEpico(ab.Cartas) {
+			t.Fatalf("pacote %d n devia garantir épico (contador %d, limite %d)", i, pity[RaridadeEpico], limite)
+		}
+		if ab.Pity[RaridadeEpico] != i {
+			t.Fatalf("depois do pacote %d o contador devia ser %d, é %d", i, i, ab.Pity[RaridadeEpico])
+		}
+		pity = ab.Pity
+	}
+
+	ab := p.Abrir(r, pity)
+	if !temEpico(ab.Cartas) {
+		t.Fatalf("o pacote %d devia garantir épico", limite+1)
+	}
+	if ab.Pity[RaridadeEpico] != 0 {
+		t.Fatalf("o contador devia zerar depois do épico, ta em %d", ab.Pity[RaridadeEpico])
+	}
+}
+
+func TestAbrirTamanhoEPool(t *testing.T) {
+	cat, err := Padrao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(7))
+	for _, p := range cat.Pacotes {
+		noPool := make(map[string]bool)
+		for _, c := range p.pool {
+			noPool[c.Modelo] = true
+		}
+		for i := 0; i < 200; i++ {
+			ab := p.Abrir(r, nil)
+			if len(ab.Cartas) != p.Cartas {
+				t.Fatalf("pacote %s devia ter %d cartas, veio com %d", p.Id, p.Cartas, len(ab.Cartas))
+			}
+			for _, c := range ab.Cartas {
+				if !noPool[c.Modelo] {
+					t.Fatalf("pacote %s deu %s, q n ta no pool", p.Id, c.Modelo)
+				}
+			}
+		}
+	}
+}
+
+func TestAbrirGarantia(t *testing.T) {
+	cat, err := Padrao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(7))
+	for _, p := range cat.Pacotes {
+		if p.Garantia == "" {
+			continue
+		}
+		for i := 0; i < 500; i++ {
+			if ab := p.Abrir(r, nil); melhorNivel(ab.Cartas) < Nivel(p.Garantia) {
+				t.Fatalf("pacote %s veio sem %s: %+v", p.Id, p.Garantia, ab.Cartas)
+			}
+		}
+	}
+}
+
+func TestAbrirMesmaSeed(t *testing.T) {
+	cat, err := Padrao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := cat.Pacote("basico")
+	r1 := rand.New(rand.NewSource(99))
+	r2 := rand.New(rand.NewSource(99))
+	var pity1, pity2 map[string]int
+	for i := 0; i < 50; i++ {
+		a1, a2 := p.Abrir(r1, pity1), p.Abrir(r2, pity2)
+		for j := range a1.Cartas {
+			if a1.Cartas[j] != a2.Cartas[j] {
+				t.Fatalf("pacote %d: a msm seed deu cartas diferentes", i)
+			}
+		}
+		pity1, pity2 = a1.Pity, a2.Pity
+	}
+}
+
+func TestPityZeraComDropNatural(t *testing.T) {
+	p := pacoteComPity(10)
+	// só o épico no pool: ele sai sozinho, sem precisar do pity, e o contador zera
+	p.montarPool([]Carta{{Modelo: "Epico", Classe: "Pesado", Vida: 1, Ataque: 1, Raridade: RaridadeEpico, Peso: 1}})
+	ab := p.Abrir(rand.New(rand.NewSource(1)), map[string]int{RaridadeEpico: 4})
+	if ab.Pity[RaridadeEpico] != 0 {
+		t.Fatalf("épico natural devia zerar o contador, ta em %d", ab.Pity[RaridadeEpico])
+	}
+}
+
+func TestPityNuncaPassaDoLimite(t *testing.T) {
+	const limite = 3
+	p := pacoteComPity(limite)
+	r := rand.New(rand.NewSource(3))
+
+	var pity map[string]int
+	semEpico, disparos := 0, 0
+	for i := 0; i < 1000; i++ {
+		ab := p.Abrir(r, pity)
+		if temEpico(ab.Cartas) {
+			if pity[RaridadeEpico] == limite {
+				disparos++
+			}
+			semEpico = 0
+		} else {
+			semEpico++
+		}
+		if semEpico > limite {
+			t.Fatalf("pacote %d: %d pacotes seguidos sem épico com limite %d", i, semEpico, limite)
+		}
+		pity = ab.Pity
+	}
+	// com o épico quase impossível, quase todo épico é o pity: 1 a cada limite+1 pacotes
+	if disparos < 1000/(limite+1)-1 {
+		t.Fatalf("pity disparou só %d vezes em 1000 pacotes", disparos)
+	}
+}
+
+// a frequência de cada carta no sorteio tem q bater com peso / soma dos pesos
+func TestSorteioPorPeso(t *testing.T) {
+	cat, err := Padrao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, c := range cat.Cartas {
+		total += c.Peso
+	}
+
+	const n = 200_000
+	contagem := make(map[string]int)
+	for _, c := range cat.Sortear(rand.New(rand.NewSource(2024)), n) {
+		contagem[c.Modelo]++
+	}
+	for _, c := range cat.Cartas {
+		esperado := float64(c.Peso) / float64(total)
+		obtido := float64(contagem[c.Modelo]) / n
+		if math.Abs(obtido-esperado) > 0.005 {
+			t.Errorf("%s: esperado %.4f, saiu %.4f", c.Modelo, esperado, obtido)
+		}
+	}
+}
@@ -3,17 +3,22 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/ecdh"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic" // pra controlar o estado do heartbeat (thread-safe)
 	"time"
 
-	"PlanoZ/models" // nossas structs
+	"PlanoZ/assinatura" // envelopes assinados (HMAC com a chave da sessao)
+	"PlanoZ/models"     // nossas structs
 
 	"github.com/fatih/color"
 	"github.com/google/uuid"
@@ -28,20 +33,45 @@ const (
 	EstadoBatalhando
 	EstadoTrocando
 	EstadoReconectando // estado novo pra qnd o server cair
+	EstadoLogin        // tela de login/registro (antes de conectar ou qnd a sessao expira)
+	EstadoNaFila       // esperando o server achar um oponente na fila
 )
 
+const TimeoutLogin = 15 * time.Second // sem resposta nesse tempo, o login volta pra tela de login
+
 // variaveis globais pra guardar o estado do jogo
 var (
-	idPessoal            string // nosso ID unico, gerado qnd a gente abre o jogo
-	idParceiro           string // id do maluco q a gente ta pareado
-	idBatalha            string // id da sala de batalha q a gente ta
-	idTroca              string // id da sala de troca
+	idPessoal            string // nosso ID unico, vem da conta no login (eh o mesmo toda vez)
+	tokenSessao          string // token da sessao, vai no envelope de toda requisicao
+	chaveSessao          string // chave q assina os envelopes (nossos e os do server). vem cifrada no login
+	usuarioLogin         string // usuario e senha do login pendente (so vivem ate a resposta chegar,
+	senhaLogin           string // a senha so vai pro server se ele pedir, ver "Conta_Antiga")
+	chaveResposta        string // chave q o server usa pra responder o login pendente ("" = nenhum pendente)
+	loginEnviadoEm       time.Time
+	chavePublicaCluster  *ecdh.PublicKey // sela o login pro cluster (CLUSTER_PUBKEY)
+	idParceiro           string          // id do maluco q a gente ta pareado
+	idBatalha            string          // id da sala de batalha q a gente ta
+	idTroca              string          // id da sala de troca
 	minhasCartas         []models.Tanque
-	indiceCartaOfertada  int    // pra saber qual carta a gente mandou na troca
-	estadoAtual          int    // onde a gente ta agora (EstadoLivre, EstadoBatalhando, etc)
-	meuCanalResposta     string // canal pessoal no redis. o server manda respostas pra ca
-	canalPessoalServidor string // canal do server q a gente ta conectado, pra mandar reqs
-	canalUdpServidor     string // o ip:porta do udp do server, pra pingar
+	maoBatalha           []models.Tanque // cartas q ainda tao vivas na batalha (o server manda a cada turno)
+	turnoBatalha         int             // turno q o server ta esperando nossa carta
+	estadoAtual          int             // onde a gente ta agora (EstadoLivre, EstadoBatalhando, etc)
+	meuCanalResposta     string          // canal pessoal no redis. o server manda respostas pra ca
+	canalPessoalServidor string          // canal do server q a gente ta conectado, pra mandar reqs
+	canalUdpServidor     string          // o ip:porta do udp do server, pra pingar
+
+	// ultimo estado da negociacao da troca q o server mandou (as duas ofertas, versao, quem confirmou)
+	ofertaTroca models.RespostaOfertaTroca
+
+	// convites de pareamento q chegaram pra gnt: id de quem convidou -> quando expira (unix)
+	muConvites        sync.Mutex
+	convitesPendentes = map[string]int64{}
+
+	// trocas cujo resultado a gnt ja aplicou (o server reenvia ate a gnt confirmar)
+	trocasVistas = map[string]bool{}
+
+	// nonces das msgs do server q ja chegaram (pra ninguem repetir uma msg velha)
+	noncesVistos = map[string]int64{}
 
 	// coisas do redis
 	ctx         = context.Background()
@@ -61,9 +91,19 @@ func unmarshalData(data interface{}, v interface{}) error {
 	return json.Unmarshal(dataBytes, v)
 }
 
-// serializa qualquer struct e envia pra uma lista/fila do redis (LPUSH)
+// serializa qualquer struct, bota no envelope assinado e envia pra uma lista/fila do redis (LPUSH)
 func enviarRequisicaoRedis(topico string, data interface{}) {
-	reqBytes, err := json.Marshal(data)
+	enviarEnvelopeRedis(topico, chaveSessao, tokenSessao, data)
+}
+
+// igual o de cima, mas com a chave e o token escolhidos (o login vai sem token, assinado com a chave de resposta)
+func enviarEnvelopeRedis(topico, chave, token string, data interface{}) {
+	env, err := assinatura.Envelopar(chave, token, data, time.Now())
+	if err != nil {
+		color.Red("Erro ao montar envelope: %v", err)
+		return
+	}
+	reqBytes, err := json.Marshal(env)
 	if err != nil {
 		color.Red("Erro ao serializar requisição: %v", err)
 		return
@@ -75,6 +115,212 @@ func enviarRequisicaoRedis(topico string, data interface{}) {
 	}
 }
 
+// pede pro server o inventario oficial (o q ta salvo no redis)
+// a resposta chega como "Inventario" e substitui o minhasCartas
+func pedirInventario() {
+	req := models.ReqPessoalServidor{
+		Tipo:          "Inventario",
+		IdRemetente:   idPessoal,
+		CanalResposta: meuCanalResposta,
+	}
+	enviarRequisicaoRedis(canalPessoalServidor, req)
+}
+
+// avisa o server q o resultado da troca chegou (senão ele fica reenviando)
+func confirmarResultadoTroca(id string) {
+	if canalPessoalServidor == "" {
+		return // reconectando: o server novo manda de novo e a gnt confirma com ele
+	}
+	req := models.ReqPessoalServidor{
+		Tipo:          "Resultado_Recebido",
+		IdRemetente:   idPessoal,
+		CanalResposta: meuCanalResposta,
+		IdTroca:       id,
+	}
+	enviarRequisicaoRedis(canalPessoalServidor, req)
+}
+
+// o comando "Abrir [tipo]". sem tipo o server usa o pacote basico
+func comprarPacote(line string) {
+	req := models.ReqComprarCarta{
+		IdRemetente:   idPessoal,
+		CanalResposta: meuCanalResposta,
+		TipoPacote:    strings.TrimSpace(strings.TrimPrefix(line, "Abrir")),
+		IdTransacao:   uuid.NewString(), // cada Abrir eh uma compra (se o pedido repetir, o server cobra 1x so)
+	}
+	enviarRequisicaoRedis("comprar_carta", req)
+}
+
+// pede a vitrine de pacotes (tipos, preco e estoque). chega como "Pacotes"
+func pedirPacotes() {
+	req := models.ReqPessoalServidor{
+		Tipo:          "Pacotes",
+		IdRemetente:   idPessoal,
+		CanalResposta: meuCanalResposta,
+	}
+	enviarRequisicaoRedis(canalPessoalServidor, req)
+}
+
+// pede o saldo e o extrato da carteira. chega como "Saldo"
+func pedirSaldo() {
+	req := models.ReqPessoalServidor{
+		Tipo:          "Saldo",
+		IdRemetente:   idPessoal,
+		CanalResposta: meuCanalResposta,
+	}
+	enviarRequisicaoRedis(canalPessoalServidor, req)
+}
+
+// manda um pedido pro mercado (qualquer server atende, vai pro topico global)
+func enviarMercado(req models.ReqMercado) {
+	req.IdRemetente = idPessoal
+	req.CanalResposta = meuCanalResposta
+	enviarRequisicaoRedis("mercado", req)
+}
+
+// os comandos do mercado, iguais no menu livre e no pareado. devolve false se a linha n for do mercado
+//
+//	Cartas                                  mostra o inventario (pra saber o <n> do Anunciar)
+//	Mercado [classe=X raridade=X tipo=X vida=N ataque=N preco=N vendedor=X meus]
+//	Anunciar <n> <preco> [duracao em s]     preco fixo
+//	Leiloar <n> <lance minimo> [duracao em s]
+//	Comprar <id> / Lance <id> <valor> / Retirar <id>
+func comandoMercado(line string) bool {
+	partes := strings.Fields(line)
+	if len(partes) == 0 {
+		return false
+	}
+	numeros := func(strs []string) ([]int, bool) {
+		var ns []int
+		for _, str := range strs {
+			n, err := strconv.Atoi(str)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			ns = append(ns, n)
+		}
+		return ns, true
+	}
+
+	switch partes[0] {
+	case "Cartas":
+		if len(minhasCartas) == 0 {
+			color.Yellow("Você não tem cartas.")
+		} else {
+			imprimirTanques(minhasCartas)
+		}
+
+	case "Mercado":
+		var filtro models.FiltroMercado
+		for _, f := range partes[1:] {
+			chave, valor, _ := strings.Cut(f, "=")
+			n, _ := strconv.Atoi(valor)
+			switch chave {
+			case "classe":
+				filtro.Classe = valor
+			case "raridade":
+				filtro.Raridade = valor
+			case "tipo":
+				filtro.Tipo = valor
+			case "vida":
+				filtro.VidaMin = n
+			case "ataque":
+				filtro.AtaqueMin = n
+			case "preco":
+				filtro.PrecoMax = n
+			case "vendedor":
+				filtro.Vendedor = valor
+			case "meus":
+				filtro.Vendedor = idPessoal
+			default:
+				color.Red("Filtro inválido: %s (use classe= raridade= tipo= vida= ataque= preco= vendedor= meus)", f)
+				return true
+			}
+		}
+		enviarMercado(models.ReqMercado{Acao: "Listar", Filtro: filtro})
+
+	case "Anunciar", "Leiloar":
+		ns, ok := numeros(partes[1:])
+		if !ok || len(ns) < 2 || len(ns) > 3 {
+			color.Red("Use '%s <n> <preço> [duração em s]'", partes[0])
+			return true
+		}
+		if ns[0] < 1 || ns[0] > len(minhasCartas) {
+			color.Red("Carta inválida. Escolha de 1 a %d (veja com 'Cartas').", len(minhasCartas))
+			return true
+		}
+		req := models.ReqMercado{Acao: "Anunciar", Tipo: "Fixo", IdCarta: minhasCartas[ns[0]-1].Id, Preco: ns[1]}
+		if partes[0] == "Leiloar" {
+			req.Tipo = "Leilao"
+		}
+		if len(ns) == 3 {
+			req.Duracao = ns[2]
+		}
+		enviarMercado(req)
+
+	case "Comprar", "Retirar":
+		if len(partes) != 2 {
+			color.Red("Use '%s <id do anúncio>'", partes[0])
+			return true
+		}
+		enviarMercado(models.ReqMercado{Acao: partes[0], IdAnuncio: partes[1]})
+
+	case "Lance":
+		if len(partes) != 3 {
+			color.Red("Use 'Lance <id do anúncio> <valor>'")
+			return true
+		}
+		valor, err := strconv.Atoi(partes[2])
+		if err != nil || valor <= 0 {
+			color.Red("Valor inválido.")
+			return true
+		}
+		enviarMercado(models.ReqMercado{Acao: "Lance", IdAnuncio: partes[1], Valor: valor})
+
+	default:
+		return false
+	}
+	return true
+}
+
+// manda um pedido sobre outro jogador (Parear, Aceitar, Recusar, Bloquear, Desbloquear)
+func pedirSobreJogador(tipo, idJogador string) {
+	req := models.ReqPessoalServidor{
+		Tipo:           tipo,
+		IdRemetente:    idPessoal,
+		CanalResposta:  meuCanalResposta,
+		IdDestinatario: strings.TrimSpace(idJogador),
+	}
+	enviarRequisicaoRedis(canalPessoalServidor, req)
+}
+
+// tira o convite da lista (aceito, recusado ou bloqueado)
+func esquecerConvite(idJogador string) {
+	muConvites.Lock()
+	delete(convitesPendentes, idJogador)
+	muConvites.Unlock()
+}
+
+// o comando "Convites": mostra quem convidou a gnt e ainda n expirou
+func listarConvites() {
+	muConvites.Lock()
+	defer muConvites.Unlock()
+	agora := time.Now().Unix()
+	for id, expira := range convitesPendentes {
+		if expira <= agora {
+			delete(convitesPendentes, id)
+		}
+	}
+	if len(convitesPendentes) == 0 {
+		color.Yellow("Nenhum convite pendente.")
+		return
+	}
+	color.Cyan("--- Convites pendentes ---")
+	for id, expira := range convitesPendentes {
+		color.Cyan("%s (expira em %ds)", id, expira-agora)
+	}
+}
+
 // essa é a goroutine do heartbeat, fica pingando o server via udp
 func iniciarMonitoramentoHeartbeat(ctxMonitor context.Context, endereco string) {
 	ticker := time.NewTicker(5 * time.Second) // a cada 5 segundos...
@@ -116,10 +362,106 @@ func iniciarMonitoramentoHeartbeat(ctxMonitor context.Context, endereco string)
 	}
 }
 
+// chaveDoCluster devolve a chave publica q sela o login. o certo eh vir no CLUSTER_PUBKEY (o server mostra
+// ela no log qnd sobe); sem isso a gnt confia na q ta no redis, q quem tem acesso ao redis consegue trocar
+func chaveDoCluster() (*ecdh.PublicKey, error) {
+	if chavePublicaCluster != nil {
+		return chavePublicaCluster, nil
+	}
+	texto := os.Getenv("CLUSTER_PUBKEY")
+	if texto == "" {
+		var err error
+		texto, err = redisClient.Get(ctx, "cluster:chave_registro").Result()
+		if err != nil {
+			return nil, errors.New("CLUSTER_PUBKEY não definida e nenhum servidor publicou a chave ainda")
+		}
+		color.Yellow("AVISO: CLUSTER_PUBKEY não definida, usando a chave publicada no Redis: %s", texto)
+	}
+	publica, err := assinatura.LerChavePublica(texto)
+	if err != nil {
+		return nil, fmt.Errorf("CLUSTER_PUBKEY inválida: %v", err)
+	}
+	chavePublicaCluster = publica // fixa: daqui pra frente usa sempre a msm
+	return publica, nil
+}
+
+// pedirLogin manda o Login/Registrar. a senha n vai: vai o segredo da conta (derivado dela) selado pro
+// cluster, junto com a chave q o server usa pra responder (o envelope vai assinado com ela tbm).
+// so com 'mandarSenha' a senha vai junto no selo, qnd o server pede pra atualizar uma conta antiga
+func pedirLogin(acao, usuario, senha string, mandarSenha bool) error {
+	publica, err := chaveDoCluster()
+	if err != nil {
+		return err
+	}
+	segredo, err := assinatura.SegredoDaConta(usuario, senha)
+	if err != nil {
+		return err
+	}
+	resposta, err := assinatura.NovaChave()
+	if err != nil {
+		return err
+	}
+	selo := models.SeloConectar{ChaveResposta: resposta, Segredo: hex.EncodeToString(segredo)}
+	if mandarSenha {
+		selo.Senha = senha
+	}
+	dados, err := json.Marshal(selo)
+	if err != nil {
+		return err
+	}
+	selado, err := assinatura.Selar(publica, dados)
+	if err != nil {
+		return err
+	}
+
+	usuarioLogin, senhaLogin = usuario, senha
+	chaveResposta = resposta
+	loginEnviadoEm = time.Now()
+	enviarEnvelopeRedis("conectar", resposta, "", models.ReqConectar{
+		CanalResposta: meuCanalResposta,
+		Acao:          acao,
+		Usuario:       usuario,
+		Selado:        selado,
+	})
+	return nil
+}
+
+// esquece o login pendente (falhou, expirou ou ja virou sessao)
+func largarLogin() {
+	usuarioLogin, senhaLogin = "", ""
+	chaveResposta = ""
+}
+
+// confere a assinatura de uma msg do server. com o login pendente a resposta vem assinada com a chave de
+// resposta q a gnt selou no pedido, dps disso com a chave da sessao. sem nenhuma das duas a gnt n espera
+// nada do server, entao descarta tudo. nada passa sem assinatura (nem o Sessao_Invalida)
+func conferirEnvelope(env models.Envelope) error {
+	chave := chaveSessao
+	if chaveResposta != "" {
+		chave = chaveResposta
+	}
+	if chave == "" {
+		return errors.New("nenhum login pendente nem sessão")
+	}
+	agora := time.Now()
+	if err := assinatura.Verificar(chave, env, agora); err != nil {
+		return err
+	}
+	if _, repetido := noncesVistos[env.Nonce]; repetido {
+		return fmt.Errorf("mensagem repetida")
+	}
+	// so precisa lembrar dos nonces dentro da janela, o resto o horario ja barra
+	for nonce, quando := range noncesVistos {
+		if agora.Sub(time.UnixMilli(quando)) > assinatura.Janela {
+			delete(noncesVistos, nonce)
+		}
+	}
+	noncesVistos[env.Nonce] = env.Quando
+	return nil
+}
+
 // A GOROUTINE MAIS IMPORTANTE. fica ouvindo o nosso canal pessoal de respostas
 func ouvirRespostasRedis() {
-	deckBatalha := make([]models.Tanque, 0, 5) // deck de batalha local
-
 	for {
 		// aqui o codigo TRAVA, esperando o proximo BLPop no nosso canal
 		resultado, err := redisClient.BLPop(ctx, 0*time.Second, meuCanalResposta).Result()
@@ -132,13 +474,22 @@ func ouvirRespostasRedis() {
 			os.Exit(1)
 		}
 
-		// qnd chega, tenta ler a msg generica
+		// qnd chega, abre o envelope e tenta ler a msg generica
+		var env models.Envelope
 		var resposta models.RespostaGenericaCliente
-		err = json.Unmarshal([]byte(resultado[1]), &resposta)
-		if err != nil {
+		if err := json.Unmarshal([]byte(resultado[1]), &env); err != nil {
+			color.Red("Erro ao deserializar envelope: %v", err)
+			continue
+		}
+		if err := json.Unmarshal(env.Corpo, &resposta); err != nil {
 			color.Red("Erro ao deserializar resposta genérica: %v", err)
 			continue
 		}
+		// qualquer um com acesso ao redis consegue escrever no nosso canal, entao so vale o q veio assinado
+		if err := conferirEnvelope(env); err != nil {
+			color.Red("Mensagem '%s' ignorada: %v", resposta.Tipo, err)
+			continue
+		}
 
 		// agora vamos ver o q o server realmente quer dizer
 		switch resposta.Tipo {
@@ -148,8 +499,13 @@ func ouvirRespostasRedis() {
 			if unmarshalData(resposta.Data, &resp) == nil {
 				color.Red("Erro do Servidor: %s", resp.Erro)
 			}
-			// volta pro menu
-			if idParceiro == "none" {
+			// volta pro menu (ou pro login, se nem logou ainda)
+			if tokenSessao == "" {
+				largarLogin() // o login falhou, o proximo pedido gera outra chave de resposta
+				estadoAtual = EstadoLogin
+			} else if idTroca != "none" {
+				estadoAtual = EstadoTrocando // acao da troca recusada, a negociacao continua
+			} else if idParceiro == "none" {
 				estadoAtual = EstadoLivre
 			} else {
 				estadoAtual = EstadoPareado
@@ -157,12 +513,74 @@ func ouvirRespostasRedis() {
 			//idTroca = "none"
 
 		case "Desconexão":
-			// o oponente desconectou (de forma limpa)
+			// o oponente desconectou (de forma limpa), o server ja desfez o par
 			color.Yellow("Parece que seu jogador pareado desconectou :(")
-			estadoAtual = EstadoLivre
+			idParceiro = "none"
+			// se tiver no meio de uma batalha, ela continua (o server espera ele voltar), so o par q acabou
+			if estadoAtual != EstadoBatalhando {
+				estadoAtual = EstadoLivre
+				idBatalha = "none"
+				idTroca = "none"
+			}
+
+		case "Despareado":
+			// alguem (a gnt ou o parceiro) deu Desparear
+			var resp models.RespostaPareamento
+			if unmarshalData(resposta.Data, &resp) == nil {
+				color.Yellow(resp.Mensagem)
+			}
+			idParceiro = "none"
+			if estadoAtual == EstadoPareado || estadoAtual == EstadoEsperandoResposta {
+				estadoAtual = EstadoLivre
+			}
+
+		case "Reconectar":
+			// o server avisou q vai desligar, entao a gnt vai pra outro antes dele sumir
+			var resp models.RespostaReconectar
+			if unmarshalData(resposta.Data, &resp) == nil {
+				color.Yellow(resp.Mensagem)
+			}
 			idParceiro = "none"
 			idBatalha = "none"
 			idTroca = "none"
+			canalPessoalServidor = ""
+			canalUdpServidor = ""
+			if monitorCancel != nil {
+				monitorCancel()
+				monitorCancel = nil
+			}
+			estadoAtual = EstadoReconectando
+			color.Cyan("Pressione Enter para reconectar...")
+
+		case "Conta_Antiga":
+			// a conta eh de antes do bcrypt do segredo: o server pede a senha uma vez (selada) pra atualizar
+			if chaveResposta == "" || senhaLogin == "" {
+				continue
+			}
+			color.Yellow("Conta criada numa versão antiga, confirmando a senha com o servidor...")
+			if err := pedirLogin("Login", usuarioLogin, senhaLogin, true); err != nil {
+				color.Red("Falha ao montar o login: %v", err)
+				largarLogin()
+				estadoAtual = EstadoLogin
+			}
+
+		case "Sessao_Invalida":
+			// o token expirou (ou o server n reconhece): volta pro login
+			var resp models.RespostaErro
+			if unmarshalData(resposta.Data, &resp) == nil {
+				color.Red(resp.Erro)
+			}
+			tokenSessao = ""
+			chaveSessao = ""
+			largarLogin()
+			idParceiro = "none"
+			idBatalha = "none"
+			idTroca = "none"
+			if monitorCancel != nil {
+				monitorCancel()
+				monitorCancel = nil
+			}
+			estadoAtual = EstadoLogin
 
 		case "Conexao_Sucesso":
 			// conseguimos conectar! o server mandou os dados dele
@@ -172,6 +590,22 @@ func ouvirRespostasRedis() {
 				continue
 			}
 			color.Green("Conectado com sucesso! Servidor: %s", resp.IdServidorConectado)
+			idPessoal = resp.IdJogador // o id da conta (os outros jogadores usam ele pra parear)
+			tokenSessao = resp.Token
+			if chaveResposta != "" {
+				// resposta do Login/Registrar: a chave da sessao vem cifrada com a nossa chave de resposta
+				// (no Retomar a gnt continua com a mesma)
+				chave, err := assinatura.Decifrar(chaveResposta, resp.ChaveSessao, resp.Token)
+				largarLogin()
+				if err != nil {
+					color.Red("Falha ao abrir a chave da sessão: %v", err)
+					tokenSessao = ""
+					estadoAtual = EstadoLogin
+					continue
+				}
+				chaveSessao = string(chave)
+			}
+			color.Yellow("Meu ID Pessoal: %s", idPessoal)
 			canalPessoalServidor = resp.CanalPessoalServidor // guarda o canal de reqs do server
 			canalUdpServidor = resp.CanalUDPPing             // guarda o udp pra pingar
 			estadoAtual = EstadoLivre                        // libera o menu principal
@@ -189,6 +623,125 @@ func ouvirRespostasRedis() {
 			ctxMonitor, monitorCancel = context.WithCancel(context.Background())
 			go iniciarMonitoramentoHeartbeat(ctxMonitor, resp.CanalUDPPing)
 
+			// quem manda no inventario eh o server, entao sincroniza
+			pedirInventario()
+
+		case "Inventario":
+			// o server mandou nosso inventario oficial
+			var resp models.RespostaInventario
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaInventario")
+				continue
+			}
+			minhasCartas = resp.Cartas
+			color.Cyan("Inventário sincronizado com o servidor (%d cartas).", len(minhasCartas))
+
+		case "Saldo":
+			// saldo e extrato da carteira
+			var resp models.RespostaSaldo
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaSaldo")
+				continue
+			}
+			color.Cyan("Saldo: %d créditos", resp.Saldo)
+			for _, l := range resp.Extrato {
+				quando := time.Unix(l.Quando, 0).Format("02/01 15:04")
+				if l.Valor >= 0 {
+					color.Green("  %s  +%d  %s (saldo %d)", quando, l.Valor, l.Motivo, l.Saldo)
+				} else {
+					color.Red("  %s  %d  %s (saldo %d)", quando, l.Valor, l.Motivo, l.Saldo)
+				}
+			}
+
+		case "Pacotes":
+			// a vitrine de pacotes
+			var resp models.RespostaPacotes
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaPacotes")
+				continue
+			}
+			color.Cyan("Pacotes à venda (use Abrir <id>):")
+			for _, p := range resp.Pacotes {
+				linha := fmt.Sprintf("  %s - %s | preço: %d | %d cartas", p.Id, p.Nome, p.Preco, p.Cartas)
+				if p.Garantia != "" {
+					linha += fmt.Sprintf(" (1+ %s garantida)", p.Garantia)
+				}
+				switch {
+				case !p.Disponivel:
+					color.White("%s | fora da temporada", linha)
+				case p.Estoque <= 0:
+					color.Red("%s | esgotado", linha)
+				default:
+					color.Green("%s | estoque: %d", linha, p.Estoque)
+				}
+			}
+
+		case "Mercado":
+			// resultado da busca no mercado
+			var resp models.RespostaMercado
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaMercado")
+				continue
+			}
+			if resp.Total == 0 {
+				color.Yellow("Nenhum anúncio encontrado.")
+				continue
+			}
+			color.Cyan("Mercado (%d de %d anúncios):", len(resp.Anuncios), resp.Total)
+			for _, a := range resp.Anuncios {
+				imprimirAnuncio(a)
+			}
+
+		case "Mercado_Evento":
+			// algo aconteceu num anuncio nosso (ou num leilao q a gnt deu lance)
+			var resp models.RespostaEventoMercado
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaEventoMercado")
+				continue
+			}
+			switch resp.Evento {
+			case "Comprado", "Arrematado":
+				// a carta ja vem com a gnt de dono
+				minhasCartas = append(minhasCartas, resp.Anuncio.Carta)
+				color.Green(resp.Mensagem)
+				color.Green("  + ADICIONADO: %s", resp.Anuncio.Carta.Modelo)
+			case "Vendido":
+				for i, c := range minhasCartas {
+					if c.Id == resp.Anuncio.Carta.Id {
+						minhasCartas = append(minhasCartas[:i], minhasCartas[i+1:]...)
+						break
+					}
+				}
+				color.Green(resp.Mensagem)
+				color.Red("  - REMOVIDO: %s", resp.Anuncio.Carta.Modelo)
+			case "Superado", "Expirado":
+				color.Yellow(resp.Mensagem)
+			default:
+				color.Cyan(resp.Mensagem)
+			}
+			if resp.Evento == "Anunciado" {
+				color.Cyan("  Id do anúncio: %s", resp.Anuncio.Id)
+			}
+
+		case "Fila":
+			// entrou, saiu ou foi tirado da fila de batalha
+			var resp models.RespostaFila
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaFila")
+				continue
+			}
+			if resp.NaFila {
+				color.Cyan(resp.Mensagem)
+				if estadoAtual == EstadoEsperandoResposta || estadoAtual == EstadoLivre {
+					estadoAtual = EstadoNaFila
+				}
+			} else {
+				color.Yellow(resp.Mensagem)
+				if estadoAtual == EstadoNaFila || estadoAtual == EstadoEsperandoResposta {
+					estadoAtual = EstadoLivre
+				}
+			}
+
 		case "Pareamento":
 			// achamos um oponente
 			var resp models.RespostaPareamento
@@ -197,9 +750,49 @@ func ouvirRespostasRedis() {
 				continue
 			}
 			color.Green("Pareamento realizado com %s", resp.IdParceiro)
+			esquecerConvite(resp.IdParceiro)
 			idParceiro = resp.IdParceiro
 			estadoAtual = EstadoPareado
 
+		case "Convite":
+			// alguem quer parear com a gnt
+			var resp models.RespostaConvite
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaConvite")
+				continue
+			}
+			muConvites.Lock()
+			convitesPendentes[resp.IdRemetente] = resp.ExpiraEm
+			muConvites.Unlock()
+			color.Cyan("%s (expira em %ds)", resp.Mensagem, resp.ExpiraEm-time.Now().Unix())
+			color.Cyan("Use 'Aceitar %s' ou 'Recusar %s'", resp.IdRemetente, resp.IdRemetente)
+
+		case "Convite_Enviado":
+			// o convite foi, agora eh esperar o outro aceitar
+			var resp models.RespostaConvite
+			if unmarshalData(resposta.Data, &resp) == nil {
+				color.Green("%s. Esperando a resposta...", resp.Mensagem)
+			}
+			if estadoAtual == EstadoEsperandoResposta {
+				estadoAtual = EstadoLivre
+			}
+
+		case "Convite_Recusado":
+			var resp models.RespostaConvite
+			if unmarshalData(resposta.Data, &resp) == nil {
+				color.Yellow(resp.Mensagem)
+				esquecerConvite(resp.IdRemetente)
+			}
+
+		case "Bloqueio":
+			var resp models.RespostaBloqueio
+			if unmarshalData(resposta.Data, &resp) == nil {
+				color.Yellow(resp.Mensagem)
+				if resp.Bloqueado {
+					esquecerConvite(resp.IdJogador)
+				}
+			}
+
 		case "Mensagem":
 			// chat
 			var resp models.RespostaMensagem
@@ -219,6 +812,7 @@ func ouvirRespostasRedis() {
 			minhasCartas = append(minhasCartas, resp.Cartas...)
 			color.Green("%s\n", resp.Mensagem)
 			imprimirTanques(resp.Cartas)
+			color.Cyan("Saldo: %d créditos", resp.Saldo)
 
 		case "Inicio_Batalha":
 			// comecou a batalha
@@ -227,19 +821,18 @@ func ouvirRespostasRedis() {
 				color.Red("Falha ao ler RespostaInicioBatalha")
 				continue
 			}
-			color.Yellow("Batalha iniciada! Oponente: %s. ID da Batalha: %s", resp.Mensagem, resp.IdBatalha)
 			idBatalha = resp.IdBatalha // guarda o id da sala
-			deckBatalha = nil
-			if len(minhasCartas) > 0 {
-				deckBatalha = append(deckBatalha, sortearDeck()...) // sorteia 5 cartas do nosso inventario
+			maoBatalha = nil
+			if resp.Retomada {
+				// o server q hospedava caiu e outro assumiu, a mao chega no proximo Pedir_Carta
+				color.Yellow("Batalha retomada! Oponente: %s. ID da Batalha: %s", resp.Mensagem, resp.IdBatalha)
+				idParceiro = resp.Mensagem
 			} else {
-				// se n tem carta, bota umas de treino
-				for i := 0; i < 5; i++ {
-					deckBatalha = append(deckBatalha, models.Tanque{Modelo: "Treinamento", Id_jogador: idPessoal, Vida: 1 + i, Ataque: 1})
-				}
+				// o deck eh o server q escolhe (do nosso inventario)
+				color.Yellow("Batalha iniciada! Oponente: %s. ID da Batalha: %s", resp.Mensagem, resp.IdBatalha)
+				color.Cyan("Seu deck de batalha é:")
+				imprimirTanques(resp.Deck)
 			}
-			color.Cyan("Seu deck de batalha é:")
-			imprimirTanques(deckBatalha)
 			estadoAtual = EstadoBatalhando // muda a "tela" pra de batalha
 
 		case "Inicio_Troca":
@@ -250,9 +843,22 @@ func ouvirRespostasRedis() {
 				continue
 			}
 			color.Magenta("Troca iniciada! Oponente: %s. ID da Troca: %s", resp.Mensagem, resp.IdTroca)
-			idTroca = resp.IdTroca       // guarda o id da sala de troca
-			indiceCartaOfertada = -1     // reseta o indice
-			estadoAtual = EstadoTrocando // muda pra "tela" de troca
+			idTroca = resp.IdTroca                     // guarda o id da sala de troca
+			ofertaTroca = models.RespostaOfertaTroca{} // reseta a negociacao
+			estadoAtual = EstadoTrocando               // muda pra "tela" de troca
+
+		case "Oferta_Troca":
+			// alguem mudou a oferta (ou confirmou): o server manda as duas ofertas inteiras
+			var resp models.RespostaOfertaTroca
+			if unmarshalData(resposta.Data, &resp) != nil {
+				color.Red("Falha ao ler RespostaOfertaTroca")
+				continue
+			}
+			if resp.IdTroca != idTroca {
+				continue
+			}
+			ofertaTroca = resp
+			imprimirOfertaTroca(resp)
 
 		case "Fim_Batalha":
 			// acabou a luta
@@ -263,10 +869,13 @@ func ouvirRespostasRedis() {
 			}
 			color.Yellow("Batalha finalizada!")
 			color.Cyan(resp.Mensagem)
+			maoBatalha = nil
 
 			// checa se o server ainda ta vivo antes de voltar pro menu
-			if serverVivo.Load() {
+			if serverVivo.Load() && idParceiro != "none" {
 				estadoAtual = EstadoPareado
+			} else if serverVivo.Load() {
+				estadoAtual = EstadoLivre // o par se desfez durante a batalha
 			} else {
 				estadoAtual = EstadoReconectando
 			}
@@ -279,68 +888,78 @@ func ouvirRespostasRedis() {
 				color.Red("Falha ao ler RespostaResultadoTroca")
 				continue
 			}
+			// o server manda de novo ate a gnt confirmar (e de novo qnd a gnt reconecta),
+			// entao confirma toda vez mas so aplica uma
+			confirmarResultadoTroca(resp.IdTroca)
+			if trocasVistas[resp.IdTroca] {
+				continue
+			}
+			trocasVistas[resp.IdTroca] = true
 
-			// se n veio carta, eh pq falhou ou foi cancelada
-			if resp.CartaRecebida.Modelo == "" {
-				color.Red("A troca falhou ou foi cancelada pelo outro jogador.")
-			} else if indiceCartaOfertada < 0 || indiceCartaOfertada >= len(minhasCartas) {
-				// deu algum erro interno de indice (nao devia acontecer)
-				color.Red("ERRO INTERNO: Não foi possível encontrar a carta ofertada (índice: %d)", indiceCartaOfertada)
-				minhasCartas = append(minhasCartas, resp.CartaRecebida) // Pelo menos adiciona a nova
-			} else {
-				// deu certo! hora de trocar as cartas no inventario
-				cartaRemovida := minhasCartas[indiceCartaOfertada]
-
-				// tira a carta antiga
-				minhasCartas = append(minhasCartas[:indiceCartaOfertada], minhasCartas[indiceCartaOfertada+1:]...)
-
-				// bota a carta nova
-				minhasCartas = append(minhasCartas, resp.CartaRecebida)
+			if resp.IdTroca != idTroca {
+				// troca q fechou enquanto a gnt tava fora: so avisa, o inventario vem do server
+				color.Yellow(resp.Mensagem)
+				pedirInventario()
+				continue
+			}
 
+			if !resp.Concluida {
+				// cancelada (timeout, desistencia, carta invalida...)
+				color.Red(resp.Mensagem)
+			} else {
+				// deu certo! o server diz quais cartas a gente deu (pelo id) e quais recebeu
+				entregues := make(map[string]bool)
+				for _, id := range resp.IdsCartasEntregues {
+					entregues[id] = true
+				}
 				color.Green("Troca Concluída!")
-				color.Red("  - REMOVIDO: %s", cartaRemovida.Modelo)
-				color.Green("  + ADICIONADO: %s", resp.CartaRecebida.Modelo)
+				restantes := make([]models.Tanque, 0, len(minhasCartas))
+				for _, c := range minhasCartas {
+					if entregues[c.Id] {
+						color.Red("  - REMOVIDO: %s", c.Modelo)
+						delete(entregues, c.Id)
+					} else {
+						restantes = append(restantes, c)
+					}
+				}
+				for _, c := range resp.CartasRecebidas {
+					color.Green("  + ADICIONADO: %s", c.Modelo)
+				}
+				minhasCartas = append(restantes, resp.CartasRecebidas...)
+				if resp.Creditos > 0 {
+					color.Green("  + %d créditos", resp.Creditos)
+				} else if resp.Creditos < 0 {
+					color.Red("  - %d créditos", -resp.Creditos)
+				}
+				if len(entregues) > 0 {
+					// a gnt n tinha alguma das cartas (inventario local velho), o do server resolve
+					pedirInventario()
+				}
 			}
 
-			if serverVivo.Load() {
+			if serverVivo.Load() && idParceiro != "none" {
 				estadoAtual = EstadoPareado
+			} else if serverVivo.Load() {
+				estadoAtual = EstadoLivre // o par se desfez durante a troca
 			} else {
 				estadoAtual = EstadoReconectando
 			}
 			idTroca = "none" // limpa o id da troca
+			ofertaTroca = models.RespostaOfertaTroca{}
 
 		case "Pedir_Carta":
 			// O SERVER TA PEDINDO NOSSA JOGADA (BATALHA)
+			// ele manda a mao atual, a gnt escolhe no loop main com 'jogar <n>'
 			var resp models.RespostaPedirCarta
 			if unmarshalData(resposta.Data, &resp) != nil {
 				color.Red("Falha ao ler RespostaPedirCarta")
 				continue
 			}
-			indice := resp.Indice // o servidor so manda o *indice* q ele quer do nosso deck de batalha
-			var carta models.Tanque
-
-			// ve se o indice é valido
-			if indice < 0 || indice >= len(deckBatalha) {
-				color.Red("ERRO: Índice %d fora do range do deck (0-%d). Enviando carta padrão.", indice, len(deckBatalha)-1)
-				carta = models.Tanque{Modelo: "Padrão", Vida: 10, Ataque: 1, Id_jogador: idPessoal}
-			} else {
-				carta = deckBatalha[indice] // acha a carta
-				color.Cyan("Enviando carta: %s (Vida: %d, Ataque: %d)", carta.Modelo, carta.Vida, carta.Ataque)
-			}
-
-			reqJogada := models.ReqJogadaBatalha{ // prepara o pacote com a carta
-				IdRemetente:   idPessoal,
-				CanalResposta: meuCanalResposta,
-				IdBatalha:     idBatalha,
-				Carta:         carta,
-			}
-			enviarRequisicaoRedis(canalPessoalServidor, reqJogada) // e manda pro server
-
-		case "Pedir_Carta_Troca":
-			// o server ta pronto pra receber nossa oferta
-			// a gente so avisa o usuario, quem le o input é o loop main
-			color.Magenta("O servidor está pronto para receber sua oferta de troca.")
-			color.Magenta("Use 'list' para ver suas cartas ou 'ofertar <indice>' para enviar.")
+			maoBatalha = resp.Mao
+			turnoBatalha = resp.Turno
+			color.Cyan("\nTurno %d - escolha sua carta:", resp.Turno)
+			imprimirTanques(maoBatalha)
+			color.Cyan("Digite 'jogar <n>' (ex: jogar 1)")
 
 		case "Turno_Realizado":
 			// o oponente jogou, so mostra o resultado
@@ -363,10 +982,8 @@ func ouvirRespostasRedis() {
 func main() {
 	color.NoColor = false
 
-	// cria nosso id e nosso canal de "email"
-	idPessoal = uuid.New().String()
-	meuCanalResposta = "client_reply:" + idPessoal
-	color.Yellow("Meu ID Pessoal: %s", idPessoal)
+	// cria nosso canal de "email". o id de vdd so vem depois do login (eh o da conta)
+	meuCanalResposta = "client_reply:" + uuid.New().String()
 	color.Yellow("Meu Canal de Resposta: %s", meuCanalResposta)
 
 	// conecta no redis
@@ -385,15 +1002,8 @@ func main() {
 	//  IMPORTANTE: inicia a goroutine de escuta (o email)
 	go ouvirRespostasRedis()
 
-	// manda a primeira msg "OI, QUERO CONECTAR"
-	reqConnect := models.ReqConectar{
-		IdRemetente:   idPessoal,
-		CanalResposta: meuCanalResposta,
-	}
-	enviarRequisicaoRedis("conectar", reqConnect)
-
-	// estado inicial, esperando o "Conexao_Sucesso"
-	estadoAtual = EstadoEsperandoResposta
+	// estado inicial: login (o "OI, QUERO CONECTAR" vai junto com o usuario e a senha)
+	estadoAtual = EstadoLogin
 	idParceiro = "none"
 	idBatalha = "none"
 	idTroca = "none"
@@ -404,7 +1014,7 @@ func main() {
 	for {
 		//  O CHECK DO HEARTBEAT
 		// se a goroutine do heartbeat (UDP) falou q o server morreu...
-		if !serverVivo.Load() && estadoAtual != EstadoEsperandoResposta && estadoAtual != EstadoReconectando {
+		if !serverVivo.Load() && estadoAtual != EstadoEsperandoResposta && estadoAtual != EstadoReconectando && estadoAtual != EstadoLogin {
 			color.Red("\n!!! CONEXÃO COM O SERVIDOR PERDIDA !!!")
 
 			if estadoAtual == EstadoBatalhando || estadoAtual == EstadoPareado {
@@ -433,7 +1043,7 @@ func main() {
 		switch estadoAtual {
 		case EstadoLivre:
 			// menu principal qnd n ta em batalha/pareado
-			fmt.Println("Comando Parear <id> / Abrir / Ping / Sair: ")
+			fmt.Println("Comando Parear <id> / Convites / Aceitar <id> / Recusar <id> / Bloquear <id> / Desbloquear <id> / Pacotes / Abrir [tipo] / Saldo / Cartas / Mercado [filtros] / Anunciar / Leiloar / Comprar / Lance / Retirar / Fila [ranqueada|casual] / Ping / Sair: ")
 			line, _ := reader.ReadString('\n')
 			line = strings.TrimSpace(line)
 
@@ -442,22 +1052,48 @@ func main() {
 			}
 
 			if strings.HasPrefix(line, "Parear ") {
-				idDestinatario := strings.TrimPrefix(line, "Parear ")
-				req := models.ReqPessoalServidor{
-					Tipo:           "Parear",
-					IdRemetente:    idPessoal,
-					CanalResposta:  meuCanalResposta,
-					IdDestinatario: idDestinatario,
-				}
-				enviarRequisicaoRedis(canalPessoalServidor, req)
+				// manda o convite, o outro jogador tem q aceitar
+				pedirSobreJogador("Parear", strings.TrimPrefix(line, "Parear "))
 				estadoAtual = EstadoEsperandoResposta
 
-			} else if strings.HasPrefix(line, "Abrir") {
-				req := models.ReqComprarCarta{
+			} else if strings.HasPrefix(line, "Aceitar ") {
+				pedirSobreJogador("Aceitar", strings.TrimPrefix(line, "Aceitar "))
+				estadoAtual = EstadoEsperandoResposta
+
+			} else if strings.HasPrefix(line, "Recusar ") {
+				pedirSobreJogador("Recusar", strings.TrimPrefix(line, "Recusar "))
+
+			} else if strings.HasPrefix(line, "Bloquear ") {
+				pedirSobreJogador("Bloquear", strings.TrimPrefix(line, "Bloquear "))
+
+			} else if strings.HasPrefix(line, "Desbloquear ") {
+				pedirSobreJogador("Desbloquear", strings.TrimPrefix(line, "Desbloquear "))
+
+			} else if line == "Convites" {
+				listarConvites()
+
+			} else if line == "Fila" || strings.HasPrefix(line, "Fila ") {
+				// entra na fila de batalha, o server acha o oponente pelo rating
+				req := models.ReqFila{
 					IdRemetente:   idPessoal,
 					CanalResposta: meuCanalResposta,
+					Acao:          "Entrar",
+					Modo:          strings.TrimSpace(strings.TrimPrefix(line, "Fila")),
 				}
-				enviarRequisicaoRedis("comprar_carta", req)
+				enviarRequisicaoRedis("fila_batalha", req)
+				estadoAtual = EstadoEsperandoResposta
+
+			} else if strings.HasPrefix(line, "Abrir") {
+				comprarPacote(line)
+
+			} else if line == "Pacotes" {
+				pedirPacotes()
+
+			} else if line == "Saldo" {
+				pedirSaldo()
+
+			} else if comandoMercado(line) {
+				// Cartas, Mercado, Anunciar, Leiloar, Comprar, Lance, Retirar
 
 			} else if strings.HasPrefix(line, "Ping") {
 				if canalUdpServidor == "" {
@@ -471,20 +1107,26 @@ func main() {
 
 		case EstadoPareado:
 			// menu qnd ta pareado com alguem
-			fmt.Println("Comando Abrir / Mensagem / Batalhar / Trocar / Ping / Sair: ")
+			fmt.Println("Comando Pacotes / Abrir [tipo] / Saldo / Cartas / Mercado [filtros] / Anunciar / Leiloar / Comprar / Lance / Retirar / Mensagem / Batalhar / Trocar / Desparear / Ping / Sair: ")
 			line, _ := reader.ReadString('\n')
 			line = strings.TrimSpace(line)
 
 			if line == "Sair" {
+				pedirSobreJogador("Desparear", idParceiro) // avisa o parceiro antes de sair
 				os.Exit(0)
 			}
 
 			if strings.HasPrefix(line, "Abrir") {
-				req := models.ReqComprarCarta{
-					IdRemetente:   idPessoal,
-					CanalResposta: meuCanalResposta,
-				}
-				enviarRequisicaoRedis("comprar_carta", req)
+				comprarPacote(line)
+
+			} else if line == "Pacotes" {
+				pedirPacotes()
+
+			} else if line == "Saldo" {
+				pedirSaldo()
+
+			} else if comandoMercado(line) {
+				// Cartas, Mercado, Anunciar, Leiloar, Comprar, Lance, Retirar
 
 			} else if strings.HasPrefix(line, "Batalhar") {
 				if len(minhasCartas) < 5 {
@@ -500,19 +1142,19 @@ func main() {
 					estadoAtual = EstadoEsperandoResposta
 				}
 			} else if strings.HasPrefix(line, "Trocar") {
-				// inicia o fluxo de troca
-				if len(minhasCartas) == 0 {
-					color.Red("Você não tem nenhuma carta para trocar.")
-				} else {
-					req := models.ReqPessoalServidor{
-						Tipo:           "Trocar",
-						IdRemetente:    idPessoal,
-						CanalResposta:  meuCanalResposta,
-						IdDestinatario: idParceiro,
-					}
-					enviarRequisicaoRedis(canalPessoalServidor, req)
-					estadoAtual = EstadoEsperandoResposta
+				// inicia o fluxo de troca (da pra trocar so creditos, n precisa ter carta)
+				req := models.ReqPessoalServidor{
+					Tipo:           "Trocar",
+					IdRemetente:    idPessoal,
+					CanalResposta:  meuCanalResposta,
+					IdDestinatario: idParceiro,
 				}
+				enviarRequisicaoRedis(canalPessoalServidor, req)
+				estadoAtual = EstadoEsperandoResposta
+			} else if line == "Desparear" {
+				pedirSobreJogador("Desparear", idParceiro)
+				estadoAtual = EstadoEsperandoResposta
+
 			} else if strings.HasPrefix(line, "Mensagem ") {
 				mensagem := strings.TrimPrefix(line, "Mensagem ")
 				req := models.ReqPessoalServidor{
@@ -539,17 +1181,61 @@ func main() {
 			color.Yellow("Esperando resposta do server...")
 			time.Sleep(1 * time.Second)
 
+			// o server descarta calado o login q ele n consegue abrir (chave publica errada), entao n fica esperando pra sempre
+			if chaveResposta != "" && time.Since(loginEnviadoEm) > TimeoutLogin {
+				color.Red("O servidor não respondeu o login. Confira a CLUSTER_PUBKEY (tem que ser a do log dos servidores).")
+				largarLogin()
+				estadoAtual = EstadoLogin
+			}
+
 		case EstadoBatalhando:
-			// em batalha, o usuario n digita, so espera o server pedir a carta
-			color.Yellow("Batalha ocorrendo!! (Aguardando instruções do servidor...)")
-			time.Sleep(5 * time.Second)
+			// sem mao = o server ainda n pediu (ou a gnt ja jogou e ta esperando o oponente)
+			if len(maoBatalha) == 0 {
+				color.Yellow("Batalha ocorrendo!! (Aguardando instruções do servidor...)")
+				time.Sleep(2 * time.Second)
+				continue
+			}
+
+			line, _ := reader.ReadString('\n')
+			line = strings.TrimSpace(line)
+			parts := strings.Fields(line)
+			if len(parts) == 0 || estadoAtual != EstadoBatalhando {
+				continue
+			}
+
+			if parts[0] == "mao" {
+				imprimirTanques(maoBatalha)
+			} else if parts[0] == "jogar" && len(parts) == 2 {
+				n, err := strconv.Atoi(parts[1])
+				if err != nil || n < 1 || n > len(maoBatalha) {
+					color.Red("Carta inválida. Escolha de 1 a %d.", len(maoBatalha))
+					continue
+				}
+				carta := maoBatalha[n-1] // o usuario ve base 1
+				color.Cyan("Enviando carta: %s (Vida: %d, Ataque: %d)", carta.Modelo, carta.Vida, carta.Ataque)
+
+				reqJogada := models.ReqJogadaBatalha{ // prepara o pacote com a carta
+					IdRemetente:   idPessoal,
+					CanalResposta: meuCanalResposta,
+					IdBatalha:     idBatalha,
+					Carta:         carta,
+				}
+				enviarRequisicaoRedis(canalPessoalServidor, reqJogada) // e manda pro server
+				maoBatalha = nil                                       // so uma carta por turno
+				color.Yellow("Carta do turno %d enviada. Aguardando o oponente...", turnoBatalha)
+			} else {
+				color.Red("Comando inválido. Use 'jogar <n>' ou 'mao'.")
+			}
 
 		case EstadoTrocando:
-			// TELA INTERATIVA DA TROCA
-			color.Magenta("Troca em andamento com %s.", idParceiro)
-			color.Magenta("Digite 'list' para ver suas cartas, 'ofertar <indice>' para enviar, ou 'cancelar'.")
+			// TELA INTERATIVA DA TROCA (negociacao: as duas ofertas aparecem a cada mudanca)
+			color.Magenta("Troca em andamento com %s (versão %d).", idParceiro, ofertaTroca.Versao)
+			color.Magenta("Digite 'list', 'ver', 'ofertar <n> [n ...]', 'creditos <qtd>', 'confirmar' ou 'cancelar'.")
 			line, _ := reader.ReadString('\n')
 			line = strings.TrimSpace(line)
+			if estadoAtual != EstadoTrocando {
+				continue // a troca acabou enquanto a gnt digitava
+			}
 
 			if line == "list" {
 				// mostra o inventario
@@ -558,50 +1244,124 @@ func main() {
 				} else {
 					imprimirTanques(minhasCartas)
 				}
+			} else if line == "ver" {
+				imprimirOfertaTroca(ofertaTroca)
 			} else if line == "cancelar" {
-				// cancela local, o server vai dar timeout sozinho
-				color.Red("Troca cancelada localmente.")
-				estadoAtual = EstadoPareado
-				idTroca = "none"
-				// O servidor (iniciarTroca) vai dar timeout e chamar encerrarTroca,
-				// que enviará um Resultado_Troca vazio ou Erro, que será ignorado
-				// pois já saímos do estado.
-			} else if strings.HasPrefix(line, "ofertar ") {
-				// o usuario quer ofertar uma carta
-				indiceStr := strings.TrimPrefix(line, "ofertar ")
-				// o usuário digita "ofertar 1" (base 1), mas o slice é base 0.
-				indice, err := strconv.Atoi(indiceStr)
-				if err != nil || indice <= 0 || indice > len(minhasCartas) {
-					color.Red("Índice inválido. Digite um número entre 1 e %d.", len(minhasCartas))
+				// o server cancela, solta as cartas e avisa os dois (Resultado_Troca)
+				enviarAcaoTroca("Cancelar", nil, 0, 0)
+				estadoAtual = EstadoEsperandoResposta
+			} else if line == "confirmar" {
+				// confirma a versao q a gnt ta vendo. se o outro mudar a oferta, tem q confirmar de novo
+				enviarAcaoTroca("Confirmar", nil, 0, ofertaTroca.Versao)
+				color.Cyan("Confirmação da versão %d enviada. Aguardando o outro jogador...", ofertaTroca.Versao)
+			} else if line == "ofertar" || strings.HasPrefix(line, "ofertar ") {
+				// a oferta vai inteira: as cartas q a gnt escolheu agora + os creditos q ja tavam ofertados
+				// ("ofertar" sozinho tira todas as cartas)
+				var ids []string
+				valido := true
+				for _, indiceStr := range strings.Fields(strings.TrimPrefix(line, "ofertar")) {
+					// o usuário digita "ofertar 1" (base 1), mas o slice é base 0.
+					indice, err := strconv.Atoi(indiceStr)
+					if err != nil || indice <= 0 || indice > len(minhasCartas) {
+						color.Red("Índice inválido: %s. Digite números entre 1 e %d.", indiceStr, len(minhasCartas))
+						valido = false
+						break
+					}
+					ids = append(ids, minhasCartas[indice-1].Id)
+				}
+				if valido {
+					enviarAcaoTroca("Ofertar", ids, minhaOfertaTroca().Creditos, 0)
+				}
+			} else if strings.HasPrefix(line, "creditos ") {
+				// muda so os creditos, as cartas da oferta continuam
+				creditos, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "creditos ")))
+				if err != nil || creditos < 0 {
+					color.Red("Quantidade inválida.")
 				} else {
-					indiceCartaOfertada = indice - 1 // converte o indice (usuario digita 1, mas o slice eh 0)
-					carta := minhasCartas[indiceCartaOfertada]
-
-					color.Cyan("Ofertando carta: %s (Vida: %d, Ataque: %d)", carta.Modelo, carta.Vida, carta.Ataque)
-
-					// prepara o pacote pra enviar
-					reqTroca := models.ReqCartaTroca{
-						IdRemetente:   idPessoal,
-						CanalResposta: meuCanalResposta,
-						IdTroca:       idTroca,
-						Carta:         carta,
+					var ids []string
+					for _, c := range minhaOfertaTroca().Cartas {
+						ids = append(ids, c.Id)
 					}
-					enviarRequisicaoRedis(canalPessoalServidor, reqTroca) // envia pro server
-
-					// agora eh so esperar o resultado da troca
-					estadoAtual = EstadoEsperandoResposta
+					enviarAcaoTroca("Ofertar", ids, creditos, 0)
 				}
 			} else {
-				color.Red("Comando inválido. Use 'list', 'ofertar <indice>' ou 'cancelar'.")
+				color.Red("Comando inválido. Use 'list', 'ver', 'ofertar <n> [n ...]', 'creditos <qtd>', 'confirmar' ou 'cancelar'.")
 			}
 
+		case EstadoNaFila:
+			// esperando oponente. o "Inicio_Batalha" muda a tela sozinho
+			fmt.Println("Na fila, procurando oponente... Comando Sair_Fila / Ping / Sair: ")
+			line, _ := reader.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if estadoAtual != EstadoNaFila {
+				if line != "" {
+					color.Yellow("Partida encontrada! Digite o comando de novo.")
+				}
+				continue
+			}
+
+			if line == "Sair_Fila" || line == "Sair" {
+				req := models.ReqFila{
+					IdRemetente:   idPessoal,
+					CanalResposta: meuCanalResposta,
+					Acao:          "Sair",
+				}
+				enviarRequisicaoRedis("fila_batalha", req)
+				if line == "Sair" {
+					os.Exit(0)
+				}
+				estadoAtual = EstadoEsperandoResposta
+			} else if strings.HasPrefix(line, "Ping") {
+				if canalUdpServidor == "" {
+					color.Red("Endereço UDP do servidor ainda não recebido.")
+				} else {
+					handleManualPing(reader)
+				}
+			} else if line != "" {
+				color.Red("Comando inválido")
+			}
+
+		case EstadoLogin:
+			// tela de login: entra numa conta q ja existe ou cria uma
+			fmt.Println("Comando Login / Registrar / Sair: ")
+			line, _ := reader.ReadString('\n')
+			line = strings.TrimSpace(line)
+
+			if line == "Sair" {
+				os.Exit(0)
+			}
+			if line != "Login" && line != "Registrar" {
+				color.Red("Comando inválido")
+				continue
+			}
+
+			fmt.Print("Usuário: ")
+			usuario, _ := reader.ReadString('\n')
+			fmt.Print("Senha: ")
+			senha, _ := reader.ReadString('\n')
+			usuario, senha = strings.TrimSpace(usuario), strings.TrimSpace(senha)
+
+			// a senha n sai daqui, entao quem confere o tamanho eh o cliente
+			if line == "Registrar" && len(senha) < assinatura.SenhaMinima {
+				color.Red("A senha precisa ter pelo menos %d caracteres", assinatura.SenhaMinima)
+				continue
+			}
+
+			// manda o "OI, QUERO CONECTAR" com a conta. algum server vivo vai pegar
+			if err := pedirLogin(line, usuario, senha, false); err != nil {
+				color.Red("Falha ao montar o login: %v", err)
+				continue
+			}
+			estadoAtual = EstadoEsperandoResposta
+
 		case EstadoReconectando:
 			// o server caiu
 			color.Yellow("Tentando reconectar a um novo servidor...")
-			// manda um "OI, QUERO CONECTAR" de novo. algum server vivo vai pegar
+			// manda um "OI, QUERO CONECTAR" de novo, so com o token (sem pedir a senha). algum server vivo vai pegar
 			reqConnect := models.ReqConectar{
 				IdRemetente:   idPessoal,
 				CanalResposta: meuCanalResposta,
+				Acao:          "Retomar",
 			}
 			enviarRequisicaoRedis("conectar", reqConnect)
 
@@ -620,18 +1380,48 @@ func main() {
 
 // --- Funções Utilitárias (Jogo) ---
 
-// sorteia 5 cartas do nosso inventario pra levar pra batalha
-func sortearDeck() []models.Tanque {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+// manda uma acao da troca (ofertar, confirmar, cancelar) pro server
+func enviarAcaoTroca(acao string, idsCartas []string, creditos, versao int) {
+	req := models.ReqAcaoTroca{
+		IdRemetente:   idPessoal,
+		CanalResposta: meuCanalResposta,
+		IdTroca:       idTroca,
+		Acao:          acao,
+		IdsCartas:     idsCartas,
+		Creditos:      creditos,
+		Versao:        versao,
+	}
+	enviarRequisicaoRedis(canalPessoalServidor, req)
+}
 
-	n := len(minhasCartas)
-	indices := r.Perm(n)[:5]
+// a nossa oferta no ultimo estado da negociacao (vazia se o server ainda n mandou)
+func minhaOfertaTroca() models.OfertaTroca {
+	for _, o := range ofertaTroca.Ofertas {
+		if o.IdJogador == idPessoal {
+			return o
+		}
+	}
+	return models.OfertaTroca{IdJogador: idPessoal}
+}
 
-	deck := make([]models.Tanque, 0, 5)
-	for _, i := range indices {
-		deck = append(deck, minhasCartas[i])
+// mostra as duas ofertas da troca e quem ja confirmou
+func imprimirOfertaTroca(o models.RespostaOfertaTroca) {
+	color.Magenta("--- Troca (versão %d) ---", o.Versao)
+	if o.Mensagem != "" {
+		color.Magenta(o.Mensagem)
 	}
-	return deck
+	for _, oferta := range o.Ofertas {
+		quem := oferta.IdJogador
+		if quem == idPessoal {
+			quem = "Você"
+		}
+		if slices.Contains(o.Confirmados, oferta.IdJogador) {
+			quem += " (confirmou)"
+		}
+		color.Cyan("%s dá %d créditos e %d carta(s):", quem, oferta.Creditos, len(oferta.Cartas))
+		imprimirTanques(oferta.Cartas)
+	}
+	color.Magenta("Use 'confirmar' para aceitar esta versão.")
 }
 
 // so imprime as cartas de um jeito bonito
@@ -639,12 +1429,40 @@ func imprimirTanques(lista []models.Tanque) {
 	for i, t := range lista {
 		fmt.Printf("Tanque %d:\n", i+1) // i+1 pra ficar base 1 pro usuario (1, 2, 3...)
 		fmt.Printf("  Modelo: %s\n", t.Modelo)
+		if t.Classe != "" {
+			fmt.Printf("  Classe: %s\n", t.Classe)
+		}
+		if t.Raridade != "" {
+			color.Magenta("  Raridade: %s", t.Raridade)
+		}
+		fmt.Printf("  ID: %s\n", t.Id)
 		color.Yellow("  Jogador: %s", t.Id_jogador)
 		color.Green("  Vida: %d", t.Vida)
 		color.Red("  Ataque: %d", t.Ataque)
 	}
 }
 
+// uma linha por anuncio na busca do mercado
+func imprimirAnuncio(a models.Anuncio) {
+	c := a.Carta
+	carta := fmt.Sprintf("%s (%s", c.Modelo, c.Classe)
+	if c.Raridade != "" {
+		carta += ", " + c.Raridade
+	}
+	carta += fmt.Sprintf(") V:%d A:%d", c.Vida, c.Ataque)
+	vence := time.Unix(a.ExpiraEm, 0).Format("02/01 15:04:05")
+
+	if a.Tipo == "Leilao" {
+		lance := fmt.Sprintf("lance mínimo %d", a.Preco)
+		if a.Licitante != "" {
+			lance = fmt.Sprintf("lance atual %d (%s)", a.Lance, a.Licitante)
+		}
+		color.Magenta("  %s | %s | leilão, %s | vence %s | vendedor %s", a.Id, carta, lance, vence, a.Vendedor)
+	} else {
+		color.Green("  %s | %s | preço %d | vence %s | vendedor %s", a.Id, carta, a.Preco, vence, a.Vendedor)
+	}
+}
+
 // --- Funções de Ping UDP (Simplificadas) ---
 
 // o comando "Ping" do menu
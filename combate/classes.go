@@ -0,0 +1,102 @@
+package combate
+
+import (
+	"PlanoZ/models"
+	"math"
+	"strings"
+)
+
+// --- Classes e efetividade ---
+
+// Habilidades de cada classe:
+//   - Leve: chance de desviar do ataque (não leva dano nenhum)
+//   - Pesado: blindagem, reduz uma parte de todo dano recebido
+//   - Médio: se sobreviver ao choque, contra-ataca com uma parte do ataque
+//
+// E a efetividade é pedra-papel-tesoura: Leve flanqueia Pesado, Pesado esmaga Médio, Médio caça Leve.
+const (
+	ChanceEvasaoLeve   = 0.25 // 25% de chance do leve desviar
+	BlindagemPesado    = 0.30 // pesado absorve 30% do dano
+	ContraAtaqueMedio  = 0.30 // médio devolve 30% do ataque dele
+	MultiplicadorForte = 1.5  // ataque super efetivo
+	MultiplicadorFraco = 0.75 // ataque pouco efetivo
+)
+
+// quem é forte contra quem
+var vantagem = map[string]string{
+	models.ClasseLeve:   models.ClassePesado,
+	models.ClassePesado: models.ClasseMedio,
+	models.ClasseMedio:  models.ClasseLeve,
+}
+
+// ClasseDe devolve a classe da carta. Cartas antigas (sem o campo) usam o sufixo do modelo, ex: "Sherman (Medium)".
+func ClasseDe(t models.Tanque) string {
+	if t.Classe != "" {
+		return t.Classe
+	}
+	switch {
+	case strings.Contains(t.Modelo, "(Light)"):
+		return models.ClasseLeve
+	case strings.Contains(t.Modelo, "(Heavy)"):
+		return models.ClassePesado
+	}
+	return models.ClasseMedio
+}
+
+// Efetividade devolve o multiplicador de dano do atacante contra o defensor
+func Efetividade(atacante, defensor string) float64 {
+	switch {
+	case vantagem[atacante] == defensor:
+		return MultiplicadorForte
+	case vantagem[defensor] == atacante:
+		return MultiplicadorFraco
+	}
+	return 1
+}
+
+// Aleatorio é a fonte de sorte do combate (evasão).
+// Em produção é um *rand.Rand; nos testes dá pra passar um com seed fixa (ou um fake).
+type Aleatorio interface {
+	Float64() float64
+}
+
+// Ataque descreve um lado do choque (quem ataca quem)
+type Ataque struct {
+	Efetividade  float64 // multiplicador da tabela de classes
+	Evadiu       bool    // o defensor (leve) desviou
+	Absorvido    int     // quanto a blindagem do defensor (pesado) segurou
+	Dano         int     // dano que entrou de fato
+	ContraAtaque int     // dano extra do contra-ataque (se o atacante for médio e sobreviver)
+}
+
+// calcularAtaque aplica efetividade, evasão e blindagem num ataque
+func calcularAtaque(atacante, defensor models.Tanque, sorte Aleatorio) Ataque {
+	classeAtq, classeDef := ClasseDe(atacante), ClasseDe(defensor)
+	a := Ataque{Efetividade: Efetividade(classeAtq, classeDef)}
+
+	if classeDef == models.ClasseLeve && sorte.Float64() < ChanceEvasaoLeve {
+		a.Evadiu = true
+		return a
+	}
+
+	bruto := float64(atacante.Ataque) * a.Efetividade
+	dano := bruto
+	if classeDef == models.ClassePesado {
+		dano = bruto * (1 - BlindagemPesado)
+	}
+	a.Dano = max(1, int(math.Round(dano)))
+	a.Absorvido = max(0, int(math.Round(bruto))-a.Dano)
+	return a
+}
+
+// contraAtaque é o dano extra do médio que sobreviveu (a blindagem do pesado também segura)
+func contraAtaque(atacante, defensor models.Tanque) int {
+	if ClasseDe(atacante) != models.ClasseMedio || atacante.Vida <= 0 {
+		return 0
+	}
+	dano := float64(atacante.Ataque) * ContraAtaqueMedio
+	if ClasseDe(defensor) == models.ClassePesado {
+		dano *= 1 - BlindagemPesado
+	}
+	return max(1, int(math.Round(dano)))
+}
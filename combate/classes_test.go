@@ -0,0 +1,160 @@
+package combate
+
+import (
+	"PlanoZ/models"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEfetividade(t *testing.T) {
+	casos := []struct {
+		atacante, defensor string
+		mult               float64
+	}{
+		{models.ClasseLeve, models.ClassePesado, MultiplicadorForte},
+		{models.ClassePesado, models.ClasseMedio, MultiplicadorForte},
+		{models.ClasseMedio, models.ClasseLeve, MultiplicadorForte},
+		{models.ClassePesado, models.ClasseLeve, MultiplicadorFraco},
+		{models.ClasseMedio, models.ClassePesado, MultiplicadorFraco},
+		{models.ClasseLeve, models.ClasseMedio, MultiplicadorFraco},
+		{models.ClasseLeve, models.ClasseLeve, 1},
+		{models.ClasseMedio, models.ClasseMedio, 1},
+		{models.ClassePesado, models.ClassePesado, 1},
+	}
+	for _, c := range casos {
+		if m := Efetividade(c.atacante, c.defensor); m != c.mult {
+			t.Errorf("%s contra %s devia ser %.2f, foi %.2f", c.atacante, c.defensor, c.mult, m)
+		}
+	}
+	if MultiplicadorForte != 1.5 || MultiplicadorFraco != 0.75 {
+		t.Fatalf("multiplicadores mudaram: %.2f/%.2f", MultiplicadorForte, MultiplicadorFraco)
+	}
+}
+
+func TestAtaqueComMultiplicador(t *testing.T) {
+	casos := []struct {
+		nome      string
+		atacante  models.Tanque
+		defensor  models.Tanque
+		dano      int
+		absorvido int
+	}{
+		{"médio caça leve", tanque("a", models.ClasseMedio, 100, 40), tanque("b", models.ClasseLeve, 100, 1), 60, 0},
+		{"leve contra médio", tanque("a", models.ClasseLeve, 100, 40), tanque("b", models.ClasseMedio, 100, 1), 30, 0},
+		{"neutro", tanque("a", models.ClasseMedio, 100, 40), tanque("b", models.ClasseMedio, 100, 1), 40, 0},
+		// efetividade primeiro, blindagem dps: 40 * 1.5 = 60, o pesado segura 18
+		{"leve flanqueia pesado", tanque("a", models.ClasseLeve, 100, 40), tanque("b", models.ClassePesado, 100, 1), 42, 18},
+		{"médio contra pesado", tanque("a", models.ClasseMedio, 100, 40), tanque("b", models.ClassePesado, 100, 1), 21, 9},
+		{"dano mínimo", tanque("a", models.ClasseMedio, 100, 1), tanque("b", models.ClassePesado, 100, 1), 1, 0},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			a := calcularAtaque(c.atacante, c.defensor, semEvasao())
+			if a.Dano != c.dano || a.Absorvido != c.absorvido {
+				t.Fatalf("devia dar %d de dano (%d absorvido), deu %d (%d)", c.dano, c.absorvido, a.Dano, a.Absorvido)
+			}
+			if a.Efetividade != Efetividade(ClasseDe(c.atacante), ClasseDe(c.defensor)) {
+				t.Fatalf("efetividade errada no ataque: %.2f", a.Efetividade)
+			}
+		})
+	}
+}
+
+func TestEvasaoLeve(t *testing.T) {
+	atacante := tanque("a", models.ClassePesado, 100, 40)
+	leve := tanque("b", models.ClasseLeve, 100, 40)
+
+	// a sorte abaixo de 25% desvia, a partir dela n
+	if a := calcularAtaque(atacante, leve, &sorteFixa{valores: []float64{ChanceEvasaoLeve - 0.001}}); !a.Evadiu || a.Dano != 0 {
+		t.Fatalf("devia desviar, veio %+v", a)
+	}
+	if a := calcularAtaque(atacante, leve, &sorteFixa{valores: []float64{ChanceEvasaoLeve}}); a.Evadiu || a.Dano == 0 {
+		t.Fatalf("n devia desviar, veio %+v", a)
+	}
+
+	// só o leve desvia: contra os outros a sorte nem é consultada
+	for _, classe := range []string{models.ClasseMedio, models.ClassePesado} {
+		sorte := &sorteFixa{valores: []float64{0}}
+		if a := calcularAtaque(atacante, tanque("c", classe, 100, 40), sorte); a.Evadiu {
+			t.Fatalf("%s n devia desviar", classe)
+		}
+		if sorte.i != 0 {
+			t.Fatalf("ataque contra %s consultou a sorte", classe)
+		}
+	}
+
+	// com seed fixa, a taxa fica perto dos 25%
+	sorte := rand.New(rand.NewSource(42))
+	const n = 20000
+	desvios := 0
+	for i := 0; i < n; i++ {
+		if calcularAtaque(atacante, leve, sorte).Evadiu {
+			desvios++
+		}
+	}
+	if taxa := float64(desvios) / n; math.Abs(taxa-ChanceEvasaoLeve) > 0.01 {
+		t.Fatalf("taxa de evasão devia ser %.2f, foi %.3f", ChanceEvasaoLeve, taxa)
+	}
+}
+
+func TestBlindagemPesado(t *testing.T) {
+	pesado := tanque("b", models.ClassePesado, 100, 40)
+	for _, ataque := range []int{10, 40, 100} {
+		a := calcularAtaque(tanque("a", models.ClassePesado, 100, ataque), pesado, semEvasao())
+		esperado := int(math.Round(float64(ataque) * (1 - BlindagemPesado)))
+		if a.Dano != esperado || a.Absorvido != ataque-esperado {
+			t.Fatalf("ataque %d: devia entrar %d e segurar %d, entrou %d e segurou %d",
+				ataque, esperado, ataque-esperado, a.Dano, a.Absorvido)
+		}
+	}
+	if BlindagemPesado != 0.30 {
+		t.Fatalf("blindagem mudou: %.2f", BlindagemPesado)
+	}
+}
+
+func TestContraAtaqueMedio(t *testing.T) {
+	casos := []struct {
+		nome     string
+		atacante models.Tanque
+		defensor models.Tanque
+		dano     int
+	}{
+		{"médio vivo", tanque("a", models.ClasseMedio, 10, 40), tanque("b", models.ClasseLeve, 10, 1), 12},
+		{"blindagem segura o contra-ataque", tanque("a", models.ClasseMedio, 10, 40), tanque("b", models.ClassePesado, 10, 1), 8},
+		{"mínimo 1", tanque("a", models.ClasseMedio, 10, 1), tanque("b", models.ClasseMedio, 10, 1), 1},
+		{"médio destruído", tanque("a", models.ClasseMedio, 0, 40), tanque("b", models.ClasseLeve, 10, 1), 0},
+		{"leve n contra-ataca", tanque("a", models.ClasseLeve, 10, 40), tanque("b", models.ClasseMedio, 10, 1), 0},
+		{"pesado n contra-ataca", tanque("a", models.ClassePesado, 10, 40), tanque("b", models.ClasseMedio, 10, 1), 0},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			if d := contraAtaque(c.atacante, c.defensor); d != c.dano {
+				t.Fatalf("contra-ataque devia ser %d, foi %d", c.dano, d)
+			}
+		})
+	}
+	if ContraAtaqueMedio != 0.30 {
+		t.Fatalf("contra-ataque mudou: %.2f", ContraAtaqueMedio)
+	}
+}
+
+func TestClasseDe(t *testing.T) {
+	casos := []struct {
+		tanque models.Tanque
+		classe string
+	}{
+		{models.Tanque{Modelo: "M24 Chaffee", Classe: models.ClasseLeve}, models.ClasseLeve},
+		{models.Tanque{Modelo: "Stuart (Light)"}, models.ClasseLeve},
+		{models.Tanque{Modelo: "Tiger (Heavy)"}, models.ClassePesado},
+		{models.Tanque{Modelo: "Sherman (Medium)"}, models.ClasseMedio},
+		{models.Tanque{Modelo: "Sem sufixo"}, models.ClasseMedio},
+		// o campo ganha do sufixo
+		{models.Tanque{Modelo: "Tiger (Heavy)", Classe: models.ClasseLeve}, models.ClasseLeve},
+	}
+	for _, c := range casos {
+		if classe := ClasseDe(c.tanque); classe != c.classe {
+			t.Errorf("%q devia ser %s, foi %s", c.tanque.Modelo, c.classe, classe)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package combate tem as regras da batalha do PlanoZ, sem nada de rede.
+//
+// A cada turno os dois jogadores escolhem, ao mesmo tempo, uma carta da mão.
+// As duas cartas se enfrentam (as duas causam dano ao mesmo tempo, com as habilidades
+// de classe e a tabela de efetividade de classes.go), quem chega a 0 de vida
+// é destruída e quem sobrevive volta pra mão com a vida que sobrou.
+// Perde quem ficar sem cartas na mão.
+package combate
+
+import (
+	"PlanoZ/models"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	TamanhoDeck = 5  // cartas de cada jogador por partida
+	MaxTurnos   = 30 // depois disso ganha quem tiver mais vida somada na mão
+
+	Empate = -1 // valor de 'vencedor' qnd ninguém ganha
+)
+
+var (
+	ErrCartaForaDaMao = errors.New("carta não está na mão do jogador")
+	ErrPartidaAcabou  = errors.New("a partida já acabou")
+)
+
+// Partida é o estado do jogo. Jogador 0 é o J1 e jogador 1 é o J2.
+type Partida struct {
+	Maos  [2][]models.Tanque // cartas que ainda estão vivas (com a vida atual)
+	Turno int                // quantos turnos já foram resolvidos
+	Sorte Aleatorio          // usada na evasão do leve (troque por uma com seed fixa pra testar)
+}
+
+// ResultadoTurno descreve o que aconteceu num turno
+type ResultadoTurno struct {
+	Turno      int
+	Cartas     [2]models.Tanque // as cartas que lutaram, já com a vida depois do dano
+	Ataques    [2]Ataque        // o ataque que a carta de cada jogador fez (efetividade, evasão, blindagem...)
+	Dano       [2]int           // dano total que a carta de cada jogador causou (com contra-ataque)
+	Destruidas [2]bool          // se a carta de cada jogador foi destruída
+}
+
+// NovaPartida começa uma partida com os decks (copiados, a partida não mexe nos slices originais)
+func NovaPartida(deck1, deck2 []models.Tanque) *Partida {
+	return &Partida{
+		Maos:  [2][]models.Tanque{copiar(deck1), copiar(deck2)},
+		Sorte: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// RetomarPartida recria a partida a partir de um estado salvo (ex: checkpoint no redis)
+func RetomarPartida(mao1, mao2 []models.Tanque, turno int) *Partida {
+	p := NovaPartida(mao1, mao2)
+	p.Turno = turno
+	return p
+}
+
+// Mao devolve uma cópia da mão do jogador (0 ou 1)
+func (p *Partida) Mao(jogador int) []models.Tanque {
+	return copiar(p.Maos[jogador])
+}
+
+// ValidarEscolha confere se a carta escolhida está na mão do jogador
+func (p *Partida) ValidarEscolha(jogador int, idCarta string) error {
+	if acabou, _ := p.Terminou(); acabou {
+		return ErrPartidaAcabou
+	}
+	if p.indiceNaMao(jogador, idCarta) < 0 {
+		return fmt.Errorf("%w: %s", ErrCartaForaDaMao, idCarta)
+	}
+	return nil
+}
+
+// ResolverTurno aplica as escolhas dos dois jogadores (ids das cartas)
+func (p *Partida) ResolverTurno(idCarta1, idCarta2 string) (ResultadoTurno, error) {
+	if err := p.ValidarEscolha(0, idCarta1); err != nil {
+		return ResultadoTurno{}, fmt.Errorf("J1: %w", err)
+	}
+	if err := p.ValidarEscolha(1, idCarta2); err != nil {
+		return ResultadoTurno{}, fmt.Errorf("J2: %w", err)
+	}
+
+	i1 := p.indiceNaMao(0, idCarta1)
+	i2 := p.indiceNaMao(1, idCarta2)
+	c1 := &p.Maos[0][i1]
+	c2 := &p.Maos[1][i2]
+
+	res := ResultadoTurno{Turno: p.Turno}
+
+	if p.Sorte == nil {
+		p.Sorte = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	// as duas atacam ao mesmo tempo (calcula os dois antes de aplicar)
+	res.Ataques[0] = calcularAtaque(*c1, *c2, p.Sorte)
+	res.Ataques[1] = calcularAtaque(*c2, *c1, p.Sorte)
+	c2.Vida -= res.Ataques[0].Dano
+	c1.Vida -= res.Ataques[1].Dano
+
+	// médio que sobreviveu contra-ataca (só se o outro ainda estiver de pé)
+	if c2.Vida > 0 {
+		res.Ataques[0].ContraAtaque = contraAtaque(*c1, *c2)
+	}
+	if c1.Vida > 0 {
+		res.Ataques[1].ContraAtaque = contraAtaque(*c2, *c1)
+	}
+	c2.Vida -= res.Ataques[0].ContraAtaque
+	c1.Vida -= res.Ataques[1].ContraAtaque
+
+	res.Dano[0] = res.Ataques[0].Dano + res.Ataques[0].ContraAtaque
+	res.Dano[1] = res.Ataques[1].Dano + res.Ataques[1].ContraAtaque
+
+	res.Cartas = [2]models.Tanque{*c1, *c2}
+	res.Destruidas = [2]bool{c1.Vida <= 0, c2.Vida <= 0}
+
+	// tira as destruídas da mão
+	if res.Destruidas[0] {
+		p.remover(0, i1)
+	}
+	if res.Destruidas[1] {
+		p.remover(1, i2)
+	}
+
+	p.Turno++
+	return res, nil
+}
+
+// Terminou diz se a partida acabou e quem venceu (0, 1 ou Empate)
+func (p *Partida) Terminou() (bool, int) {
+	vazia1 := len(p.Maos[0]) == 0
+	vazia2 := len(p.Maos[1]) == 0
+	switch {
+	case vazia1 && vazia2:
+		return true, Empate
+	case vazia1:
+		return true, 1
+	case vazia2:
+		return true, 0
+	}
+
+	if p.Turno >= MaxTurnos {
+		vida1, vida2 := VidaTotal(p.Maos[0]), VidaTotal(p.Maos[1])
+		switch {
+		case vida1 > vida2:
+			return true, 0
+		case vida2 > vida1:
+			return true, 1
+		}
+		return true, Empate
+	}
+	return false, Empate
+}
+
+// VidaTotal soma a vida das cartas
+func VidaTotal(cartas []models.Tanque) int {
+	total := 0
+	for _, c := range cartas {
+		total += c.Vida
+	}
+	return total
+}
+
+func (p *Partida) indiceNaMao(jogador int, idCarta string) int {
+	if idCarta == "" {
+		return -1
+	}
+	for i, c := range p.Maos[jogador] {
+		if c.Id == idCarta {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Partida) remover(jogador, i int) {
+	mao := p.Maos[jogador]
+	p.Maos[jogador] = append(mao[:i:i], mao[i+1:]...)
+}
+
+func copiar(cartas []models.Tanque) []models.Tanque {
+	return append([]models.Tanque(nil), cartas...)
+}
+
+// Descrever monta o texto do turno do ponto de vista de um jogador (0 ou 1)
+func Descrever(res ResultadoTurno, jogador int) string {
+	eu, ele := jogador, 1-jogador
+	minha, dele := res.Cartas[eu], res.Cartas[ele]
+
+	texto := fmt.Sprintf("Turno %d: seu %s (%s) enfrentou o %s (%s) do oponente.",
+		res.Turno, minha.Modelo, ClasseDe(minha), dele.Modelo, ClasseDe(dele))
+	texto += " Você: " + descreverAtaque(res.Ataques[eu], dele.Modelo)
+	texto += " Oponente: " + descreverAtaque(res.Ataques[ele], "seu "+minha.Modelo)
+
+	switch {
+	case res.Destruidas[eu] && res.Destruidas[ele]:
+		texto += " Os dois tanques foram destruídos!"
+	case res.Destruidas[eu]:
+		texto += fmt.Sprintf(" Seu %s foi destruído!", minha.Modelo)
+	case res.Destruidas[ele]:
+		texto += fmt.Sprintf(" O %s do oponente foi destruído!", dele.Modelo)
+	}
+	return texto
+}
+
+// descreverAtaque explica um lado do choque, ex: "super efetivo! 42 de dano (blindagem segurou 18)."
+func descreverAtaque(a Ataque, alvo string) string {
+	if a.Evadiu {
+		return fmt.Sprintf("o %s desviou do ataque!", alvo)
+	}
+
+	texto := ""
+	switch a.Efetividade {
+	case MultiplicadorForte:
+		texto = "super efetivo! "
+	case MultiplicadorFraco:
+		texto = "pouco efetivo... "
+	}
+	texto += fmt.Sprintf("%d de dano", a.Dano)
+	if a.Absorvido > 0 {
+		texto += fmt.Sprintf(" (a blindagem segurou %d)", a.Absorvido)
+	}
+	if a.ContraAtaque > 0 {
+		texto += fmt.Sprintf(" + contra-ataque de %d", a.ContraAtaque)
+	}
+	return texto + "."
+}
@@ -0,0 +1,272 @@
+package combate
+
+import (
+	"PlanoZ/models"
+	"errors"
+	"testing"
+)
+
+// sorteFixa devolve os valores em sequência (e repete o último), pra evasão ser previsível
+type sorteFixa struct {
+	valores []float64
+	i       int
+}
+
+func (s *sorteFixa) Float64() float64 {
+	v := s.valores[min(s.i, len(s.valores)-1)]
+	s.i++
+	return v
+}
+
+// nunca cai abaixo de ChanceEvasaoLeve: ninguém desvia
+func semEvasao() *sorteFixa { return &sorteFixa{valores: []float64{0.99}} }
+
+func tanque(id, classe string, vida, ataque int) models.Tanque {
+	return models.Tanque{Id: id, Modelo: id, Classe: classe, Vida: vida, Ataque: ataque}
+}
+
+func partidaDeTeste(deck1, deck2 []models.Tanque, sorte Aleatorio) *Partida {
+	p := NovaPartida(deck1, deck2)
+	p.Sorte = sorte
+	return p
+}
+
+func TestNovaPartidaCopiaDecks(t *testing.T) {
+	deck1 := []models.Tanque{tanque("a", models.ClasseMedio, 100, 40)}
+	deck2 := []models.Tanque{tanque("b", models.ClasseMedio, 100, 40)}
+
+	p := partidaDeTeste(deck1, deck2, semEvasao())
+	if p.Turno != 0 {
+		t.Fatalf("partida nova devia começar no turno 0, ta no %d", p.Turno)
+	}
+	if _, err := p.ResolverTurno("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if deck1[0].Vida != 100 || deck2[0].Vida != 100 {
+		t.Fatalf("a partida mexeu nos decks originais: %d/%d", deck1[0].Vida, deck2[0].Vida)
+	}
+
+	// a mão devolvida também é cópia
+	mao := p.Mao(0)
+	mao[0].Vida = 1
+	if p.Maos[0][0].Vida == 1 {
+		t.Fatal("Mao devia devolver uma cópia")
+	}
+}
+
+func TestValidarEscolha(t *testing.T) {
+	casos := []struct {
+		nome    string
+		mao1    []models.Tanque
+		mao2    []models.Tanque
+		jogador int
+		carta   string
+		erro    error
+	}{
+		{"carta na mão", []models.Tanque{tanque("a", models.ClasseLeve, 10, 1)}, []models.Tanque{tanque("b", models.ClasseLeve, 10, 1)}, 0, "a", nil},
+		{"carta do j2", []models.Tanque{tanque("a", models.ClasseLeve, 10, 1)}, []models.Tanque{tanque("b", models.ClasseLeve, 10, 1)}, 1, "b", nil},
+		{"carta do oponente", []models.Tanque{tanque("a", models.ClasseLeve, 10, 1)}, []models.Tanque{tanque("b", models.ClasseLeve, 10, 1)}, 0, "b", ErrCartaForaDaMao},
+		{"carta inexistente", []models.Tanque{tanque("a", models.ClasseLeve, 10, 1)}, []models.Tanque{tanque("b", models.ClasseLeve, 10, 1)}, 1, "z", ErrCartaForaDaMao},
+		{"sem escolha", []models.Tanque{tanque("a", models.ClasseLeve, 10, 1)}, []models.Tanque{tanque("b", models.ClasseLeve, 10, 1)}, 0, "", ErrCartaForaDaMao},
+		{"partida acabou", []models.Tanque{tanque("a", models.ClasseLeve, 10, 1)}, nil, 0, "a", ErrPartidaAcabou},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			p := partidaDeTeste(c.mao1, c.mao2, semEvasao())
+			err := p.ValidarEscolha(c.jogador, c.carta)
+			if c.erro == nil && err != nil {
+				t.Fatalf("n devia dar erro: %v", err)
+			}
+			if c.erro != nil && !errors.Is(err, c.erro) {
+				t.Fatalf("devia dar %v, deu %v", c.erro, err)
+			}
+		})
+	}
+}
+
+func TestResolverTurno(t *testing.T) {
+	casos := []struct {
+		nome       string
+		carta1     models.Tanque
+		carta2     models.Tanque
+		sorte      []float64
+		vida       [2]int
+		dano       [2]int
+		destruidas [2]bool
+	}{
+		{
+			// 40 de dano pra cada lado, os dois sobrevivem e contra-atacam com 12
+			nome:   "medio contra medio",
+			carta1: tanque("a", models.ClasseMedio, 100, 40),
+			carta2: tanque("b", models.ClasseMedio, 100, 40),
+			sorte:  []float64{0.99},
+			vida:   [2]int{48, 48},
+			dano:   [2]int{52, 52},
+		},
+		{
+			// os dois golpes saem ao mesmo tempo: o j1 morre mas o dano dele entra,
+			// e morto n contra-ataca (nem leva contra-ataque)
+			nome:       "choque simultâneo",
+			carta1:     tanque("a", models.ClasseMedio, 30, 40),
+			carta2:     tanque("b", models.ClasseMedio, 100, 50),
+			sorte:      []float64{0.99},
+			vida:       [2]int{-20, 60},
+			dano:       [2]int{40, 50},
+			destruidas: [2]bool{true, false},
+		},
+		{
+			nome:       "os dois destruídos",
+			carta1:     tanque("a", models.ClassePesado, 30, 50),
+			carta2:     tanque("b", models.ClassePesado, 30, 50),
+			sorte:      []float64{0.99},
+			vida:       [2]int{-5, -5},
+			dano:       [2]int{35, 35},
+			destruidas: [2]bool{true, true},
+		},
+		{
+			// só o j2 é leve, entao só o ataque do j1 consome a sorte: 0.1 < 25% desvia.
+			// o leve leva só o contra-ataque (12), e bate pouco efetivo no médio (20 * 0.75)
+			nome:   "leve desvia",
+			carta1: tanque("a", models.ClasseMedio, 100, 40),
+			carta2: tanque("b", models.ClasseLeve, 100, 20),
+			sorte:  []float64{0.1},
+			vida:   [2]int{85, 88},
+			dano:   [2]int{12, 15},
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			reserva1 := tanque("r1", models.ClasseMedio, 10, 1)
+			reserva2 := tanque("r2", models.ClasseMedio, 10, 1)
+			p := partidaDeTeste([]models.Tanque{c.carta1, reserva1}, []models.Tanque{c.carta2, reserva2}, &sorteFixa{valores: c.sorte})
+
+			res, err := p.ResolverTurno(c.carta1.Id, c.carta2.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Turno != 0 || p.Turno != 1 {
+				t.Fatalf("resultado devia ser do turno 0 e a partida ir pro 1, veio %d/%d", res.Turno, p.Turno)
+			}
+			if res.Cartas[0].Vida != c.vida[0] || res.Cartas[1].Vida != c.vida[1] {
+				t.Fatalf("vida devia ficar %v, ficou [%d %d]", c.vida, res.Cartas[0].Vida, res.Cartas[1].Vida)
+			}
+			if res.Dano != c.dano {
+				t.Fatalf("dano devia ser %v, foi %v", c.dano, res.Dano)
+			}
+			if res.Destruidas != c.destruidas {
+				t.Fatalf("destruídas devia ser %v, foi %v", c.destruidas, res.Destruidas)
+			}
+
+			// destruída sai da mão, sobrevivente fica com a vida nova
+			for j := 0; j < 2; j++ {
+				i := p.indiceNaMao(j, res.Cartas[j].Id)
+				if c.destruidas[j] {
+					if i >= 0 {
+						t.Fatalf("carta destruída do jogador %d continua na mão", j)
+					}
+					if len(p.Maos[j]) != 1 {
+						t.Fatalf("jogador %d devia ficar só com a reserva, tem %d cartas", j, len(p.Maos[j]))
+					}
+					continue
+				}
+				if i < 0 || p.Maos[j][i].Vida != c.vida[j] {
+					t.Fatalf("carta do jogador %d devia voltar pra mão com %d de vida", j, c.vida[j])
+				}
+			}
+		})
+	}
+}
+
+func TestResolverTurnoEscolhaInvalida(t *testing.T) {
+	p := partidaDeTeste(
+		[]models.Tanque{tanque("a", models.ClasseMedio, 100, 40)},
+		[]models.Tanque{tanque("b", models.ClasseMedio, 100, 40)},
+		semEvasao(),
+	)
+	if _, err := p.ResolverTurno("a", "a"); !errors.Is(err, ErrCartaForaDaMao) {
+		t.Fatalf("carta do oponente devia ser recusada, deu %v", err)
+	}
+	if p.Turno != 0 || p.Maos[0][0].Vida != 100 || p.Maos[1][0].Vida != 100 {
+		t.Fatal("escolha inválida n pode mexer na partida")
+	}
+}
+
+func TestTerminou(t *testing.T) {
+	uma := func(vida int) []models.Tanque { return []models.Tanque{tanque("x", models.ClasseMedio, vida, 1)} }
+	casos := []struct {
+		nome     string
+		mao1     []models.Tanque
+		mao2     []models.Tanque
+		turno    int
+		acabou   bool
+		vencedor int
+	}{
+		{"em andamento", uma(10), uma(10), 0, false, Empate},
+		{"j1 sem cartas", nil, uma(10), 3, true, 1},
+		{"j2 sem cartas", uma(10), nil, 3, true, 0},
+		{"os dois sem cartas", nil, nil, 3, true, Empate},
+		{"ultimo turno antes do limite", uma(10), uma(50), MaxTurnos - 1, false, Empate},
+		{"limite com j1 na frente", uma(50), uma(10), MaxTurnos, true, 0},
+		{"limite com j2 na frente", uma(10), uma(50), MaxTurnos, true, 1},
+		{"limite empatado", uma(30), uma(30), MaxTurnos, true, Empate},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			p := RetomarPartida(c.mao1, c.mao2, c.turno)
+			acabou, vencedor := p.Terminou()
+			if acabou != c.acabou || vencedor != c.vencedor {
+				t.Fatalf("devia ser (%v, %d), foi (%v, %d)", c.acabou, c.vencedor, acabou, vencedor)
+			}
+		})
+	}
+}
+
+func TestRetomarPartida(t *testing.T) {
+	mao1 := []models.Tanque{tanque("a", models.ClasseMedio, 48, 40)}
+	mao2 := []models.Tanque{tanque("b", models.ClasseMedio, 48, 40)}
+
+	p := RetomarPartida(mao1, mao2, 7)
+	p.Sorte = semEvasao()
+	if p.Turno != 7 {
+		t.Fatalf("devia continuar do turno 7, ta no %d", p.Turno)
+	}
+
+	// continua com a vida salva: 48 - 40 = 8, contra-ataque de 12 destrói os dois
+	res, err := p.ResolverTurno("a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Turno != 7 || res.Destruidas != [2]bool{true, true} {
+		t.Fatalf("turno 7 devia destruir os dois, veio turno %d %v", res.Turno, res.Destruidas)
+	}
+	if acabou, vencedor := p.Terminou(); !acabou || vencedor != Empate {
+		t.Fatalf("devia acabar empatado, veio (%v, %d)", acabou, vencedor)
+	}
+	if mao1[0].Vida != 48 {
+		t.Fatal("retomar n pode mexer nas mãos salvas")
+	}
+}
+
+func TestPartidaCompleta(t *testing.T) {
+	// o pesado do j1 esmaga os médios (60 * 1.5 = 90 por turno) e o j2 fica sem cartas no turno 2
+	p := partidaDeTeste(
+		[]models.Tanque{tanque("p", models.ClassePesado, 200, 60)},
+		[]models.Tanque{tanque("m1", models.ClasseMedio, 90, 40), tanque("m2", models.ClasseMedio, 80, 40)},
+		semEvasao(),
+	)
+	for _, id := range []string{"m1", "m2"} {
+		if acabou, _ := p.Terminou(); acabou {
+			t.Fatalf("acabou antes da hora no turno %d", p.Turno)
+		}
+		if _, err := p.ResolverTurno("p", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acabou, vencedor := p.Terminou()
+	if !acabou || vencedor != 0 {
+		t.Fatalf("j1 devia vencer, veio (%v, %d)", acabou, vencedor)
+	}
+	if err := p.ValidarEscolha(0, "p"); !errors.Is(err, ErrPartidaAcabou) {
+		t.Fatalf("depois do fim n aceita escolha, deu %v", err)
+	}
+}
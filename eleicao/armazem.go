@@ -0,0 +1,142 @@
+package eleicao
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Armazém no Redis (produção) ---
+
+// ArmazemRedis guarda o contador de termos e o lease no Redis.
+// As duas chaves devem ficar no mesmo slot do estoque, pro termo servir de fencing token nos scripts.
+type ArmazemRedis struct {
+	Cliente    redis.UniversalClient
+	ChaveTermo string // ex: "{inventario}:termo_lider"
+	ChaveLease string // ex: "{inventario}:lease_lider"
+}
+
+// renova só se o valor ainda for o nosso (ninguém pegou o lease depois que ele expirou)
+var scriptRenovarLease = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// libera só se ainda for o dono (ver valorLease)
+var scriptLiberarLease = redis.NewScript(`
+local atual = redis.call('GET', KEYS[1])
+if atual and string.sub(atual, 1, string.len(ARGV[1]) + 1) == ARGV[1] .. '|' then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (a *ArmazemRedis) ProximoTermo(ctx context.Context) (int64, error) {
+	return a.Cliente.Incr(ctx, a.ChaveTermo).Result()
+}
+
+func (a *ArmazemRedis) AdquirirLease(ctx context.Context, id string, termo int64, ttl time.Duration) (bool, error) {
+	return a.Cliente.SetNX(ctx, a.ChaveLease, valorLease(id, termo), ttl).Result()
+}
+
+func (a *ArmazemRedis) RenovarLease(ctx context.Context, id string, termo int64, ttl time.Duration) (bool, error) {
+	res, err := scriptRenovarLease.Run(ctx, a.Cliente, []string{a.ChaveLease}, valorLease(id, termo), ttl.Milliseconds()).Int()
+	return res == 1, err
+}
+
+func (a *ArmazemRedis) LerLease(ctx context.Context) (string, int64, bool, error) {
+	valor, err := a.Cliente.Get(ctx, a.ChaveLease).Result()
+	if err == redis.Nil {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+	id, termo, ok := lerValorLease(valor)
+	return id, termo, ok, nil
+}
+
+func (a *ArmazemRedis) LiberarLease(ctx context.Context, id string) error {
+	return scriptLiberarLease.Run(ctx, a.Cliente, []string{a.ChaveLease}, id).Err()
+}
+
+// --- Armazém em memória (fake pra testes) ---
+
+// ArmazemMemoria implementa o Armazem dentro do próprio processo.
+// Serve pra testar os eleitores sem Redis: vários "servidores" podem dividir a mesma instância,
+// e o relógio pode ser trocado (Agora) pra simular o TTL expirando.
+type ArmazemMemoria struct {
+	Agora func() time.Time // se for nil, usa time.Now
+
+	mu         sync.Mutex
+	termo      int64
+	valorLease string
+	expiraEm   time.Time
+}
+
+func NovoArmazemMemoria() *ArmazemMemoria {
+	return &ArmazemMemoria{}
+}
+
+func (a *ArmazemMemoria) agora() time.Time {
+	if a.Agora != nil {
+		return a.Agora()
+	}
+	return time.Now()
+}
+
+// leaseAtivo precisa ser chamado com o mutex travado
+func (a *ArmazemMemoria) leaseAtivo() bool {
+	return a.valorLease != "" && a.agora().Before(a.expiraEm)
+}
+
+func (a *ArmazemMemoria) ProximoTermo(ctx context.Context) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.termo++
+	return a.termo, nil
+}
+
+func (a *ArmazemMemoria) AdquirirLease(ctx context.Context, id string, termo int64, ttl time.Duration) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.leaseAtivo() {
+		return false, nil
+	}
+	a.valorLease = valorLease(id, termo)
+	a.expiraEm = a.agora().Add(ttl)
+	return true, nil
+}
+
+func (a *ArmazemMemoria) RenovarLease(ctx context.Context, id string, termo int64, ttl time.Duration) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.leaseAtivo() || a.valorLease != valorLease(id, termo) {
+		return false, nil
+	}
+	a.expiraEm = a.agora().Add(ttl)
+	return true, nil
+}
+
+func (a *ArmazemMemoria) LerLease(ctx context.Context) (string, int64, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.leaseAtivo() {
+		return "", 0, false, nil
+	}
+	id, termo, ok := lerValorLease(a.valorLease)
+	return id, termo, ok, nil
+}
+
+func (a *ArmazemMemoria) LiberarLease(ctx context.Context, id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if dono, _, ok := lerValorLease(a.valorLease); ok && dono == id {
+		a.valorLease = ""
+	}
+	return nil
+}
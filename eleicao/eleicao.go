@@ -0,0 +1,93 @@
+// Package eleicao tem os algoritmos de eleição de líder do PlanoZ.
+//
+// Os algoritmos não conhecem HTTP nem o Server: eles só falam com um Armazem
+// (Redis em produção, memória nos testes) e devolvem quem é o líder e o termo dele.
+// Quem aplica o resultado (anunciar, aceitar, sair da liderança) é o servidor.
+package eleicao
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Modos aceitos na variável de ambiente ELECTION_MODE
+const (
+	ModoHealthCheck = "healthcheck" // menor ID vivo (health check via HTTP)
+	ModoLease       = "lease"       // chave com TTL no Redis (SET NX PX + renovação)
+)
+
+// Resultado de uma rodada de eleição.
+// Termo == 0 significa "líder ainda não confirmado" (esperando o anúncio dele).
+type Resultado struct {
+	Lider string
+	Termo int64
+}
+
+// Eleitor é a estratégia de eleição que o servidor usa.
+type Eleitor interface {
+	// Nome do algoritmo (pros logs)
+	Nome() string
+
+	// Eleger roda uma eleição. 'vivos' são os servidores que responderam ao health check
+	// (o próprio servidor é sempre considerado vivo).
+	Eleger(ctx context.Context, meuID string, vivos map[string]bool) (Resultado, error)
+
+	// Manter é chamado a cada IntervaloManter. Renova/confere a liderança.
+	// Se 'mudou' for false, não tem resultado novo pra aplicar.
+	Manter(ctx context.Context, meuID string, souLider bool) (res Resultado, mudou bool, err error)
+
+	// IntervaloManter é de quanto em quanto tempo chamar Manter (0 = não precisa)
+	IntervaloManter() time.Duration
+
+	// UsaHealthCheck diz se a queda do líder detectada pelo health check deve disparar Eleger.
+	UsaHealthCheck() bool
+}
+
+// Armazem é o estado compartilhado que os eleitores usam.
+type Armazem interface {
+	// ProximoTermo devolve um termo novo, maior que todos os anteriores (fencing token)
+	ProximoTermo(ctx context.Context) (int64, error)
+
+	// AdquirirLease tenta pegar a liderança se ninguém tiver (SET NX PX)
+	AdquirirLease(ctx context.Context, id string, termo int64, ttl time.Duration) (bool, error)
+
+	// RenovarLease estende o TTL, mas só se o lease ainda for de 'id' com 'termo'
+	RenovarLease(ctx context.Context, id string, termo int64, ttl time.Duration) (bool, error)
+
+	// LerLease diz quem tem o lease agora (ok == false se ninguém tiver)
+	LerLease(ctx context.Context) (id string, termo int64, ok bool, err error)
+
+	// LiberarLease solta o lease (saída limpa), se ainda for de 'id'
+	LiberarLease(ctx context.Context, id string) error
+}
+
+// NovoEleitor monta o eleitor pelo nome do modo
+func NovoEleitor(modo string, armazem Armazem, ttlLease time.Duration) (Eleitor, error) {
+	switch strings.ToLower(modo) {
+	case "", ModoHealthCheck:
+		return &EleitorMenorID{Armazem: armazem}, nil
+	case ModoLease:
+		return &EleitorLease{Armazem: armazem, TTL: ttlLease}, nil
+	}
+	return nil, fmt.Errorf("modo de eleição desconhecido: %s", modo)
+}
+
+// valorLease codifica o dono do lease como "id|termo"
+func valorLease(id string, termo int64) string {
+	return fmt.Sprintf("%s|%d", id, termo)
+}
+
+// lerValorLease faz o caminho contrário de valorLease
+func lerValorLease(valor string) (string, int64, bool) {
+	i := strings.LastIndex(valor, "|")
+	if i < 0 {
+		return "", 0, false
+	}
+	var termo int64
+	if _, err := fmt.Sscanf(valor[i+1:], "%d", &termo); err != nil {
+		return "", 0, false
+	}
+	return valor[:i], termo, true
+}
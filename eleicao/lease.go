@@ -0,0 +1,85 @@
+package eleicao
+
+import (
+	"context"
+	"time"
+)
+
+// EleitorLease usa um lease com TTL no armazém: quem conseguir criar a chave é o líder,
+// e continua sendo enquanto renovar antes do TTL acabar. Se o líder travar ou cair,
+// a chave expira sozinha e o próximo que tentar assume (com um termo novo).
+type EleitorLease struct {
+	Armazem Armazem
+	TTL     time.Duration
+
+	termo int64 // termo do lease que *eu* tenho (0 se não tenho)
+}
+
+func (e *EleitorLease) Nome() string { return ModoLease }
+
+// no modo lease a queda do líder é detectada pela expiração da chave, não pelo health check
+func (e *EleitorLease) UsaHealthCheck() bool { return false }
+
+func (e *EleitorLease) Eleger(ctx context.Context, meuID string, vivos map[string]bool) (Resultado, error) {
+	// se alguém já tem o lease, ele é o líder
+	dono, termo, ok, err := e.Armazem.LerLease(ctx)
+	if err != nil {
+		return Resultado{}, err
+	}
+	if ok {
+		if dono == meuID {
+			e.termo = termo
+		}
+		return Resultado{Lider: dono, Termo: termo}, nil
+	}
+
+	// ninguém tem: tenta pegar com um termo novo
+	novoTermo, err := e.Armazem.ProximoTermo(ctx)
+	if err != nil {
+		return Resultado{}, err
+	}
+	conseguiu, err := e.Armazem.AdquirirLease(ctx, meuID, novoTermo, e.TTL)
+	if err != nil {
+		return Resultado{}, err
+	}
+	if conseguiu {
+		e.termo = novoTermo
+		return Resultado{Lider: meuID, Termo: novoTermo}, nil
+	}
+
+	// outro servidor ganhou a corrida, lê de novo pra saber quem
+	dono, termo, ok, err = e.Armazem.LerLease(ctx)
+	if err != nil || !ok {
+		return Resultado{}, err
+	}
+	return Resultado{Lider: dono, Termo: termo}, nil
+}
+
+// renova com folga: três tentativas antes do TTL acabar
+func (e *EleitorLease) IntervaloManter() time.Duration { return e.TTL / 3 }
+
+func (e *EleitorLease) Manter(ctx context.Context, meuID string, souLider bool) (Resultado, bool, error) {
+	if souLider && e.termo > 0 {
+		renovou, err := e.Armazem.RenovarLease(ctx, meuID, e.termo, e.TTL)
+		if err != nil {
+			return Resultado{}, false, err
+		}
+		if renovou {
+			return Resultado{Lider: meuID, Termo: e.termo}, false, nil
+		}
+		// perdi o lease (expirou enquanto eu tava travado, por ex)
+		e.termo = 0
+	}
+
+	res, err := e.Eleger(ctx, meuID, nil)
+	if err != nil {
+		return Resultado{}, false, err
+	}
+	return res, true, nil
+}
+
+// Liberar solta o lease numa saída limpa, pra outro assumir sem esperar o TTL
+func (e *EleitorLease) Liberar(ctx context.Context, meuID string) error {
+	e.termo = 0
+	return e.Armazem.LiberarLease(ctx, meuID)
+}
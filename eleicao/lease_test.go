@@ -0,0 +1,149 @@
+package eleicao
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// relogio deixa o teste andar o tempo do ArmazemMemoria na mão
+type relogio struct{ t time.Time }
+
+func (r *relogio) agora() time.Time      { return r.t }
+func (r *relogio) andar(d time.Duration) { r.t = r.t.Add(d) }
+func novoRelogio() *relogio              { return &relogio{t: time.Unix(1_700_000_000, 0)} }
+func armazemComRelogio(r *relogio) *ArmazemMemoria {
+	a := NovoArmazemMemoria()
+	a.Agora = r.agora
+	return a
+}
+
+func TestLeaseAdquirir(t *testing.T) {
+	ctx := context.Background()
+	r := novoRelogio()
+	a := armazemComRelogio(r)
+
+	s1 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+	s2 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+
+	res, err := s1.Eleger(ctx, "server1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lider != "server1" || res.Termo != 1 {
+		t.Fatalf("server1 devia pegar o lease no termo 1, veio %+v", res)
+	}
+
+	// o segundo só enxerga o dono atual, sem gastar termo
+	res, err = s2.Eleger(ctx, "server2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lider != "server1" || res.Termo != 1 {
+		t.Fatalf("server2 devia ver server1 no termo 1, veio %+v", res)
+	}
+	if a.termo != 1 {
+		t.Fatalf("quem perdeu n devia gastar termo: contador em %d", a.termo)
+	}
+}
+
+func TestLeaseRenovar(t *testing.T) {
+	ctx := context.Background()
+	r := novoRelogio()
+	a := armazemComRelogio(r)
+
+	s1 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+	s2 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+
+	if _, err := s1.Eleger(ctx, "server1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// renovando a cada TTL/3 o lease nunca expira, msm passando bem mais q o TTL
+	for i := 0; i < 10; i++ {
+		r.andar(s1.IntervaloManter())
+		res, mudou, err := s1.Manter(ctx, "server1", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mudou || res.Lider != "server1" || res.Termo != 1 {
+			t.Fatalf("rodada %d: renovação devia manter server1 no termo 1, veio %+v (mudou=%v)", i, res, mudou)
+		}
+	}
+
+	res, _, err := s2.Manter(ctx, "server2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lider != "server1" || res.Termo != 1 {
+		t.Fatalf("server2 devia continuar vendo server1, veio %+v", res)
+	}
+}
+
+func TestLeaseExpiraEOutroAssume(t *testing.T) {
+	ctx := context.Background()
+	r := novoRelogio()
+	a := armazemComRelogio(r)
+
+	s1 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+	s2 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+
+	if _, err := s1.Eleger(ctx, "server1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// server1 travou e n renovou: depois do TTL a chave some
+	r.andar(4 * time.Second)
+
+	res, mudou, err := s2.Manter(ctx, "server2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mudou || res.Lider != "server2" || res.Termo != 2 {
+		t.Fatalf("server2 devia assumir no termo 2, veio %+v (mudou=%v)", res, mudou)
+	}
+
+	// server1 volta achando q ainda eh lider: a renovação falha e ele segue o termo novo
+	res, mudou, err = s1.Manter(ctx, "server1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mudou || res.Lider != "server2" || res.Termo != 2 {
+		t.Fatalf("server1 devia descobrir server2 no termo 2, veio %+v (mudou=%v)", res, mudou)
+	}
+	if s1.termo != 0 {
+		t.Fatalf("server1 n devia guardar o termo velho, ta com %d", s1.termo)
+	}
+}
+
+func TestLeaseLiberar(t *testing.T) {
+	ctx := context.Background()
+	r := novoRelogio()
+	a := armazemComRelogio(r)
+
+	s1 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+	s2 := &EleitorLease{Armazem: a, TTL: 3 * time.Second}
+
+	if _, err := s1.Eleger(ctx, "server1", nil); err != nil {
+		t.Fatal(err)
+	}
+	// quem n eh dono n consegue soltar
+	if err := s2.Liberar(ctx, "server2"); err != nil {
+		t.Fatal(err)
+	}
+	if dono, _, ok, _ := a.LerLease(ctx); !ok || dono != "server1" {
+		t.Fatalf("lease de server1 n devia sair com o Liberar de server2 (dono=%q ok=%v)", dono, ok)
+	}
+
+	// saída limpa: o outro assume sem esperar o TTL
+	if err := s1.Liberar(ctx, "server1"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s2.Eleger(ctx, "server2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lider != "server2" || res.Termo != 2 {
+		t.Fatalf("server2 devia assumir no termo 2, veio %+v", res)
+	}
+}
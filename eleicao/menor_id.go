@@ -0,0 +1,44 @@
+package eleicao
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// EleitorMenorID é o algoritmo original: o menor ID entre os servidores vivos vence.
+// Só o eleito pega um termo novo; os outros esperam o anúncio (Termo == 0).
+type EleitorMenorID struct {
+	Armazem Armazem
+}
+
+func (e *EleitorMenorID) Nome() string { return ModoHealthCheck }
+
+func (e *EleitorMenorID) UsaHealthCheck() bool { return true }
+
+func (e *EleitorMenorID) Eleger(ctx context.Context, meuID string, vivos map[string]bool) (Resultado, error) {
+	ids := []string{meuID}
+	for id, vivo := range vivos {
+		if vivo && id != meuID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	if ids[0] != meuID {
+		return Resultado{Lider: ids[0]}, nil
+	}
+
+	termo, err := e.Armazem.ProximoTermo(ctx)
+	if err != nil {
+		return Resultado{}, err
+	}
+	return Resultado{Lider: meuID, Termo: termo}, nil
+}
+
+// Manter não faz nada aqui: quem percebe a queda do líder é o health check
+func (e *EleitorMenorID) Manter(ctx context.Context, meuID string, souLider bool) (Resultado, bool, error) {
+	return Resultado{}, false, nil
+}
+
+func (e *EleitorMenorID) IntervaloManter() time.Duration { return 0 }
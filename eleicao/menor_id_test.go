@@ -0,0 +1,106 @@
+package eleicao
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMenorIDVence(t *testing.T) {
+	ctx := context.Background()
+	a := NovoArmazemMemoria()
+	vivos := map[string]bool{"server1": true, "server2": true, "server3": true}
+
+	s1 := &EleitorMenorID{Armazem: a}
+	s2 := &EleitorMenorID{Armazem: a}
+
+	// quem n eh o menor só aponta pro candidato, sem termo (espera o anúncio)
+	res, err := s2.Eleger(ctx, "server2", vivos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lider != "server1" || res.Termo != 0 {
+		t.Fatalf("server2 devia apontar pra server1 sem termo, veio %+v", res)
+	}
+
+	res, err = s1.Eleger(ctx, "server1", vivos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lider != "server1" || res.Termo != 1 {
+		t.Fatalf("server1 devia se eleger no termo 1, veio %+v", res)
+	}
+}
+
+func TestMenorIDIgnoraMortos(t *testing.T) {
+	ctx := context.Background()
+	e := &EleitorMenorID{Armazem: NovoArmazemMemoria()}
+
+	// server1 ta no mapa mas morto: server2 eh o menor vivo
+	res, err := e.Eleger(ctx, "server2", map[string]bool{"server1": false, "server3": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lider != "server2" || res.Termo != 1 {
+		t.Fatalf("server2 devia se eleger no termo 1, veio %+v", res)
+	}
+}
+
+func TestMenorIDTermoSempreCresce(t *testing.T) {
+	ctx := context.Background()
+	a := NovoArmazemMemoria()
+
+	// cada reeleição (do msm servidor ou de outro) pega um termo maior q todos os anteriores
+	rodadas := []struct {
+		meuID string
+		vivos map[string]bool
+	}{
+		{"server1", map[string]bool{"server2": true}},
+		{"server2", map[string]bool{"server1": false, "server3": true}},
+		{"server1", map[string]bool{"server2": true, "server3": true}},
+		{"server3", nil},
+	}
+
+	var ultimo int64
+	for i, r := range rodadas {
+		res, err := (&EleitorMenorID{Armazem: a}).Eleger(ctx, r.meuID, r.vivos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Lider != r.meuID {
+			t.Fatalf("rodada %d: %s devia se eleger, veio %+v", i, r.meuID, res)
+		}
+		if res.Termo <= ultimo {
+			t.Fatalf("rodada %d: termo %d n eh maior q o anterior %d", i, res.Termo, ultimo)
+		}
+		ultimo = res.Termo
+	}
+}
+
+func TestNovoEleitor(t *testing.T) {
+	a := NovoArmazemMemoria()
+	casos := []struct {
+		modo string
+		nome string
+		erro bool
+	}{
+		{"", ModoHealthCheck, false},
+		{"healthcheck", ModoHealthCheck, false},
+		{"LEASE", ModoLease, false},
+		{"raft", "", true},
+	}
+	for _, c := range casos {
+		e, err := NovoEleitor(c.modo, a, 0)
+		if c.erro {
+			if err == nil {
+				t.Fatalf("modo %q devia dar erro", c.modo)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("modo %q: %v", c.modo, err)
+		}
+		if e.Nome() != c.nome {
+			t.Fatalf("modo %q devia montar %s, montou %s", c.modo, c.nome, e.Nome())
+		}
+	}
+}
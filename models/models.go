@@ -1,41 +1,144 @@
 package models
 
+import "encoding/json"
+
 // pacote com os modelos principais utilizados ao longo do projeto
 
 // estruturas do jogo
 // a struct da nossa carta (o tanque)
 type Tanque struct {
+	Id         string `json:"id"` // id unico da instancia (gerado no sorteio), eh assim q a gnt sabe de qm eh cada carta
 	Modelo     string `json:"modelo"`
+	Classe     string `json:"classe"`             // ClasseLeve, ClasseMedio ou ClassePesado (muda as habilidades no combate)
+	Raridade   string `json:"raridade,omitempty"` // vem do catalogo (Comum, Raro, Epico, Lendario)
 	Id_jogador string `json:"id_jogador"`
 	Vida       int    `json:"vida"`
 	Ataque     int    `json:"ataque"`
 }
 
+// classes dos tanques
+const (
+	ClasseLeve   = "Leve"
+	ClasseMedio  = "Medio"
+	ClassePesado = "Pesado"
+)
+
 // Batalha: isso aqui fica no map s.batalhas la do server
 type Batalha struct {
 	Jogador1     string      `json:"jogador1"`
 	Jogador2     string      `json:"jogador2"`
 	ServidorJ1   string      `json:"servidor_j1"` // ex: "server1:9090"
 	ServidorJ2   string      `json:"servidor_j2"` // ex: "server2:9091"
+	DeckJ1       []Tanque    `json:"deck_j1"`     // cartas q o server sorteou do inventario do j1 pra essa partida
+	DeckJ2       []Tanque    `json:"deck_j2"`     // idem pro j2
 	CanalJ1      chan Tanque `json:"-"`           // canal pra receber a carta do j1 (q ta no mesmo server)
 	CanalJ2      chan Tanque `json:"-"`           // canal pra receber a carta do j2 (q vem pela api)
 	CanalEncerra chan bool   `json:"-"`           // pra gnt mandar a goroutine da batalha parar
+	Ranqueada    bool        `json:"ranqueada"`   // veio da fila ranqueada (conta pro rating)
+	Geracao      int64       `json:"geracao"`     // geracao do checkpoint q esse host tem (ver battle_checkpoint.go)
+}
+
+// CheckpointBatalha: estado da batalha salvo no redis no fim de cada turno.
+// se o server host cair, o server do outro jogador carrega isso e continua de onde parou.
+// J1 eh sempre o jogador do server q ta hospedando agora (na retomada os papeis trocam).
+type CheckpointBatalha struct {
+	IdBatalha string   `json:"id_batalha"`
+	HostID    string   `json:"host_id"` // server q ta hospedando (ex: "server1")
+	Geracao   int64    `json:"geracao"` // sobe a cada adoção, junto com o HostID cerca o host antigo
+	Jogador1  string   `json:"jogador1"`
+	Jogador2  string   `json:"jogador2"`
+	Turno     int      `json:"turno"`
+	MaoJ1     []Tanque `json:"mao_j1"` // cartas vivas do j1, com a vida q sobrou
+	MaoJ2     []Tanque `json:"mao_j2"`
+	Ranqueada bool     `json:"ranqueada,omitempty"`
+
+	// pro historico (ver RegistroPartida)
+	DeckJ1 []Tanque       `json:"deck_j1,omitempty"`
+	DeckJ2 []Tanque       `json:"deck_j2,omitempty"`
+	Log    []TurnoPartida `json:"log,omitempty"`
+	Inicio int64          `json:"inicio,omitempty"` // unix
+}
+
+// um turno fechado da batalha, do jeito q fica no historico
+type TurnoPartida struct {
+	Turno       int    `json:"turno"`
+	CartaJ1     Tanque `json:"carta_j1"` // ja com a vida depois do dano
+	CartaJ2     Tanque `json:"carta_j2"`
+	DanoJ1      int    `json:"dano_j1"` // dano q a carta do j1 causou (com contra-ataque)
+	DanoJ2      int    `json:"dano_j2"`
+	DestruidaJ1 bool   `json:"destruida_j1"`
+	DestruidaJ2 bool   `json:"destruida_j2"`
+}
+
+// RegistroPartida: uma batalha encerrada, salva no redis pra sempre (GET /matches/:id)
+type RegistroPartida struct {
+	Id         string         `json:"id"`
+	Jogador1   string         `json:"jogador1"`
+	Jogador2   string         `json:"jogador2"`
+	DeckJ1     []Tanque       `json:"deck_j1"`
+	DeckJ2     []Tanque       `json:"deck_j2"`
+	Turnos     []TurnoPartida `json:"turnos"`
+	Vencedor   string         `json:"vencedor"` // id do jogador ou "Ninguém"
+	Motivo     string         `json:"motivo"`   // ex: "J2 sem cartas", "Timeout J2", "Servidor do oponente caiu"
+	Ranqueada  bool           `json:"ranqueada"`
+	RatingJ1   int            `json:"rating_j1,omitempty"` // rating depois da partida (so se contou pro rating)
+	RatingJ2   int            `json:"rating_j2,omitempty"`
+	VariacaoJ1 int            `json:"variacao_j1,omitempty"`
+	VariacaoJ2 int            `json:"variacao_j2,omitempty"`
+	Inicio     int64          `json:"inicio"` // unix
+	Fim        int64          `json:"fim"`
+}
+
+// rating e numeros do jogador (GET /players/:id/rating)
+type EstatisticasJogador struct {
+	IdJogador  string `json:"id_jogador"`
+	Rating     int    `json:"rating"`
+	Partidas   int    `json:"partidas"`
+	Vitorias   int    `json:"vitorias"`
+	Derrotas   int    `json:"derrotas"`
+	Empates    int    `json:"empates"`
+	Ranqueadas int    `json:"ranqueadas"`
 }
 
 // Troca: mesma logica da batalha, so q pra troca
 // fica no map s.trades do server
 type Troca struct {
-	Jogador1     string      `json:"jogador1"`
-	Jogador2     string      `json:"jogador2"`
-	ServidorJ1   string      `json:"servidor_j1"`
-	ServidorJ2   string      `json:"servidor_j2"`
-	CanalJ1      chan Tanque `json:"-"` // canal pra receber a carta do j1
-	CanalJ2      chan Tanque `json:"-"` // canal pra receber a carta do j2
-	CanalEncerra chan bool   `json:"-"` // pra forçar o encerramento
+	Jogador1     string         `json:"jogador1"`
+	Jogador2     string         `json:"jogador2"`
+	ServidorJ1   string         `json:"servidor_j1"`
+	ServidorJ2   string         `json:"servidor_j2"`
+	Acoes        chan AcaoTroca `json:"-"` // ofertas/confirmacoes dos dois (do redis ou da api), a goroutine da troca processa em ordem
+	CanalEncerra chan bool      `json:"-"` // pra forçar o encerramento
+}
+
+// uma acao de um jogador na negociacao da troca
+type AcaoTroca struct {
+	IdJogador string   `json:"id_jogador"`
+	Acao      string   `json:"acao"`                 // "Ofertar", "Confirmar" ou "Cancelar"
+	IdsCartas []string `json:"ids_cartas,omitempty"` // Ofertar: as cartas q o jogador da (substitui a oferta anterior)
+	Creditos  int      `json:"creditos,omitempty"`   // Ofertar: creditos q o jogador da junto
+	Versao    int      `json:"versao,omitempty"`     // Confirmar: a versao da oferta q o jogador viu
+}
+
+// o q um dos lados ta dando na troca
+type OfertaTroca struct {
+	IdJogador string   `json:"id_jogador"`
+	Cartas    []Tanque `json:"cartas"`
+	Creditos  int      `json:"creditos"`
 }
 
 // comunicacao via redis (cliente <-> servidor)
 
+// toda msg no redis (cliente -> servidor e servidor -> cliente) vai dentro de um envelope
+// assinado com a chave da sessao (ver o pacote assinatura)
+type Envelope struct {
+	Token      string          `json:"token,omitempty"`      // sessao de qm mandou (vazio no Login/Registrar e nas msgs do server)
+	Quando     int64           `json:"quando"`               // unix em ms, msg velha eh recusada
+	Nonce      string          `json:"nonce"`                // aleatorio, o mesmo envelope n vale 2x
+	Corpo      json.RawMessage `json:"corpo"`                // a requisicao (ou a RespostaGenericaCliente) de vdd
+	Assinatura string          `json:"assinatura,omitempty"` // HMAC-SHA256 (hex) do token, quando, nonce e corpo
+}
+
 // msg generica q o servidor manda pro cliente
 // o cliente sempre recebe isso e tem q olhar o 'Tipo' pra saber oq é
 type RespostaGenericaCliente struct {
@@ -49,21 +152,76 @@ type RespostaGenericaCliente struct {
 type ReqConectar struct {
 	IdRemetente   string `json:"id_remetente"`
 	CanalResposta string `json:"canal_resposta"` // ex: "client_reply:UUID_DO_CLIENTE"
+	Acao          string `json:"acao"`           // "Registrar", "Login" ou "Retomar" (reconexao com o token)
+	Usuario       string `json:"usuario,omitempty"`
+	Selado        string `json:"selado,omitempty"` // SeloConectar cifrado pra chave publica do cluster (Login/Registrar)
+}
+
+// SeloConectar vai selado no ReqConectar, so os servidores conseguem abrir (ver assinatura/credencial.go).
+// a senha so vai qnd o server pede (conta antiga, "Conta_Antiga"), nas outras vezes vai so o segredo derivado dela
+type SeloConectar struct {
+	ChaveResposta string `json:"chave_resposta"`  // aleatoria, o server assina a resposta desse pedido com ela (o envelope tb)
+	Segredo       string `json:"segredo"`         // segredo da conta (hex), derivado do usuario e da senha
+	Senha         string `json:"senha,omitempty"` // so pra atualizar conta antiga (bcrypt da senha)
 }
 
 // qnd o cliente quer comprar carta, manda isso pro topico 'comprar_carta'
 type ReqComprarCarta struct {
 	IdRemetente   string `json:"id_remetente"`
 	CanalResposta string `json:"canal_resposta"`
+	TipoPacote    string `json:"tipo_pacote,omitempty"` // id do pacote no catalogo (vazio = "basico")
+	IdTransacao   string `json:"id_transacao"`          // gerado pelo cliente: se o pedido chegar 2x, cobra 1x so
+}
+
+// entrar/sair da fila de batalha, manda isso pro topico 'fila_batalha'
+type ReqFila struct {
+	IdRemetente   string `json:"id_remetente"`
+	CanalResposta string `json:"canal_resposta"`
+	Acao          string `json:"acao"`           // "Entrar" ou "Sair"
+	Modo          string `json:"modo,omitempty"` // "Ranqueada" ou "Casual"
+}
+
+// quem ta esperando na fila (fica no redis, o lider le pra montar as partidas)
+type EntradaFila struct {
+	IdJogador string `json:"id_jogador"`
+	Modo      string `json:"modo"`
+	Rating    int    `json:"rating"`
+	Desde     int64  `json:"desde"` // unix ms, qnd entrou na fila
+}
+
+// mercado (anunciar, listar, comprar, dar lance, retirar), manda isso pro topico 'mercado'
+type ReqMercado struct {
+	IdRemetente   string        `json:"id_remetente"`
+	CanalResposta string        `json:"canal_resposta"`
+	Acao          string        `json:"acao"`                 // "Anunciar", "Listar", "Comprar", "Lance" ou "Retirar"
+	IdCarta       string        `json:"id_carta,omitempty"`   // Anunciar
+	Tipo          string        `json:"tipo,omitempty"`       // Anunciar: "Fixo" ou "Leilao"
+	Preco         int           `json:"preco,omitempty"`      // Anunciar: preco (fixo) ou lance minimo (leilao)
+	Duracao       int           `json:"duracao,omitempty"`    // Anunciar: em segundos (0 = padrao do server)
+	IdAnuncio     string        `json:"id_anuncio,omitempty"` // Comprar, Lance, Retirar
+	Valor         int           `json:"valor,omitempty"`      // Lance
+	Filtro        FiltroMercado `json:"filtro"`               // Listar
+}
+
+// filtro da busca no mercado (campo vazio/zero = tanto faz)
+type FiltroMercado struct {
+	Classe    string `json:"classe,omitempty"`
+	Raridade  string `json:"raridade,omitempty"`
+	Tipo      string `json:"tipo,omitempty"` // "Fixo" ou "Leilao"
+	VidaMin   int    `json:"vida_min,omitempty"`
+	AtaqueMin int    `json:"ataque_min,omitempty"`
+	PrecoMax  int    `json:"preco_max,omitempty"` // no leilao conta o lance atual (ou o minimo)
+	Vendedor  string `json:"vendedor,omitempty"`
 }
 
 // req pro canal pessoal do servidor (parear, msg, iniciar batalha/troca)
 type ReqPessoalServidor struct {
-	Tipo           string `json:"tipo"` // "Parear", "Mensagem", "Batalhar", "Trocar"
+	Tipo           string `json:"tipo"` // "Parear", "Aceitar", "Recusar", "Bloquear", "Desbloquear", "Desparear", "Mensagem", "Batalhar", "Trocar", "Inventario", "Pacotes", "Saldo", "Resultado_Recebido"
 	IdRemetente    string `json:"id_remetente"`
 	CanalResposta  string `json:"canal_resposta"`
 	IdDestinatario string `json:"id_destinatario,omitempty"` // pra quem eh
 	Mensagem       string `json:"mensagem,omitempty"`        // se for tipo "Mensagem"
+	IdTroca        string `json:"id_troca,omitempty"`        // se for tipo "Resultado_Recebido" (confirma o Resultado_Troca)
 }
 
 // qnd o server pede nossa carta da batalha, a gnt manda isso
@@ -74,12 +232,15 @@ type ReqJogadaBatalha struct {
 	Carta         Tanque `json:"carta"`
 }
 
-// qnd a gnt oferta uma carta na troca, manda isso
-type ReqCartaTroca struct {
-	IdRemetente   string `json:"id_remetente"`
-	CanalResposta string `json:"canal_resposta"`
-	IdTroca       string `json:"id_troca"`
-	Carta         Tanque `json:"carta"` // a carta q o jogador ta ofertando
+// durante a troca (ofertar, confirmar, cancelar), manda isso
+type ReqAcaoTroca struct {
+	IdRemetente   string   `json:"id_remetente"`
+	CanalResposta string   `json:"canal_resposta"`
+	IdTroca       string   `json:"id_troca"`
+	Acao          string   `json:"acao"`                 // "Ofertar", "Confirmar" ou "Cancelar"
+	IdsCartas     []string `json:"ids_cartas,omitempty"` // Ofertar: ids das cartas (a oferta inteira, n so o q mudou)
+	Creditos      int      `json:"creditos,omitempty"`   // Ofertar: creditos
+	Versao        int      `json:"versao,omitempty"`     // Confirmar: versao da oferta q a gnt ta aceitando
 }
 
 // respostas do servidor pro cliente
@@ -95,13 +256,33 @@ type RespostaConexao struct {
 	IdServidorConectado  string `json:"id_servidor_conectado"`
 	CanalPessoalServidor string `json:"canal_pessoal_servidor"` // ex: "servidor_pessoal:server1"
 	CanalUDPPing         string `json:"canal_udp_ping"`         // ex: "server1:8081" (host:porta) pro heartbeat
+	IdJogador            string `json:"id_jogador"`             // id fixo da conta (o inventario e a carteira sao dele)
+	Token                string `json:"token"`                  // token da sessao, vai no envelope de toda requisicao depois
+	ChaveSessao          string `json:"chave_sessao,omitempty"` // chave da sessao cifrada com a chave de resposta (so no Login/Registrar)
 }
 
+// vai no "Pareamento" e tb no "Despareado"/"Desconexão" (qnd o par se desfaz)
 type RespostaPareamento struct {
 	Mensagem   string `json:"mensagem"` // "pareamento realizado com..."
 	IdParceiro string `json:"id_parceiro"`
 }
 
+// convite de pareamento. chega pro convidado como "Convite", pra quem convidou como "Convite_Enviado"
+// e pros dois como "Convite_Recusado"
+type RespostaConvite struct {
+	IdRemetente    string `json:"id_remetente"`    // quem convidou
+	IdDestinatario string `json:"id_destinatario"` // quem foi convidado
+	Mensagem       string `json:"mensagem"`
+	ExpiraEm       int64  `json:"expira_em,omitempty"` // unix, dps disso o "Aceitar" n vale mais
+}
+
+// resposta do "Bloquear"/"Desbloquear"
+type RespostaBloqueio struct {
+	IdJogador string `json:"id_jogador"`
+	Bloqueado bool   `json:"bloqueado"`
+	Mensagem  string `json:"mensagem"`
+}
+
 type RespostaMensagem struct {
 	Remetente string `json:"remetente"`
 	Mensagem  string `json:"mensagem"`
@@ -110,19 +291,94 @@ type RespostaMensagem struct {
 type RespostaSorteio struct {
 	Mensagem string   `json:"mensagem"`
 	Cartas   []Tanque `json:"cartas"`
+	Saldo    int      `json:"saldo"` // saldo depois da compra
+}
+
+// um lancamento do extrato da carteira (credito ou debito)
+type LancamentoCarteira struct {
+	Tx      string `json:"tx"` // id da transacao
+	Jogador string `json:"jogador"`
+	Valor   int    `json:"valor"` // positivo = credito, negativo = debito
+	Saldo   int    `json:"saldo"` // saldo depois do lancamento
+	Motivo  string `json:"motivo"`
+	Quando  int64  `json:"quando"` // unix
+}
+
+// saldo e ultimos lancamentos (resposta do "Saldo")
+type RespostaSaldo struct {
+	Saldo   int                  `json:"saldo"`
+	Extrato []LancamentoCarteira `json:"extrato"`
+}
+
+// um tipo de pacote a venda (resposta do "Pacotes")
+type InfoPacote struct {
+	Id         string `json:"id"`
+	Nome       string `json:"nome"`
+	Preco      int    `json:"preco"`
+	Estoque    int    `json:"estoque"`
+	Cartas     int    `json:"cartas"`   // cartas por pacote
+	Garantia   string `json:"garantia"` // raridade minima garantida
+	Disponivel bool   `json:"disponivel"`
+}
+
+// vitrine de pacotes
+type RespostaPacotes struct {
+	Pacotes []InfoPacote `json:"pacotes"`
+}
+
+// um anuncio do mercado
+type Anuncio struct {
+	Id        string `json:"id"`
+	Vendedor  string `json:"vendedor"`
+	Carta     Tanque `json:"carta"`
+	Tipo      string `json:"tipo"`                // "Fixo" ou "Leilao"
+	Preco     int    `json:"preco"`               // fixo: o preco; leilao: lance minimo
+	Lance     int    `json:"lance,omitempty"`     // leilao: maior lance ate agora
+	Licitante string `json:"licitante,omitempty"` // leilao: quem deu o maior lance
+	ExpiraEm  int64  `json:"expira_em"`           // unix
+}
+
+// resultado da busca no mercado ("Mercado")
+type RespostaMercado struct {
+	Anuncios []Anuncio `json:"anuncios"`
+	Total    int       `json:"total"` // quantos anuncios bateram com o filtro (a lista vem cortada)
+}
+
+// algo aconteceu com um anuncio nosso ou q a gnt deu lance ("Mercado_Evento")
+type RespostaEventoMercado struct {
+	Evento   string  `json:"evento"` // "Anunciado", "Comprado", "Vendido", "Lance", "Superado", "Arrematado", "Expirado", "Retirado"
+	Mensagem string  `json:"mensagem"`
+	Anuncio  Anuncio `json:"anuncio"`
+}
+
+// inventario oficial do jogador (o q ta salvo no servidor)
+type RespostaInventario struct {
+	Cartas []Tanque `json:"cartas"`
+}
+
+// estado do jogador na fila de batalha ("Fila")
+type RespostaFila struct {
+	NaFila   bool   `json:"na_fila"`
+	Modo     string `json:"modo,omitempty"`
+	Rating   int    `json:"rating,omitempty"`
+	Mensagem string `json:"mensagem"`
 }
 
 type RespostaInicioBatalha struct {
-	Mensagem  string `json:"mensagem"` // "batalha iniciada com..."
-	IdBatalha string `json:"id_batalha"`
+	Mensagem  string   `json:"mensagem"` // "batalha iniciada com..."
+	IdBatalha string   `json:"id_batalha"`
+	Retomada  bool     `json:"retomada"` // true se eh uma batalha antiga voltando
+	Deck      []Tanque `json:"deck"`     // as cartas q o jogador vai usar na partida
 }
 
 type RespostaFimBatalha struct {
 	Mensagem string `json:"mensagem"` // "batalha finalizada! vencedor: ..."
 }
 
+// server pedindo pro jogador escolher qual carta da mao vai jogar nesse turno
 type RespostaPedirCarta struct {
-	Indice int `json:"indice"` // server pedindo a carta da batalha (ele fala o *indice* q ele quer)
+	Turno int      `json:"turno"`
+	Mao   []Tanque `json:"mao"` // cartas q ainda tao vivas
 }
 
 type RespostaTurnoRealizado struct {
@@ -135,32 +391,52 @@ type RespostaInicioTroca struct {
 	IdTroca  string `json:"id_troca"`
 }
 
-// server (local ou remoto) avisando q é nossa vez de ofertar na troca
-type RespostaPedirCartaTroca struct {
-	IdTroca string `json:"id_troca"` // so pra gnt saber pra qual troca eh
+// estado da negociacao: vai pros dois a cada mudanca ("Oferta_Troca")
+type RespostaOfertaTroca struct {
+	IdTroca     string        `json:"id_troca"`
+	Versao      int           `json:"versao"`      // muda a cada oferta nova, confirmar vale so pra versao atual
+	Ofertas     []OfertaTroca `json:"ofertas"`     // [j1, j2]
+	Confirmados []string      `json:"confirmados"` // quem ja confirmou essa versao
+	Mensagem    string        `json:"mensagem"`
 }
 
-// o resultado final da troca. se 'CartaRecebida' tiver vazia, falhou
+// o server vai desligar: o cliente tem q se conectar em outro
+type RespostaReconectar struct {
+	Mensagem string `json:"mensagem"`
+}
+
+// o resultado final da troca. se 'Concluida' for false, foi cancelada
 type RespostaResultadoTroca struct {
-	Mensagem      string `json:"mensagem"`       // "troca realizada com sucesso!"
-	CartaRecebida Tanque `json:"carta_recebida"` // a carta q o jogador recebeu
+	IdTroca            string   `json:"id_troca"` // o resultado chega de novo ate o cliente confirmar (Resultado_Recebido), ele usa isso pra ignorar
+	Concluida          bool     `json:"concluida"`
+	Mensagem           string   `json:"mensagem"`             // "troca realizada com sucesso!"
+	CartasRecebidas    []Tanque `json:"cartas_recebidas"`     // as cartas q o jogador recebeu
+	IdsCartasEntregues []string `json:"ids_cartas_entregues"` // ids das cartas q o jogador deu (pra tirar do inventario)
+	Creditos           int      `json:"creditos"`             // saldo da troca pro jogador (negativo se ele pagou)
 }
 
 // comunicacao via rest (servidor <-> servidor)
 
 // sync de estado (lider manda pros seguidores)
 
+// toda msg q so o lider pode mandar (ou q so o lider pode receber) leva o termo
+// o seguidor recusa msg de lider com termo velho, e o lider q ve termo mais novo sai da lideranca
+
 // lider avisando q um player entrou ou saiu (POST /players/update)
 type UpdatePlayerListRequest struct {
 	PlayerID      string `json:"player_id"`
 	ServerID      string `json:"server_id"`
 	CanalResposta string `json:"canal_resposta"`
 	Acao          string `json:"acao"` // "add" ou "remove"
+	IdLider       string `json:"id_lider"`
+	Termo         int64  `json:"termo"`
 }
 
 // lider avisando q o estoque de pacotes mudou (POST /inventory/update)
 type UpdateInventoryRequest struct {
-	PacotesRestantes int `json:"pacotes_restantes"`
+	Estoques map[string]int `json:"estoques"` // id do pacote -> quantos sobraram
+	IdLider  string         `json:"id_lider"`
+	Termo    int64          `json:"termo"`
 }
 
 // reqs dos seguidores pro lider
@@ -170,12 +446,16 @@ type LeaderConnectRequest struct {
 	PlayerID      string `json:"player_id"`
 	ServerID      string `json:"server_id"` // id do server q recebeu a conexao
 	CanalResposta string `json:"canal_resposta"`
+	Termo         int64  `json:"termo"` // termo q o seguidor conhece
 }
 
 // seguidor pedindo pro lider processar uma compra (POST /cards/buy)
 type LeaderBuyCardRequest struct {
-	PlayerID string `json:"player_id"` // id do jogador q ta comprando
-	ServerID string `json:"server_id"` // id do server q atendeu o pedido
+	PlayerID    string `json:"player_id"`    // id do jogador q ta comprando
+	ServerID    string `json:"server_id"`    // id do server q atendeu o pedido
+	TipoPacote  string `json:"tipo_pacote"`  // id do pacote no catalogo
+	IdTransacao string `json:"id_transacao"` // id da transacao da compra (ver carteira.go)
+	Termo       int64  `json:"termo"`
 }
 
 // comunicacao da batalha (s1 <-> s2)
@@ -183,16 +463,26 @@ type LeaderBuyCardRequest struct {
 // s1 (host) -> s2 (peer) pra iniciar a batalha (POST /battle/initiate)
 // manda o 'HostServidor' pra s2 saber pra qm responder
 type BattleInitiateRequest struct {
-	IdBatalha      string `json:"id_batalha"`
-	IdJogadorLocal string `json:"id_jogador_local"` // jogador 2 (q ta no s2)
-	IdOponente     string `json:"id_oponente"`      // jogador 1 (q ta no s1)
-	HostServidor   string `json:"host_servidor"`    // api do s1 (ex: "server1:9090")
+	IdBatalha      string   `json:"id_batalha"`
+	IdJogadorLocal string   `json:"id_jogador_local"` // jogador 2 (q ta no s2)
+	IdOponente     string   `json:"id_oponente"`      // jogador 1 (q ta no s1)
+	HostServidor   string   `json:"host_servidor"`    // api do s1 (ex: "server1:9090")
+	Retomada       bool     `json:"retomada"`         // batalha retomada de um checkpoint (host antigo caiu)
+	Deck           []Tanque `json:"deck"`             // deck do j2 (sorteado pelo host)
+}
+
+// lider -> server do j1 pra comecar uma partida da fila (POST /battle/start)
+type BattleStartRequest struct {
+	IdJogador1 string `json:"id_jogador1"` // conectado no server q recebe (vira o host)
+	IdJogador2 string `json:"id_jogador2"`
+	Ranqueada  bool   `json:"ranqueada"`
 }
 
 // s1 (host) -> s2 (peer) pra pedir a carta do j2 (POST /battle/request_move)
 type BattleRequestMoveRequest struct {
-	IdBatalha string `json:"id_batalha"`
-	Indice    int    `json:"indice"`
+	IdBatalha string   `json:"id_batalha"`
+	Turno     int      `json:"turno"`
+	Mao       []Tanque `json:"mao"` // mao atual do j2
 }
 
 // s1 (host) -> s2 (peer) pra mandar o resultado do turno (POST /battle/turn_result)
@@ -223,21 +513,25 @@ type TradeInitiateRequest struct {
 	HostServidor   string `json:"host_servidor"`    // api do s1
 }
 
-// s1 (host) -> s2 (peer) pra pedir a carta do j2 (POST /trade/request_card)
-type TradeRequestCardRequest struct {
-	IdTroca string `json:"id_troca"`
+// s1 (host) -> s2 (peer) pra mandar o estado da negociacao (ou um erro) pro j2 (POST /trade/update)
+type TradeUpdateRequest struct {
+	IdTroca   string              `json:"id_troca"`
+	IdJogador string              `json:"id_jogador"` // o j2
+	Oferta    RespostaOfertaTroca `json:"oferta"`
+	Erro      string              `json:"erro,omitempty"` // se tiver preenchido, o j2 recebe so o erro
 }
 
 // s1 (host) -> s2 (peer) pra mandar o resultado final da troca (POST /trade/result)
 type TradeResultRequest struct {
-	IdTroca       string `json:"id_troca"`
-	CartaRecebida Tanque `json:"carta_recebida"` // a carta q o j1 ofertou (e q o j2 vai receber)
+	IdTroca   string                 `json:"id_troca"`
+	IdJogador string                 `json:"id_jogador"` // o j2 (o s2 acha ele msm se ja tiver esquecido a troca)
+	Resultado RespostaResultadoTroca `json:"resultado"`  // o q vai pro cliente do j2 (o msm q fica pendente no registro da troca)
 }
 
-// s2 (peer) -> s1 (host) pra mandar a carta q o j2 ofertou (POST /trade/submit_card)
-type TradeSubmitCardRequest struct {
-	IdTroca string `json:"id_troca"`
-	Carta   Tanque `json:"carta"` // a carta q o j2 ta ofertando
+// s2 (peer) -> s1 (host) pra mandar a acao do j2 na negociacao (POST /trade/action)
+type TradeActionRequest struct {
+	IdTroca string    `json:"id_troca"`
+	Acao    AcaoTroca `json:"acao"`
 }
 
 // eleicao e health check
@@ -247,4 +541,25 @@ type HealthCheckResponse struct {
 	Status   string `json:"status"` // "OK"
 	ServerID string `json:"server_id"`
 	IsLeader bool   `json:"is_leader"`
+	Termo    int64  `json:"termo"`    // termo atual q esse server conhece
+	Catalogo int64  `json:"catalogo"` // versao do catalogo de cartas q esse server ta usando
+}
+
+// server -> todos: publiquei um catalogo novo no redis (POST /catalogo/update)
+type CatalogoUpdateRequest struct {
+	Versao int64 `json:"versao"`
+}
+
+// lider recem eleito se anunciando pros outros (POST /election/coordinator)
+type LeaderAnnounceRequest struct {
+	IdLider string `json:"id_lider"`
+	Termo   int64  `json:"termo"`
+}
+
+// membros do cluster
+
+// server entrando ou saindo do cluster (POST /cluster/join e /cluster/leave)
+type ClusterMembroRequest struct {
+	ServerID string `json:"server_id"`
+	Host     string `json:"host"` // host:porta da api (so precisa no join)
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"PlanoZ/assinatura"
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// --- Envelopes assinados ---
+
+// Toda requisição q chega pelo Redis vem num models.Envelope. Os listeners abrem o envelope,
+// conferem a assinatura com a chave da sessão do token e só então despacham. Sem isso qualquer
+// um com acesso ao Redis podia mandar uma requisição com o IdRemetente de outro jogador.
+// No sentido contrário, o sendToClient assina tudo com a chave da sessão dona do canal; a resposta
+// do Login/Registrar vai com a chave de resposta q veio selada no pedido (ver contas.go).
+const (
+	PrefixoNonce = "nonce:" // nonces ja usados (vivem 2 janelas, dps disso o horário ja barra)
+)
+
+var (
+	errEnvelopeInvalido = errors.New("envelope inválido")
+	errNonceRepetido    = errors.New("mensagem repetida")
+)
+
+// abrirEnvelope devolve a sessão de quem mandou e o envelope (o corpo é a requisição).
+// Envelope sem token (Login/Registrar) volta com sessão nil, quem chama decide se aceita.
+func (s *Server) abrirEnvelope(msg string) (*Sessao, models.Envelope, error) {
+	var env models.Envelope
+	if err := json.Unmarshal([]byte(msg), &env); err != nil || len(env.Corpo) == 0 {
+		return nil, env, errEnvelopeInvalido
+	}
+	if env.Token == "" {
+		return nil, env, nil
+	}
+
+	sessao, err := s.buscarSessao(env.Token)
+	if err != nil {
+		return nil, env, err
+	}
+	if err := assinatura.Verificar(sessao.Chave, env, time.Now()); err != nil {
+		return nil, env, err
+	}
+	if err := s.marcarNonce(env.Nonce, sessao.IdJogador); err != nil {
+		return nil, env, err
+	}
+	return sessao, env, nil
+}
+
+// marcarNonce queima o nonce: o mesmo envelope n vale 2x (alguem copiando a msg da fila e mandando de novo)
+func (s *Server) marcarNonce(nonce, dono string) error {
+	novo, err := s.redisClient.SetNX(s.ctx, PrefixoNonce+nonce, dono, 2*assinatura.Janela).Result()
+	if err != nil {
+		return err
+	}
+	if !novo {
+		return errNonceRepetido
+	}
+	return nil
+}
+
+// checarEnvelope junta as duas checagens de uma requisição de jogador: o envelope (assinatura,
+// horário, nonce) e se o remetente é mesmo o dono da sessão
+func (s *Server) checarEnvelope(sessao *Sessao, env models.Envelope, errEnv error, playerID, canalResposta string) bool {
+	if errEnv != nil {
+		s.recusarEnvelope(errEnv, canalResposta, env.Token)
+		return false
+	}
+	return s.checarRemetente(sessao, playerID, canalResposta)
+}
+
+// recusarEnvelope descarta a requisição. So a sessão expirada volta pro cliente (pra ele logar de novo);
+// assinatura errada ou msg repetida eh descartada calada, n tem pq responder quem forjou.
+func (s *Server) recusarEnvelope(err error, canalResposta, token string) {
+	color.Red("ASSINATURA: Requisição para %s recusada: %v", canalResposta, err)
+	if err == errSessaoInvalida {
+		s.avisarSessaoInvalida(canalResposta, token)
+	}
+}
+
+// avisarSessaoInvalida assina o Sessao_Invalida com a chave derivada do token q veio no envelope.
+// O cliente so aceita se for o token dele, entao ninguem derruba a sessão de outro mandando um token
+// qualquer com o canal da vítima. Sem token n tem chave, e a msg n vai.
+func (s *Server) avisarSessaoInvalida(canalResposta, token string) {
+	if token == "" {
+		return
+	}
+	chave := s.chaveDoToken(token)
+	s.sendToClientComChave(canalResposta, chave, "Sessao_Invalida", models.RespostaErro{Erro: errSessaoInvalida.Error()})
+}
+
+// chaveDoCanal devolve a chave da sessão presa ao canal do cliente ("" se ainda n tem sessão)
+func (s *Server) chaveDoCanal(canal string) string {
+	if sessao := s.sessaoDoCanal(canal); sessao != nil {
+		return sessao.Chave
+	}
+	return "" // manda sem assinatura (o cliente descarta)
+}
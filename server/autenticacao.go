@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gin-gonic/gin"
+)
+
+// --- Autenticação entre Servidores (API REST) ---
+
+// Toda chamada entre servidores leva um HMAC-SHA256 feito com o CLUSTER_SECRET (o mesmo em todos).
+// A assinatura cobre o método, o caminho, quem mandou, o horário, um nonce e o hash do corpo, então
+// ninguém na rede consegue forjar um /inventory/update nem mandar a jogada do J2 no lugar do server dele.
+// A resposta também volta assinada (junto com o nonce do pedido), então quem chamou sabe q falou
+// com um servidor de vdd: a autenticação vale pros dois lados.
+// As rotas de admin (rotasAdmin) aceitam também o segredo direto no 'Authorization: Bearer', pra
+// dar pra chamar com curl.
+const (
+	HeaderServidor   = "X-Servidor"
+	HeaderQuando     = "X-Quando" // unix em ms
+	HeaderNonce      = "X-Nonce"
+	HeaderAssinatura = "X-Assinatura"
+
+	JanelaCluster = 30 * time.Second // idade máxima de uma requisição (os relógios só precisam tar perto)
+)
+
+var (
+	errSemSegredo          = errors.New("CLUSTER_SECRET não definido")
+	errNaoAssinada         = errors.New("requisição sem assinatura")
+	errAssinaturaServidor  = errors.New("assinatura inválida")
+	errForaDaJanelaCluster = errors.New("requisição fora da janela de tempo")
+	errNonceCluster        = errors.New("requisição repetida")
+	errRespostaNaoAssinada = errors.New("resposta sem assinatura válida")
+)
+
+// rotas q o admin chama na mão (curl). o resto so outro servidor chama
+var rotasAdmin = map[string]bool{
+	"/admin/catalogo/reload": true,
+	"/cluster/leave":         true,
+	"/players/:id/rating":    true,
+	"/players/:id/history":   true,
+	"/matches/:id":           true,
+}
+
+// lerSegredoCluster lê o CLUSTER_SECRET. Sem ele o server n sobe: a API ficaria aberta pra rede toda.
+func lerSegredoCluster() ([]byte, error) {
+	segredo := getEnv("CLUSTER_SECRET", "")
+	if segredo == "" {
+		return nil, errSemSegredo
+	}
+	return []byte(segredo), nil
+}
+
+// assinaturaCluster faz o HMAC das partes (separadas por \n)
+func (s *Server) assinaturaCluster(partes ...string) string {
+	mac := hmac.New(sha256.New, s.segredoCluster)
+	mac.Write([]byte(strings.Join(partes, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hashCorpo(corpo []byte) string {
+	h := sha256.Sum256(corpo)
+	return hex.EncodeToString(h[:])
+}
+
+// novaRequisicao monta uma requisição pra outro servidor ja assinada. Devolve o nonce junto,
+// pra conferir a resposta dps (ver conferirResposta).
+func (s *Server) novaRequisicao(metodo, host, endpoint string, corpo []byte) (*http.Request, string, error) {
+	req, err := http.NewRequest(metodo, fmt.Sprintf("http://%s%s", host, endpoint), bytes.NewReader(corpo))
+	if err != nil {
+		return nil, "", err
+	}
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return nil, "", err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	quando := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	req.Header.Set(HeaderServidor, s.ID)
+	req.Header.Set(HeaderQuando, quando)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderAssinatura, s.assinaturaCluster(metodo, endpoint, s.ID, quando, nonce, hashCorpo(corpo)))
+	return req, nonce, nil
+}
+
+// conferirResposta lê o corpo da resposta e confere se ela veio assinada pro nosso nonce
+func (s *Server) conferirResposta(resp *http.Response, nonce string) ([]byte, error) {
+	corpo, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	esperada := s.assinaturaCluster("resposta", nonce, strconv.Itoa(resp.StatusCode), hashCorpo(corpo))
+	if !hmac.Equal([]byte(resp.Header.Get(HeaderAssinatura)), []byte(esperada)) {
+		return nil, errRespostaNaoAssinada
+	}
+	return corpo, nil
+}
+
+// verificarRequisicao confere a assinatura, o horário e o nonce de uma requisição q chegou
+func (s *Server) verificarRequisicao(c *gin.Context, corpo []byte) error {
+	servidor := c.GetHeader(HeaderServidor)
+	quando := c.GetHeader(HeaderQuando)
+	nonce := c.GetHeader(HeaderNonce)
+	recebida := c.GetHeader(HeaderAssinatura)
+	if recebida == "" || nonce == "" {
+		return errNaoAssinada
+	}
+
+	esperada := s.assinaturaCluster(c.Request.Method, c.Request.URL.Path, servidor, quando, nonce, hashCorpo(corpo))
+	if !hmac.Equal([]byte(recebida), []byte(esperada)) {
+		return errAssinaturaServidor
+	}
+
+	ms, err := strconv.ParseInt(quando, 10, 64)
+	if err != nil {
+		return errAssinaturaServidor
+	}
+	idade := time.Since(time.UnixMilli(ms))
+	if idade > JanelaCluster || idade < -JanelaCluster {
+		return errForaDaJanelaCluster
+	}
+
+	// nonce: so precisa lembrar dos q ainda tao dentro da janela, o resto o horário ja barra
+	s.muNonces.Lock()
+	defer s.muNonces.Unlock()
+	if _, repetido := s.noncesCluster[nonce]; repetido {
+		return errNonceCluster
+	}
+	agora := time.Now()
+	for n, visto := range s.noncesCluster {
+		if agora.Sub(visto) > 2*JanelaCluster {
+			delete(s.noncesCluster, n)
+		}
+	}
+	s.noncesCluster[nonce] = agora
+	return nil
+}
+
+// autenticarCluster é o middleware do Gin: recusa quem n assinou e assina a resposta
+func (s *Server) autenticarCluster(c *gin.Context) {
+	corpo, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(corpo)) // devolve o corpo pro handler
+
+	admin := rotasAdmin[c.FullPath()] && hmac.Equal([]byte(c.GetHeader("Authorization")), []byte("Bearer "+string(s.segredoCluster)))
+	if !admin {
+		if err := s.verificarRequisicao(c, corpo); err != nil {
+			color.Red("AUTH: %s %s de %s recusada: %v", c.Request.Method, c.Request.URL.Path, c.ClientIP(), err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Não autorizado"})
+			return
+		}
+	}
+
+	// segura a resposta pra assinar ela inteira (status + corpo) antes de mandar
+	escritor := &escritorAssinado{ResponseWriter: c.Writer}
+	c.Writer = escritor
+	c.Next()
+
+	status := escritor.Status()
+	escritor.ResponseWriter.Header().Set(HeaderAssinatura,
+		s.assinaturaCluster("resposta", c.GetHeader(HeaderNonce), strconv.Itoa(status), hashCorpo(escritor.corpo.Bytes())))
+	escritor.ResponseWriter.WriteHeader(status)
+	escritor.ResponseWriter.Write(escritor.corpo.Bytes())
+}
+
+// escritorAssinado guarda o corpo da resposta em vez de mandar direto
+type escritorAssinado struct {
+	gin.ResponseWriter
+	corpo bytes.Buffer
+}
+
+func (e *escritorAssinado) Write(b []byte) (int, error)       { return e.corpo.Write(b) }
+func (e *escritorAssinado) WriteString(s string) (int, error) { return e.corpo.WriteString(s) }
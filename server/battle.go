@@ -1,23 +1,117 @@
 package main
 
 import (
+	"PlanoZ/combate"
 	"PlanoZ/models" // certifique-se q o caminho ta certo
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/google/uuid"
 )
 
 // logica da batalha (distribuida)
 
+// TempoEscolha eh quanto cada turno espera os jogadores escolherem a carta
+const TempoEscolha = 30 * time.Second
+
+var (
+	errJogadorNaoEncontrado = errors.New("Um dos jogadores não foi encontrado")
+	errSemCartasBatalha     = errors.New("Os dois jogadores precisam ter cartas para batalhar")
+	errServidorOponente     = errors.New("Falha ao iniciar batalha com o servidor do oponente")
+)
+
+// abrirBatalha cria a batalha com este server de host e avisa o server do j2.
+// O j1 tem q tar conectado aqui (as jogadas dele chegam no canal pessoal deste server).
+// Serve pro "Batalhar" do par e pras partidas q a fila monta (ver matchmaking.go).
+func (s *Server) abrirBatalha(idJ1, idJ2 string, ranqueada bool) error {
+	s.muPlayers.RLock()
+	infoJ1, okJ1 := s.playerList[idJ1]
+	infoJ2, okJ2 := s.playerList[idJ2]
+	s.muPlayers.RUnlock()
+
+	if !okJ1 || !okJ2 {
+		return errJogadorNaoEncontrado
+	}
+
+	// 1. Sortear os decks (do inventário no Redis) e criar a struct Batalha
+	deckJ1, errJ1 := s.sortearDeckBatalha(idJ1)
+	deckJ2, errJ2 := s.sortearDeckBatalha(idJ2)
+	if errJ1 != nil || errJ2 != nil {
+		color.Red("Batalha recusada: J1: %v, J2: %v", errJ1, errJ2)
+		return errSemCartasBatalha
+	}
+
+	battleID := "battle:" + uuid.New().String()[:8]
+	// marca os dois (ver ocupado.go): ninguem ta em duas batalhas/trocas ao mesmo tempo, nem entra na fila
+	if err := s.ocuparJogadores(battleID, CheckpointTTL, idJ1, idJ2); err != nil {
+		return err
+	}
+	batalha := &models.Batalha{
+		Jogador1:     idJ1,
+		Jogador2:     idJ2,
+		ServidorJ1:   infoJ1.ServerHost,
+		ServidorJ2:   infoJ2.ServerHost,
+		DeckJ1:       deckJ1,
+		DeckJ2:       deckJ2,
+		CanalJ1:      make(chan models.Tanque, 1), // Canal com buffer 1
+		CanalJ2:      make(chan models.Tanque, 1), // Canal com buffer 1
+		CanalEncerra: make(chan bool, 1),
+		Ranqueada:    ranqueada,
+		Geracao:      1, // o checkpoint começa na geracao 1 (ver battle_checkpoint.go)
+	}
+
+	// 2. Armazenar a batalha localmente (como Host)
+	s.muBatalhas.Lock()
+	s.batalhas[battleID] = batalha
+	s.muBatalhas.Unlock()
+
+	// 3. Iniciar a goroutine da batalha
+	go s.iniciarBatalha(battleID, batalha, infoJ1.ReplyChannel)
+
+	// 4. Notificar o Servidor J2 (Peer) para ele avisar o J2
+	initReq := models.BattleInitiateRequest{
+		IdBatalha:      battleID,
+		IdJogadorLocal: idJ2,      // J2
+		IdOponente:     idJ1,      // J1
+		HostServidor:   s.HostAPI, // Endereço de callback (EU, S1)
+		Deck:           deckJ2,
+	}
+
+	// Se for um self-test (J1 e J2 no mesmo server)
+	if infoJ1.ServerID == infoJ2.ServerID {
+		// Simula a chamada de rede localmente (chamando a lógica do handler)
+		s.muBatalhasPeer.Lock()
+		s.batalhasPeer[battleID] = peerBattleInfo{
+			PlayerID: idJ2,
+			HostAPI:  s.HostAPI,
+		}
+		s.muBatalhasPeer.Unlock()
+
+		respInicioJ2 := models.RespostaInicioBatalha{
+			Mensagem:  idJ1,
+			IdBatalha: battleID,
+			Deck:      deckJ2,
+		}
+		s.sendToClient(infoJ2.ReplyChannel, "Inicio_Batalha", respInicioJ2)
+		color.Green("BATALHA (Self-Test): Batalha %s registrada para J2 %s", battleID, idJ2)
+	} else if err := s.sendToHost(infoJ2.ServerHost, "/battle/initiate", initReq); err != nil {
+		// Chamada de rede normal para S2
+		s.encerrarBatalha(battleID, "Ninguém", "Falha de Rede")
+		return errServidorOponente
+	}
+	return nil
+}
+
 // essa eh a goroutine principal da batalha, ela q manda em tudo
 // (esse server eh o "host" s1)
 func (s *Server) iniciarBatalha(battleID string, b *models.Batalha, canalRespostaJ1 string) {
 	color.Yellow("BATALHA (Host J1): Iniciando loop da batalha %s (%s vs %s)", battleID, b.Jogador1, b.Jogador2)
 
-	// pega os dados do j2 pra gnt saber pra qm responder
+	// ve se o j2 ainda ta por ai
 	s.muPlayers.RLock()
-	infoJ2, okJ2 := s.playerList[b.Jogador2]
+	_, okJ2 := s.playerList[b.Jogador2]
 	s.muPlayers.RUnlock()
 
 	if !okJ2 {
@@ -25,20 +119,49 @@ func (s *Server) iniciarBatalha(battleID string, b *models.Batalha, canalRespost
 		return
 	}
 
-	// avisa o j1 q comecou (o j2 ja foi avisado pelo handleBattleInitiate)
+	// avisa o j1 q comecou e qual eh o deck dele (o j2 ja foi avisado pelo handleBattleInitiate)
 	respInicioJ1 := models.RespostaInicioBatalha{
 		Mensagem:  b.Jogador2,
 		IdBatalha: battleID,
+		Deck:      b.DeckJ1,
 	}
 	s.sendToClient(canalRespostaJ1, "Inicio_Batalha", respInicioJ1)
 
 	time.Sleep(1 * time.Second) // da um segundinho pros clients respirarem
 
-	turno := 0
-	indice1, indice2 := 0, 0
-	var carta1, carta2 *models.Tanque
+	estado := &models.CheckpointBatalha{
+		IdBatalha: battleID,
+		HostID:    s.ID,
+		Jogador1:  b.Jogador1,
+		Jogador2:  b.Jogador2,
+		MaoJ1:     b.DeckJ1,
+		MaoJ2:     b.DeckJ2,
+		Ranqueada: b.Ranqueada,
+		DeckJ1:    b.DeckJ1,
+		DeckJ2:    b.DeckJ2,
+		Inicio:    time.Now().Unix(),
+	}
+	s.criarCheckpoint(estado) // ja salva o turno 0, se o host cair antes do 1o turno da pra retomar
+
+	s.rodarBatalha(battleID, b, canalRespostaJ1, estado)
+}
+
+// rodarBatalha eh o loop de turnos. comeca do estado recebido
+// (zerado numa batalha nova, ou o checkpoint numa batalha retomada).
+// as regras ficam no pacote combate, aqui eh so a parte de rede.
+func (s *Server) rodarBatalha(battleID string, b *models.Batalha, canalRespostaJ1 string, estado *models.CheckpointBatalha) {
+	// pega os dados do j2 pra gnt saber pra qm responder
+	s.muPlayers.RLock()
+	infoJ2, okJ2 := s.playerList[b.Jogador2]
+	s.muPlayers.RUnlock()
+
+	if !okJ2 {
+		s.encerrarBatalha(battleID, b.Jogador1, "J2 desconectou")
+		return
+	}
 
 	isSelfTest := b.ServidorJ1 == b.ServidorJ2 // checa se eh um teste local (j1 e j2 no msm server)
+	partida := combate.RetomarPartida(estado.MaoJ1, estado.MaoJ2, estado.Turno)
 
 	for {
 		// ve se alguem mandou a gnt parar (tipo o cleanup.go)
@@ -49,78 +172,74 @@ func (s *Server) iniciarBatalha(battleID string, b *models.Batalha, canalRespost
 		default:
 		}
 
-		//  pegar carta do j1 (local)
-		if carta1 == nil {
-			if indice1 >= 5 { // 5 cartas por partida
-				s.encerrarBatalha(battleID, b.Jogador2, "J1 sem cartas")
-				return
-			}
-			// pede a carta pro j1 (via redis)
-			s.sendToClient(canalRespostaJ1, "Pedir_Carta", models.RespostaPedirCarta{Indice: indice1})
-
-			// agora trava aqui e espera o j1 responder no canalj1
-			// (quem bota a carta aqui eh o handlers_redis.go)
-			novaCarta, ok := s.esperarCarta(b.CanalJ1, 20*time.Second) // 20s de timeout, se n responder ja era
-			if !ok {
-				s.encerrarBatalha(battleID, b.Jogador2, "Timeout J1")
-				return
-			}
-			carta1 = novaCarta
-			indice1++
+		if acabou, vencedor := partida.Terminou(); acabou {
+			nome, motivo := resultadoFinal(b, partida, vencedor)
+			s.encerrarBatalha(battleID, nome, motivo)
+			return
 		}
 
-		//  pegar carta do j2 (remoto ou self-test)
-		if carta2 == nil {
-			if indice2 >= 5 {
-				s.encerrarBatalha(battleID, b.Jogador1, "J2 sem cartas")
+		//  pede a carta pros dois ao mesmo tempo
+		// (cada um so ve a escolha do outro depois q o turno fecha)
+		s.sendToClient(canalRespostaJ1, "Pedir_Carta", models.RespostaPedirCarta{Turno: partida.Turno, Mao: partida.Mao(0)})
+		if isSelfTest {
+			// pede pro j2 localmente (via redis)
+			s.sendToClient(infoJ2.ReplyChannel, "Pedir_Carta", models.RespostaPedirCarta{Turno: partida.Turno, Mao: partida.Mao(1)})
+		} else {
+			// pede pro j2 la no outro server (via api)
+			reqMove := models.BattleRequestMoveRequest{IdBatalha: battleID, Turno: partida.Turno, Mao: partida.Mao(1)}
+			if err := s.sendToHost(infoJ2.ServerHost, "/battle/request_move", reqMove); err != nil {
+				s.encerrarBatalha(battleID, b.Jogador1, "Falha de rede ao pedir carta J2")
 				return
 			}
+		}
 
-			if isSelfTest {
-				// pede pro j2 localmente (via redis)
-				s.sendToClient(infoJ2.ReplyChannel, "Pedir_Carta", models.RespostaPedirCarta{Indice: indice2})
-			} else {
-				// pede pro j2 la no outro server (via api)
-				reqMove := models.BattleRequestMoveRequest{IdBatalha: battleID, Indice: indice2}
-				if err := s.sendToHost(infoJ2.ServerHost, "/battle/request_move", reqMove); err != nil {
-					s.encerrarBatalha(battleID, b.Jogador1, "Falha de rede ao pedir carta J2")
-					return
-				}
-			}
+		//  espera as duas escolhas
+		// (quem bota a carta do j1 aqui eh o handlers_redis.go, a do j2 vem pelo handlers_api.go)
+		escolha1, escolha2, ok := s.esperarEscolhas(b, TempoEscolha)
+		if !ok {
+			return // canal fechou, a batalha foi encerrada por fora
+		}
+		switch {
+		case escolha1 == nil && escolha2 == nil:
+			s.encerrarBatalha(battleID, "Ninguém", "Nenhum jogador escolheu a carta")
+			return
+		case escolha1 == nil:
+			s.encerrarBatalha(battleID, b.Jogador2, "Timeout J1")
+			return
+		case escolha2 == nil:
+			s.encerrarBatalha(battleID, b.Jogador1, "Timeout J2")
+			return
+		}
 
-			// agora espera o j2 responder no canalj2
-			// (quem bota a carta aqui eh o handlers_api.go)
-			novaCarta, ok := s.esperarCarta(b.CanalJ2, 20*time.Second) // 20s de timeout tbm
-			if !ok {
-				s.encerrarBatalha(battleID, b.Jogador1, "Timeout J2")
-				return
-			}
-			carta2 = novaCarta
-			indice2++
+		// a carta tem q estar na mao q o server deu pro jogador
+		if err := partida.ValidarEscolha(0, escolha1.Id); err != nil {
+			color.Red("BATALHA %s: Carta inválida de J1: %v", battleID, err)
+			s.encerrarBatalha(battleID, b.Jogador2, "Carta inválida J1")
+			return
+		}
+		if err := partida.ValidarEscolha(1, escolha2.Id); err != nil {
+			color.Red("BATALHA %s: Carta inválida de J2: %v", battleID, err)
+			s.encerrarBatalha(battleID, b.Jogador1, "Carta inválida J2")
+			return
 		}
 
 		//  processar o turno
-		var respTurno models.RespostaTurnoRealizado // cria a struct de resposta
-		if turno%2 == 0 {                           // turno par, j1 ataca
-			carta2.Vida -= carta1.Ataque
-			respTurno.Mensagem = fmt.Sprintf("Jogador %s (você) jogou no turno %d", b.Jogador1, turno)
-		} else { // turno impar, j2 ataca
-			carta1.Vida -= carta2.Ataque
-			respTurno.Mensagem = fmt.Sprintf("Jogador %s (oponente) jogou no turno %d", b.Jogador2, turno)
+		res, err := partida.ResolverTurno(escolha1.Id, escolha2.Id)
+		if err != nil {
+			color.Red("BATALHA %s: Falha ao resolver turno: %v", battleID, err)
+			s.encerrarBatalha(battleID, "Ninguém", "Erro interno")
+			return
 		}
 
-		// bota as cartas na resposta
-		respTurno.Cartas = []models.Tanque{*carta1, *carta2}
-
 		// manda pro j1 (o sendtoclient bota o 'tipo' generico)
+		respTurno := models.RespostaTurnoRealizado{
+			Mensagem: combate.Descrever(res, 0),
+			Cartas:   []models.Tanque{res.Cartas[0], res.Cartas[1]},
+		}
 		s.sendToClient(canalRespostaJ1, "Turno_Realizado", respTurno)
 
 		// ajusta a msg pro ponto de vista do j2
-		if turno%2 == 0 {
-			respTurno.Mensagem = fmt.Sprintf("Jogador %s (oponente) jogou no turno %d", b.Jogador1, turno)
-		} else {
-			respTurno.Mensagem = fmt.Sprintf("Jogador %s (você) jogou no turno %d", b.Jogador2, turno)
-		}
+		respTurno.Mensagem = combate.Descrever(res, 1)
 
 		if isSelfTest {
 			// envia pro j2 (se for self-test)
@@ -131,31 +250,74 @@ func (s *Server) iniciarBatalha(battleID string, b *models.Batalha, canalRespost
 			s.sendToHost(infoJ2.ServerHost, "/battle/turn_result", reqResult)
 		}
 
-		// ve se alguem morreu
-		if carta1.Vida <= 0 {
-			carta1 = nil
+		// turno fechado, salva pra quem for retomar (e o log vai pro historico no fim)
+		estado.Log = append(estado.Log, models.TurnoPartida{
+			Turno:       res.Turno,
+			CartaJ1:     res.Cartas[0],
+			CartaJ2:     res.Cartas[1],
+			DanoJ1:      res.Dano[0],
+			DanoJ2:      res.Dano[1],
+			DestruidaJ1: res.Destruidas[0],
+			DestruidaJ2: res.Destruidas[1],
+		})
+		estado.Turno = partida.Turno
+		estado.MaoJ1 = partida.Mao(0)
+		estado.MaoJ2 = partida.Mao(1)
+		if err := s.salvarCheckpoint(estado); err != nil {
+			// o peer achou q eu caí e adotou: ele q termina a batalha, eu so saio
+			color.Red("BATALHA %s: %v, largando a batalha", battleID, err)
+			s.largarBatalha(battleID)
+			return
 		}
-		if carta2.Vida <= 0 {
-			carta2 = nil
-		}
-
-		turno++
 		time.Sleep(1 * time.Second)
 	}
 }
 
-// funcao helper q espera uma carta chegar no canal, ou da timeout
-func (s *Server) esperarCarta(canal chan models.Tanque, tempo time.Duration) (*models.Tanque, bool) {
+// esperarEscolhas espera a carta dos dois jogadores, em qualquer ordem.
+// devolve nil pra quem nao escolheu a tempo, e ok=false se os canais fecharam (batalha encerrada)
+func (s *Server) esperarEscolhas(b *models.Batalha, tempo time.Duration) (*models.Tanque, *models.Tanque, bool) {
+	var escolha1, escolha2 *models.Tanque
 	timeout := time.After(tempo)
-	select {
-	case c, ok := <-canal:
-		if !ok {
-			return nil, false // canal fechou, deu ruim
+
+	for escolha1 == nil || escolha2 == nil {
+		select {
+		case c, ok := <-b.CanalJ1:
+			if !ok {
+				return nil, nil, false
+			}
+			if escolha1 == nil { // se mandar 2x no msm turno, vale a primeira
+				escolha1 = &c
+			}
+		case c, ok := <-b.CanalJ2:
+			if !ok {
+				return nil, nil, false
+			}
+			if escolha2 == nil {
+				escolha2 = &c
+			}
+		case <-timeout:
+			return escolha1, escolha2, true
 		}
-		return &c, true
-	case <-timeout:
-		return nil, false
 	}
+	return escolha1, escolha2, true
+}
+
+// resultadoFinal traduz o vencedor do combate pro id do jogador e o motivo
+func resultadoFinal(b *models.Batalha, partida *combate.Partida, vencedor int) (string, string) {
+	limite := partida.Turno >= combate.MaxTurnos && len(partida.Maos[0]) > 0 && len(partida.Maos[1]) > 0
+	switch {
+	case vencedor == combate.Empate && limite:
+		return "Ninguém", "Empate no limite de turnos"
+	case vencedor == combate.Empate:
+		return "Ninguém", "Empate, os dois ficaram sem cartas"
+	case limite && vencedor == 0:
+		return b.Jogador1, "Mais vida no limite de turnos"
+	case limite:
+		return b.Jogador2, "Mais vida no limite de turnos"
+	case vencedor == 0:
+		return b.Jogador1, "J2 sem cartas"
+	}
+	return b.Jogador2, "J1 sem cartas"
 }
 
 // funcao central pra limpar a batalha, fechar canais e avisar todo mundo
@@ -170,15 +332,17 @@ func (s *Server) encerrarBatalha(battleID, vencedor, motivo string) {
 	delete(s.batalhas, battleID)
 	s.muBatalhas.Unlock()
 
-	// fecha os canais pra destravar as goroutines
-	// manda um sinal nao-blocante pra goroutine da batalha parar (se ela ainda tiver la)
-	select {
-	case batalha.CanalEncerra <- true:
-	default:
+	// o log dos turnos ta no checkpoint, entao monta o registro antes de apagar (ver historico.go)
+	registro := s.registroDaBatalha(battleID, batalha, vencedor, motivo)
+	dono := s.fecharCheckpoint(battleID, batalha.Geracao) // acabou, ninguem mais precisa retomar
+	fecharCanaisBatalha(batalha)
+
+	if !dono {
+		// outro server adotou a batalha (achou q eu tinha caído): quem paga e avisa é ele
+		color.Red("BATALHA %s: Adotada por outro servidor, largando sem encerrar", battleID)
+		return
 	}
-	close(batalha.CanalEncerra)
-	close(batalha.CanalJ1)
-	close(batalha.CanalJ2)
+	s.liberarJogadores(battleID, batalha.Jogador1, batalha.Jogador2)
 
 	color.Yellow("BATALHA %s: Encerrada. Vencedor: %s. Motivo: %s", battleID, vencedor, motivo)
 
@@ -187,6 +351,22 @@ func (s *Server) encerrarBatalha(battleID, vencedor, motivo string) {
 		Mensagem: fmt.Sprintf("Batalha encerrada! Vencedor: %s (%s).", vencedor, motivo),
 	}
 
+	// paga o vencedor (a transacao eh por batalha, entao n paga 2x se encerrar de novo)
+	if vencedor == batalha.Jogador1 || vencedor == batalha.Jogador2 {
+		if s.recompensarVitoria(vencedor, battleID) {
+			respFim.Mensagem += fmt.Sprintf(" %s ganhou %d créditos.", vencedor, RecompensaVitoria)
+		}
+	}
+
+	// salva no historico e mexe no rating (so ranqueada)
+	registro, err := s.registrarPartida(registro)
+	if err != nil {
+		color.Red("BATALHA %s: Falha ao registrar no histórico: %v", battleID, err)
+	} else if registro.RatingJ1 > 0 {
+		respFim.Mensagem += fmt.Sprintf(" Rating: %s %d (%+d), %s %d (%+d).",
+			registro.Jogador1, registro.RatingJ1, registro.VariacaoJ1, registro.Jogador2, registro.RatingJ2, registro.VariacaoJ2)
+	}
+
 	// avisa os jogadores
 	s.muPlayers.RLock()
 	infoJ1, okJ1 := s.playerList[batalha.Jogador1]
@@ -207,3 +387,29 @@ func (s *Server) encerrarBatalha(battleID, vencedor, motivo string) {
 		}
 	}
 }
+
+// largarBatalha tira a batalha deste server sem pagar nem avisar ninguem
+// (usado qnd outro server adotou ela, ver battle_checkpoint.go)
+func (s *Server) largarBatalha(battleID string) {
+	s.muBatalhas.Lock()
+	batalha, ok := s.batalhas[battleID]
+	if ok {
+		delete(s.batalhas, battleID)
+	}
+	s.muBatalhas.Unlock()
+	if ok {
+		fecharCanaisBatalha(batalha)
+	}
+}
+
+// fecharCanaisBatalha fecha os canais pra destravar as goroutines da batalha
+func fecharCanaisBatalha(batalha *models.Batalha) {
+	// manda um sinal nao-blocante pra goroutine da batalha parar (se ela ainda tiver la)
+	select {
+	case batalha.CanalEncerra <- true:
+	default:
+	}
+	close(batalha.CanalEncerra)
+	close(batalha.CanalJ1)
+	close(batalha.CanalJ2)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Checkpoint e Retomada de Batalha ---
+
+// O host salva o estado da batalha (as maos com a vida de cada carta) no redis no fim de cada turno.
+// Se ele cair, o server do outro jogador (o peer) adota a batalha: vira o host,
+// espera o oponente reconectar em algum server e continua do ultimo turno fechado.
+//
+// A adoção vem de um health check perdido, entao o host antigo pode estar vivo ainda.
+// Pra dois servers nunca rodarem a msm batalha, o checkpoint é um hash com o dono (host + geracao)
+// e toda escrita é um compare-and-set nele: quem adota sobe a geracao, e o host antigo,
+// qnd for salvar o proximo turno (ou encerrar), ve q perdeu e larga a batalha sem pagar nada.
+const (
+	PrefixoCheckpoint = "checkpoint:"    // + id da batalha (ex: "checkpoint:battle:1a2b3c4d"), hash: host, geracao, dados
+	CheckpointTTL     = 10 * time.Minute // batalha parada mais q isso ja era
+	RetomadaTimeout   = 60 * time.Second // quanto tempo esperar o oponente reconectar
+)
+
+var errCheckpointPerdido = errors.New("outro servidor adotou a batalha")
+
+func chaveCheckpoint(battleID string) string {
+	return PrefixoCheckpoint + battleID
+}
+
+// scriptSalvarCheckpoint grava o estado, mas so se o checkpoint ainda for desse host nessa geracao.
+// KEYS[1] = checkpoint
+// ARGV[1] = host, ARGV[2] = geracao, ARGV[3] = estado em JSON, ARGV[4] = TTL (ms), ARGV[5] = "1" se a batalha é nova
+// Retorna 1 = salvo, 0 = o checkpoint é de outro (ou a batalha ja acabou)
+var scriptSalvarCheckpoint = redis.NewScript(`
+if ARGV[5] == '1' then
+	if redis.call('EXISTS', KEYS[1]) == 1 then return 0 end
+elseif redis.call('HGET', KEYS[1], 'host') ~= ARGV[1] or redis.call('HGET', KEYS[1], 'geracao') ~= ARGV[2] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'host', ARGV[1], 'geracao', ARGV[2], 'dados', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+// scriptAdotarCheckpoint passa o checkpoint pro novo host e sobe a geracao.
+// So adota se o dono ainda for o host q caiu (se outro peer ja adotou, n faz nada).
+// KEYS[1] = checkpoint
+// ARGV[1] = host antigo, ARGV[2] = novo host, ARGV[3] = TTL (ms)
+// Retorna {geracao nova, estado em JSON} ou {0} se n deu
+var scriptAdotarCheckpoint = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'host') ~= ARGV[1] then return {0} end
+local geracao = redis.call('HINCRBY', KEYS[1], 'geracao', 1)
+redis.call('HSET', KEYS[1], 'host', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return {geracao, redis.call('HGET', KEYS[1], 'dados')}
+`)
+
+// scriptFecharCheckpoint apaga o checkpoint no fim da batalha, se ele ainda for desse host.
+// KEYS[1] = checkpoint
+// ARGV[1] = host, ARGV[2] = geracao
+// Retorna 1 = apagado, 0 = é de outro host, -1 = n existe (batalha q nem chegou a salvar)
+var scriptFecharCheckpoint = redis.NewScript(`
+local host = redis.call('HGET', KEYS[1], 'host')
+if not host then return -1 end
+if host ~= ARGV[1] or redis.call('HGET', KEYS[1], 'geracao') ~= ARGV[2] then return 0 end
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// criarCheckpoint grava o turno 0 de uma batalha nova (geracao 1)
+func (s *Server) criarCheckpoint(estado *models.CheckpointBatalha) {
+	estado.Geracao = 1
+	s.gravarCheckpoint(estado, true)
+}
+
+// salvarCheckpoint grava o estado atual da batalha (se o redis falhar, a batalha segue, so n da pra retomar).
+// Retorna errCheckpointPerdido se outro server adotou a batalha: ai quem chamou tem q largar ela.
+func (s *Server) salvarCheckpoint(estado *models.CheckpointBatalha) error {
+	return s.gravarCheckpoint(estado, false)
+}
+
+func (s *Server) gravarCheckpoint(estado *models.CheckpointBatalha, nova bool) error {
+	dados, err := json.Marshal(estado)
+	if err != nil {
+		color.Red("BATALHA %s: Falha ao serializar checkpoint: %v", estado.IdBatalha, err)
+		return nil
+	}
+	flag := "0"
+	if nova {
+		flag = "1"
+	}
+	res, err := scriptSalvarCheckpoint.Run(s.ctx, s.redisClient, []string{chaveCheckpoint(estado.IdBatalha)},
+		estado.HostID, estado.Geracao, dados, CheckpointTTL.Milliseconds(), flag).Int()
+	if err != nil {
+		color.Red("BATALHA %s: Falha ao salvar checkpoint: %v", estado.IdBatalha, err)
+		return nil
+	}
+	if res == 0 {
+		return errCheckpointPerdido
+	}
+	s.renovarOcupado(estado.IdBatalha, CheckpointTTL, estado.Jogador1, estado.Jogador2) // (do ocupado.go)
+	return nil
+}
+
+// carregarCheckpoint le o ultimo estado salvo da batalha (o dono vem dos campos do hash, q sao os q valem)
+func (s *Server) carregarCheckpoint(battleID string) (models.CheckpointBatalha, bool) {
+	campos, err := s.redisClient.HGetAll(s.ctx, chaveCheckpoint(battleID)).Result()
+	if err != nil {
+		color.Red("BATALHA %s: Falha ao ler checkpoint: %v", battleID, err)
+		return models.CheckpointBatalha{}, false
+	}
+	if campos["dados"] == "" {
+		return models.CheckpointBatalha{}, false
+	}
+	var estado models.CheckpointBatalha
+	if err := json.Unmarshal([]byte(campos["dados"]), &estado); err != nil {
+		color.Red("BATALHA %s: Checkpoint corrompido: %v", battleID, err)
+		return models.CheckpointBatalha{}, false
+	}
+	estado.HostID = campos["host"]
+	estado.Geracao, _ = strconv.ParseInt(campos["geracao"], 10, 64)
+	return estado, true
+}
+
+// tomarCheckpoint adota o checkpoint de um host q caiu e devolve o estado mais recente dele
+// (o host antigo pode ter fechado mais um turno entre o health check e a adoção).
+// ok == false se outro server ja adotou ou a batalha acabou.
+func (s *Server) tomarCheckpoint(battleID, hostAntigo string) (models.CheckpointBatalha, bool) {
+	res, err := scriptAdotarCheckpoint.Run(s.ctx, s.redisClient, []string{chaveCheckpoint(battleID)},
+		hostAntigo, s.ID, CheckpointTTL.Milliseconds()).Slice()
+	if err != nil {
+		color.Red("BATALHA %s: Falha ao adotar checkpoint: %v", battleID, err)
+		return models.CheckpointBatalha{}, false
+	}
+	geracao, _ := res[0].(int64)
+	if geracao == 0 || len(res) < 2 {
+		return models.CheckpointBatalha{}, false
+	}
+	dados, _ := res[1].(string)
+	var estado models.CheckpointBatalha
+	if err := json.Unmarshal([]byte(dados), &estado); err != nil {
+		color.Red("BATALHA %s: Checkpoint corrompido: %v", battleID, err)
+		return models.CheckpointBatalha{}, false
+	}
+	estado.HostID = s.ID
+	estado.Geracao = geracao
+	s.renovarOcupado(battleID, CheckpointTTL, estado.Jogador1, estado.Jogador2) // agora sou eu q seguro a marca
+	return estado, true
+}
+
+// fecharCheckpoint apaga o checkpoint no fim da batalha.
+// Retorna false se o checkpoint é de outro host: a batalha foi adotada e quem encerra é ele.
+func (s *Server) fecharCheckpoint(battleID string, geracao int64) bool {
+	res, err := scriptFecharCheckpoint.Run(s.ctx, s.redisClient, []string{chaveCheckpoint(battleID)}, s.ID, geracao).Int()
+	if err != nil {
+		// sem redis n da pra saber, segue encerrando (a recompensa e o historico sao idempotentes por batalha)
+		color.Red("BATALHA %s: Falha ao apagar checkpoint: %v", battleID, err)
+		return true
+	}
+	return res != 0
+}
+
+// inverterCheckpoint troca j1 <-> j2 (o novo host tem q ser o j1)
+func inverterCheckpoint(estado *models.CheckpointBatalha) {
+	estado.Jogador1, estado.Jogador2 = estado.Jogador2, estado.Jogador1
+	estado.MaoJ1, estado.MaoJ2 = estado.MaoJ2, estado.MaoJ1
+	estado.DeckJ1, estado.DeckJ2 = estado.DeckJ2, estado.DeckJ1
+	for i := range estado.Log {
+		t := &estado.Log[i]
+		t.CartaJ1, t.CartaJ2 = t.CartaJ2, t.CartaJ1
+		t.DanoJ1, t.DanoJ2 = t.DanoJ2, t.DanoJ1
+		t.DestruidaJ1, t.DestruidaJ2 = t.DestruidaJ2, t.DestruidaJ1
+	}
+}
+
+// adotarBatalha eh chamada no peer (s2) qnd o host da batalha morreu.
+// o nosso jogador local vira o j1 e o oponente (q tava no server morto) vira o j2.
+// o host antigo pode so ter perdido um health check: a adoção sobe a geracao do checkpoint
+// antes de qualquer coisa, entao se ele tiver vivo ele larga a batalha no proximo turno.
+func (s *Server) adotarBatalha(battleID, jogadorLocal, hostAntigo string) {
+	estado, ok := s.tomarCheckpoint(battleID, hostAntigo)
+	if !ok {
+		color.Yellow("[Retomada]: Batalha %s já foi adotada ou encerrada, nada a fazer", battleID)
+		return
+	}
+	color.Yellow("[Retomada]: Adotando batalha %s (host %s caiu, turno %d, geração %d)", battleID, hostAntigo, estado.Turno, estado.Geracao)
+
+	if estado.Jogador1 != jogadorLocal {
+		inverterCheckpoint(&estado)
+	}
+	oponente := estado.Jogador2
+
+	s.muPlayers.RLock()
+	infoLocal, okLocal := s.playerList[jogadorLocal]
+	s.muPlayers.RUnlock()
+	if !okLocal {
+		color.Red("[Retomada]: Jogador local %s sumiu, descartando batalha %s", jogadorLocal, battleID)
+		if s.fecharCheckpoint(battleID, estado.Geracao) {
+			// o tomarCheckpoint marcou os dois como ocupados: sem isso eles ficam presos até o TTL
+			s.liberarJogadores(battleID, estado.Jogador1, estado.Jogador2)
+		}
+		return
+	}
+
+	// registra a batalha ja, assim o encerrarBatalha funciona se o oponente n voltar
+	batalha := &models.Batalha{
+		Jogador1:     jogadorLocal,
+		Jogador2:     oponente,
+		ServidorJ1:   s.HostAPI,
+		CanalJ1:      make(chan models.Tanque, 1),
+		CanalJ2:      make(chan models.Tanque, 1),
+		CanalEncerra: make(chan bool, 1),
+		Ranqueada:    estado.Ranqueada,
+		Geracao:      estado.Geracao,
+	}
+	s.muBatalhas.Lock()
+	s.batalhas[battleID] = batalha
+	s.muBatalhas.Unlock()
+
+	// espera o oponente aparecer conectado em outro server (o cliente dele reconecta sozinho)
+	infoOponente, ok := s.esperarReconexao(oponente, hostAntigo, RetomadaTimeout)
+	if !ok {
+		s.encerrarBatalha(battleID, jogadorLocal, "Oponente não reconectou")
+		return
+	}
+	batalha.ServidorJ2 = infoOponente.ServerHost
+
+	// avisa o oponente q a batalha voltou
+	if infoOponente.ServerID == s.ID {
+		// os dois agora tao em mim (vira um self-test)
+		s.muBatalhasPeer.Lock()
+		s.batalhasPeer[battleID] = peerBattleInfo{PlayerID: oponente, HostAPI: s.HostAPI}
+		s.muBatalhasPeer.Unlock()
+		s.sendToClient(infoOponente.ReplyChannel, "Inicio_Batalha", models.RespostaInicioBatalha{
+			Mensagem: jogadorLocal, IdBatalha: battleID, Retomada: true,
+		})
+	} else {
+		initReq := models.BattleInitiateRequest{
+			IdBatalha:      battleID,
+			IdJogadorLocal: oponente,
+			IdOponente:     jogadorLocal,
+			HostServidor:   s.HostAPI,
+			Retomada:       true,
+		}
+		if err := s.sendToHost(infoOponente.ServerHost, "/battle/initiate", initReq); err != nil {
+			s.encerrarBatalha(battleID, jogadorLocal, "Falha ao retomar com o servidor do oponente")
+			return
+		}
+	}
+
+	// e avisa o nosso jogador
+	s.sendToClient(infoLocal.ReplyChannel, "Inicio_Batalha", models.RespostaInicioBatalha{
+		Mensagem: oponente, IdBatalha: battleID, Retomada: true,
+	})
+
+	if err := s.salvarCheckpoint(&estado); err != nil {
+		s.largarBatalha(battleID)
+		return
+	}
+	color.Green("[Retomada]: Batalha %s retomada no turno %d (%s vs %s)", battleID, estado.Turno, jogadorLocal, oponente)
+
+	time.Sleep(1 * time.Second) // da um segundinho pros clients respirarem
+	s.rodarBatalha(battleID, batalha, infoLocal.ReplyChannel, &estado)
+}
+
+// esperarReconexao espera o jogador aparecer na lista conectado num server diferente do q caiu
+func (s *Server) esperarReconexao(playerID, serverMorto string, timeout time.Duration) (PlayerInfo, bool) {
+	limite := time.Now().Add(timeout)
+	for time.Now().Before(limite) {
+		s.muPlayers.RLock()
+		info, ok := s.playerList[playerID]
+		s.muPlayers.RUnlock()
+		if ok && info.ServerID != serverMorto && info.ServerHost != "" {
+			return info, true
+		}
+		time.Sleep(1 * time.Second)
+	}
+	return PlayerInfo{}, false
+}
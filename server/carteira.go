@@ -0,0 +1,136 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Carteira e Extrato (Redis) ---
+
+// Cada jogador tem um saldo de créditos e um extrato só de escrita (append-only) no Redis.
+// Toda movimentação tem um id de transação: se a mesma transação chegar duas vezes
+// (retry, dois servers processando, batalha encerrada de novo) ela só vale uma vez.
+// As chaves usam a hash tag "{inventario}" pra venda de pacote debitar no mesmo script q entrega as cartas.
+const (
+	PrefixoCarteira = "{inventario}:carteira:"  // saldo do jogador
+	PrefixoExtrato  = "{inventario}:extrato:"   // lista de lançamentos do jogador (JSON), só cresce
+	ChaveTransacoes = "{inventario}:transacoes" // id da transação -> saldo depois dela (idempotência)
+
+	SaldoInicial      = 300 // créditos q todo jogador novo ganha
+	RecompensaVitoria = 50  // créditos por batalha vencida
+	TamanhoExtrato    = 10  // quantos lançamentos o comando "Saldo" mostra
+)
+
+// errSaldoInsuficiente indica q o jogador não tem créditos pra operação
+var errSaldoInsuficiente = errors.New("saldo insuficiente")
+
+// errTransacaoRepetida indica q a transação já tinha sido processada antes
+var errTransacaoRepetida = errors.New("transação já processada")
+
+func chaveCarteira(playerID string) string { return PrefixoCarteira + playerID }
+func chaveExtrato(playerID string) string  { return PrefixoExtrato + playerID }
+
+// luaLancar é a função lua q movimenta o saldo e grava o lançamento no extrato.
+// Vai na frente dos scripts q mexem na carteira (esse aqui, pacote, troca e mercado),
+// assim o formato do extrato é um só. Quem chama já conferiu transação repetida e saldo.
+const luaLancar = `
+local function lancar(chaveSaldo, chaveExtrato, chaveTx, tx, jogador, valor, motivo, quando)
+	local saldo = redis.call('INCRBY', chaveSaldo, valor)
+	redis.call('HSET', chaveTx, tx, saldo)
+	redis.call('RPUSH', chaveExtrato, cjson.encode({tx = tx, jogador = jogador, valor = valor,
+		saldo = saldo, motivo = motivo, quando = quando}))
+	return saldo
+end
+`
+
+// scriptMovimentarCarteira credita (valor > 0) ou debita (valor < 0) a carteira.
+// KEYS[1] = saldo, KEYS[2] = extrato, KEYS[3] = transações
+// ARGV[1] = id da transação, ARGV[2] = id do jogador, ARGV[3] = valor, ARGV[4] = motivo, ARGV[5] = quando (unix)
+// Retorna {status, saldo}: 1 = ok, 0 = transação repetida (saldo daquela vez), -1 = saldo insuficiente.
+var scriptMovimentarCarteira = redis.NewScript(luaLancar + `
+if redis.call('HEXISTS', KEYS[3], ARGV[1]) == 1 then
+	return {0, tonumber(redis.call('HGET', KEYS[3], ARGV[1]))}
+end
+local saldo = tonumber(redis.call('GET', KEYS[1]) or '0')
+local valor = tonumber(ARGV[3])
+if saldo + valor < 0 then return {-1, saldo} end
+return {1, lancar(KEYS[1], KEYS[2], KEYS[3], ARGV[1], ARGV[2], valor, ARGV[4], tonumber(ARGV[5]))}
+`)
+
+// movimentarCarteira aplica uma transação na carteira e devolve o saldo depois dela
+func (s *Server) movimentarCarteira(playerID, tx string, valor int, motivo string) (int, error) {
+	res, err := scriptMovimentarCarteira.Run(s.ctx, s.redisClient,
+		[]string{chaveCarteira(playerID), chaveExtrato(playerID), ChaveTransacoes},
+		tx, playerID, valor, motivo, time.Now().Unix()).Int64Slice()
+	if err != nil {
+		return 0, fmt.Errorf("falha no script da carteira: %v", err)
+	}
+	saldo := int(res[1])
+	switch res[0] {
+	case 0:
+		return saldo, errTransacaoRepetida
+	case -1:
+		return saldo, errSaldoInsuficiente
+	}
+	return saldo, nil
+}
+
+// txCompra monta o id da transação de uma compra. O id q o cliente manda vai junto com o dele,
+// pra um jogador nunca conseguir colidir com transação de outro. Sem id, gera um (aí n tem retry seguro).
+func txCompra(playerID, idCliente string) string {
+	if idCliente == "" {
+		idCliente = uuid.NewString()
+	}
+	return "compra:" + playerID + ":" + idCliente
+}
+
+// darSaldoInicial credita o saldo de boas-vindas (só na primeira vez, a transação é por jogador)
+func (s *Server) darSaldoInicial(playerID string) {
+	saldo, err := s.movimentarCarteira(playerID, "inicial:"+playerID, SaldoInicial, "Saldo inicial")
+	if err == nil {
+		color.Cyan("CARTEIRA: %s ganhou o saldo inicial (%d créditos)", playerID, saldo)
+	} else if err != errTransacaoRepetida {
+		color.Red("CARTEIRA: Falha ao dar saldo inicial para %s: %v", playerID, err)
+	}
+}
+
+// recompensarVitoria credita o vencedor da batalha (a transação é por batalha, então
+// mesmo se ela for encerrada duas vezes, tipo na retomada, paga uma vez só)
+func (s *Server) recompensarVitoria(playerID, battleID string) bool {
+	_, err := s.movimentarCarteira(playerID, "vitoria:"+battleID, RecompensaVitoria, "Vitória na batalha "+battleID)
+	if err != nil && err != errTransacaoRepetida {
+		color.Red("CARTEIRA: Falha ao pagar vitória de %s na batalha %s: %v", playerID, battleID, err)
+		return false
+	}
+	return err == nil
+}
+
+// lerCarteira devolve o saldo e os últimos lançamentos do extrato
+func (s *Server) lerCarteira(playerID string) (int, []models.LancamentoCarteira, error) {
+	saldo, err := s.redisClient.Get(s.ctx, chaveCarteira(playerID)).Int()
+	if err != nil && err != redis.Nil {
+		return 0, nil, err
+	}
+	linhas, err := s.redisClient.LRange(s.ctx, chaveExtrato(playerID), -TamanhoExtrato, -1).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	extrato := make([]models.LancamentoCarteira, 0, len(linhas))
+	for _, linha := range linhas {
+		var l models.LancamentoCarteira
+		if err := json.Unmarshal([]byte(linha), &l); err != nil {
+			color.Red("CARTEIRA: Lançamento corrompido no extrato de %s: %v", playerID, err)
+			continue
+		}
+		extrato = append(extrato, l)
+	}
+	return saldo, extrato, nil
+}
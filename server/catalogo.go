@@ -0,0 +1,166 @@
+package main
+
+import (
+	"PlanoZ/catalogo"
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fatih/color"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Catálogo de Cartas ---
+
+// Cada server carrega o catálogo do CATALOGO_PATH (ou o padrão embutido), mas quem vale é o
+// catálogo publicado no Redis: ao subir (ou no reload) o server publica o dele se a versão for
+// maior, senão adota o q ta lá. Assim o cluster nunca roda dois catálogos misturados
+// (e a venda de pacote confere a versão no script, ver inventory.go).
+const (
+	ChaveCatalogo       = "{inventario}:catalogo"        // catálogo ativo (JSON)
+	ChaveVersaoCatalogo = "{inventario}:catalogo_versao" // versão do catálogo ativo
+)
+
+// errCatalogoVelho indica q a versão do arquivo não é maior q a q ja ta publicada
+var errCatalogoVelho = errors.New("versão do catálogo não é maior que a publicada")
+
+// scriptPublicarCatalogo publica o catálogo se a versão for maior que a atual.
+// KEYS[1] = catálogo, KEYS[2] = versão; ARGV[1] = versão nova, ARGV[2] = catálogo (JSON)
+// Retorna o catálogo q ficou ativo (o novo ou o q ja tava).
+var scriptPublicarCatalogo = redis.NewScript(`
+local atual = tonumber(redis.call('GET', KEYS[2]) or '0')
+if tonumber(ARGV[1]) > atual then
+	redis.call('SET', KEYS[1], ARGV[2])
+	redis.call('SET', KEYS[2], ARGV[1])
+	return ARGV[2]
+end
+return redis.call('GET', KEYS[1])
+`)
+
+// catalogoAtual devolve o catálogo em uso (nunca é nil depois do startup)
+func (s *Server) catalogoAtual() *catalogo.Catalogo {
+	return s.catalogo.Load()
+}
+
+// versaoCatalogo devolve a versão em uso (0 se ainda não carregou)
+func (s *Server) versaoCatalogo() int64 {
+	if c := s.catalogo.Load(); c != nil {
+		return c.Versao
+	}
+	return 0
+}
+
+// carregarCatalogo lê o catálogo do CATALOGO_PATH e sincroniza com o Redis
+func (s *Server) carregarCatalogo() error {
+	local, err := catalogo.Carregar(getEnv("CATALOGO_PATH", ""))
+	if err != nil {
+		return err
+	}
+	ativo, err := s.publicarCatalogo(local)
+	if err != nil {
+		return err
+	}
+	if ativo.Versao != local.Versao {
+		color.Yellow("[Catálogo]: Arquivo local tem a versão %d, mas o cluster já usa a %d. Usando a do cluster.", local.Versao, ativo.Versao)
+	}
+	return nil
+}
+
+// publicarCatalogo tenta publicar o catálogo no Redis e passa a usar o q ficou ativo
+func (s *Server) publicarCatalogo(c *catalogo.Catalogo) (*catalogo.Catalogo, error) {
+	dados, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	ativoJSON, err := scriptPublicarCatalogo.Run(s.ctx, s.redisClient,
+		[]string{ChaveCatalogo, ChaveVersaoCatalogo}, c.Versao, dados).Text()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao publicar catálogo: %v", err)
+	}
+	ativo, err := catalogo.Ler([]byte(ativoJSON))
+	if err != nil {
+		return nil, fmt.Errorf("catálogo do Redis inválido: %v", err)
+	}
+	s.usarCatalogo(ativo)
+	return ativo, nil
+}
+
+// sincronizarCatalogo relê o catálogo ativo do Redis (qnd outro server publicou um mais novo)
+func (s *Server) sincronizarCatalogo() error {
+	dados, err := s.redisClient.Get(s.ctx, ChaveCatalogo).Bytes()
+	if err != nil {
+		return fmt.Errorf("falha ao ler catálogo do Redis: %v", err)
+	}
+	ativo, err := catalogo.Ler(dados)
+	if err != nil {
+		return fmt.Errorf("catálogo do Redis inválido: %v", err)
+	}
+	s.usarCatalogo(ativo)
+	return nil
+}
+
+// usarCatalogo troca o catálogo em uso (só se for mais novo, pra nunca voltar versão)
+func (s *Server) usarCatalogo(c *catalogo.Catalogo) {
+	for {
+		atual := s.catalogo.Load()
+		if atual != nil && atual.Versao >= c.Versao {
+			return
+		}
+		if s.catalogo.CompareAndSwap(atual, c) {
+			color.Green("[Catálogo]: Usando catálogo versão %d (%d cartas, %d pacotes)", c.Versao, len(c.Cartas), len(c.Pacotes))
+			// pacote novo no catálogo ganha o estoque inicial dele (os q ja existem mantêm o do redis)
+			if err := s.inicializarEstoques(c); err != nil {
+				color.Red("[Catálogo]: Falha ao inicializar estoques: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// (admin) POST /admin/catalogo/reload: relê o arquivo, valida e publica pro cluster todo
+func (s *Server) handleCatalogoReload(c *gin.Context) {
+	novo, err := catalogo.Carregar(getEnv("CATALOGO_PATH", ""))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if novo.Versao <= s.versaoCatalogo() {
+		c.JSON(http.StatusConflict, gin.H{"error": errCatalogoVelho.Error(), "versao_atual": s.versaoCatalogo()})
+		return
+	}
+
+	ativo, err := s.publicarCatalogo(novo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ativo.Versao != novo.Versao {
+		// alguem publicou uma versão maior entre a leitura e o script
+		c.JSON(http.StatusConflict, gin.H{"error": errCatalogoVelho.Error(), "versao_atual": ativo.Versao})
+		return
+	}
+
+	// avisa os outros pra relerem do redis (quem perder o aviso pega pelo /health)
+	s.broadcastToServers("/catalogo/update", models.CatalogoUpdateRequest{Versao: ativo.Versao})
+	c.JSON(http.StatusOK, gin.H{"message": "Catálogo publicado", "versao": ativo.Versao, "cartas": len(ativo.Cartas)})
+}
+
+// (server -> todos) POST /catalogo/update: tem catálogo novo no redis
+func (s *Server) handleCatalogoUpdate(c *gin.Context) {
+	var req models.CatalogoUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
+		return
+	}
+	if req.Versao > s.versaoCatalogo() {
+		if err := s.sincronizarCatalogo(); err != nil {
+			color.Red("[Catálogo]: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"versao": s.versaoCatalogo()})
+}
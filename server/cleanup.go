@@ -13,7 +13,7 @@ func (s *Server) limparRecursosServidoresMortos(deadServerIDs []string) {
 	// Converte IDs (ex: "server1") para Hosts API (ex: "server1:9090")
 	deadServerHosts := make(map[string]bool)
 	for _, id := range deadServerIDs {
-		if host, ok := s.serverList[id]; ok {
+		if host, ok := s.hostDe(id); ok {
 			deadServerHosts[host] = true
 		}
 	}
@@ -74,7 +74,15 @@ func (s *Server) limparBatalhasPeer(deadServerHosts map[string]bool) {
 	}
 	s.muBatalhasPeer.Unlock()
 
-	// Agora, notifica os clientes J2 locais
+	// Se o host deixou checkpoint, a gnt adota a batalha em vez de encerrar (battle_checkpoint.go)
+	for battleID, peerInfo := range batalhasAMatar {
+		if estado, ok := s.carregarCheckpoint(battleID); ok {
+			go s.adotarBatalha(battleID, peerInfo.PlayerID, estado.HostID)
+			delete(batalhasAMatar, battleID)
+		}
+	}
+
+	// Agora, notifica os clientes J2 locais (das batalhas q n deu pra retomar)
 	s.muPlayers.RLock()
 	defer s.muPlayers.RUnlock()
 
@@ -127,8 +135,11 @@ func (s *Server) limparPlayers(deadServerIDs []string) {
 			PlayerID: playerID,
 			ServerID: info.ServerID, // O ID do servidor MORTO
 			Acao:     "remove",
+			IdLider:  s.ID,
+			Termo:    s.termoAtual(),
 		}
 		s.broadcastToServers("/players/update", updateRemove)
+		s.desparear(playerID, "Desconexão", "%s desconectou") // avisa o parceiro (se ele tiver em outro server)
 	}
 
 	if len(playersARemover) > 0 {
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"PlanoZ/assinatura"
+	"PlanoZ/models"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// --- Contas e Sessões ---
+
+// O jogador tem uma conta (usuário + bcrypt) e o id dele é fixo, então as cartas e a carteira sobrevivem
+// ao cliente fechar. A senha n sai do cliente: ele deriva dela o segredo da conta (assinatura.SegredoDaConta)
+// e manda o segredo selado pra chave de registro do cluster. No Redis fica so o bcrypt do segredo, então
+// nem quem tem o Redis e o CLUSTER_SECRET consegue logar como outro jogador.
+// Depois do bcrypt o server sorteia o token da sessão, e a chave da sessão sai do token e de uma chave
+// do cluster: n é guardada, e vai pro cliente cifrada com a chave de resposta q veio no selo.
+// Toda requisição depois disso vai num envelope com o token, assinado com essa chave (ver assinatura.go),
+// e o server confere se ele é mesmo daquele jogador e daquele canal.
+// Na reconexão (server caiu) o cliente manda só o token, sem pedir a senha de novo.
+// Conta antiga é atualizada pro bcrypt do segredo no próximo login: a do bcrypt da senha pede a senha
+// uma vez ("Conta_Antiga"), e a q guardava o segredo cifrado confere com ele.
+const (
+	PrefixoConta       = "conta:"                 // conta do jogador (JSON), pelo usuário em minúsculo
+	PrefixoSessao      = "sessao:"                // token -> Sessao (JSON, com TTL)
+	PrefixoCanalSessao = "canal_sessao:"          // canal de resposta -> token (pra achar a chave q assina o q vai pro cliente)
+	ChaveRegistro      = "cluster:chave_registro" // chave pública de registro (hex), pro cliente q n tem CLUSTER_PUBKEY
+
+	SessaoTTLPadrao = 24 * time.Hour // renovado a cada requisição
+
+	AcaoRegistrar = "Registrar"
+	AcaoLogin     = "Login"
+	AcaoRetomar   = "Retomar" // reconexão com o token q o cliente ja tem
+)
+
+var (
+	errUsuarioExiste   = errors.New("usuário já existe")
+	errLoginInvalido   = errors.New("usuário ou senha inválidos")
+	errSessaoInvalida  = errors.New("sessão inválida ou expirada, faça login de novo")
+	errUsuarioInvalido = errors.New("usuário precisa ter de 3 a 20 letras, números ou _")
+	errSegredoInvalido = errors.New("segredo da conta inválido")
+	errContaAntiga     = errors.New("conta criada numa versão antiga, confirmando a senha")
+	errCanalEmUso      = errors.New("canal de resposta em uso por outro jogador")
+)
+
+var regexUsuario = regexp.MustCompile(`^[a-zA-Z0-9_]{3,20}$`)
+
+// Conta é o q fica salvo no Redis. Só uma das 3 formas de conferir o login vem preenchida
+type Conta struct {
+	Usuario     string `json:"usuario"`
+	IdJogador   string `json:"id_jogador"`
+	SegredoHash string `json:"segredo_hash,omitempty"` // bcrypt do segredo da conta
+	SenhaHash   string `json:"senha_hash,omitempty"`   // conta antiga: bcrypt da senha (vira SegredoHash no login)
+	Segredo     string `json:"segredo,omitempty"`      // conta antiga: segredo cifrado com a chave das contas (idem)
+	CriadaEm    int64  `json:"criada_em"`
+}
+
+// Sessao é o q fica salvo no Redis pra cada token
+type Sessao struct {
+	Token     string `json:"-"`
+	IdJogador string `json:"id_jogador"`
+	Chave     string `json:"-"`     // chave do HMAC dos envelopes (derivada do token, nunca vai pro Redis)
+	Canal     string `json:"canal"` // canal de resposta do cliente (as requisições têm q usar esse)
+}
+
+func chaveConta(usuario string) string     { return PrefixoConta + strings.ToLower(usuario) }
+func chaveSessao(token string) string      { return PrefixoSessao + token }
+func chaveCanalSessao(canal string) string { return PrefixoCanalSessao + canal }
+
+// autenticar resolve o pedido de conexão (registrar, login ou retomar) e devolve a sessão.
+// 'atual' é a sessão do envelope (so existe no Retomar, q ja chega assinado). O Login e o Registrar
+// chegam sem token, e o envelope deles é conferido aqui com a chave de resposta q veio no selo.
+func (s *Server) autenticar(req models.ReqConectar, selo models.SeloConectar, env models.Envelope, atual *Sessao) (*Sessao, error) {
+	if req.Acao == AcaoRetomar {
+		if atual == nil || atual.Canal != req.CanalResposta {
+			return nil, errSessaoInvalida
+		}
+		return atual, nil
+	}
+	if req.Acao != AcaoRegistrar && req.Acao != AcaoLogin {
+		return nil, errors.New("faça login ou registre uma conta")
+	}
+
+	// so quem montou o selo sabe a chave de resposta, então o envelope n da pra trocar nem repetir
+	if err := s.conferirLogin(selo.ChaveResposta, env); err != nil {
+		return nil, err
+	}
+	segredo, err := hex.DecodeString(selo.Segredo)
+	if err != nil || len(segredo) != assinatura.TamanhoSegredo {
+		return nil, errSegredoInvalido
+	}
+
+	if req.Acao == AcaoRegistrar {
+		conta, err := s.registrarConta(req.Usuario, segredo)
+		if err != nil {
+			return nil, err
+		}
+		s.darSaldoInicial(conta.IdJogador) // conta nova ganha os creditos iniciais
+		return s.criarSessao(conta, req.CanalResposta)
+	}
+
+	conta, err := s.buscarConta(req.Usuario)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.conferirSegredo(conta, segredo, selo.Senha); err != nil {
+		return nil, err
+	}
+	return s.criarSessao(conta, req.CanalResposta)
+}
+
+// conferirLogin confere o envelope do Login/Registrar com a chave de resposta e queima o nonce dele
+func (s *Server) conferirLogin(chaveResposta string, env models.Envelope) error {
+	if err := assinatura.Verificar(chaveResposta, env, time.Now()); err != nil {
+		return err
+	}
+	return s.marcarNonce(env.Nonce, "login")
+}
+
+// conferirSegredo confere o segredo do login com o q ta na conta. Conta antiga (bcrypt da senha ou
+// segredo cifrado) q bate é atualizada pro bcrypt do segredo.
+func (s *Server) conferirSegredo(conta Conta, segredo []byte, senha string) error {
+	switch {
+	case conta.SegredoHash != "":
+		if bcrypt.CompareHashAndPassword([]byte(conta.SegredoHash), segredo) != nil {
+			return errLoginInvalido
+		}
+		return nil
+
+	case conta.Segredo != "":
+		guardado, err := s.abrirSegredo(conta)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(guardado, segredo) {
+			return errLoginInvalido
+		}
+
+	case conta.SenhaHash != "":
+		// o bcrypt é da senha: o cliente manda ela uma vez (selada) pra conta virar bcrypt do segredo
+		if senha == "" {
+			return errContaAntiga
+		}
+		if bcrypt.CompareHashAndPassword([]byte(conta.SenhaHash), []byte(senha)) != nil {
+			return errLoginInvalido
+		}
+		derivado, err := assinatura.SegredoDaConta(conta.Usuario, senha)
+		if err != nil || !hmac.Equal(derivado, segredo) {
+			return errLoginInvalido
+		}
+
+	default:
+		return errLoginInvalido
+	}
+	s.atualizarConta(conta, segredo)
+	return nil
+}
+
+// registrarConta cria a conta se o usuário ainda não existir
+func (s *Server) registrarConta(usuario string, segredo []byte) (Conta, error) {
+	if !regexUsuario.MatchString(usuario) {
+		return Conta{}, errUsuarioInvalido
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(segredo, bcrypt.DefaultCost)
+	if err != nil {
+		return Conta{}, err
+	}
+	conta := Conta{
+		Usuario:     usuario,
+		IdJogador:   uuid.NewString(),
+		SegredoHash: string(hash),
+		CriadaEm:    time.Now().Unix(),
+	}
+	dados, err := json.Marshal(conta)
+	if err != nil {
+		return Conta{}, err
+	}
+
+	// SETNX: se dois clientes registrarem o mesmo usuário ao mesmo tempo, so um ganha
+	criou, err := s.redisClient.SetNX(s.ctx, chaveConta(usuario), dados, 0).Result()
+	if err != nil {
+		return Conta{}, fmt.Errorf("falha ao salvar conta: %v", err)
+	}
+	if !criou {
+		return Conta{}, errUsuarioExiste
+	}
+	color.Cyan("CONTAS: Conta '%s' criada (jogador %s)", usuario, conta.IdJogador)
+	return conta, nil
+}
+
+// atualizarConta troca o q a conta antiga guardava pelo bcrypt do segredo. Se falhar o login segue
+// (a conta continua do jeito antigo e tenta de novo no próximo)
+func (s *Server) atualizarConta(conta Conta, segredo []byte) {
+	hash, err := bcrypt.GenerateFromPassword(segredo, bcrypt.DefaultCost)
+	if err != nil {
+		color.Red("CONTAS: Falha ao atualizar a conta '%s': %v", conta.Usuario, err)
+		return
+	}
+	conta.SegredoHash, conta.SenhaHash, conta.Segredo = string(hash), "", ""
+	dados, err := json.Marshal(conta)
+	if err != nil {
+		return
+	}
+	if err := s.redisClient.SetXX(s.ctx, chaveConta(conta.Usuario), dados, redis.KeepTTL).Err(); err != nil {
+		color.Red("CONTAS: Falha ao atualizar a conta '%s': %v", conta.Usuario, err)
+		return
+	}
+	color.Cyan("CONTAS: Conta '%s' atualizada pro bcrypt do segredo", conta.Usuario)
+}
+
+// buscarConta lê a conta pelo usuário (usuário inexistente vira login inválido, pra n vazar quem existe)
+func (s *Server) buscarConta(usuario string) (Conta, error) {
+	dados, err := s.redisClient.Get(s.ctx, chaveConta(usuario)).Bytes()
+	if err == redis.Nil {
+		return Conta{}, errLoginInvalido
+	}
+	if err != nil {
+		return Conta{}, fmt.Errorf("falha ao ler conta: %v", err)
+	}
+	var conta Conta
+	if err := json.Unmarshal(dados, &conta); err != nil {
+		return Conta{}, fmt.Errorf("conta '%s' corrompida: %v", usuario, err)
+	}
+	return conta, nil
+}
+
+// ChavesCluster são as chaves q saem do CLUSTER_SECRET. São as mesmas em todos os servidores e nenhuma vai pro Redis.
+type ChavesCluster struct {
+	Contas   []byte           // abre o segredo cifrado das contas antigas (so pra atualizar)
+	Sessoes  []byte           // deriva a chave de cada sessão a partir do token
+	Registro *ecdh.PrivateKey // abre o selo do Login/Registrar (X25519)
+}
+
+func chavesDoCluster(segredoCluster []byte) (ChavesCluster, error) {
+	registro, err := ecdh.X25519().NewPrivateKey(derivarDoCluster(segredoCluster, "planoz:registro"))
+	if err != nil {
+		return ChavesCluster{}, err
+	}
+	return ChavesCluster{
+		Contas:   derivarDoCluster(segredoCluster, "planoz:contas"),
+		Sessoes:  derivarDoCluster(segredoCluster, "planoz:sessoes"),
+		Registro: registro,
+	}, nil
+}
+
+func derivarDoCluster(segredoCluster []byte, rotulo string) []byte {
+	mac := hmac.New(sha256.New, segredoCluster)
+	mac.Write([]byte(rotulo))
+	return mac.Sum(nil)
+}
+
+// publicarChaveRegistro deixa a chave pública de registro no Redis, pro cliente q n recebeu CLUSTER_PUBKEY
+func (s *Server) publicarChaveRegistro() {
+	publica := hex.EncodeToString(s.chaves.Registro.PublicKey().Bytes())
+	if err := s.redisClient.Set(s.ctx, ChaveRegistro, publica, 0).Err(); err != nil {
+		color.Red("CONTAS: Falha ao publicar a chave de registro: %v", err)
+	}
+	color.Cyan("CONTAS: Chave pública de registro (CLUSTER_PUBKEY do cliente): %s", publica)
+}
+
+// abrirSelo abre o SeloConectar do Login/Registrar (so quem tem o CLUSTER_SECRET consegue)
+func (s *Server) abrirSelo(selado string) (models.SeloConectar, error) {
+	var selo models.SeloConectar
+	dados, err := assinatura.AbrirSelado(s.chaves.Registro, selado)
+	if err != nil {
+		return selo, err
+	}
+	if err := json.Unmarshal(dados, &selo); err != nil || selo.ChaveResposta == "" {
+		return selo, assinatura.ErrSeloInvalido
+	}
+	return selo, nil
+}
+
+func (s *Server) aeadContas() (cipher.AEAD, error) {
+	bloco, err := aes.NewCipher(s.chaves.Contas)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(bloco)
+}
+
+// abrirSegredo decifra o segredo da conta antiga (de antes do bcrypt do segredo)
+func (s *Server) abrirSegredo(conta Conta) ([]byte, error) {
+	aead, err := s.aeadContas()
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(conta.Segredo)
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("segredo da conta '%s' corrompido", conta.Usuario)
+	}
+	segredo, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(conta.IdJogador))
+	if err != nil {
+		return nil, fmt.Errorf("segredo da conta '%s' n abre (o CLUSTER_SECRET mudou?)", conta.Usuario)
+	}
+	return segredo, nil
+}
+
+// chaveDoToken deriva a chave da sessão a partir do token (igual em todo server).
+// Funciona mesmo com a sessão expirada, pra assinar o Sessao_Invalida.
+func (s *Server) chaveDoToken(token string) string {
+	return assinatura.ChaveDaSessao(s.chaves.Sessoes, token)
+}
+
+// criarSessao sorteia o token do jogador (depois do login conferido), preso ao canal de resposta dele
+func (s *Server) criarSessao(conta Conta, canal string) (*Sessao, error) {
+	// o canal so pode ser de um jogador: senão alguém logava na própria conta com o canal de outro
+	// e passava a receber as msgs q chegam pra ele
+	if dono := s.sessaoDoCanal(canal); dono != nil && dono.IdJogador != conta.IdJogador {
+		return nil, errCanalEmUso
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(bytes)
+	sessao := &Sessao{
+		Token:     token,
+		IdJogador: conta.IdJogador,
+		Chave:     s.chaveDoToken(token),
+		Canal:     canal,
+	}
+	dados, err := json.Marshal(sessao)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := envDuration("SESSAO_TTL", SessaoTTLPadrao)
+	_, err = s.redisClient.Pipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(s.ctx, chaveSessao(sessao.Token), dados, ttl)
+		pipe.Set(s.ctx, chaveCanalSessao(canal), sessao.Token, ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao criar sessão: %v", err)
+	}
+	return sessao, nil
+}
+
+// buscarSessao lê a sessão do token (e renova o TTL, a sessão vale enquanto o jogador tiver ativo)
+func (s *Server) buscarSessao(token string) (*Sessao, error) {
+	if token == "" {
+		return nil, errSessaoInvalida
+	}
+	ttl := envDuration("SESSAO_TTL", SessaoTTLPadrao)
+	sessao, err := lerSessao(token, s.redisClient.GetEx(s.ctx, chaveSessao(token), ttl))
+	if err != nil {
+		return nil, err
+	}
+	sessao.Chave = s.chaveDoToken(token)
+	s.redisClient.Expire(s.ctx, chaveCanalSessao(sessao.Canal), ttl)
+	return sessao, nil
+}
+
+// sessaoDoCanal devolve a sessão presa ao canal (nil se n tem), sem renovar nada
+func (s *Server) sessaoDoCanal(canal string) *Sessao {
+	token, err := s.redisClient.Get(s.ctx, chaveCanalSessao(canal)).Result()
+	if err != nil {
+		return nil
+	}
+	sessao, err := lerSessao(token, s.redisClient.Get(s.ctx, chaveSessao(token)))
+	if err != nil || sessao.Canal != canal {
+		return nil
+	}
+	sessao.Chave = s.chaveDoToken(token)
+	return sessao
+}
+
+func lerSessao(token string, cmd *redis.StringCmd) (*Sessao, error) {
+	dados, err := cmd.Bytes()
+	if err == redis.Nil {
+		return nil, errSessaoInvalida
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sessao Sessao
+	if err := json.Unmarshal(dados, &sessao); err != nil {
+		return nil, fmt.Errorf("sessão corrompida: %v", err)
+	}
+	sessao.Token = token
+	return &sessao, nil
+}
+
+// checarRemetente confere se a requisição é mesmo do dono da sessão (id e canal de resposta).
+// Se não for, avisa o cliente (ele volta pra tela de login) e devolve false.
+func (s *Server) checarRemetente(sessao *Sessao, playerID, canalResposta string) bool {
+	if sessao != nil && sessao.IdJogador == playerID && sessao.Canal == canalResposta {
+		return true
+	}
+	color.Red("CONTAS: Requisição de %s recusada (sessão inválida)", playerID)
+	if sessao != nil {
+		s.avisarSessaoInvalida(canalResposta, sessao.Token)
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"PlanoZ/assinatura"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// servidorDeTeste monta um server so com as chaves do cluster. O Redis aponta pra lugar nenhum:
+// a atualização da conta antiga falha (e so loga), o login segue
+func servidorDeTeste(t *testing.T) *Server {
+	t.Helper()
+	chaves, err := chavesDoCluster([]byte("segredo de teste"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:1"}, MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+	return &Server{ctx: context.Background(), redisClient: rdb, chaves: chaves}
+}
+
+func TestConferirSegredo(t *testing.T) {
+	s := servidorDeTeste(t)
+	segredo, _ := assinatura.SegredoDaConta("joao", "senha123")
+	errado, _ := assinatura.SegredoDaConta("joao", "senha124")
+
+	hashSegredo, _ := bcrypt.GenerateFromPassword(segredo, bcrypt.MinCost)
+	hashSenha, _ := bcrypt.GenerateFromPassword([]byte("senha123"), bcrypt.MinCost)
+
+	// conta do tempo em q o segredo ficava cifrado com a chave das contas
+	aead, err := s.aeadContas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	cifrado := hex.EncodeToString(aead.Seal(nonce, nonce, segredo, []byte("id-joao")))
+
+	nova := Conta{Usuario: "joao", IdJogador: "id-joao", SegredoHash: string(hashSegredo)}
+	comSenha := Conta{Usuario: "joao", IdJogador: "id-joao", SenhaHash: string(hashSenha)}
+	comCifrado := Conta{Usuario: "joao", IdJogador: "id-joao", Segredo: cifrado}
+
+	casos := []struct {
+		nome    string
+		conta   Conta
+		segredo []byte
+		senha   string
+		err     error
+	}{
+		{"bcrypt do segredo", nova, segredo, "", nil},
+		{"bcrypt do segredo, errado", nova, errado, "", errLoginInvalido},
+		{"bcrypt da senha pede a senha", comSenha, segredo, "", errContaAntiga},
+		{"bcrypt da senha, senha certa", comSenha, segredo, "senha123", nil},
+		{"bcrypt da senha, senha errada", comSenha, segredo, "senha124", errLoginInvalido},
+		// a senha certa n serve com um segredo q n sai dela
+		{"bcrypt da senha, segredo de outra", comSenha, errado, "senha123", errLoginInvalido},
+		{"segredo cifrado", comCifrado, segredo, "", nil},
+		{"segredo cifrado, errado", comCifrado, errado, "", errLoginInvalido},
+		{"conta sem nada", Conta{Usuario: "joao"}, segredo, "", errLoginInvalido},
+	}
+	for _, c := range casos {
+		if err := s.conferirSegredo(c.conta, c.segredo, c.senha); err != c.err {
+			t.Errorf("%s: conferirSegredo = %v, esperava %v", c.nome, err, c.err)
+		}
+	}
+}
+
+func TestChaveDoTokenIgualEmTodoServer(t *testing.T) {
+	s1, s2 := servidorDeTeste(t), servidorDeTeste(t)
+	if s1.chaveDoToken("abc") != s2.chaveDoToken("abc") {
+		t.Fatal("o msm token tem q dar a msm chave em todo server (o Retomar cai em qualquer um)")
+	}
+	if s1.chaveDoToken("abc") == s1.chaveDoToken("abd") {
+		t.Fatal("cada token devia ter a sua chave")
+	}
+}
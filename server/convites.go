@@ -0,0 +1,190 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Convites de Pareamento (Redis) ---
+
+// Parear não junta mais os dois na hora: vira um convite q o outro jogador aceita ou recusa.
+// O convite fica no Redis (qualquer server atende o "Aceitar", n precisa ser o do convite)
+// e some sozinho depois do CONVITE_TTL. Cada jogador tem uma lista de bloqueados: convite de
+// quem ta bloqueado é descartado calado (quem convidou n fica sabendo do bloqueio).
+const (
+	PrefixoConvite   = "convite:"   // convite:<convidado>:<quem convidou> -> hora do convite
+	PrefixoBloqueios = "bloqueios:" // set com quem o jogador bloqueou
+
+	ConviteTTLPadrao = 60 * time.Second // CONVITE_TTL
+)
+
+var (
+	errJogadorOffline     = errors.New("jogador destinatário não encontrado ou offline")
+	errJogadorInvalido    = errors.New("jogador inválido")
+	errConviteProprio     = errors.New("você não pode convidar a si mesmo")
+	errConviteRepetido    = errors.New("convite já enviado, espere a resposta")
+	errConviteInexistente = errors.New("convite não encontrado ou expirado")
+)
+
+// errosConvite são os erros q podem voltar pro cliente como estão (o resto é falha do Redis)
+var errosConvite = []error{errJogadorOffline, errJogadorInvalido, errConviteProprio, errConviteRepetido, errConviteInexistente,
+	errJaPareado, errParceiroOcupado, errNaoPareado}
+
+func chaveConvite(convidado, remetente string) string {
+	return PrefixoConvite + convidado + ":" + remetente
+}
+func chaveBloqueios(playerID string) string { return PrefixoBloqueios + playerID }
+
+// convidar manda o convite de pareamento pro destinatário
+func (s *Server) convidar(req models.ReqPessoalServidor) error {
+	if req.IdDestinatario == req.IdRemetente {
+		return errConviteProprio
+	}
+	if s.parceiroDe(req.IdRemetente) != "" {
+		return errJaPareado
+	}
+	infoDest, ok := s.infoJogador(req.IdDestinatario)
+	if !ok {
+		return errJogadorOffline
+	}
+
+	ttl := envDuration("CONVITE_TTL", ConviteTTLPadrao)
+	agora := time.Now()
+	resp := models.RespostaConvite{
+		IdRemetente:    req.IdRemetente,
+		IdDestinatario: req.IdDestinatario,
+		Mensagem:       fmt.Sprintf("Convite enviado para %s", req.IdDestinatario),
+		ExpiraEm:       agora.Add(ttl).Unix(),
+	}
+
+	bloqueado, err := s.redisClient.SIsMember(s.ctx, chaveBloqueios(req.IdDestinatario), req.IdRemetente).Result()
+	if err != nil {
+		return err
+	}
+	if bloqueado {
+		// finge q mandou: quem foi bloqueado n descobre
+		color.Yellow("CONVITE: %s bloqueou %s, convite descartado", req.IdDestinatario, req.IdRemetente)
+		s.sendToClient(req.CanalResposta, "Convite_Enviado", resp)
+		return nil
+	}
+
+	// SETNX: o mesmo convite n vai 2x enquanto o primeiro n expirar
+	novo, err := s.redisClient.SetNX(s.ctx, chaveConvite(req.IdDestinatario, req.IdRemetente), agora.Unix(), ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !novo {
+		return errConviteRepetido
+	}
+
+	color.Green("CONVITE: %s convidou %s", req.IdRemetente, req.IdDestinatario)
+	s.sendToClient(req.CanalResposta, "Convite_Enviado", resp)
+	resp.Mensagem = fmt.Sprintf("%s quer parear com você", req.IdRemetente)
+	s.sendToClient(infoDest.ReplyChannel, "Convite", resp)
+	return nil
+}
+
+// aceitarConvite consome o convite (GETDEL, so vale 1x) e pareia os dois (ver pares.go)
+func (s *Server) aceitarConvite(req models.ReqPessoalServidor) error {
+	err := s.redisClient.GetDel(s.ctx, chaveConvite(req.IdRemetente, req.IdDestinatario)).Err()
+	if err == redis.Nil {
+		return errConviteInexistente
+	}
+	if err != nil {
+		return err
+	}
+	infoConvidou, ok := s.infoJogador(req.IdDestinatario)
+	if !ok {
+		return errJogadorOffline
+	}
+	if err := s.parear(req.IdRemetente, req.IdDestinatario); err != nil {
+		return err
+	}
+
+	color.Green("CONVITE: %s aceitou o convite de %s", req.IdRemetente, req.IdDestinatario)
+	// pareado n espera na fila (ver matchmaking.go)
+	s.tirarDaFilaAoParear(req.IdRemetente, req.IdDestinatario)
+	s.tirarDaFilaAoParear(req.IdDestinatario, req.IdRemetente)
+	s.sendToClient(req.CanalResposta, "Pareamento", models.RespostaPareamento{
+		Mensagem:   fmt.Sprintf("Pareamento realizado com %s", req.IdDestinatario),
+		IdParceiro: req.IdDestinatario,
+	})
+	s.sendToClient(infoConvidou.ReplyChannel, "Pareamento", models.RespostaPareamento{
+		Mensagem:   fmt.Sprintf("Pareamento realizado com %s", req.IdRemetente),
+		IdParceiro: req.IdRemetente,
+	})
+	return nil
+}
+
+// recusarConvite apaga o convite e avisa quem convidou (se ainda tiver online)
+func (s *Server) recusarConvite(req models.ReqPessoalServidor) error {
+	apagados, err := s.redisClient.Del(s.ctx, chaveConvite(req.IdRemetente, req.IdDestinatario)).Result()
+	if err != nil {
+		return err
+	}
+	if apagados == 0 {
+		return errConviteInexistente
+	}
+
+	resp := models.RespostaConvite{
+		IdRemetente:    req.IdDestinatario,
+		IdDestinatario: req.IdRemetente,
+		Mensagem:       fmt.Sprintf("Convite de %s recusado", req.IdDestinatario),
+	}
+	s.sendToClient(req.CanalResposta, "Convite_Recusado", resp)
+	if infoConvidou, ok := s.infoJogador(req.IdDestinatario); ok {
+		resp.Mensagem = fmt.Sprintf("%s recusou seu convite", req.IdRemetente)
+		s.sendToClient(infoConvidou.ReplyChannel, "Convite_Recusado", resp)
+	}
+	return nil
+}
+
+// bloquear (ou desbloquear) um jogador. Bloquear apaga o convite pendente dele, se tiver.
+func (s *Server) bloquear(req models.ReqPessoalServidor, bloquear bool) error {
+	if req.IdDestinatario == "" || req.IdDestinatario == req.IdRemetente {
+		return errJogadorInvalido
+	}
+	chave := chaveBloqueios(req.IdRemetente)
+	mensagem := fmt.Sprintf("%s desbloqueado", req.IdDestinatario)
+	if bloquear {
+		if err := s.redisClient.SAdd(s.ctx, chave, req.IdDestinatario).Err(); err != nil {
+			return err
+		}
+		s.redisClient.Del(s.ctx, chaveConvite(req.IdRemetente, req.IdDestinatario))
+		mensagem = fmt.Sprintf("%s bloqueado: os convites dele não chegam mais", req.IdDestinatario)
+	} else if err := s.redisClient.SRem(s.ctx, chave, req.IdDestinatario).Err(); err != nil {
+		return err
+	}
+
+	s.sendToClient(req.CanalResposta, "Bloqueio", models.RespostaBloqueio{
+		IdJogador: req.IdDestinatario,
+		Bloqueado: bloquear,
+		Mensagem:  mensagem,
+	})
+	return nil
+}
+
+// responderErroConvite manda o erro de um convite/bloqueio pro cliente
+func (s *Server) responderErroConvite(req models.ReqPessoalServidor, err error) {
+	for _, conhecido := range errosConvite {
+		if err == conhecido {
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: err.Error()})
+			return
+		}
+	}
+	color.Red("CONVITE: Falha no %s de %s: %v", req.Tipo, req.IdRemetente, err)
+	s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao processar o pedido, tente de novo"})
+}
+
+// infoJogador procura o jogador na lista global (online)
+func (s *Server) infoJogador(playerID string) (PlayerInfo, bool) {
+	s.muPlayers.RLock()
+	defer s.muPlayers.RUnlock()
+	info, ok := s.playerList[playerID]
+	return info, ok
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Escrow da Troca (Redis) ---
+
+// A troca é um commit em duas fases:
+//  1. Em cada oferta, as cartas ficam presas no escrow (ChaveEscrow: id da carta -> troca|prazo). Enquanto
+//     elas tiverem lá, não entram em outra troca. Se o server host morrer, o prazo vence e as cartas soltam sozinhas.
+//     Mudar a oferta solta as cartas q saíram dela.
+//  2. Com os dois confirmando a mesma versão, um script só move as cartas, acerta os créditos e marca a troca como concluída.
+//     Se der timeout ou alguém desistir antes, a troca é marcada como cancelada e as cartas soltas.
+//
+// Os créditos não ficam presos: o script da fase 2 confere o saldo na hora e, se n der, nada muda e a troca cai.
+// O status fica no registro da troca ({inventario}:troca:<id>), então concluir e cancelar
+// nunca acontecem os dois: quem chegar primeiro no Redis ganha, e repetir qualquer um dá no mesmo.
+//
+// O mesmo script q fecha a troca grava no registro o resultado de cada jogador (resultado:<id>) e bota a
+// troca nos pendentes dele. O resultado sai do Redis, não da memória do host: o server onde o jogador
+// tiver conectado manda de novo (RunResultadosTroca e na reconexão) até o cliente confirmar com um
+// "Resultado_Recebido". Vale mesmo se o host caiu, reiniciou ou o líder mudou.
+const (
+	ChaveEscrow            = "{inventario}:escrow"            // id da carta -> "<id da troca>|<prazo unix ms>"
+	PrefixoRegistroTroca   = "{inventario}:troca:"            // status, cartas reservadas (carta:<id> -> jogador) e resultados (resultado:<jogador> -> JSON)
+	PrefixoResultadosTroca = "{inventario}:resultados_troca:" // jogador -> set das trocas q ele ainda n confirmou
+
+	PrazoEscrow         = PrazoNegociacao + 30*time.Second // cobre a negociação com folga
+	TTLRegistroTroca    = time.Hour                        // registro da troca em andamento
+	TTLResultadoTroca   = 7 * 24 * time.Hour               // depois de fechada, quanto o resultado espera o jogador voltar
+	IntervaloResultados = 5 * time.Second                  // de quanto em quanto tempo reenvia os resultados pendentes
+)
+
+var (
+	errCartaNaoEhSua  = errors.New("a carta ofertada não é sua")
+	errCartaEmEscrow  = errors.New("a carta já está presa em outra troca ou no mercado")
+	errTrocaEncerrada = errors.New("troca já encerrada")
+	errEscrowVencido  = errors.New("prazo da troca vencido")
+)
+
+func chaveRegistroTroca(tradeID string) string    { return PrefixoRegistroTroca + tradeID }
+func chaveResultadosTroca(playerID string) string { return PrefixoResultadosTroca + playerID }
+
+// luaEscrowDaTroca diz se o valor do escrow é dessa troca e ainda ta no prazo
+const luaEscrowDaTroca = `
+local function escrowDaTroca(valor, troca, agora)
+	if not valor then return false end
+	local sep = string.find(valor, '|', 1, true)
+	return string.sub(valor, 1, sep - 1) == troca and tonumber(string.sub(valor, sep + 1)) > agora
+end
+local function escrowLivre(valor, agora)
+	if not valor then return true end
+	local sep = string.find(valor, '|', 1, true)
+	return tonumber(string.sub(valor, sep + 1)) <= agora
+end
+`
+
+// scriptReservarCartas troca as cartas q o jogador tem presas nessa troca pelas da oferta nova (tudo ou nada).
+// KEYS[1] = hash de donos, KEYS[2] = escrow, KEYS[3] = registro da troca
+// ARGV[1] = jogador, ARGV[2] = id da troca, ARGV[3] = prazo (unix ms), ARGV[4] = agora (unix ms),
+// ARGV[5] = TTL do registro (s), ARGV[6..] = ids das cartas
+// Retorna 1 = ok, -1 = carta n é do jogador, -2 = carta em outra troca, -3 = troca ja encerrada
+var scriptReservarCartas = redis.NewScript(luaEscrowDaTroca + `
+if redis.call('HEXISTS', KEYS[3], 'status') == 1 then return -3 end
+local agora = tonumber(ARGV[4])
+local nova = {}
+for i = 6, #ARGV do
+	if redis.call('HGET', KEYS[1], ARGV[i]) ~= ARGV[1] then return -1 end
+	local atual = redis.call('HGET', KEYS[2], ARGV[i])
+	if not escrowLivre(atual, agora) and not escrowDaTroca(atual, ARGV[2], agora) then return -2 end
+	nova[ARGV[i]] = true
+end
+-- solta as cartas do jogador q sairam da oferta
+local campos = redis.call('HGETALL', KEYS[3])
+for i = 1, #campos, 2 do
+	if string.sub(campos[i], 1, 6) == 'carta:' and campos[i + 1] == ARGV[1] then
+		local carta = string.sub(campos[i], 7)
+		if not nova[carta] then
+			if escrowDaTroca(redis.call('HGET', KEYS[2], carta), ARGV[2], agora) then
+				redis.call('HDEL', KEYS[2], carta)
+			end
+			redis.call('HDEL', KEYS[3], campos[i])
+		end
+	end
+end
+for carta in pairs(nova) do
+	redis.call('HSET', KEYS[2], carta, ARGV[2] .. '|' .. ARGV[3])
+	redis.call('HSET', KEYS[3], 'carta:' .. carta, ARGV[1])
+end
+redis.call('EXPIRE', KEYS[3], ARGV[5])
+return 1
+`)
+
+// scriptConcluirTroca é a fase 2: move as cartas das duas ofertas (q têm q tar no escrow dessa troca),
+// acerta os créditos e fecha a troca.
+// KEYS[1] = inventário J1, KEYS[2] = inventário J2, KEYS[3] = hash de donos, KEYS[4] = escrow, KEYS[5] = registro,
+// KEYS[6] = saldo J1, KEYS[7] = extrato J1, KEYS[8] = saldo J2, KEYS[9] = extrato J2, KEYS[10] = transações,
+// KEYS[11] = pendentes J1, KEYS[12] = pendentes J2
+// ARGV[1] = id de J1, ARGV[2] = id de J2, ARGV[3] = id da troca, ARGV[4] = agora (unix ms), ARGV[5] = TTL do resultado (s),
+// ARGV[6] = créditos q J2 recebe de J1 menos os q J1 recebe de J2, ARGV[7] = quando (unix s),
+// ARGV[8] = quantas cartas J1 da, ARGV[9] = resultado de J1 (JSON), ARGV[10] = resultado de J2 (JSON),
+// ARGV[11..] = pares (id, carta já com o novo dono em JSON): primeiro as de J1, dps as de J2
+// Retorna 1 = concluída (agora ou antes), -1/-2 = J1/J2 n tem a carta, -3 = troca cancelada, -4 = escrow vencido,
+// -5/-6 = J1/J2 sem saldo
+var scriptConcluirTroca = redis.NewScript(luaEscrowDaTroca + luaLancar + `
+local status = redis.call('HGET', KEYS[5], 'status')
+if status == 'concluida' then return 1 end
+if status then return -3 end
+local agora = tonumber(ARGV[4])
+local n1 = tonumber(ARGV[8])
+local total = (#ARGV - 10) / 2
+-- confere tudo antes de mexer em qualquer coisa
+for i = 0, total - 1 do
+	local id = ARGV[11 + 2 * i]
+	if not escrowDaTroca(redis.call('HGET', KEYS[4], id), ARGV[3], agora) then return -4 end
+	if i < n1 then
+		if redis.call('HEXISTS', KEYS[1], id) == 0 then return -1 end
+	elseif redis.call('HEXISTS', KEYS[2], id) == 0 then return -2 end
+end
+local liquido = tonumber(ARGV[6])
+if tonumber(redis.call('GET', KEYS[6]) or '0') - liquido < 0 then return -5 end
+if tonumber(redis.call('GET', KEYS[8]) or '0') + liquido < 0 then return -6 end
+
+for i = 0, total - 1 do
+	local id, dados = ARGV[11 + 2 * i], ARGV[12 + 2 * i]
+	local de, para, dono = KEYS[1], KEYS[2], ARGV[2]
+	if i >= n1 then de, para, dono = KEYS[2], KEYS[1], ARGV[1] end
+	redis.call('HDEL', de, id)
+	redis.call('HSET', para, id, dados)
+	redis.call('HSET', KEYS[3], id, dono)
+	redis.call('HDEL', KEYS[4], id)
+end
+if liquido ~= 0 then
+	local motivo = 'Troca ' .. ARGV[3]
+	lancar(KEYS[6], KEYS[7], KEYS[10], 'troca:' .. ARGV[3] .. ':' .. ARGV[1], ARGV[1], -liquido, motivo, tonumber(ARGV[7]))
+	lancar(KEYS[8], KEYS[9], KEYS[10], 'troca:' .. ARGV[3] .. ':' .. ARGV[2], ARGV[2], liquido, motivo, tonumber(ARGV[7]))
+end
+redis.call('HSET', KEYS[5], 'status', 'concluida', 'resultado:' .. ARGV[1], ARGV[9], 'resultado:' .. ARGV[2], ARGV[10])
+redis.call('EXPIRE', KEYS[5], ARGV[5])
+for _, pendentes in ipairs({KEYS[11], KEYS[12]}) do
+	redis.call('SADD', pendentes, ARGV[3])
+	redis.call('EXPIRE', pendentes, ARGV[5])
+end
+return 1
+`)
+
+// scriptCancelarTroca é o rollback: marca a troca como cancelada, solta as cartas reservadas e deixa
+// o cancelamento pendente pros dois jogadores.
+// KEYS[1] = escrow, KEYS[2] = registro da troca, KEYS[3] = pendentes J1, KEYS[4] = pendentes J2
+// ARGV[1] = id da troca, ARGV[2] = agora (unix ms), ARGV[3] = TTL do resultado (s), ARGV[4] = id de J1,
+// ARGV[5] = id de J2, ARGV[6] = resultado (JSON, o msm pros dois)
+// Retorna 1 = cancelada, 0 = ja tinha sido concluída (nada muda)
+var scriptCancelarTroca = redis.NewScript(luaEscrowDaTroca + `
+local status = redis.call('HGET', KEYS[2], 'status')
+if status == 'concluida' then return 0 end
+local campos = redis.call('HGETALL', KEYS[2])
+for i = 1, #campos, 2 do
+	if string.sub(campos[i], 1, 6) == 'carta:' then
+		local carta = string.sub(campos[i], 7)
+		if escrowDaTroca(redis.call('HGET', KEYS[1], carta), ARGV[1], tonumber(ARGV[2])) then
+			redis.call('HDEL', KEYS[1], carta)
+		end
+	end
+end
+redis.call('HSET', KEYS[2], 'status', 'cancelada')
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+-- so na primeira vez: cancelar de novo n pode ressuscitar um resultado q o jogador ja confirmou
+if not status then
+	redis.call('HSET', KEYS[2], 'resultado:' .. ARGV[4], ARGV[6], 'resultado:' .. ARGV[5], ARGV[6])
+	for _, pendentes in ipairs({KEYS[3], KEYS[4]}) do
+		redis.call('SADD', pendentes, ARGV[1])
+		redis.call('EXPIRE', pendentes, ARGV[3])
+	end
+end
+return 1
+`)
+
+// reservarCartasTroca é a fase 1: prende as cartas da oferta no escrow da troca (e solta as q saíram dela)
+func (s *Server) reservarCartasTroca(tradeID, playerID string, idsCartas []string) error {
+	agora := time.Now()
+	args := []interface{}{playerID, tradeID, agora.Add(PrazoEscrow).UnixMilli(), agora.UnixMilli(), int(TTLRegistroTroca.Seconds())}
+	for _, id := range idsCartas {
+		args = append(args, id)
+	}
+	res, err := scriptReservarCartas.Run(s.ctx, s.redisClient,
+		[]string{ChaveDonoCartas, ChaveEscrow, chaveRegistroTroca(tradeID)}, args...).Int()
+	if err != nil {
+		return fmt.Errorf("falha ao reservar cartas: %v", err)
+	}
+	switch res {
+	case -1:
+		return errCartaNaoEhSua
+	case -2:
+		return errCartaEmEscrow
+	case -3:
+		return errTrocaEncerrada
+	}
+	color.Cyan("ESCROW: %d carta(s) de %s reservadas na troca %s", len(idsCartas), playerID, tradeID)
+	return nil
+}
+
+// concluirTroca é a fase 2 (tudo ou nada) com as ofertas q os dois confirmaram.
+// Devolve o resultado de cada um (as cartas recebidas já vêm com o novo dono), q tbm fica pendente no Redis.
+func (s *Server) concluirTroca(tradeID string, oferta1, oferta2 models.OfertaTroca) (models.RespostaResultadoTroca, models.RespostaResultadoTroca, error) {
+	j1, j2 := oferta1.IdJogador, oferta2.IdJogador
+	res1 := models.RespostaResultadoTroca{
+		IdTroca:            tradeID,
+		Concluida:          true,
+		Mensagem:           fmt.Sprintf("Troca com %s concluída!", j2),
+		CartasRecebidas:    make([]models.Tanque, 0, len(oferta2.Cartas)),
+		IdsCartasEntregues: idsDasCartas(oferta1.Cartas),
+		Creditos:           oferta2.Creditos - oferta1.Creditos,
+	}
+	res2 := models.RespostaResultadoTroca{
+		IdTroca:            tradeID,
+		Concluida:          true,
+		Mensagem:           fmt.Sprintf("Troca com %s concluída!", j1),
+		CartasRecebidas:    make([]models.Tanque, 0, len(oferta1.Cartas)),
+		IdsCartasEntregues: idsDasCartas(oferta2.Cartas),
+		Creditos:           oferta1.Creditos - oferta2.Creditos,
+	}
+	var pares []interface{}
+	for _, carta := range oferta1.Cartas {
+		carta.Id_jogador = j2
+		dados, _ := json.Marshal(carta)
+		pares = append(pares, carta.Id, dados)
+		res2.CartasRecebidas = append(res2.CartasRecebidas, carta)
+	}
+	for _, carta := range oferta2.Cartas {
+		carta.Id_jogador = j1
+		dados, _ := json.Marshal(carta)
+		pares = append(pares, carta.Id, dados)
+		res1.CartasRecebidas = append(res1.CartasRecebidas, carta)
+	}
+	dados1, _ := json.Marshal(res1)
+	dados2, _ := json.Marshal(res2)
+	args := append([]interface{}{j1, j2, tradeID, time.Now().UnixMilli(), int(TTLResultadoTroca.Seconds()),
+		oferta1.Creditos - oferta2.Creditos, time.Now().Unix(), len(oferta1.Cartas), dados1, dados2}, pares...)
+
+	var nada models.RespostaResultadoTroca
+	res, err := scriptConcluirTroca.Run(s.ctx, s.redisClient,
+		[]string{chaveInventario(j1), chaveInventario(j2), ChaveDonoCartas, ChaveEscrow, chaveRegistroTroca(tradeID),
+			chaveCarteira(j1), chaveExtrato(j1), chaveCarteira(j2), chaveExtrato(j2), ChaveTransacoes,
+			chaveResultadosTroca(j1), chaveResultadosTroca(j2)},
+		args...).Int()
+	if err != nil {
+		return nada, nada, fmt.Errorf("falha no script de troca: %v", err)
+	}
+	switch res {
+	case -1:
+		return nada, nada, fmt.Errorf("%s não possui mais uma das cartas ofertadas", j1)
+	case -2:
+		return nada, nada, fmt.Errorf("%s não possui mais uma das cartas ofertadas", j2)
+	case -3:
+		return nada, nada, errTrocaEncerrada
+	case -4:
+		return nada, nada, errEscrowVencido
+	case -5:
+		return nada, nada, fmt.Errorf("%s: %v", j1, errSaldoInsuficiente)
+	case -6:
+		return nada, nada, fmt.Errorf("%s: %v", j2, errSaldoInsuficiente)
+	}
+	return res1, res2, nil
+}
+
+// cancelarTroca é o rollback. Devolve false se a troca ja tinha sido concluída (aí n tem o q desfazer).
+func (s *Server) cancelarTroca(tradeID, j1, j2 string, resultado models.RespostaResultadoTroca) (bool, error) {
+	dados, err := json.Marshal(resultado)
+	if err != nil {
+		return false, err
+	}
+	res, err := scriptCancelarTroca.Run(s.ctx, s.redisClient,
+		[]string{ChaveEscrow, chaveRegistroTroca(tradeID), chaveResultadosTroca(j1), chaveResultadosTroca(j2)},
+		tradeID, time.Now().UnixMilli(), int(TTLResultadoTroca.Seconds()), j1, j2, dados).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// avisarResultadoTroca tenta entregar o resultado agora: direto, se o jogador ta conectado aqui, ou pelo
+// server dele (q tbm esquece a troca). Uma tentativa só: se n chegar, o resultado continua pendente
+// no Redis e o server do jogador manda de novo (RunResultadosTroca).
+func (s *Server) avisarResultadoTroca(playerID string, resultado models.RespostaResultadoTroca) {
+	info, ok := s.infoJogador(playerID)
+	if !ok {
+		return // desconectado: recebe qnd voltar (processConectar)
+	}
+	if info.ServerHost == s.HostAPI {
+		s.sendToClient(info.ReplyChannel, "Resultado_Troca", resultado)
+		return
+	}
+	req := models.TradeResultRequest{IdTroca: resultado.IdTroca, IdJogador: playerID, Resultado: resultado}
+	if err := s.sendToHost(info.ServerHost, "/trade/result", req); err != nil {
+		color.Yellow("TROCA %s: Falha ao avisar %s (%s), fica pendente no Redis: %v", resultado.IdTroca, playerID, info.ServerID, err)
+	}
+}
+
+// reenviarResultadosTroca manda de novo os resultados q o jogador ainda n confirmou
+func (s *Server) reenviarResultadosTroca(playerID, canal string) {
+	ids, err := s.redisClient.SMembers(s.ctx, chaveResultadosTroca(playerID)).Result()
+	if err != nil {
+		color.Red("TROCA: Falha ao ler resultados pendentes de %s: %v", playerID, err)
+		return
+	}
+	for _, tradeID := range ids {
+		dados, err := s.redisClient.HGet(s.ctx, chaveRegistroTroca(tradeID), "resultado:"+playerID).Bytes()
+		if err == redis.Nil {
+			// o registro venceu (ou ja foi confirmado): n tem mais o q mandar
+			s.redisClient.SRem(s.ctx, chaveResultadosTroca(playerID), tradeID)
+			continue
+		}
+		if err != nil {
+			color.Red("TROCA %s: Falha ao ler o resultado de %s: %v", tradeID, playerID, err)
+			continue
+		}
+		var resultado models.RespostaResultadoTroca
+		if err := json.Unmarshal(dados, &resultado); err != nil {
+			color.Red("TROCA %s: Resultado de %s corrompido: %v", tradeID, playerID, err)
+			continue
+		}
+		s.sendToClient(canal, "Resultado_Troca", resultado)
+	}
+}
+
+// confirmarResultadoTroca tira a troca dos pendentes do jogador (o cliente mandou "Resultado_Recebido")
+func (s *Server) confirmarResultadoTroca(playerID, tradeID string) {
+	_, err := s.redisClient.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(s.ctx, chaveResultadosTroca(playerID), tradeID)
+		pipe.HDel(s.ctx, chaveRegistroTroca(tradeID), "resultado:"+playerID)
+		return nil
+	})
+	if err != nil {
+		color.Red("TROCA %s: Falha ao confirmar o resultado de %s: %v", tradeID, playerID, err)
+	}
+}
+
+// RunResultadosTroca reenvia, de tempos em tempos, os resultados q os jogadores conectados aqui
+// ainda n confirmaram (de qualquer host, inclusive de um q ja caiu)
+func (s *Server) RunResultadosTroca() {
+	ticker := time.NewTicker(envDuration("TRADE_RESULT_INTERVAL", IntervaloResultados))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.foraDoCluster() {
+			return
+		}
+		s.muPlayers.RLock()
+		locais := make(map[string]string)
+		for playerID, info := range s.playerList {
+			if info.ServerID == s.ID {
+				locais[playerID] = info.ReplyChannel
+			}
+		}
+		s.muPlayers.RUnlock()
+
+		for playerID, canal := range locais {
+			s.reenviarResultadosTroca(playerID, canal)
+		}
+	}
+}
+
+// resultadoCancelamento monta o "Resultado_Troca" de uma troca cancelada
+func resultadoCancelamento(tradeID, motivo string) models.RespostaResultadoTroca {
+	return models.RespostaResultadoTroca{
+		IdTroca:  tradeID,
+		Mensagem: fmt.Sprintf("Troca cancelada: %s", motivo),
+	}
+}
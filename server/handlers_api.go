@@ -2,6 +2,7 @@ package main
 
 import (
 	"PlanoZ/models"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -12,16 +13,96 @@ import (
 // handlers da api rest (gin)
 
 // o outro server ta me perguntando se eu to vivo (health check)
+// (tbm aviso se eu sou o lider ou n, e qual termo eu conheço)
 func (s *Server) handleHealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, models.HealthCheckResponse{
-		Status:   "OK",
-		ServerID: s.ID,
-		IsLeader: s.isLeader(), // tbm aviso se eu sou o lider ou n
-	})
+	c.JSON(http.StatusOK, s.estadoSaude())
+}
+
+// um server acabou de ser eleito e ta se anunciando (coordenador)
+func (s *Server) handleLeaderAnnounce(c *gin.Context) {
+	var req models.LeaderAnnounceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
+		return
+	}
+
+	if !s.aceitarLider(req.IdLider, req.Termo) {
+		color.Red("Anúncio de líder %s recusado: termo %d é velho (atual: %d)", req.IdLider, req.Termo, s.termoAtual())
+		c.JSON(http.StatusConflict, gin.H{"error": "Termo obsoleto", "termo": s.termoAtual()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Líder reconhecido"})
+}
+
+// membros do cluster
+
+// um server novo subiu e ta avisando (ele tambem ja ta no redis, isso so adianta)
+func (s *Server) handleClusterJoin(c *gin.Context) {
+	var req models.ClusterMembroRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ServerID == "" || req.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
+		return
+	}
+
+	s.adicionarMembro(req.ServerID, req.Host)
+	c.JSON(http.StatusOK, gin.H{"message": "Membro adicionado", "membros": s.idsMembros()})
+}
+
+// um server ta saindo do cluster de forma limpa.
+// se o id for o meu, eh o admin pedindo pra *este* server sair
+func (s *Server) handleClusterLeave(c *gin.Context) {
+	var req models.ClusterMembroRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ServerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
+		return
+	}
+
+	if req.ServerID == s.ID {
+		go s.sairDoCluster()
+		c.JSON(http.StatusOK, gin.H{"message": "Saindo do cluster"})
+		return
+	}
+
+	s.muLeader.RLock()
+	eraLider := s.currentLeader == req.ServerID
+	s.muLeader.RUnlock()
+
+	// trata como um server morto (limpa batalhas/players dele), mas sem esperar o health check
+	s.muLiveServers.Lock()
+	delete(s.liveServers, req.ServerID)
+	vivos := make(map[string]bool)
+	for id, vivo := range s.liveServers {
+		vivos[id] = vivo
+	}
+	s.muLiveServers.Unlock()
+	s.limparRecursosServidoresMortos([]string{req.ServerID})
+	s.removerMembro(req.ServerID)
+
+	if eraLider {
+		color.Yellow("Líder %s saiu do cluster. Iniciando nova eleição.", req.ServerID)
+		s.muLeader.Lock()
+		if s.currentLeader == req.ServerID {
+			s.currentLeader = ""
+		}
+		s.muLeader.Unlock()
+		go s.electNewLeader(vivos)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Membro removido"})
 }
 
 // handlers de sincronização
 
+// recusarSeTermoNovo eh usado pelo lider: se o seguidor ja viu um termo maior q o meu,
+// eu sou um lider velho (ex: fiquei isolado numa particao) e tenho q sair
+func (s *Server) recusarSeTermoNovo(c *gin.Context, termo int64) bool {
+	if termo > s.termoAtual() {
+		s.deixarLideranca(fmt.Sprintf("seguidor conhece o termo %d", termo))
+		c.JSON(http.StatusConflict, gin.H{"error": "Líder obsoleto"})
+		return true
+	}
+	return false
+}
+
 // (so o lider executa) um seguidor (outro server) ta me avisando q um player conectou nele
 func (s *Server) handleLeaderConnect(c *gin.Context) {
 	if !s.isLeader() {
@@ -34,14 +115,18 @@ func (s *Server) handleLeaderConnect(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
 		return
 	}
+	if s.recusarSeTermoNovo(c, req.Termo) {
+		return
+	}
 
 	// atualiza a lista global de players
+	hostServidor, _ := s.hostDe(req.ServerID)
 	s.muPlayers.Lock()
 	oldInfo, exists := s.playerList[req.PlayerID] // ve se ele ja tava em outro server
 
 	playerInfo := PlayerInfo{
 		ServerID:     req.ServerID,
-		ServerHost:   s.serverList[req.ServerID],
+		ServerHost:   hostServidor,
 		ReplyChannel: req.CanalResposta,
 	}
 	s.playerList[req.PlayerID] = playerInfo
@@ -54,12 +139,14 @@ func (s *Server) handleLeaderConnect(c *gin.Context) {
 	if exists && oldInfo.ServerID != req.ServerID {
 		updateRemove := models.UpdatePlayerListRequest{
 			PlayerID: req.PlayerID, ServerID: oldInfo.ServerID, Acao: "remove",
+			IdLider: s.ID, Termo: s.termoAtual(),
 		}
 		s.broadcastToServers("/players/update", updateRemove)
 	}
 	// e avisa pra adicionar no server novo
 	updateReq := models.UpdatePlayerListRequest{
 		PlayerID: req.PlayerID, ServerID: req.ServerID, CanalResposta: req.CanalResposta, Acao: "add",
+		IdLider: s.ID, Termo: s.termoAtual(),
 	}
 	s.broadcastToServers("/players/update", updateReq)
 
@@ -74,12 +161,20 @@ func (s *Server) handlePlayerUpdate(c *gin.Context) {
 		return
 	}
 
+	// msg de lider com termo velho eh ignorada
+	if !s.aceitarLider(req.IdLider, req.Termo) {
+		color.Red("SEGUIDOR: Update de jogadores recusado (termo %d obsoleto)", req.Termo)
+		c.JSON(http.StatusConflict, gin.H{"error": "Termo obsoleto", "termo": s.termoAtual()})
+		return
+	}
+
+	hostServidor, _ := s.hostDe(req.ServerID)
 	s.muPlayers.Lock()
 	if req.Acao == "add" {
 		// adiciona o player na nossa copia local
 		s.playerList[req.PlayerID] = PlayerInfo{
 			ServerID:     req.ServerID,
-			ServerHost:   s.serverList[req.ServerID],
+			ServerHost:   hostServidor,
 			ReplyChannel: req.CanalResposta,
 		}
 		color.Cyan("SEGUIDOR: Lista de jogadores atualizada, ADD %s", req.PlayerID)
@@ -108,6 +203,9 @@ func (s *Server) handleLeaderBuyCard(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
 		return
 	}
+	if s.recusarSeTermoNovo(c, req.Termo) {
+		return
+	}
 
 	// acha o player pra saber pra qm responder
 	s.muPlayers.RLock()
@@ -118,30 +216,66 @@ func (s *Server) handleLeaderBuyCard(c *gin.Context) {
 		return
 	}
 
-	// aqui eh a logica de negocio (unica fonte da verdade)
-	s.muInventory.Lock()
-	if s.pacoteCounter <= 0 {
-		// sem estoque
-		s.muInventory.Unlock()
-		s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Não há mais pacotes disponíveis"})
+	// aqui eh a logica de negocio: o estoque vive no redis (unica fonte da verdade)
+	// e a venda + entrega das cartas acontece num script so
+	idPacote := req.TipoPacote
+	if idPacote == "" {
+		idPacote = PacotePadrao
+	}
+	tx := req.IdTransacao // o seguidor ja montou com o txCompra
+	if tx == "" {
+		tx = txCompra(req.PlayerID, "")
+	}
+	cartas, pacotesRestantes, saldo, err := s.venderPacote(req.PlayerID, idPacote, tx)
+	if err == errSaldoInsuficiente {
+		s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: fmt.Sprintf("Saldo insuficiente (você tem %d créditos)", saldo)})
+		c.JSON(http.StatusOK, gin.H{"message": "Saldo insuficiente"})
+		return
+	}
+	if err == errTransacaoRepetida {
+		// retry de uma compra tão velha q o resultado ja venceu (TTLCompra): nao cobra de novo
+		s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Essa compra já foi processada"})
+		c.JSON(http.StatusOK, gin.H{"message": "Compra repetida"})
+		return
+	}
+	if err == errEstoqueEsgotado {
+		// sem estoque (desse tipo de pacote)
+		s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: fmt.Sprintf("Não há mais pacotes '%s' disponíveis", idPacote)})
 		c.JSON(http.StatusOK, gin.H{"message": "Estoque esgotado"})
 		return
 	}
-	s.pacoteCounter-- // tira 1 do estoque
-	pacotesRestantes := s.pacoteCounter
-	s.muInventory.Unlock()
+	if err == errPacoteInexistente || err == errPacoteIndisponivel {
+		s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: fmt.Sprintf("Pacote '%s': %v", idPacote, err)})
+		c.JSON(http.StatusOK, gin.H{"message": err.Error()})
+		return
+	}
+	if err == errLiderObsoleto {
+		// o redis (fencing) disse q tem lider mais novo: nao vendo nada
+		s.deixarLideranca("termo recusado pelo Redis na venda")
+		s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Liderança em transição, tente novamente"})
+		c.JSON(http.StatusConflict, gin.H{"error": "Líder obsoleto", "cliente_avisado": true})
+		return
+	}
+	if err != nil {
+		color.Red("LÍDER: Falha ao vender pacote para %s: %v", req.PlayerID, err)
+		s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Falha ao processar a compra"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao processar a compra", "cliente_avisado": true})
+		return
+	}
 
-	color.Cyan("LÍDER: Pacote vendido para %s. Restantes: %d", req.PlayerID, pacotesRestantes)
+	color.Cyan("LÍDER: Pacote '%s' vendido para %s. Restantes: %d", idPacote, req.PlayerID, pacotesRestantes)
 
-	// avisa todo mundo (os outros seguidores) q o estoque mudou
-	invUpdate := models.UpdateInventoryRequest{PacotesRestantes: pacotesRestantes}
-	s.broadcastToServers("/inventory/update", invUpdate)
+	// avisa todo mundo (os outros seguidores) q o estoque mudou (manda o mapa todo, eh pequeno)
+	if estoques, err := s.lerEstoques(); err == nil {
+		invUpdate := models.UpdateInventoryRequest{Estoques: estoques, IdLider: s.ID, Termo: s.termoAtual()}
+		s.broadcastToServers("/inventory/update", invUpdate)
+	}
 
-	// sorteia as cartas e manda direto pro cliente (via redis)
-	cartas := s.sortearCartas(req.PlayerID)
+	// manda as cartas direto pro cliente (via redis)
 	respSorteio := models.RespostaSorteio{
 		Mensagem: "Sorteio realizado com sucesso!",
 		Cartas:   cartas,
+		Saldo:    saldo,
 	}
 	s.sendToClient(playerInfo.ReplyChannel, "Sorteio", respSorteio)
 
@@ -156,16 +290,43 @@ func (s *Server) handleInventoryUpdate(c *gin.Context) {
 		return
 	}
 
-	s.muInventory.Lock()
-	s.pacoteCounter = req.PacotesRestantes // so atualiza o valor local
-	s.muInventory.Unlock()
+	if !s.aceitarLider(req.IdLider, req.Termo) {
+		color.Red("SEGUIDOR: Update de estoque recusado (termo %d obsoleto)", req.Termo)
+		c.JSON(http.StatusConflict, gin.H{"error": "Termo obsoleto", "termo": s.termoAtual()})
+		return
+	}
+
+	s.atualizarCacheEstoques(req.Estoques) // so atualiza o cache local (o valor real ta no redis)
 
-	color.Yellow("SEGUIDOR: Inventário atualizado. Pacotes restantes: %d", req.PacotesRestantes)
+	color.Yellow("SEGUIDOR: Inventário atualizado. Pacotes restantes: %v", req.Estoques)
 	c.JSON(http.StatusOK, gin.H{"message": "Inventário atualizado"})
 }
 
 // handlers de batalha (p2p entre servers)
 
+// (server 1) o lider montou uma partida na fila e o j1 ta aqui, entao eu hospedo (ver matchmaking.go)
+func (s *Server) handleBattleStart(c *gin.Context) {
+	var req models.BattleStartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
+		return
+	}
+	if s.drenando.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Servidor desligando"})
+		return
+	}
+	if info, ok := s.infoJogador(req.IdJogador1); !ok || info.ServerID != s.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Jogador 1 não está neste servidor"})
+		return
+	}
+
+	if err := s.abrirBatalha(req.IdJogador1, req.IdJogador2, req.Ranqueada); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Batalha iniciada"})
+}
+
 // (server 2) o server 1 (host) ta me avisando q uma batalha comecou
 func (s *Server) handleBattleInitiate(c *gin.Context) {
 	var req models.BattleInitiateRequest
@@ -199,6 +360,8 @@ func (s *Server) handleBattleInitiate(c *gin.Context) {
 	resp := models.RespostaInicioBatalha{
 		Mensagem:  req.IdOponente, // manda o id do oponente
 		IdBatalha: req.IdBatalha,
+		Retomada:  req.Retomada,
+		Deck:      req.Deck,
 	}
 	s.sendToClient(player2Info.ReplyChannel, "Inicio_Batalha", resp)
 
@@ -261,11 +424,11 @@ func (s *Server) handleBattleRequestMove(c *gin.Context) {
 		return
 	}
 
-	// manda a msg pro meu cliente (j2) "ei, joga ai" (via redis)
-	resp := models.RespostaPedirCarta{Indice: req.Indice}
+	// manda a msg pro meu cliente (j2) "ei, escolhe uma carta da mao" (via redis)
+	resp := models.RespostaPedirCarta{Turno: req.Turno, Mao: req.Mao}
 	s.sendToClient(player2Info.ReplyChannel, "Pedir_Carta", resp)
 
-	color.Green("BATALHA (Peer J2): Pedido de carta (índice %d) enviado ao cliente %s", req.Indice, peerInfo.PlayerID)
+	color.Green("BATALHA (Peer J2): Pedido de carta (turno %d) enviado ao cliente %s", req.Turno, peerInfo.PlayerID)
 	c.JSON(http.StatusOK, gin.H{"message": "Pedido de jogada enviado"})
 }
 
@@ -378,9 +541,9 @@ func (s *Server) handleTradeInitiate(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Troca iniciada e registrada"})
 }
 
-// (server 1 - host) o server 2 ta me devolvendo a carta q o j2 ofertou
-func (s *Server) handleTradeSubmitCard(c *gin.Context) {
-	var req models.TradeSubmitCardRequest
+// (server 1 - host) o server 2 ta me mandando uma ação do j2 na negociação
+func (s *Server) handleTradeAction(c *gin.Context) {
+	var req models.TradeActionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
 		return
@@ -396,49 +559,36 @@ func (s *Server) handleTradeSubmitCard(c *gin.Context) {
 		return
 	}
 
-	// joga a carta do j2 no canal q a goroutine 'iniciarTroca' ta esperando
-	select {
-	case trade.CanalJ2 <- req.Carta:
-		color.Magenta("TROCA (Host J1): Recebida carta de J2 para troca %s", req.IdTroca)
-		c.JSON(http.StatusOK, gin.H{"message": "Oferta recebida"})
-	case <-time.After(5 * time.Second): // timeout
-		color.Red("TROCA (Host J1): Timeout ao enviar carta de J2 para canal da troca %s", req.IdTroca)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Timeout interno"})
+	// pela api so vem ação do j2. joga na fila q a goroutine 'iniciarTroca' ta lendo
+	// (se a ação n valer, a goroutine avisa o j2 pelo /trade/update)
+	req.Acao.IdJogador = trade.Jogador2
+	if err := s.entregarAcaoTroca(trade, req.Acao, 5*time.Second); err != nil {
+		color.Red("TROCA (Host J1): Ação de J2 perdida na troca %s: %v", req.IdTroca, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Troca encerrada"})
+		return
 	}
+	color.Magenta("TROCA (Host J1): Recebido %s de J2 para troca %s", req.Acao.Acao, req.IdTroca)
+	c.JSON(http.StatusOK, gin.H{"message": "Ação recebida"})
 }
 
-// (server 2) o server 1 (host) ta pedindo a oferta do meu player (j2)
-func (s *Server) handleTradeRequestCard(c *gin.Context) {
-	var req models.TradeRequestCardRequest
+// (server 2) o server 1 (host) ta mandando o estado da negociação (ou um erro) pro meu player (j2)
+func (s *Server) handleTradeUpdate(c *gin.Context) {
+	var req models.TradeUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Requisição inválida"})
 		return
 	}
 
-	// descobre quem eh o meu player (j2)
-	s.muTradesPeer.RLock()
-	peerInfo, ok := s.tradesPeer[req.IdTroca]
-	s.muTradesPeer.RUnlock()
-	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Associação de troca não encontrada (J2)"})
-		return
-	}
-
 	// acha o canal de resposta dele
-	s.muPlayers.RLock()
-	player2Info, ok := s.playerList[peerInfo.PlayerID]
-	s.muPlayers.RUnlock()
-	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Jogador (J2) não encontrado na lista"})
+	player2Info, ok := s.infoJogador(req.IdJogador)
+	if !ok || player2Info.ServerID != s.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "J2 não está neste servidor"})
 		return
 	}
 
-	// manda a msg pro meu cliente (j2) "ei, oferta ai" (via redis)
-	resp := models.RespostaPedirCartaTroca{IdTroca: req.IdTroca}
-	s.sendToClient(player2Info.ReplyChannel, "Pedir_Carta_Troca", resp)
-
-	color.Magenta("TROCA (Peer J2): Pedido de carta enviado ao cliente %s", peerInfo.PlayerID)
-	c.JSON(http.StatusOK, gin.H{"message": "Pedido de oferta enviado"})
+	// manda pro meu cliente (j2) via redis
+	s.mostrarTroca(player2Info.ReplyChannel, req.Oferta, req.Erro)
+	c.JSON(http.StatusOK, gin.H{"message": "Estado da troca enviado"})
 }
 
 // (server 2) o server 1 (host) ta mandando o resultado final da troca
@@ -449,37 +599,26 @@ func (s *Server) handleTradeResult(c *gin.Context) {
 		return
 	}
 
-	// acha o j2 e limpa o mapa
+	// acha o j2 e limpa o mapa. o resultado tbm fica pendente no Redis (a gnt reenvia ate o cliente
+	// confirmar), entao pode chegar depois de a gnt ter esquecido a troca: o id do j2 vem no pedido
 	s.muTradesPeer.Lock()
-	peerInfo, ok := s.tradesPeer[req.IdTroca]
-	if ok {
-		delete(s.tradesPeer, req.IdTroca) // limpeza!
-	}
+	delete(s.tradesPeer, req.IdTroca) // limpeza!
 	s.muTradesPeer.Unlock()
 
-	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Associação de troca (J2) não encontrada (ou já encerrada)"})
-		return
-	}
-
 	// acha o canal de resposta do j2
-	s.muPlayers.RLock()
-	player2Info, ok := s.playerList[peerInfo.PlayerID]
-	s.muPlayers.RUnlock()
-	if !ok {
-		color.Yellow("TROCA (Peer J2): Fim da troca %s, mas J2 (%s) não encontrado. Associação limpa.", req.IdTroca, peerInfo.PlayerID)
-		c.JSON(http.StatusOK, gin.H{"message": "Fim da troca processado, mas J2 não encontrado"})
+	player2Info, ok := s.infoJogador(req.IdJogador)
+	if !ok || player2Info.ServerID != s.ID {
+		// ele n ta (mais) aqui. o server onde ele tiver manda o resultado pendente
+		color.Yellow("TROCA (Peer J2): Fim da troca %s, mas J2 (%s) não está neste servidor", req.IdTroca, req.IdJogador)
+		c.JSON(http.StatusNotFound, gin.H{"error": "J2 não está neste servidor"})
 		return
 	}
 
 	// avisa o meu cliente (j2) o resultado (via redis)
-	// a 'CartaRecebida' aqui eh a carta q o j1 ofertou (e q o j2 ta recebendo)
-	resp := models.RespostaResultadoTroca{
-		Mensagem:      "Troca concluída!",
-		CartaRecebida: req.CartaRecebida,
-	}
-	s.sendToClient(player2Info.ReplyChannel, "Resultado_Troca", resp)
+	// as 'CartasRecebidas' aqui sao as q o j1 ofertou (e q o j2 ta recebendo).
+	// resultado repetido o cliente ignora pelo id da troca
+	s.sendToClient(player2Info.ReplyChannel, "Resultado_Troca", req.Resultado)
 
-	color.Magenta("TROCA (Peer J2): Resultado da troca enviado ao cliente %s e associação limpa", peerInfo.PlayerID)
+	color.Magenta("TROCA (Peer J2): Resultado da troca %s enviado ao cliente %s", req.IdTroca, req.IdJogador)
 	c.JSON(http.StatusOK, gin.H{"message": "Fim da troca enviado"})
 }
@@ -1,44 +1,87 @@
 package main
 
 import (
+	"PlanoZ/assinatura"
 	"PlanoZ/models"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
 //  Listeners do Redis
 
-// Ouve tópicos globais (conectar, comprar_carta)
+// Ouve tópicos globais (conectar, comprar_carta, mercado, fila_batalha)
 func (s *Server) listenRedisGlobal(topico string) {
 	color.Cyan("Ouvindo tópico global do Redis: %s", topico)
 	for {
-		resultado, err := s.redisClient.BLPop(s.ctx, 0*time.Second, topico).Result()
+		// desligando: para de pegar conexoes novas, elas ficam na fila pros outros servers
+		if topico == TopicoConectar && s.drenando.Load() {
+			color.Yellow("Parando de ouvir %s (servidor desligando)", topico)
+			return
+		}
+
+		// timeout finito pra dar tempo de checar o 'drenando' de vez em quando
+		resultado, err := s.redisClient.BLPop(s.ctx, IntervaloBLPop, topico).Result()
+		if err == redis.Nil {
+			continue // ninguem mandou nada nesse intervalo
+		}
 		if err != nil {
 			color.Red("Erro ao ler do tópico %s: %v", topico, err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
-		msg := resultado[1]
+		// abre o envelope e confere a assinatura antes de qualquer coisa (ver assinatura.go)
+		sessao, env, errEnv := s.abrirEnvelope(resultado[1])
+		if errEnv == errEnvelopeInvalido {
+			color.Red("Mensagem inválida no tópico %s (sem envelope)", topico)
+			continue
+		}
 
 		if topico == TopicoConectar {
 			var req models.ReqConectar
-			if err := json.Unmarshal([]byte(msg), &req); err != nil {
+			if err := json.Unmarshal(env.Corpo, &req); err != nil {
 				color.Red("Erro ao decodificar ReqConectar: %v", err)
 				continue
 			}
-			go s.processConectar(req)
+			// Login/Registrar chegam sem sessão (a assinatura é conferida no autenticar), o Retomar tem q vir assinado
+			if errEnv != nil {
+				s.recusarEnvelope(errEnv, req.CanalResposta, env.Token)
+				continue
+			}
+			go s.processConectar(req, env, sessao)
 		} else if topico == TopicoComprarCarta {
 			var req models.ReqComprarCarta
-			if err := json.Unmarshal([]byte(msg), &req); err != nil {
+			if err := json.Unmarshal(env.Corpo, &req); err != nil {
 				color.Red("Erro ao decodificar ReqComprarCarta: %v", err)
 				continue
 			}
-			go s.processComprarCarta(req)
+			if s.checarEnvelope(sessao, env, errEnv, req.IdRemetente, req.CanalResposta) {
+				go s.processComprarCarta(req)
+			}
+		} else if topico == TopicoMercado {
+			var req models.ReqMercado
+			if err := json.Unmarshal(env.Corpo, &req); err != nil {
+				color.Red("Erro ao decodificar ReqMercado: %v", err)
+				continue
+			}
+			if s.checarEnvelope(sessao, env, errEnv, req.IdRemetente, req.CanalResposta) {
+				go s.processMercado(req) // (do mercado.go)
+			}
+		} else if topico == TopicoFila {
+			var req models.ReqFila
+			if err := json.Unmarshal(env.Corpo, &req); err != nil {
+				color.Red("Erro ao decodificar ReqFila: %v", err)
+				continue
+			}
+			if s.checarEnvelope(sessao, env, errEnv, req.IdRemetente, req.CanalResposta) {
+				go s.processFila(req) // (do matchmaking.go)
+			}
 		}
 	}
 }
@@ -54,32 +97,44 @@ func (s *Server) listenRedisPersonal() {
 			continue
 		}
 
-		msg := resultado[1]
+		// abre o envelope e confere a assinatura antes de qualquer coisa (ver assinatura.go)
+		sessao, env, errEnv := s.abrirEnvelope(resultado[1])
+		msg := env.Corpo
+		if errEnv == errEnvelopeInvalido {
+			color.Red("Mensagem inválida no tópico pessoal (sem envelope)")
+			continue
+		}
 
 		// Tenta decodificar como ReqPessoalServidor (parear, batalhar, msg)
 		var reqPessoal models.ReqPessoalServidor
-		errPessoal := json.Unmarshal([]byte(msg), &reqPessoal)
+		errPessoal := json.Unmarshal(msg, &reqPessoal)
 
 		if errPessoal == nil && reqPessoal.Tipo != "" {
-			go s.processReqPessoal(reqPessoal)
+			if s.checarEnvelope(sessao, env, errEnv, reqPessoal.IdRemetente, reqPessoal.CanalResposta) {
+				go s.processReqPessoal(reqPessoal)
+			}
 			continue
 		}
 
 		// Se não for, tenta decodificar como ReqJogadaBatalha
 		var reqJogada models.ReqJogadaBatalha
-		errJogada := json.Unmarshal([]byte(msg), &reqJogada)
+		errJogada := json.Unmarshal(msg, &reqJogada)
 
 		if errJogada == nil && reqJogada.IdBatalha != "" {
-			go s.processReqJogadaBatalha(reqJogada)
+			if s.checarEnvelope(sessao, env, errEnv, reqJogada.IdRemetente, reqJogada.CanalResposta) {
+				go s.processReqJogadaBatalha(reqJogada)
+			}
 			continue
 		}
 
-		// Se não for, tenta decodificar como ReqCartaTroca
-		var reqTroca models.ReqCartaTroca
-		errTroca := json.Unmarshal([]byte(msg), &reqTroca)
+		// Se não for, tenta decodificar como ReqAcaoTroca
+		var reqTroca models.ReqAcaoTroca
+		errTroca := json.Unmarshal(msg, &reqTroca)
 
 		if errTroca == nil && reqTroca.IdTroca != "" {
-			go s.processReqCartaTroca(reqTroca) // Nova função (ver abaixo)
+			if s.checarEnvelope(sessao, env, errEnv, reqTroca.IdRemetente, reqTroca.CanalResposta) {
+				go s.processReqAcaoTroca(reqTroca)
+			}
 			continue
 		}
 
@@ -90,13 +145,49 @@ func (s *Server) listenRedisPersonal() {
 //  Processadores de Requisições Redis
 
 // Processa uma nova conexão de cliente
-func (s *Server) processConectar(req models.ReqConectar) {
-	color.Green("Processando conexão para %s", req.IdRemetente)
+func (s *Server) processConectar(req models.ReqConectar, env models.Envelope, atual *Sessao) {
+	// primeiro descobre quem eh (login, registro ou token de uma sessao q ja existe, ver contas.go)
+	// no Login/Registrar o cliente ainda n tem a chave da sessão, entao a resposta vai assinada
+	// com a chave de resposta q veio selada no pedido. sem selo n tem como responder assinado
+	responder := s.sendToClient
+	var selo models.SeloConectar
+	if req.Acao != AcaoRetomar {
+		var err error
+		if selo, err = s.abrirSelo(req.Selado); err != nil {
+			color.Red("CONTAS: Pedido de conexão para %s descartado: %v", req.CanalResposta, err)
+			return
+		}
+		responder = func(canal, tipo string, data interface{}) {
+			s.sendToClientComChave(canal, selo.ChaveResposta, tipo, data)
+		}
+	}
+
+	sessao, err := s.autenticar(req, selo, env, atual)
+	if err == errSessaoInvalida {
+		s.avisarSessaoInvalida(req.CanalResposta, env.Token)
+		return
+	}
+	if err == errContaAntiga {
+		// o cliente manda o login de novo com a senha no selo (so dessa vez, ver contas.go)
+		responder(req.CanalResposta, "Conta_Antiga", models.RespostaErro{Erro: err.Error()})
+		return
+	}
+	if err != nil {
+		responder(req.CanalResposta, "Erro", models.RespostaErro{Erro: err.Error()})
+		return
+	}
+	playerID := sessao.IdJogador
+	req.IdRemetente = playerID // daqui pra frente o id eh o da conta
+
+	// o cliente sempre (re)conecta sem parceiro, entao desfaz o par q tinha e avisa o outro lado
+	s.desparear(playerID, "Desconexão", "%s desconectou")
+	color.Green("Processando conexão para %s (%s)", req.IdRemetente, req.Acao)
 
 	leaderReq := models.LeaderConnectRequest{
 		PlayerID:      req.IdRemetente,
 		ServerID:      s.ID,
 		CanalResposta: req.CanalResposta,
+		Termo:         s.termoAtual(),
 	}
 
 	if s.isLeader() {
@@ -116,15 +207,17 @@ func (s *Server) processConectar(req models.ReqConectar) {
 		if exists && oldInfo.ServerID != s.ID {
 			s.broadcastToServers("/players/update", models.UpdatePlayerListRequest{
 				PlayerID: req.IdRemetente, ServerID: oldInfo.ServerID, Acao: "remove",
+				IdLider: s.ID, Termo: s.termoAtual(),
 			})
 		}
 		s.broadcastToServers("/players/update", models.UpdatePlayerListRequest{
 			PlayerID: req.IdRemetente, ServerID: s.ID, CanalResposta: req.CanalResposta, Acao: "add",
+			IdLider: s.ID, Termo: s.termoAtual(),
 		})
 	} else {
 		// Se NÃO sou o líder, encaminho para ele
 		if err := s.sendToLeader("/players/connect", leaderReq); err != nil {
-			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao contatar o líder"})
+			responder(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao contatar o líder"})
 			return
 		}
 	}
@@ -135,17 +228,39 @@ func (s *Server) processConectar(req models.ReqConectar) {
 		IdServidorConectado:  s.ID,
 		CanalPessoalServidor: s.CanalPessoal,
 		CanalUDPPing:         s.HostUDP, // Envia o "host:porta" UDP, ex: "server1:8081"
+		IdJogador:            playerID,
+		Token:                sessao.Token,
+	}
+	if selo.ChaveResposta != "" {
+		// no Login/Registrar o cliente ainda n tem a chave da sessão: vai cifrada com a chave de resposta
+		cifrada, err := assinatura.Cifrar(selo.ChaveResposta, []byte(sessao.Chave), sessao.Token)
+		if err != nil {
+			color.Red("CONTAS: Falha ao cifrar a chave da sessão de %s: %v", playerID, err)
+			responder(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao criar a sessão"})
+			return
+		}
+		resp.ChaveSessao = cifrada
 	}
-	s.sendToClient(req.CanalResposta, "Conexao_Sucesso", resp)
+	responder(req.CanalResposta, "Conexao_Sucesso", resp)
+
+	// alguma troca pode ter fechado enquanto ele tava fora (escrow.go)
+	s.reenviarResultadosTroca(playerID, req.CanalResposta)
 }
 
 // Processa uma compra de pacote
 func (s *Server) processComprarCarta(req models.ReqComprarCarta) {
-	color.Green("Processando compra de carta para %s", req.IdRemetente)
+	idPacote := req.TipoPacote
+	if idPacote == "" {
+		idPacote = PacotePadrao
+	}
+	color.Green("Processando compra de pacote '%s' para %s", idPacote, req.IdRemetente)
 
 	leaderReq := models.LeaderBuyCardRequest{
-		PlayerID: req.IdRemetente,
-		ServerID: s.ID,
+		PlayerID:    req.IdRemetente,
+		ServerID:    s.ID,
+		TipoPacote:  idPacote,
+		IdTransacao: txCompra(req.IdRemetente, req.IdTransacao),
+		Termo:       s.termoAtual(),
 	}
 
 	if s.isLeader() {
@@ -158,64 +273,135 @@ func (s *Server) processComprarCarta(req models.ReqComprarCarta) {
 			return
 		}
 
-		s.muInventory.Lock()
-		if s.pacoteCounter <= 0 {
-			s.muInventory.Unlock()
-			s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Não há mais pacotes disponíveis"})
+		// Venda atômica no Redis (estoque + cartas de uma vez só)
+		cartas, pacotesRestantes, saldo, err := s.venderPacote(req.IdRemetente, idPacote, leaderReq.IdTransacao)
+		if err == errSaldoInsuficiente {
+			s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: fmt.Sprintf("Saldo insuficiente (você tem %d créditos)", saldo)})
+			return
+		}
+		if err == errTransacaoRepetida {
+			s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Essa compra já foi processada"})
+			return
+		}
+		if err == errEstoqueEsgotado {
+			s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: fmt.Sprintf("Não há mais pacotes '%s' disponíveis", idPacote)})
+			return
+		}
+		if err == errPacoteInexistente || err == errPacoteIndisponivel {
+			s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: fmt.Sprintf("Pacote '%s': %v", idPacote, err)})
+			return
+		}
+		if err == errLiderObsoleto {
+			s.deixarLideranca("termo recusado pelo Redis na venda")
+			s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Liderança em transição, tente novamente"})
+			return
+		}
+		if err != nil {
+			color.Red("LÍDER: Falha ao vender pacote para %s: %v", req.IdRemetente, err)
+			s.sendToClient(playerInfo.ReplyChannel, "Erro", models.RespostaErro{Erro: "Falha ao processar a compra"})
 			return
 		}
-		s.pacoteCounter--
-		pacotesRestantes := s.pacoteCounter
-		s.muInventory.Unlock()
 
-		color.Cyan("LÍDER: Pacote vendido para %s. Restantes: %d", req.IdRemetente, pacotesRestantes)
+		color.Cyan("LÍDER: Pacote '%s' vendido para %s. Restantes: %d", idPacote, req.IdRemetente, pacotesRestantes)
 
-		invUpdate := models.UpdateInventoryRequest{PacotesRestantes: pacotesRestantes}
-		s.broadcastToServers("/inventory/update", invUpdate)
+		if estoques, err := s.lerEstoques(); err == nil {
+			invUpdate := models.UpdateInventoryRequest{Estoques: estoques, IdLider: s.ID, Termo: s.termoAtual()}
+			s.broadcastToServers("/inventory/update", invUpdate)
+		}
 
-		cartas := s.sortearCartas(req.IdRemetente)
 		respSorteio := models.RespostaSorteio{
 			Mensagem: "Sorteio realizado com sucesso!",
 			Cartas:   cartas,
+			Saldo:    saldo,
 		}
 		s.sendToClient(playerInfo.ReplyChannel, "Sorteio", respSorteio)
 
 	} else {
 		// Se NÃO sou o líder, encaminho para ele
 		if err := s.sendToLeader("/cards/buy", leaderReq); err != nil {
+			if errors.Is(err, errClienteAvisado) {
+				// o líder recusou mas ja mandou o motivo pro cliente
+				color.Yellow("Compra de %s recusada pelo líder: %v", req.IdRemetente, err)
+				return
+			}
 			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao contatar o líder"})
 			return
 		}
 	}
 }
 
-// Processa requisições pessoais (Parear, Mensagem, Batalhar)
+// Processa requisições pessoais (Parear, Mensagem, Batalhar, Inventario)
 func (s *Server) processReqPessoal(req models.ReqPessoalServidor) {
-	switch req.Tipo {
-	case "Parear":
-		color.Green("Processando pareamento para %s com %s", req.IdRemetente, req.IdDestinatario)
-		s.muPlayers.RLock()
-		infoDest, ok := s.playerList[req.IdDestinatario]
-		s.muPlayers.RUnlock()
+	// desligando: nada de batalha/troca nova, so deixa terminar as q ja tao rolando
+	if s.drenando.Load() && (req.Tipo == "Batalhar" || req.Tipo == "Trocar") {
+		s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Servidor desligando, aguarde a reconexão"})
+		return
+	}
 
-		if !ok {
-			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Jogador destinatário não encontrado ou offline"})
+	// batalha, troca e chat so com o parceiro atual (o par fica no Redis, ver pares.go)
+	if req.Tipo == "Mensagem" || req.Tipo == "Batalhar" || req.Tipo == "Trocar" {
+		if err := s.checarParceiro(req); err != nil {
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: err.Error()})
+			return
+		}
+	}
+
+	switch req.Tipo {
+	case "Inventario":
+		// Devolve o inventário oficial (Redis) para o cliente sincronizar o dele
+		cartas, err := s.listarInventario(req.IdRemetente)
+		if err != nil {
+			color.Red("Erro ao listar inventário de %s: %v", req.IdRemetente, err)
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao ler inventário"})
 			return
 		}
+		s.sendToClient(req.CanalResposta, "Inventario", models.RespostaInventario{Cartas: cartas})
+
+	case "Pacotes":
+		// Vitrine: tipos de pacote do catálogo com preço e estoque
+		s.sendToClient(req.CanalResposta, "Pacotes", models.RespostaPacotes{Pacotes: s.listarPacotes()})
 
-		// Notifica o remetente
-		respRemetente := models.RespostaPareamento{
-			Mensagem:   fmt.Sprintf("Pareamento realizado com %s", req.IdDestinatario),
-			IdParceiro: req.IdDestinatario,
+	case "Resultado_Recebido":
+		// o cliente recebeu o Resultado_Troca: para de reenviar (escrow.go)
+		s.confirmarResultadoTroca(req.IdRemetente, req.IdTroca)
+
+	case "Saldo":
+		// Saldo e extrato da carteira (carteira.go)
+		saldo, extrato, err := s.lerCarteira(req.IdRemetente)
+		if err != nil {
+			color.Red("Erro ao ler carteira de %s: %v", req.IdRemetente, err)
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao ler carteira"})
+			return
+		}
+		s.sendToClient(req.CanalResposta, "Saldo", models.RespostaSaldo{Saldo: saldo, Extrato: extrato})
+
+	case "Parear", "Aceitar", "Recusar", "Bloquear", "Desbloquear":
+		// Pareamento por convite (ver convites.go): Parear convida, o outro aceita ou recusa
+		var err error
+		switch req.Tipo {
+		case "Parear":
+			err = s.convidar(req)
+		case "Aceitar":
+			err = s.aceitarConvite(req)
+		case "Recusar":
+			err = s.recusarConvite(req)
+		default:
+			err = s.bloquear(req, req.Tipo == "Bloquear")
+		}
+		if err != nil {
+			s.responderErroConvite(req, err)
 		}
-		s.sendToClient(req.CanalResposta, "Pareamento", respRemetente)
 
-		// Notifica o destinatário
-		respDestinatario := models.RespostaPareamento{
-			Mensagem:   fmt.Sprintf("Pareamento realizado com %s", req.IdRemetente),
-			IdParceiro: req.IdRemetente,
+	case "Desparear":
+		parceiro := s.desparear(req.IdRemetente, "Despareado", "%s desfez o pareamento")
+		if parceiro == "" {
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Você não está pareado"})
+			return
 		}
-		s.sendToClient(infoDest.ReplyChannel, "Pareamento", respDestinatario)
+		s.sendToClient(req.CanalResposta, "Despareado", models.RespostaPareamento{
+			Mensagem:   fmt.Sprintf("Pareamento com %s desfeito", parceiro),
+			IdParceiro: parceiro,
+		})
 
 	case "Mensagem":
 		color.Green("Processando msg de %s para %s", req.IdRemetente, req.IdDestinatario)
@@ -238,68 +424,9 @@ func (s *Server) processReqPessoal(req models.ReqPessoalServidor) {
 	case "Batalhar":
 		color.Green("Processando início de batalha entre %s e %s", req.IdRemetente, req.IdDestinatario)
 
-		// Este servidor (S1) será o HOST da batalha.
-		s.muPlayers.RLock()
-		infoJ1, okJ1 := s.playerList[req.IdRemetente]
-		infoJ2, okJ2 := s.playerList[req.IdDestinatario]
-		s.muPlayers.RUnlock()
-
-		if !okJ1 || !okJ2 {
-			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Um dos jogadores não foi encontrado"})
-			return
-		}
-
-		// 1. Criar a struct Batalha
-		battleID := "battle:" + uuid.New().String()[:8]
-		batalha := &models.Batalha{
-			Jogador1:     req.IdRemetente,
-			Jogador2:     req.IdDestinatario,
-			ServidorJ1:   infoJ1.ServerHost,
-			ServidorJ2:   infoJ2.ServerHost,
-			CanalJ1:      make(chan models.Tanque, 1), // Canal com buffer 1
-			CanalJ2:      make(chan models.Tanque, 1), // Canal com buffer 1
-			CanalEncerra: make(chan bool, 1),
-		}
-
-		// 2. Armazenar a batalha localmente (como Host)
-		s.muBatalhas.Lock()
-		s.batalhas[battleID] = batalha
-		s.muBatalhas.Unlock()
-
-		// 3. Iniciar a goroutine da batalha (do battle.go)
-		go s.iniciarBatalha(battleID, batalha, infoJ1.ReplyChannel)
-
-		// 4. Notificar o Servidor J2 (Peer) para ele avisar o J2
-		initReq := models.BattleInitiateRequest{
-			IdBatalha:      battleID,
-			IdJogadorLocal: req.IdDestinatario, // J2
-			IdOponente:     req.IdRemetente,    // J1
-			HostServidor:   s.HostAPI,          // Endereço de callback (EU, S1)
-		}
-
-		// Se for um self-test (J1 e J2 no mesmo server)
-		if infoJ1.ServerID == infoJ2.ServerID {
-			// Simula a chamada de rede localmente (chamando a lógica do handler)
-			s.muBatalhasPeer.Lock()
-			s.batalhasPeer[battleID] = peerBattleInfo{
-				PlayerID: req.IdDestinatario,
-				HostAPI:  s.HostAPI,
-			}
-			s.muBatalhasPeer.Unlock()
-
-			respInicioJ2 := models.RespostaInicioBatalha{
-				Mensagem:  req.IdRemetente,
-				IdBatalha: battleID,
-			}
-			s.sendToClient(infoJ2.ReplyChannel, "Inicio_Batalha", respInicioJ2)
-			color.Green("BATALHA (Self-Test): Batalha %s registrada para J2 %s", battleID, req.IdDestinatario)
-		} else {
-			// Chamada de rede normal para S2
-			if err := s.sendToHost(infoJ2.ServerHost, "/battle/initiate", initReq); err != nil {
-				s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao iniciar batalha com o servidor do oponente"})
-				s.encerrarBatalha(battleID, "Ninguém", "Falha de Rede")
-				return
-			}
+		// Este servidor (S1) será o HOST da batalha (do battle.go)
+		if err := s.abrirBatalha(req.IdRemetente, req.IdDestinatario, false); err != nil {
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: err.Error()})
 		}
 
 	case "Trocar":
@@ -318,13 +445,21 @@ func (s *Server) processReqPessoal(req models.ReqPessoalServidor) {
 
 		// 1. Criar a struct Troca (usando models.Troca)
 		tradeID := "trade:" + uuid.New().String()[:8]
+		// marca os dois (ver ocupado.go); a troca n dura mais q o escrow
+		if err := s.ocuparJogadores(tradeID, PrazoEscrow, req.IdRemetente, req.IdDestinatario); err != nil {
+			mensagem := "Falha ao iniciar troca"
+			if err == errJogadorOcupado {
+				mensagem = err.Error()
+			}
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: mensagem})
+			return
+		}
 		troca := &models.Troca{
 			Jogador1:     req.IdRemetente,
 			Jogador2:     req.IdDestinatario,
 			ServidorJ1:   infoJ1.ServerHost,
 			ServidorJ2:   infoJ2.ServerHost,
-			CanalJ1:      make(chan models.Tanque, 1), // Canal com buffer 1
-			CanalJ2:      make(chan models.Tanque, 1), // Canal com buffer 1
+			Acoes:        make(chan models.AcaoTroca, 4),
 			CanalEncerra: make(chan bool, 1),
 		}
 
@@ -334,12 +469,8 @@ func (s *Server) processReqPessoal(req models.ReqPessoalServidor) {
 		s.trades[tradeID] = troca
 		s.muTrades.Unlock()
 
-		// 3. Iniciar a goroutine da troca (precisa ser criada, ex: trade.go)
-		// Esta função (s.iniciarTroca) será responsável por orquestrar a troca,
-		// pedir as cartas e enviar o resultado.
-		go s.iniciarTroca(tradeID, troca, infoJ1.ReplyChannel)
-
-		// 4. Notificar o Servidor J2 (Peer) para ele avisar o J2
+		// 3. Notificar o Servidor J2 (Peer) para ele avisar o J2
+		// (antes de a goroutine começar, senão o primeiro "Oferta_Troca" chega no J2 antes do "Inicio_Troca")
 		initReq := models.TradeInitiateRequest{
 			IdTroca:        tradeID,
 			IdJogadorLocal: req.IdDestinatario, // J2
@@ -373,6 +504,11 @@ func (s *Server) processReqPessoal(req models.ReqPessoalServidor) {
 				return
 			}
 		}
+
+		// 4. Iniciar a goroutine da troca (trade.go)
+		// Esta função (s.iniciarTroca) será responsável por orquestrar a negociação
+		// e enviar o resultado.
+		go s.iniciarTroca(tradeID, troca, infoJ1.ReplyChannel)
 	}
 }
 
@@ -435,63 +571,48 @@ func (s *Server) processReqJogadaBatalha(req models.ReqJogadaBatalha) {
 	s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Batalha não encontrada ou já encerrada."})
 }
 
-// Processa uma carta de troca (recebida do Redis)
-func (s *Server) processReqCartaTroca(req models.ReqCartaTroca) {
+// Processa uma ação de troca: ofertar, confirmar ou cancelar (recebida do Redis)
+func (s *Server) processReqAcaoTroca(req models.ReqAcaoTroca) {
 	// Esta requisição pode ser de J1 (Host) ou J2 (Peer)
+	acao := models.AcaoTroca{
+		IdJogador: req.IdRemetente,
+		Acao:      req.Acao,
+		IdsCartas: req.IdsCartas,
+		Creditos:  req.Creditos,
+		Versao:    req.Versao,
+	}
 
-	// Tenta como Host (J1)
-	// (Requer s.muTrades e s.trades)
+	// Tenta como Host (J1, ou J2 no self-test)
 	s.muTrades.RLock()
 	tradeHost, okHost := s.trades[req.IdTroca]
 	s.muTrades.RUnlock()
 
-	if okHost {
-		// Verifica se é o J1 (Host) enviando
-		if req.IdRemetente == tradeHost.Jogador1 {
-			select {
-			case tradeHost.CanalJ1 <- req.Carta:
-				color.Green("TROCA (Host J1): Recebida carta de J1 para troca %s", req.IdTroca)
-			case <-time.After(2 * time.Second):
-				color.Red("TROCA (Host J1): Timeout ao enviar carta de J1 (canal cheio?) %s", req.IdTroca)
-			}
-			return
-		}
-		// Verifica se é o J2 (Host-Self-Test) enviando
-		if req.IdRemetente == tradeHost.Jogador2 {
-			select {
-			case tradeHost.CanalJ2 <- req.Carta:
-				color.Green("TROCA (Host J2-Self): Recebida carta de J2 para troca %s", req.IdTroca)
-			case <-time.After(2 * time.Second):
-				color.Red("TROCA (Host J2-Self): Timeout ao enviar carta de J2 (canal cheio?) %s", req.IdTroca)
-			}
-			return
+	if okHost && (req.IdRemetente == tradeHost.Jogador1 || req.IdRemetente == tradeHost.Jogador2) {
+		if err := s.entregarAcaoTroca(tradeHost, acao, 2*time.Second); err != nil {
+			color.Red("TROCA (Host): %s de %s perdido na troca %s: %v", req.Acao, req.IdRemetente, req.IdTroca, err)
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Troca não encontrada ou já encerrada."})
 		}
+		return
 	}
 
 	// Tenta como Peer (J2)
-	// (Requer s.muTradesPeer e s.tradesPeer)
 	s.muTradesPeer.RLock()
 	peerInfo, okPeer := s.tradesPeer[req.IdTroca]
 	s.muTradesPeer.RUnlock()
 
 	if okPeer && req.IdRemetente == peerInfo.PlayerID {
 		// É o J2 (Peer) enviando. Precisamos encaminhar para o Servidor Host (J1)
-		submitReq := models.TradeSubmitCardRequest{
-			IdTroca: req.IdTroca,
-			Carta:   req.Carta,
-		}
-
-		color.Green("TROCA (Peer J2): Encaminhando carta de %s para Host %s", req.IdRemetente, peerInfo.HostAPI)
+		color.Green("TROCA (Peer J2): Encaminhando %s de %s para Host %s", req.Acao, req.IdRemetente, peerInfo.HostAPI)
 
-		// Envia a carta para o S1 (Host) via API
-		if err := s.sendToHost(peerInfo.HostAPI, "/trade/submit_card", submitReq); err != nil {
+		actionReq := models.TradeActionRequest{IdTroca: req.IdTroca, Acao: acao}
+		if err := s.sendToHost(peerInfo.HostAPI, "/trade/action", actionReq); err != nil {
 			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{
-				Erro: fmt.Sprintf("Falha ao enviar carta para o servidor host: %v", err),
+				Erro: fmt.Sprintf("Falha ao enviar a ação para o servidor host: %v", err),
 			})
 		}
 		return
 	}
 
-	color.Red("TROCA: Recebida carta para troca %s, mas troca não encontrada como Host ou Peer.", req.IdTroca)
+	color.Red("TROCA: Recebida ação para troca %s, mas troca não encontrada como Host ou Peer.", req.IdTroca)
 	s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Troca não encontrada ou já encerrada."})
 }
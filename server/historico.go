@@ -0,0 +1,327 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Histórico de Partidas e Rating (Redis) ---
+
+// Toda batalha encerrada vira um RegistroPartida no Redis (jogadores, decks, o log dos turnos,
+// vencedor e motivo), e cada jogador tem um índice das partidas dele por data.
+// O rating é Elo: partida da fila ranqueada (ver matchmaking.go) q teve turno ou vencedor mexe no
+// rating dos dois (o q um ganha o outro perde). Partida q nem começou (falha de rede no início)
+// fica no histórico, mas n conta nada.
+// Nada disso precisa do slot do inventário: a partida fica no slot dela ({partida:<id>}), o índice e as
+// estatísticas no slot do jogador ({jogador:<id>}) e só o rating dos dois anda junto ({rating}).
+// Cada passo pode repetir sem contar 2x (se o encerramento rodar 2x, registra 1x):
+//  1. o rating marca a partida q ja aplicou e devolve o msm rating na repetição
+//  2. o registro é SET NX, e quem chega depois usa o q ta salvo
+//  3. o índice de cada jogador so soma nas estatísticas se a partida for nova nele
+//
+// O registro vence em RetencaoPartida e o índice guarda no máximo MaxHistorico partidas, então nada cresce pra sempre.
+// Consultas pela API (qualquer server responde, ta tudo no Redis; o admin chama com o segredo):
+//   - GET /players/:id/rating                           rating e vitórias/derrotas/empates
+//   - GET /players/:id/history?limite=20&antes=<unix>   partidas do jogador, da mais nova pra mais velha
+//   - GET /matches/:id                                  uma partida inteira (decks e turnos)
+const (
+	PrefixoRatingPartida = "{rating}:partida:" // + id da batalha -> "<rating novo j1>:<rating novo j2>:<variação j1>" (TTL)
+
+	RetencaoPartida = 90 * 24 * time.Hour // quanto tempo o registro da partida fica no Redis
+	MaxHistorico    = 500                 // partidas no índice de cada jogador (as mais velhas saem)
+
+	FatorK          = 32  // quanto o rating anda numa partida (Elo)
+	LimitePadrao    = 20  // partidas por consulta do histórico, se n vier 'limite'
+	LimiteHistorico = 100 // máximo de partidas por consulta
+)
+
+func chavePartida(battleID string) string       { return "{partida:" + battleID + "}:registro" }
+func chaveHistorico(playerID string) string     { return "{jogador:" + playerID + "}:historico" }    // zset id da partida -> fim (unix)
+func chaveEstatisticas(playerID string) string  { return "{jogador:" + playerID + "}:estatisticas" } // hash partidas/vitorias/derrotas/empates/ranqueadas
+func chaveRatingPartida(battleID string) string { return PrefixoRatingPartida + battleID }
+
+// scriptAplicarRating muda o rating dos dois jogadores, uma vez por partida
+// KEYS[1] = ratings, KEYS[2] = marca da partida
+// ARGV[1] = j1, ARGV[2] = j2, ARGV[3] = rating do j1 lido, ARGV[4] = rating do j2 lido,
+// ARGV[5] = rating novo do j1, ARGV[6] = rating novo do j2, ARGV[7] = variação do j1,
+// ARGV[8] = rating inicial, ARGV[9] = TTL da marca (ms)
+// Retorna a marca ("<rating novo j1>:<rating novo j2>:<variação j1>", a de antes se ja aplicou) ou ” se o rating
+// de alguém mudou no meio (lê de novo)
+var scriptAplicarRating = redis.NewScript(`
+local marca = redis.call('GET', KEYS[2])
+if marca then return marca end
+local r1 = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or ARGV[8])
+local r2 = tonumber(redis.call('HGET', KEYS[1], ARGV[2]) or ARGV[8])
+if r1 ~= tonumber(ARGV[3]) or r2 ~= tonumber(ARGV[4]) then return '' end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[5], ARGV[2], ARGV[6])
+marca = ARGV[5] .. ':' .. ARGV[6] .. ':' .. ARGV[7]
+redis.call('SET', KEYS[2], marca, 'PX', ARGV[9])
+return marca
+`)
+
+// scriptIndexarPartida põe a partida no índice do jogador e, se ela for nova ali, soma nas estatísticas.
+// O índice perde as partidas vencidas e fica com as MaxHistorico mais novas.
+// KEYS[1] = histórico do jogador, KEYS[2] = estatísticas do jogador
+// ARGV[1] = id da partida, ARGV[2] = fim (unix), ARGV[3] = resultado ('vitorias', 'derrotas', 'empates' ou ” se n conta),
+// ARGV[4] = ranqueada ('1' ou '0'), ARGV[5] = fim mais velho q ainda fica (unix), ARGV[6] = máximo de partidas
+// Retorna 1 = indexou, 0 = ja tava no índice
+var scriptIndexarPartida = redis.NewScript(`
+local nova = redis.call('ZADD', KEYS[1], 'NX', ARGV[2], ARGV[1])
+if nova == 1 and ARGV[3] ~= '' then
+	redis.call('HINCRBY', KEYS[2], 'partidas', 1)
+	redis.call('HINCRBY', KEYS[2], ARGV[3], 1)
+	if ARGV[4] == '1' then redis.call('HINCRBY', KEYS[2], 'ranqueadas', 1) end
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[5])
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[6]) - 1)
+return nova
+`)
+
+// variacaoElo é quanto o j1 ganha (ou perde, se negativo) com o placar dado (1 vitória, 0.5 empate, 0 derrota).
+// O j2 anda o mesmo tanto pro outro lado.
+func variacaoElo(ratingJ1, ratingJ2 int, placarJ1 float64) int {
+	esperado := 1 / (1 + math.Pow(10, float64(ratingJ2-ratingJ1)/400))
+	return int(math.Round(FatorK * (placarJ1 - esperado)))
+}
+
+// resultadoPartida diz como a partida conta pra cada jogador ('vitorias', 'derrotas', 'empates', ou "" se
+// ela nem começou) e o placar do j1 pro Elo
+func resultadoPartida(reg models.RegistroPartida) (resultadoJ1, resultadoJ2 string, placarJ1 float64) {
+	switch {
+	case reg.Vencedor == reg.Jogador1:
+		return "vitorias", "derrotas", 1
+	case reg.Vencedor == reg.Jogador2:
+		return "derrotas", "vitorias", 0
+	case len(reg.Turnos) > 0:
+		return "empates", "empates", 0.5
+	}
+	// partida q nem começou (ninguém ganhou e n teve turno) fica registrada mas n conta
+	return "", "", 0
+}
+
+// registrarPartida salva a batalha encerrada no histórico e atualiza o rating (se for ranqueada).
+// O registro chega sem o rating, q é preenchido aqui. Devolve o registro como ficou salvo.
+func (s *Server) registrarPartida(reg models.RegistroPartida) (models.RegistroPartida, error) {
+	resultadoJ1, resultadoJ2, placarJ1 := resultadoPartida(reg)
+	contaRating := reg.Ranqueada && resultadoJ1 != ""
+
+	reg.RatingJ1, reg.RatingJ2, reg.VariacaoJ1, reg.VariacaoJ2 = 0, 0, 0, 0
+	if contaRating {
+		if err := s.aplicarRating(&reg, placarJ1); err != nil {
+			return reg, err
+		}
+	}
+
+	dados, err := json.Marshal(reg)
+	if err != nil {
+		return reg, err
+	}
+	novo, err := s.redisClient.SetNX(s.ctx, chavePartida(reg.Id), dados, RetencaoPartida).Result()
+	if err != nil {
+		return reg, fmt.Errorf("falha ao salvar a partida: %v", err)
+	}
+	if !novo {
+		// ja tinha sido registrada (encerramento repetido): segue com a q ta salva
+		salvo, ok, err := s.buscarPartida(reg.Id)
+		if err != nil {
+			return reg, err
+		}
+		if ok {
+			reg = salvo
+		}
+	}
+
+	// o índice de cada jogador fica no slot dele, então vai um script por jogador
+	corte := time.Now().Add(-RetencaoPartida).Unix()
+	ranqueada := "0"
+	if contaRating {
+		ranqueada = "1"
+	}
+	for _, j := range []struct{ id, resultado string }{{reg.Jogador1, resultadoJ1}, {reg.Jogador2, resultadoJ2}} {
+		err := scriptIndexarPartida.Run(s.ctx, s.redisClient, []string{chaveHistorico(j.id), chaveEstatisticas(j.id)},
+			reg.Id, reg.Fim, j.resultado, ranqueada, corte, MaxHistorico).Err()
+		if err != nil {
+			return reg, fmt.Errorf("falha ao indexar a partida pro %s: %v", j.id, err)
+		}
+	}
+	return reg, nil
+}
+
+// aplicarRating muda o rating dos dois e preenche o registro com ele. Se a partida ja tinha mexido no
+// rating (encerramento repetido), devolve o msm rating de antes.
+func (s *Server) aplicarRating(reg *models.RegistroPartida, placarJ1 float64) error {
+	// o rating é lido aqui e conferido no script: se outra partida mexer nele no meio, lê de novo
+	for tentativa := 0; tentativa < 5; tentativa++ {
+		ratingJ1, ratingJ2 := s.ratingDe(reg.Jogador1), s.ratingDe(reg.Jogador2)
+		variacao := variacaoElo(ratingJ1, ratingJ2, placarJ1)
+
+		marca, err := scriptAplicarRating.Run(s.ctx, s.redisClient,
+			[]string{ChaveRating, chaveRatingPartida(reg.Id)},
+			reg.Jogador1, reg.Jogador2, ratingJ1, ratingJ2, ratingJ1+variacao, ratingJ2-variacao, variacao,
+			RatingInicial, RetencaoPartida.Milliseconds()).Text()
+		if err != nil {
+			return fmt.Errorf("falha no script do rating: %v", err)
+		}
+		if marca == "" {
+			continue
+		}
+		if _, err := fmt.Sscanf(marca, "%d:%d:%d", &reg.RatingJ1, &reg.RatingJ2, &variacao); err != nil {
+			return fmt.Errorf("marca de rating inválida %q: %v", marca, err)
+		}
+		reg.VariacaoJ1, reg.VariacaoJ2 = variacao, -variacao
+		return nil
+	}
+	return fmt.Errorf("rating mudando sem parar, partida %s n registrada", reg.Id)
+}
+
+// registroDaBatalha monta o registro da batalha encerrada. O log dos turnos (e os decks, numa
+// batalha retomada) vem do checkpoint, q é salvo a cada turno fechado.
+func (s *Server) registroDaBatalha(battleID string, b *models.Batalha, vencedor, motivo string) models.RegistroPartida {
+	agora := time.Now().Unix()
+	reg := models.RegistroPartida{
+		Id:        battleID,
+		Jogador1:  b.Jogador1,
+		Jogador2:  b.Jogador2,
+		DeckJ1:    b.DeckJ1,
+		DeckJ2:    b.DeckJ2,
+		Vencedor:  vencedor,
+		Motivo:    motivo,
+		Ranqueada: b.Ranqueada,
+		Inicio:    agora,
+		Fim:       agora,
+	}
+	if estado, ok := s.carregarCheckpoint(battleID); ok {
+		if estado.Jogador1 != b.Jogador1 {
+			inverterCheckpoint(&estado) // checkpoint velho, de antes da retomada
+		}
+		if len(estado.DeckJ1) > 0 {
+			reg.DeckJ1, reg.DeckJ2 = estado.DeckJ1, estado.DeckJ2
+		}
+		if estado.Inicio > 0 {
+			reg.Inicio = estado.Inicio
+		}
+		reg.Turnos = estado.Log
+	}
+	return reg
+}
+
+// buscarPartida lê uma partida do histórico
+func (s *Server) buscarPartida(battleID string) (models.RegistroPartida, bool, error) {
+	dados, err := s.redisClient.Get(s.ctx, chavePartida(battleID)).Result()
+	if err == redis.Nil {
+		return models.RegistroPartida{}, false, nil
+	}
+	if err != nil {
+		return models.RegistroPartida{}, false, err
+	}
+	var reg models.RegistroPartida
+	if err := json.Unmarshal([]byte(dados), &reg); err != nil {
+		return models.RegistroPartida{}, false, err
+	}
+	return reg, true, nil
+}
+
+// historicoJogador devolve as últimas partidas do jogador (mais nova primeiro), terminadas antes de 'antes' (unix, 0 = agora)
+func (s *Server) historicoJogador(playerID string, limite int, antes int64) ([]models.RegistroPartida, error) {
+	teto := "+inf"
+	if antes > 0 {
+		teto = "(" + strconv.FormatInt(antes, 10)
+	}
+	ids, err := s.redisClient.ZRevRangeByScore(s.ctx, chaveHistorico(playerID), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   teto,
+		Count: int64(limite),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	partidas := make([]models.RegistroPartida, 0, len(ids))
+	for _, id := range ids {
+		reg, ok, err := s.buscarPartida(id)
+		if err != nil {
+			color.Red("HISTÓRICO: Falha ao ler a partida %s: %v", id, err)
+			continue
+		}
+		if ok {
+			partidas = append(partidas, reg)
+		}
+	}
+	return partidas, nil
+}
+
+// estatisticasJogador devolve o rating e os números do jogador
+func (s *Server) estatisticasJogador(playerID string) (models.EstatisticasJogador, error) {
+	campos, err := s.redisClient.HGetAll(s.ctx, chaveEstatisticas(playerID)).Result()
+	if err != nil {
+		return models.EstatisticasJogador{}, err
+	}
+	numero := func(campo string) int {
+		n, _ := strconv.Atoi(campos[campo])
+		return n
+	}
+	return models.EstatisticasJogador{
+		IdJogador:  playerID,
+		Rating:     s.ratingDe(playerID),
+		Partidas:   numero("partidas"),
+		Vitorias:   numero("vitorias"),
+		Derrotas:   numero("derrotas"),
+		Empates:    numero("empates"),
+		Ranqueadas: numero("ranqueadas"),
+	}, nil
+}
+
+// (admin/servidor) rating e números do jogador
+func (s *Server) handlePlayerRating(c *gin.Context) {
+	estatisticas, err := s.estatisticasJogador(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, estatisticas)
+}
+
+// (admin/servidor) partidas do jogador, da mais nova pra mais velha.
+// Pra paginar, manda o 'fim' da última partida recebida no 'antes'.
+func (s *Server) handlePlayerHistory(c *gin.Context) {
+	limite, err := strconv.Atoi(c.DefaultQuery("limite", strconv.Itoa(LimitePadrao)))
+	if err != nil || limite < 1 || limite > LimiteHistorico {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limite inválido (de 1 a %d)", LimiteHistorico)})
+		return
+	}
+	antes, err := strconv.ParseInt(c.DefaultQuery("antes", "0"), 10, 64)
+	if err != nil || antes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "antes inválido (unix em segundos)"})
+		return
+	}
+
+	partidas, err := s.historicoJogador(c.Param("id"), limite, antes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id_jogador": c.Param("id"), "partidas": partidas})
+}
+
+// (admin/servidor) uma partida do histórico
+func (s *Server) handleMatch(c *gin.Context) {
+	reg, ok, err := s.buscarPartida(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Partida não encontrada"})
+		return
+	}
+	c.JSON(http.StatusOK, reg)
+}
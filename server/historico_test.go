@@ -0,0 +1,68 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"strings"
+	"testing"
+)
+
+// hashTag devolve a parte da chave q decide o slot no cluster
+func hashTag(chave string) string {
+	ini := strings.Index(chave, "{")
+	fim := strings.Index(chave[ini+1:], "}")
+	if ini < 0 || fim <= 0 {
+		return chave
+	}
+	return chave[ini+1 : ini+1+fim]
+}
+
+func TestChavesDoHistoricoPorEntidade(t *testing.T) {
+	// o índice e as estatísticas do jogador vão no msm script, então precisam do msm slot
+	if hashTag(chaveHistorico("j1")) != hashTag(chaveEstatisticas("j1")) {
+		t.Fatal("histórico e estatísticas do jogador deviam cair no msm slot")
+	}
+	if hashTag(chaveHistorico("j1")) == hashTag(chaveHistorico("j2")) {
+		t.Fatal("cada jogador devia ter o slot dele")
+	}
+	if hashTag(chaveRatingPartida("b1")) != hashTag(ChaveRating) {
+		t.Fatal("a marca da partida e o rating vão no msm script, então precisam do msm slot")
+	}
+	for _, chave := range []string{chavePartida("b1"), chaveHistorico("j1"), chaveEstatisticas("j1"), ChaveRating} {
+		if hashTag(chave) == "inventario" {
+			t.Fatalf("%s n devia ficar no slot do inventário", chave)
+		}
+	}
+}
+
+func TestResultadoPartida(t *testing.T) {
+	casos := []struct {
+		nome   string
+		reg    models.RegistroPartida
+		r1, r2 string
+		placar float64
+	}{
+		{"j1 ganhou", models.RegistroPartida{Jogador1: "a", Jogador2: "b", Vencedor: "a"}, "vitorias", "derrotas", 1},
+		{"j2 ganhou", models.RegistroPartida{Jogador1: "a", Jogador2: "b", Vencedor: "b"}, "derrotas", "vitorias", 0},
+		{"empate", models.RegistroPartida{Jogador1: "a", Jogador2: "b", Turnos: make([]models.TurnoPartida, 1)}, "empates", "empates", 0.5},
+		{"nem começou", models.RegistroPartida{Jogador1: "a", Jogador2: "b"}, "", "", 0},
+	}
+	for _, c := range casos {
+		r1, r2, placar := resultadoPartida(c.reg)
+		if r1 != c.r1 || r2 != c.r2 || placar != c.placar {
+			t.Errorf("%s: veio %q/%q/%v, esperava %q/%q/%v", c.nome, r1, r2, placar, c.r1, c.r2, c.placar)
+		}
+	}
+}
+
+func TestVariacaoElo(t *testing.T) {
+	if v := variacaoElo(1000, 1000, 1); v != FatorK/2 {
+		t.Fatalf("vitória entre iguais devia dar %d, deu %d", FatorK/2, v)
+	}
+	if v := variacaoElo(1000, 1000, 0.5); v != 0 {
+		t.Fatalf("empate entre iguais n devia mexer, deu %d", v)
+	}
+	// o favorito ganha pouco e o azarão ganha muito
+	if favorito, azarao := variacaoElo(1400, 1000, 1), -variacaoElo(1400, 1000, 0); favorito >= azarao {
+		t.Fatalf("favorito ganhou %d e azarão %d", favorito, azarao)
+	}
+}
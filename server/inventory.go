@@ -0,0 +1,405 @@
+package main
+
+import (
+	"PlanoZ/catalogo"
+	"PlanoZ/combate"
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Inventário Autoritativo (Redis) ---
+
+// O inventário de cada jogador fica num hash do Redis: campo = id da carta, valor = carta (JSON).
+// Além disso, o hash ChaveDonoCartas guarda id da carta -> dono, pra saber de quem é qualquer carta.
+// Todas as chaves usam a hash tag "{inventario}" pra cair no mesmo slot do cluster,
+// assim os scripts Lua conseguem mexer em dois inventários de uma vez (troca).
+const (
+	PrefixoInventario    = "{inventario}:jogador:"
+	ChaveDonoCartas      = "{inventario}:dono"
+	ChaveEstoquesPacotes = "{inventario}:estoques" // estoque de cada tipo de booster (id do pacote -> restantes)
+	PrefixoPity          = "{inventario}:pity:"    // contadores de pity de cada jogador/pacote (raridade -> pacotes sem ela)
+	PrefixoCompra        = "{inventario}:compra:"  // + id da transação -> hash cartas/restante/saldo da compra (pra responder o retry)
+	TTLCompra            = 24 * time.Hour          // quanto tempo o resultado de uma compra fica guardado pro retry
+	PacotePadrao         = "basico"                // tipo de pacote qnd o cliente não fala qual quer
+	TentativasVenda      = 3                       // tentativas de venda se o catálogo ou o pity mudar no meio
+)
+
+// errEstoqueEsgotado indica que não há mais pacotes para vender
+var errEstoqueEsgotado = errors.New("estoque de pacotes esgotado")
+
+// errPacoteInexistente indica que o tipo de pacote não existe no catálogo
+var errPacoteInexistente = errors.New("tipo de pacote inexistente")
+
+// errPacoteIndisponivel indica que o pacote existe mas ta fora da temporada
+var errPacoteIndisponivel = errors.New("pacote fora da temporada")
+
+// errCatalogoDesatualizado indica que o sorteio usou um catálogo diferente do ativo no Redis
+var errCatalogoDesatualizado = errors.New("catálogo de cartas desatualizado")
+
+// errPityMudou indica que outra compra mexeu no pity do jogador entre a leitura e a venda
+var errPityMudou = errors.New("pity do jogador mudou durante a compra")
+
+// errLiderObsoleto indica que o Redis recusou a operação porque já existe um termo de liderança maior
+var errLiderObsoleto = errors.New("termo de liderança obsoleto")
+
+// chaveInventario monta a chave do hash de inventário de um jogador
+func chaveInventario(playerID string) string {
+	return PrefixoInventario + playerID
+}
+
+// chaveCompra monta a chave do resultado guardado de uma compra
+func chaveCompra(tx string) string {
+	return PrefixoCompra + tx
+}
+
+// chavePity monta a chave do hash de pity de um jogador num tipo de pacote
+func chavePity(playerID, idPacote string) string {
+	return PrefixoPity + playerID + ":" + idPacote
+}
+
+// scriptVenderPacote cobra o preço, baixa o estoque e entrega as cartas na mesma operação,
+// então uma venda nunca é contada sem as cartas nem sem o débito (nem o contrário).
+// O termo do líder funciona como fencing token: se já existe um termo maior, a venda é recusada.
+// A versão do catálogo também é conferida, pra nunca entregar carta de um catálogo q ja saiu.
+// O pity usado no sorteio tb: se outra compra do msm jogador mexeu nele no meio, a venda é recusada
+// (senão as duas gastavam a msm garantia, ou uma apagava o contador da outra).
+// O débito usa o id da transação (carteira.go), então a mesma compra repetida não cobra duas vezes:
+// o resultado (cartas, estoque e saldo) fica guardado com o id e a repetição recebe ele de novo.
+// KEYS[1] = estoques (hash), KEYS[2] = inventário do jogador, KEYS[3] = hash de donos, KEYS[4] = termo atual,
+// KEYS[5] = versão do catálogo ativo, KEYS[6] = pity do jogador nesse pacote,
+// KEYS[7] = saldo, KEYS[8] = extrato, KEYS[9] = transações, KEYS[10] = resultado da compra
+// ARGV[1] = id do jogador, ARGV[2] = termo do líder, ARGV[3] = versão do catálogo usado no sorteio,
+// ARGV[4] = id do pacote, ARGV[5] = preço, ARGV[6] = id da transação, ARGV[7] = quando (unix),
+// ARGV[8] = cartas sorteadas (JSON), ARGV[9] = TTL do resultado (ms),
+// ARGV[10] = N (quantos contadores de pity novos), ARGV[11] = M (quantos contadores de pity foram lidos),
+// ARGV[12..11+2N] = pares (raridade, contador novo), depois 2M = pares (raridade, contador lido),
+// o resto = pares (id da carta, carta em JSON)
+// Retorna {estoque restante, saldo}, ou no primeiro campo: -1 se estiver esgotado, -2 se o termo
+// do líder for velho, -3 se o catálogo for outro, -4 se a transação já foi feita, -5 se faltar saldo
+// ou -6 se o pity mudou depois de lido. No -4 vem {-4, saldo, restante, cartas (JSON)} daquela compra,
+// ou só {-4, saldo} se o resultado ja venceu.
+var scriptVenderPacote = redis.NewScript(luaLancar + `
+if tonumber(redis.call('GET', KEYS[4]) or '0') > tonumber(ARGV[2]) then return {-2, 0} end
+if tonumber(redis.call('GET', KEYS[5]) or '0') ~= tonumber(ARGV[3]) then return {-3, 0} end
+local saldo = tonumber(redis.call('GET', KEYS[7]) or '0')
+if redis.call('HEXISTS', KEYS[9], ARGV[6]) == 1 then
+	local compra = redis.call('HMGET', KEYS[10], 'saldo', 'restante', 'cartas')
+	if compra[3] then return {-4, tonumber(compra[1]), tonumber(compra[2]), compra[3]} end
+	return {-4, saldo}
+end
+local fimNovo = 11 + 2 * tonumber(ARGV[10])
+local fimLido = fimNovo + 2 * tonumber(ARGV[11])
+if redis.call('HLEN', KEYS[6]) ~= tonumber(ARGV[11]) then return {-6, saldo} end
+for i = fimNovo + 1, fimLido, 2 do
+	if tonumber(redis.call('HGET', KEYS[6], ARGV[i]) or '-1') ~= tonumber(ARGV[i + 1]) then return {-6, saldo} end
+end
+local estoque = tonumber(redis.call('HGET', KEYS[1], ARGV[4]) or '0')
+if estoque <= 0 then return {-1, saldo} end
+local preco = tonumber(ARGV[5])
+if saldo < preco then return {-5, saldo} end
+local restante = redis.call('HINCRBY', KEYS[1], ARGV[4], -1)
+saldo = lancar(KEYS[7], KEYS[8], KEYS[9], ARGV[6], ARGV[1], -preco, 'Compra do pacote ' .. ARGV[4], tonumber(ARGV[7]))
+for i = 12, fimNovo, 2 do
+	redis.call('HSET', KEYS[6], ARGV[i], ARGV[i + 1])
+end
+for i = fimLido + 1, #ARGV, 2 do
+	redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 1])
+	redis.call('HSET', KEYS[3], ARGV[i], ARGV[1])
+end
+redis.call('HSET', KEYS[10], 'saldo', saldo, 'restante', restante, 'cartas', ARGV[8])
+redis.call('PEXPIRE', KEYS[10], ARGV[9])
+return {restante, saldo}
+`)
+
+// inicializarEstoques cria o contador de cada tipo de pacote do catálogo no Redis, se ainda não existir.
+// Se já existir (outro servidor criou, ou é um restart), mantém o valor atual.
+func (s *Server) inicializarEstoques(cat *catalogo.Catalogo) error {
+	for _, p := range cat.Pacotes {
+		if err := s.redisClient.HSetNX(s.ctx, ChaveEstoquesPacotes, p.Id, p.Estoque).Err(); err != nil {
+			return err
+		}
+	}
+	_, err := s.lerEstoques()
+	return err
+}
+
+// lerEstoques lê o estoque de todos os pacotes do Redis, atualiza o cache local e devolve uma cópia
+func (s *Server) lerEstoques() (map[string]int, error) {
+	valores, err := s.redisClient.HGetAll(s.ctx, ChaveEstoquesPacotes).Result()
+	if err != nil {
+		return nil, err
+	}
+	estoques := make(map[string]int, len(valores))
+	for id, v := range valores {
+		estoques[id], _ = strconv.Atoi(v)
+	}
+	s.atualizarCacheEstoques(estoques)
+	return estoques, nil
+}
+
+// atualizarCacheEstoques troca o cache local dos estoques (é só informativo, quem manda é o Redis)
+func (s *Server) atualizarCacheEstoques(estoques map[string]int) {
+	copia := make(map[string]int, len(estoques))
+	for id, n := range estoques {
+		copia[id] = n
+	}
+	s.muInventory.Lock()
+	s.estoques = copia
+	s.muInventory.Unlock()
+}
+
+// estoqueCache devolve o estoque de um pacote segundo o cache local
+func (s *Server) estoqueCache(idPacote string) int {
+	s.muInventory.RLock()
+	defer s.muInventory.RUnlock()
+	return s.estoques[idPacote]
+}
+
+// venderPacote cobra, sorteia as cartas de um tipo de pacote e faz a venda atômica no Redis.
+// 'tx' é o id da transação da compra (o mesmo tx nunca cobra duas vezes).
+// Devolve as cartas entregues, quantos pacotes desse tipo sobraram e o saldo do jogador.
+func (s *Server) venderPacote(playerID, idPacote, tx string) ([]models.Tanque, int, int, error) {
+	for tentativa := 1; ; tentativa++ {
+		cartas, restante, saldo, err := s.tentarVenderPacote(playerID, idPacote, tx)
+		if tentativa == TentativasVenda {
+			return cartas, restante, saldo, err
+		}
+		switch err {
+		case errCatalogoDesatualizado:
+			// outro server publicou um catálogo novo: relê do redis e sorteia de novo
+			color.Yellow("LÍDER: Catálogo desatualizado na venda, sincronizando...")
+			if err := s.sincronizarCatalogo(); err != nil {
+				return nil, 0, 0, err
+			}
+		case errPityMudou:
+			// outra compra do jogador andou o pity no meio: relê e sorteia de novo
+			color.Yellow("LÍDER: Pity de %s mudou durante a venda, sorteando de novo...", playerID)
+		default:
+			return cartas, restante, saldo, err
+		}
+	}
+}
+
+// tentarVenderPacote faz uma tentativa de venda com o catálogo em uso agora
+func (s *Server) tentarVenderPacote(playerID, idPacote, tx string) ([]models.Tanque, int, int, error) {
+	cat := s.catalogoAtual()
+	pacote, ok := cat.Pacote(idPacote)
+	if !ok {
+		return nil, 0, 0, errPacoteInexistente
+	}
+	if !pacote.Disponivel(time.Now()) {
+		return nil, 0, 0, errPacoteIndisponivel
+	}
+
+	pity, err := s.lerPity(playerID, idPacote)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	cartas, novoPity := s.abrirPacote(pacote, playerID, pity)
+
+	compra, err := json.Marshal(cartas)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	args := []interface{}{playerID, s.termoAtual(), cat.Versao, idPacote, pacote.Preco, tx, time.Now().Unix(),
+		compra, TTLCompra.Milliseconds(), len(novoPity), len(pity)}
+	for raridade, contador := range novoPity {
+		args = append(args, raridade, contador)
+	}
+	for raridade, contador := range pity {
+		args = append(args, raridade, contador)
+	}
+	for _, c := range cartas {
+		dados, err := json.Marshal(c)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		args = append(args, c.Id, dados)
+	}
+
+	res, err := scriptVenderPacote.Run(s.ctx, s.redisClient,
+		[]string{ChaveEstoquesPacotes, chaveInventario(playerID), ChaveDonoCartas, ChaveTermoLider, ChaveVersaoCatalogo,
+			chavePity(playerID, idPacote), chaveCarteira(playerID), chaveExtrato(playerID), ChaveTransacoes, chaveCompra(tx)},
+		args...).Slice()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("falha no script de venda: %v", err)
+	}
+	restante, saldo := int(res[0].(int64)), int(res[1].(int64))
+	switch restante {
+	case -1:
+		return nil, 0, saldo, errEstoqueEsgotado
+	case -2:
+		return nil, 0, saldo, errLiderObsoleto
+	case -3:
+		return nil, 0, saldo, errCatalogoDesatualizado
+	case -4:
+		if len(res) < 4 {
+			return nil, 0, saldo, errTransacaoRepetida
+		}
+		// retry de uma compra q ja passou (a resposta se perdeu): manda de novo o q foi entregue
+		var entregues []models.Tanque
+		if err := json.Unmarshal([]byte(res[3].(string)), &entregues); err != nil {
+			return nil, 0, saldo, fmt.Errorf("resultado da compra %s corrompido: %v", tx, err)
+		}
+		color.Yellow("LÍDER: Compra %s repetida, reenviando as cartas entregues", tx)
+		return entregues, int(res[2].(int64)), saldo, nil
+	case -5:
+		return nil, 0, saldo, errSaldoInsuficiente
+	case -6:
+		return nil, 0, saldo, errPityMudou
+	}
+
+	return cartas, restante, saldo, nil
+}
+
+// lerPity lê os contadores de pity do jogador num tipo de pacote (quem nunca abriu n tem nada)
+func (s *Server) lerPity(playerID, idPacote string) (map[string]int, error) {
+	valores, err := s.redisClient.HGetAll(s.ctx, chavePity(playerID, idPacote)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler pity de %s: %v", playerID, err)
+	}
+	pity := make(map[string]int, len(valores))
+	for raridade, v := range valores {
+		pity[raridade], _ = strconv.Atoi(v)
+	}
+	return pity, nil
+}
+
+// abrirPacote sorteia as cartas de um pacote (pool, composição, garantia e pity do catalogo/pacote.go)
+// e já devolve as cartas com id e dono, junto com o pity novo do jogador
+func (s *Server) abrirPacote(pacote *catalogo.Pacote, playerID string, pity map[string]int) ([]models.Tanque, map[string]int) {
+	s.muSorteio.Lock()
+	abertura := pacote.Abrir(s.sorteio, pity)
+	s.muSorteio.Unlock()
+
+	cartas := make([]models.Tanque, 0, len(abertura.Cartas))
+	for _, carta := range abertura.Cartas {
+		cartas = append(cartas, carta.Tanque(uuid.NewString(), playerID))
+	}
+	return cartas, abertura.Pity
+}
+
+// novoSorteio cria o gerador dos boosters. Com BOOSTER_SEED a sequência de pacotes é
+// sempre a mesma (pra conferir as taxas de drop); sem ela usa o relógio.
+func novoSorteio() (*rand.Rand, error) {
+	seed := time.Now().UnixNano()
+	if v := getEnv("BOOSTER_SEED", ""); v != "" {
+		var err error
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("BOOSTER_SEED inválido: %v", err)
+		}
+		color.Yellow("Sorteio de boosters com seed fixa: %d", seed)
+	}
+	return rand.New(rand.NewSource(seed)), nil
+}
+
+// listarPacotes monta a vitrine de pacotes (catálogo + estoque do cache) pro cliente
+func (s *Server) listarPacotes() []models.InfoPacote {
+	agora := time.Now()
+	cat := s.catalogoAtual()
+	pacotes := make([]models.InfoPacote, 0, len(cat.Pacotes))
+	for _, p := range cat.Pacotes {
+		pacotes = append(pacotes, models.InfoPacote{
+			Id:         p.Id,
+			Nome:       p.Nome,
+			Preco:      p.Preco,
+			Estoque:    s.estoqueCache(p.Id),
+			Cartas:     p.Cartas,
+			Garantia:   p.Garantia,
+			Disponivel: p.Disponivel(agora),
+		})
+	}
+	return pacotes
+}
+
+// buscarCartaJogador lê uma carta específica do inventário do jogador
+func (s *Server) buscarCartaJogador(playerID, idCarta string) (models.Tanque, bool, error) {
+	dados, err := s.redisClient.HGet(s.ctx, chaveInventario(playerID), idCarta).Result()
+	if err == redis.Nil {
+		return models.Tanque{}, false, nil
+	}
+	if err != nil {
+		return models.Tanque{}, false, err
+	}
+	var carta models.Tanque
+	if err := json.Unmarshal([]byte(dados), &carta); err != nil {
+		return models.Tanque{}, false, err
+	}
+	return carta, true, nil
+}
+
+// listarInventario devolve todas as cartas do jogador
+func (s *Server) listarInventario(playerID string) ([]models.Tanque, error) {
+	inventario, err := s.redisClient.HGetAll(s.ctx, chaveInventario(playerID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cartas := make([]models.Tanque, 0, len(inventario))
+	for id, dados := range inventario {
+		var carta models.Tanque
+		if err := json.Unmarshal([]byte(dados), &carta); err != nil {
+			color.Red("INVENTÁRIO: Carta %s corrompida no inventário de %s: %v", id, playerID, err)
+			continue
+		}
+		cartas = append(cartas, carta)
+	}
+
+	// ordena pra lista sair sempre igual pro cliente
+	sort.Slice(cartas, func(i, j int) bool {
+		if cartas[i].Modelo != cartas[j].Modelo {
+			return cartas[i].Modelo < cartas[j].Modelo
+		}
+		return cartas[i].Id < cartas[j].Id
+	})
+	return cartas, nil
+}
+
+// sortearDeckBatalha escolhe as cartas do jogador pra uma partida (ate combate.TamanhoDeck),
+// direto do inventario do redis, entao o cliente nao tem como inventar carta
+func (s *Server) sortearDeckBatalha(playerID string) ([]models.Tanque, error) {
+	cartas, err := s.listarInventario(playerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(cartas) == 0 {
+		return nil, fmt.Errorf("jogador %s não tem cartas", playerID)
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	r.Shuffle(len(cartas), func(i, j int) { cartas[i], cartas[j] = cartas[j], cartas[i] })
+	if len(cartas) > combate.TamanhoDeck {
+		cartas = cartas[:combate.TamanhoDeck]
+	}
+	return cartas, nil
+}
+
+// validarCartaJogador confere, pelo id, se a carta é do jogador e devolve a versão salva no servidor.
+// 'usadas' são os ids que o jogador já gastou no contexto atual (ex: na batalha).
+func (s *Server) validarCartaJogador(playerID string, carta models.Tanque, usadas map[string]bool) (models.Tanque, error) {
+	if carta.Id == "" {
+		return models.Tanque{}, fmt.Errorf("carta '%s' sem id", carta.Modelo)
+	}
+	if usadas[carta.Id] {
+		return models.Tanque{}, fmt.Errorf("carta %s já foi usada", carta.Id)
+	}
+
+	oficial, ok, err := s.buscarCartaJogador(playerID, carta.Id)
+	if err != nil {
+		return models.Tanque{}, fmt.Errorf("falha ao ler inventário: %v", err)
+	}
+	if !ok {
+		return models.Tanque{}, fmt.Errorf("jogador não possui a carta %s", carta.Id)
+	}
+	return oficial, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestNovoSorteioComSeed(t *testing.T) {
+	t.Setenv("BOOSTER_SEED", "1234")
+	r1, err := novoSorteio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := novoSorteio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if a, b := r1.Int63(), r2.Int63(); a != b {
+			t.Fatalf("com BOOSTER_SEED a sequência devia ser a msm (%d != %d)", a, b)
+		}
+	}
+
+	t.Setenv("BOOSTER_SEED", "abc")
+	if _, err := novoSorteio(); err == nil {
+		t.Fatal("BOOSTER_SEED inválido devia dar erro")
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"fmt"
+	"PlanoZ/eleicao"
+	"PlanoZ/models"
+	"encoding/json"
 	"net/http"
-	"sort"
 	"sync"
 	"time"
 
@@ -12,12 +13,24 @@ import (
 
 //  Eleição de Líder e Health Check
 
+// ChaveTermoLider é o contador global de termos (epoch) da liderança.
+// Cada líder eleito pega um termo novo com INCR, então dois líderes nunca têm o mesmo termo.
+// Fica no mesmo slot do estoque ("{inventario}") pro script de venda usar como fencing token.
+const ChaveTermoLider = "{inventario}:termo_lider"
+
+// ChaveLeaseLider guarda "id|termo" do líder no modo lease (com TTL)
+const ChaveLeaseLider = "{inventario}:lease_lider"
+
 // RunHealthChecks periodicamente verifica a saúde de outros servidores
 func (s *Server) RunHealthChecks() {
 	ticker := time.NewTicker(HealthCheckInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		if s.foraDoCluster() {
+			return
+		}
+
 		s.muLeader.RLock()
 		leader := s.currentLeader
 		s.muLeader.RUnlock()
@@ -26,14 +39,27 @@ func (s *Server) RunHealthChecks() {
 		liveNow := make(map[string]bool)
 
 		var wg sync.WaitGroup
-		for id, host := range s.serverList {
+		for id, host := range s.membros() {
 			wg.Add(1)
 			go func(id, host string) {
 				defer wg.Done()
-				if s.checkServerHealth(host) {
+				if saude, ok := s.checkServerHealth(host); ok {
 					s.muLiveServers.Lock() // Protege a escrita no mapa
 					liveNow[id] = true
 					s.muLiveServers.Unlock()
+
+					// Se alguém se diz líder com um termo mais novo, segue ele
+					// (cobre o caso do anúncio do coordenador ter se perdido)
+					if saude.IsLeader && saude.ServerID != s.ID {
+						s.aceitarLider(saude.ServerID, saude.Termo)
+					}
+
+					// Alguém ta com catálogo mais novo (perdi o /catalogo/update): relê do redis
+					if saude.Catalogo > s.versaoCatalogo() {
+						if err := s.sincronizarCatalogo(); err != nil {
+							color.Red("[Catálogo]: %v", err)
+						}
+					}
 				}
 			}(id, host)
 		}
@@ -60,31 +86,70 @@ func (s *Server) RunHealthChecks() {
 		}
 
 		// Lógica de reeleição (agora usa o mapa 'liveNow' atualizado)
+		// No modo lease quem detecta a queda é o RunEleicao (a chave expira)
 		leaderIsAlive := liveNow[leader]
-		if !leaderIsAlive && leader != "" {
-			color.Red("Líder %s está OFFLINE. Iniciando nova eleição.", leader)
+		if !leaderIsAlive && s.eleitor.UsaHealthCheck() {
+			if leader != "" {
+				color.Red("Líder %s está OFFLINE. Iniciando nova eleição.", leader)
+			}
 			s.electNewLeader(liveNow) // Passa o mapa atualizado
 		}
 	}
 }
 
 // checkServerHealth envia um GET /health para outro servidor
-func (s *Server) checkServerHealth(host string) bool {
+func (s *Server) checkServerHealth(host string) (models.HealthCheckResponse, bool) {
 	if host == s.HostAPI { // Saúde própria
-		return true
+		return s.estadoSaude(), true
 	}
 
-	resp, err := s.httpClient.Get(fmt.Sprintf("http://%s/health", host))
+	req, nonce, err := s.novaRequisicao("GET", host, "/health", nil)
 	if err != nil {
-		return false
+		return models.HealthCheckResponse{}, false
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return models.HealthCheckResponse{}, false
 	}
 	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	// quem n assina a resposta n eh do cluster (ou ta com outro CLUSTER_SECRET): conta como fora
+	corpo, err := s.conferirResposta(resp, nonce)
+	if err != nil {
+		color.Red("Health check de %s: %v", host, err)
+		return models.HealthCheckResponse{}, false
+	}
+	if resp.StatusCode != http.StatusOK {
+		return models.HealthCheckResponse{}, false
+	}
+
+	var saude models.HealthCheckResponse
+	if err := json.Unmarshal(corpo, &saude); err != nil {
+		return models.HealthCheckResponse{}, true // ta vivo, so n entendi a resposta
+	}
+	return saude, true
 }
 
-// electNewLeader elege o novo líder com base no ID alfabético (menor porta)
+// estadoSaude monta a resposta do /health deste servidor
+func (s *Server) estadoSaude() models.HealthCheckResponse {
+	s.muLeader.RLock()
+	defer s.muLeader.RUnlock()
+	return models.HealthCheckResponse{
+		Status:   "OK",
+		ServerID: s.ID,
+		IsLeader: s.currentLeader == s.ID,
+		Termo:    s.currentTerm,
+		Catalogo: s.versaoCatalogo(),
+	}
+}
+
+// electNewLeader roda uma eleição com o eleitor configurado (ELECTION_MODE).
+// No modo healthcheck o menor ID vivo vence; no modo lease vence quem pegar a chave no Redis.
+// Só o próprio eleito pega um termo novo e se anuncia; os outros esperam o anúncio
+// (ou descobrem o termo pelo /health) pra aceitar mensagens dele.
 func (s *Server) electNewLeader(liveNow map[string]bool) {
-	liveIDs := []string{}
+	if s.isLeader() || s.foraDoCluster() {
+		return
+	}
 
 	// Se for a eleição inicial (liveNow == nil), faz health check de todos
 	if liveNow == nil {
@@ -94,11 +159,11 @@ func (s *Server) electNewLeader(liveNow map[string]bool) {
 		var wg sync.WaitGroup
 		var mu sync.Mutex
 
-		for id, host := range s.serverList {
+		for id, host := range s.membros() {
 			wg.Add(1)
 			go func(id, host string) {
 				defer wg.Done()
-				if s.checkServerHealth(host) {
+				if _, ok := s.checkServerHealth(host); ok {
 					mu.Lock()
 					liveNow[id] = true
 					mu.Unlock()
@@ -116,46 +181,133 @@ func (s *Server) electNewLeader(liveNow map[string]bool) {
 		s.muLiveServers.Unlock()
 	}
 
-	// Coleta os IDs dos servidores vivos
-	for id := range liveNow {
-		liveIDs = append(liveIDs, id)
+	res, err := s.eleitor.Eleger(s.ctx, s.ID, liveNow)
+	if err != nil {
+		// sem termo não dá pra vender nada com segurança, tenta de novo no próximo ciclo
+		color.Red("Falha na eleição (%s): %v", s.eleitor.Nome(), err)
+		return
 	}
+	color.Cyan("[DEBUG] Eleito líder: %s (termo %d, modo %s)", res.Lider, res.Termo, s.eleitor.Nome())
+	s.aplicarEleicao(res)
+}
 
-	// DEBUG: Imprima quem está vivo
-	color.Yellow("[DEBUG] Servidores vivos detectados: %v", liveIDs)
+// RunEleicao chama o Manter do eleitor periodicamente.
+// No modo lease é aqui que o líder renova a chave e os outros percebem se ela expirou.
+func (s *Server) RunEleicao() {
+	intervalo := s.eleitor.IntervaloManter()
+	if intervalo <= 0 {
+		return // nada pra manter, o RunHealthChecks cuida
+	}
+
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
 
-	// Adiciona a si mesmo se não estiver na lista
-	isMeInList := false
-	for _, id := range liveIDs {
-		if id == s.ID {
-			isMeInList = true
-			break
+	for range ticker.C {
+		if s.foraDoCluster() {
+			return
 		}
+		res, mudou, err := s.eleitor.Manter(s.ctx, s.ID, s.isLeader())
+		if err != nil {
+			color.Red("Falha ao manter liderança (%s): %v", s.eleitor.Nome(), err)
+			continue
+		}
+		if mudou {
+			s.aplicarEleicao(res)
+		}
+	}
+}
+
+// aplicarEleicao atualiza o estado local com o resultado do eleitor
+func (s *Server) aplicarEleicao(res eleicao.Resultado) {
+	if res.Lider == "" {
+		return
+	}
+
+	if res.Lider == s.ID && res.Termo > 0 {
+		s.assumirLideranca(res.Termo)
+		return
 	}
 
-	if !isMeInList {
-		liveIDs = append(liveIDs, s.ID)
-		color.Yellow("[DEBUG] Adicionei a mim mesmo: %s", s.ID)
+	if res.Termo > 0 {
+		s.aceitarLider(res.Lider, res.Termo)
+		return
 	}
 
-	if len(liveIDs) == 0 {
-		color.Red("Nenhum servidor vivo detectado. Assumindo liderança.")
-		liveIDs = append(liveIDs, s.ID)
+	// Não sou eu: aponto pro candidato, mas o termo só muda quando ele se anunciar
+	s.muLeader.Lock()
+	if s.currentLeader != res.Lider {
+		s.currentLeader = res.Lider
+		color.Yellow("Aguardando anúncio do líder %s (termo atual: %d)", res.Lider, s.currentTerm)
+	}
+	s.muLeader.Unlock()
+}
+
+// assumirLideranca registra este servidor como líder no termo recebido do eleitor
+// e se anuncia para os outros servidores
+func (s *Server) assumirLideranca(termo int64) {
+	s.muLeader.Lock()
+	if s.currentLeader == s.ID && s.currentTerm == termo {
+		s.muLeader.Unlock()
+		return
 	}
+	s.currentLeader = s.ID
+	s.currentTerm = termo
+	s.muLeader.Unlock()
 
-	sort.Strings(liveIDs)
-	newLeaderID := liveIDs[0]
+	color.Green("\n========================================")
+	color.Green("🎖️  NOVO LÍDER ELEITO: %s (termo %d)", s.ID, termo)
+	color.Green("========================================\n")
 
-	color.Cyan("[DEBUG] Eleito líder: %s (entre %v)", newLeaderID, liveIDs)
+	s.broadcastToServers("/election/coordinator", models.LeaderAnnounceRequest{IdLider: s.ID, Termo: termo})
+}
 
+// aceitarLider registra um líder anunciado (ou visto no /health), se o termo dele não for velho.
+// Retorna false se a mensagem veio de um líder obsoleto.
+func (s *Server) aceitarLider(liderID string, termo int64) bool {
 	s.muLeader.Lock()
-	if s.currentLeader != newLeaderID {
-		s.currentLeader = newLeaderID
-		color.Green("\n========================================")
-		color.Green("🎖️  NOVO LÍDER ELEITO: %s", s.currentLeader)
-		color.Green("========================================\n")
+	defer s.muLeader.Unlock()
+
+	if termo < s.currentTerm {
+		return false
+	}
+	if termo == s.currentTerm && s.currentLeader == liderID {
+		return true
 	}
+	if liderID == "" {
+		// msg sem id do líder: só dá pra comparar o termo
+		return true
+	}
+
+	if s.currentLeader == s.ID && liderID != s.ID {
+		color.Red("Líder %s assumiu com termo %d. Deixando a liderança.", liderID, termo)
+	}
+	s.currentLeader = liderID
+	s.currentTerm = termo
+	color.Green("🎖️  Líder reconhecido: %s (termo %d)", liderID, termo)
+	return true
+}
+
+// deixarLideranca é chamada quando o líder descobre que o termo dele é velho
+// (fencing do Redis ou mensagem de alguém que já viu um termo maior).
+func (s *Server) deixarLideranca(motivo string) {
+	s.muLeader.Lock()
+	if s.currentLeader != s.ID {
+		s.muLeader.Unlock()
+		return
+	}
+	s.currentLeader = ""
 	s.muLeader.Unlock()
+
+	color.Red("Deixando a liderança: %s", motivo)
+
+	// reeleição com o estado atual (se eu ainda for o menor ID, pego um termo novo)
+	s.muLiveServers.RLock()
+	vivos := make(map[string]bool)
+	for id, vivo := range s.liveServers {
+		vivos[id] = vivo
+	}
+	s.muLiveServers.RUnlock()
+	go s.electNewLeader(vivos)
 }
 
 // isLeader verifica se este servidor é o líder
@@ -164,3 +316,10 @@ func (s *Server) isLeader() bool {
 	defer s.muLeader.RUnlock()
 	return s.currentLeader == s.ID
 }
+
+// termoAtual retorna o termo de liderança que este servidor conhece
+func (s *Server) termoAtual() int64 {
+	s.muLeader.RLock()
+	defer s.muLeader.RUnlock()
+	return s.currentTerm
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestAceitarLiderRejeitaTermoVelho(t *testing.T) {
+	s := &Server{ID: "server2"}
+
+	if !s.aceitarLider("server1", 5) {
+		t.Fatal("termo 5 devia ser aceito num servidor q n conhece nenhum")
+	}
+	if s.currentLeader != "server1" || s.currentTerm != 5 {
+		t.Fatalf("devia seguir server1 no termo 5, ta em %s/%d", s.currentLeader, s.currentTerm)
+	}
+
+	// anúncio atrasado de um líder antigo: n pode voltar o termo
+	if s.aceitarLider("server3", 4) {
+		t.Fatal("termo 4 eh velho e devia ser rejeitado")
+	}
+	if s.currentLeader != "server1" || s.currentTerm != 5 {
+		t.Fatalf("termo velho mudou o estado pra %s/%d", s.currentLeader, s.currentTerm)
+	}
+
+	// o msm anúncio repetido continua valendo
+	if !s.aceitarLider("server1", 5) {
+		t.Fatal("reanúncio do líder atual devia ser aceito")
+	}
+
+	// termo maior troca o líder
+	if !s.aceitarLider("server3", 6) {
+		t.Fatal("termo 6 devia ser aceito")
+	}
+	if s.currentLeader != "server3" || s.currentTerm != 6 {
+		t.Fatalf("devia seguir server3 no termo 6, ta em %s/%d", s.currentLeader, s.currentTerm)
+	}
+}
+
+func TestAceitarLiderDerrubaLiderVelho(t *testing.T) {
+	s := &Server{ID: "server1", currentLeader: "server1", currentTerm: 3}
+
+	// outro servidor se elegeu com termo maior enquanto eu tava isolado
+	if !s.aceitarLider("server2", 4) {
+		t.Fatal("termo 4 devia ser aceito")
+	}
+	if s.isLeader() {
+		t.Fatal("server1 devia deixar de ser líder ao ver o termo 4")
+	}
+	if s.termoAtual() != 4 {
+		t.Fatalf("termo devia ir pra 4, ta em %d", s.termoAtual())
+	}
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"PlanoZ/catalogo"
+	"PlanoZ/eleicao"
 	"PlanoZ/models" // certifique-se q o caminho ta certo
 
 	"github.com/fatih/color"
@@ -24,28 +26,16 @@ const (
 	// topicos globais do redis
 	TopicoConectar     = "conectar"
 	TopicoComprarCarta = "comprar_carta"
+	TopicoMercado      = "mercado"
+	TopicoFila         = "fila_batalha"
 
 	// configs do health check
 	HealthCheckInterval = 5 * time.Second
 	RequestTimeout      = 2 * time.Second
-)
 
-// pacote de cartas inicial
-var pacote_1 = []models.Tanque{
-	{"M22 (Light)", "server", 50, 10}, {"M22 (Light)", "server", 50, 10}, {"M22 (Light)", "server", 50, 10},
-	{"FIAT6614 (Light)", "server", 55, 12}, {"FIAT6614 (Light)", "server", 55, 12}, {"FIAT6614 (Light)", "server", 55, 12},
-	{"BMP (Light)", "server", 60, 15}, {"BMP (Light)", "server", 60, 15}, {"BMP (Light)", "server", 60, 15},
-	{"Fox (Light)", "server", 52, 11}, {"Fox (Light)", "server", 52, 11}, {"Fox (Light)", "server", 52, 11},
-	{"AMX13 (Light)", "server", 58, 14}, {"AMX13 (Light)", "server", 58, 14}, {"AMX13 (Light)", "server", 58, 14},
-	{"Sherman (Medium)", "server", 100, 28}, {"Sherman (Medium)", "server", 100, 28},
-	{"T-34 (Medium)", "server", 110, 27}, {"T-34 (Medium)", "server", 110, 27},
-	{"Panther (Medium)", "server", 120, 25}, {"Panther (Medium)", "server", 120, 25},
-	{"M47 (Medium)", "server", 115, 30}, {"M47 (Medium)", "server", 115, 30},
-	{"Tiger II (Heavy)", "server", 200, 53}, {"IS-6 (Heavy)", "server", 220, 55},
-	{"M26 Pershing (Heavy)", "server", 210, 52}, {"T-10M (Heavy)", "server", 230, 58},
-	{"KV-2 (Heavy)", "server", 250, 50}, {"Maus (Heavy)", "server", 280, 57},
-	{"M26E5 (Heavy)", "server", 240, 54},
-}
+	// ttl padrao do lease de lider (so no modo ELECTION_MODE=lease)
+	LeaseTTLPadrao = 6 * time.Second
+)
 
 // structs do servidor
 
@@ -80,19 +70,39 @@ type Server struct {
 	ginEngine   *gin.Engine
 	ctx         context.Context
 
+	// autenticacao da api entre servidores (ver autenticacao.go)
+	segredoCluster []byte // CLUSTER_SECRET
+	muNonces       sync.Mutex
+	noncesCluster  map[string]time.Time // nonces q ja chegaram (dentro da janela)
+
+	// contas e sessões (ver contas.go): saem do CLUSTER_SECRET
+	chaves ChavesCluster
+
 	// estado global (sincronizado pelo lider)
-	muPlayers     sync.RWMutex
-	playerList    map[string]PlayerInfo // map[playerID] -> PlayerInfo
-	muInventory   sync.RWMutex
-	pacoteCounter int
+	muPlayers   sync.RWMutex
+	playerList  map[string]PlayerInfo // map[playerID] -> PlayerInfo
+	muInventory sync.RWMutex
+	estoques    map[string]int // cache do estoque de cada tipo de pacote (o valor de vdd fica no redis, ver inventory.go)
+	muSorteio   sync.Mutex
+	sorteio     *rand.Rand // gerador dos boosters (BOOSTER_SEED deixa ele deterministico)
 
 	// estado de lideranca
 	muLeader      sync.RWMutex
-	currentLeader string            // ex: "server1"
-	serverList    map[string]string // map[serverID] -> host:porta
-	liveServers   map[string]bool   // map[serverID] -> ta vivo?
+	currentLeader string          // ex: "server1"
+	currentTerm   int64           // termo (epoch) do lider atual, vem do contador no redis
+	eleitor       eleicao.Eleitor // algoritmo de eleicao (healthcheck ou lease, ver leadership.go)
+	liveServers   map[string]bool // map[serverID] -> ta vivo?
 	muLiveServers sync.RWMutex
 
+	// membros do cluster (ver membership.go)
+	muMembros  sync.RWMutex
+	serverList map[string]string // map[serverID] -> host:porta (começa com o SERVER_LIST e muda em runtime)
+	saindo     bool              // true depois do leave, pra nao voltar a se registrar
+
+	drenando atomic.Bool // true qnd recebeu SIGTERM: nao aceita conexao/batalha/troca nova
+
+	catalogo atomic.Pointer[catalogo.Catalogo] // catalogo de cartas em uso (o ativo fica no redis, ver catalogo.go)
+
 	// estado local (coisas q so esse server precisa saber)
 	muBatalhas     sync.RWMutex
 	batalhas       map[string]*models.Batalha // batalhas q *eu* hospedo (eu sou o s1)
@@ -116,25 +126,23 @@ func main() {
 	redisAddrs := getEnv("REDIS_ADDRS", "redis-node-1:6379,redis-node-2:6379,redis-node-3:6379")
 	serverListStr := getEnv("SERVER_LIST", "server1:9090,server2:9091,server3:9092")
 
+	segredoCluster, err := lerSegredoCluster() // (do autenticacao.go)
+	if err != nil {
+		panic(err.Error())
+	}
+	chaves, err := chavesDoCluster(segredoCluster) // (do contas.go)
+	if err != nil {
+		panic(err.Error())
+	}
+
 	// conecta no cluster redis
 	rdb := redis.NewClusterClient(&redis.ClusterOptions{
 		Addrs: strings.Split(redisAddrs, ","),
 	})
 	ctx := context.Background()
-	// testa a conexao com o redis
-	if _, err := rdb.Ping(ctx).Result(); err != nil {
-		panic(fmt.Sprintf("Falha ao conectar ao Redis: %v", err))
-	}
-	color.Green("Conectado ao Cluster Redis em %s", redisAddrs)
 
-	// *** pausa critica ***
-	// da um tempo pro 'redis-cluster-init' terminar de criar o cluster
-	// se a gnt tentar ler (blpop) antes disso, da o erro 'clusterdown'
-	initialWait := 15 * time.Second
-	color.Yellow("Aguardando %v para estabilização do cluster Redis...", initialWait)
-	time.Sleep(initialWait)
-
-	// le a lista de todos os servers (do env)
+	// le a lista inicial de servers (do env). eh so o ponto de partida,
+	// quem subir depois se registra no redis (membership.go)
 	serverMap := make(map[string]string)
 	for _, s := range strings.Split(serverListStr, ",") {
 		parts := strings.Split(s, ":") // "server1:9090"
@@ -145,41 +153,90 @@ func main() {
 
 	// cria a struct principal do server
 	s := &Server{
-		ID:            serverID,
-		HostAPI:       fmt.Sprintf("%s:%s", serverID, apiPort), // "server1:9090"
-		HostUDP:       fmt.Sprintf("%s:%s", serverID, udpPort), // "server1:8081" (importante pro cliente)
-		CanalPessoal:  fmt.Sprintf("servidor_pessoal:%s", serverID),
-		redisClient:   rdb,
-		httpClient:    &http.Client{Timeout: RequestTimeout},
-		ctx:           ctx,
-		playerList:    make(map[string]PlayerInfo),
-		serverList:    serverMap,
-		liveServers:   make(map[string]bool),
-		batalhas:      make(map[string]*models.Batalha),
-		batalhasPeer:  make(map[string]peerBattleInfo),
-		trades:        make(map[string]*models.Troca),
-		tradesPeer:    make(map[string]peerTradeInfo),
-		pacoteCounter: 10, // estoque inicial
+		ID:             serverID,
+		HostAPI:        fmt.Sprintf("%s:%s", serverID, apiPort), // "server1:9090"
+		HostUDP:        fmt.Sprintf("%s:%s", serverID, udpPort), // "server1:8081" (importante pro cliente)
+		CanalPessoal:   fmt.Sprintf("servidor_pessoal:%s", serverID),
+		redisClient:    rdb,
+		httpClient:     &http.Client{Timeout: RequestTimeout},
+		ctx:            ctx,
+		segredoCluster: segredoCluster,
+		noncesCluster:  make(map[string]time.Time),
+		chaves:         chaves,
+		playerList:     make(map[string]PlayerInfo),
+		serverList:     serverMap,
+		liveServers:    make(map[string]bool),
+		batalhas:       make(map[string]*models.Batalha),
+		batalhasPeer:   make(map[string]peerBattleInfo),
+		trades:         make(map[string]*models.Troca),
+		tradesPeer:     make(map[string]peerTradeInfo),
 	}
 	s.ginEngine = s.setupRouter() // prepara as rotas da api (do router.go)
 
+	// espera o cluster redis ficar ok de verdade (do startup.go)
+	if err := s.esperarRedisPronto(envDuration("REDIS_READY_TIMEOUT", RedisProntoTimeoutPadrao)); err != nil {
+		panic(err.Error())
+	}
+	color.Green("Conectado ao Cluster Redis em %s", redisAddrs)
+
+	// escolhe o algoritmo de eleicao (padrao: health check + menor id)
+	leaseTTL := envDuration("ELECTION_LEASE_TTL", LeaseTTLPadrao)
+	armazemEleicao := &eleicao.ArmazemRedis{Cliente: rdb, ChaveTermo: ChaveTermoLider, ChaveLease: ChaveLeaseLider}
+	eleitor, err := eleicao.NovoEleitor(getEnv("ELECTION_MODE", eleicao.ModoHealthCheck), armazemEleicao, leaseTTL)
+	if err != nil {
+		panic(err.Error())
+	}
+	s.eleitor = eleitor
+	color.Green("Modo de eleição: %s", s.eleitor.Nome())
+
+	// catalogo de cartas (CATALOGO_PATH ou o padrao embutido), sincronizado com o q ta no redis
+	if err := s.carregarCatalogo(); err != nil {
+		panic(fmt.Sprintf("Falha ao carregar catálogo: %v", err))
+	}
+
+	sorteio, err := novoSorteio() // (do inventory.go)
+	if err != nil {
+		panic(err.Error())
+	}
+	s.sorteio = sorteio
+
+	// o estoque de cada tipo de pacote mora no redis, entao sobrevive a eleicao e a restart
+	// (o carregarCatalogo ja criou os contadores q faltavam, aqui eh so pra mostrar)
+	estoques, err := s.lerEstoques()
+	if err != nil {
+		panic(fmt.Sprintf("Falha ao ler estoques no Redis: %v", err))
+	}
+	color.Green("Estoque de pacotes (Redis): %v", estoques)
+
+	// chave pública q o cliente usa pra selar o login (do contas.go)
+	s.publicarChaveRegistro()
+
+	// entra no cluster (registra no redis e avisa quem ja ta rodando)
+	if err := s.entrarNoCluster(); err != nil {
+		panic(err.Error())
+	}
+
 	// inicia as goroutines principais
 	go s.RunRedisListeners() // goroutine pra ouvir o redis
 	go s.RunAPI(apiPort)     // goroutine pra servir a api http
 	go s.RunUDP(udpPort)     // goroutine pro udp (ping/heartbeat)
 
-	// espera o admin dar enter no terminal
+	// espera o admin dar enter (ou o quorum, no modo HEADLESS)
 	color.Yellow("Servidor %s pronto.", s.ID)
 	color.Yellow("API rodando em :%s, UDP em :%s", apiPort, udpPort)
-	color.Cyan("Pressione ENTER para iniciar a eleição de líder e os health checks...")
-	bufio.NewReader(os.Stdin).ReadString('\n')
+	s.esperarLiberacao() // (do startup.go)
 
 	// agora sim, comeca a eleicao
-	go s.RunHealthChecks() // (do leadership.go)
-	s.electNewLeader(nil)  // (do leadership.go)
+	go s.RunHealthChecks()    // (do leadership.go)
+	s.electNewLeader(nil)     // (do leadership.go)
+	go s.RunEleicao()         // renova o lease (so faz algo no modo lease)
+	go s.RunMembership()      // heartbeat e lista de membros (do membership.go)
+	go s.RunMercado()         // fecha os anúncios vencidos (so faz algo no líder, do mercado.go)
+	go s.RunResultadosTroca() // reenvia os resultados de troca q os clientes daqui n confirmaram (do escrow.go)
+	go s.RunMatchmaking()     // monta as partidas da fila (so faz algo no líder, do matchmaking.go)
 
-	// trava a main thread aqui pra sempre
-	select {}
+	// trava a main thread ate chegar um SIGTERM/SIGINT (do shutdown.go)
+	s.esperarSinalDesligar()
 }
 
 // funcoes de inicializacao (run)
@@ -211,10 +268,12 @@ func (s *Server) RunUDP(port string) {
 	s.lidarPing(udpConn) // (do utils.go)
 }
 
-// inicia as 3 goroutines q ouvem o redis
+// inicia as 5 goroutines q ouvem o redis
 func (s *Server) RunRedisListeners() {
 	color.Green("Iniciando listeners do Redis...")
 	go s.listenRedisGlobal(TopicoConectar)     // (do handlers_redis.go)
 	go s.listenRedisGlobal(TopicoComprarCarta) // (do handlers_redis.go)
+	go s.listenRedisGlobal(TopicoMercado)      // (do handlers_redis.go)
+	go s.listenRedisGlobal(TopicoFila)         // (do handlers_redis.go)
 	go s.listenRedisPersonal()                 // (do handlers_redis.go)
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Fila de Batalha (Redis) ---
+
+// Pra achar oponente sem saber o id de ninguém: o jogador entra na fila (tópico 'fila_batalha',
+// qualquer server atende) numa das duas modalidades, e o líder monta as partidas a cada segundo.
+//   - A fila é um hash no Redis (jogador -> EntradaFila), então sobrevive à queda de qualquer server.
+//   - O líder junta jogadores da mesma modalidade pelo rating. A janela começa em JanelaInicial e abre
+//     JanelaPorSegundo a cada segundo de espera (até JanelaMax), então quem espera mais aceita oponente
+//     mais longe. Os dois lados têm q aceitar (vale a menor das duas janelas) e quem ta esperando há
+//     mais tempo escolhe primeiro.
+//   - Tirar os dois da fila é um script com o termo do líder (fencing, igual no mercado).
+//   - A batalha roda no server de um dos dois (POST /battle/start), q vira o host. Se n der, os dois
+//     voltam pra fila sem perder o lugar.
+const (
+	ChaveFila   = "{inventario}:fila_batalha" // hash jogador -> EntradaFila (JSON)
+	ChaveRating = "{rating}:jogadores"        // hash jogador -> rating (slot próprio, ver historico.go)
+
+	ModoRanqueada = "Ranqueada"
+	ModoCasual    = "Casual"
+
+	RatingInicial    = 1000
+	JanelaInicial    = 50              // diferença de rating aceita logo q entra na fila
+	JanelaPorSegundo = 10              // quanto a janela abre por segundo de espera
+	JanelaMax        = 1000            // dps disso junta qualquer um (até MaxEsperaFila)
+	MaxEsperaFila    = 5 * time.Minute // FILA_MAX_ESPERA: passou disso sai da fila
+	IntervaloFila    = 1 * time.Second // de quanto em quanto tempo o líder monta as partidas
+)
+
+var (
+	errModoFila      = errors.New("modalidade inválida (use Ranqueada ou Casual)")
+	errJaNaFila      = errors.New("você já está na fila")
+	errForaDaFila    = errors.New("você não está na fila")
+	errSemCartasFila = errors.New("você precisa ter cartas para entrar na fila")
+	errAcaoFila      = errors.New("ação de fila inválida")
+	errOcupadoFila   = errors.New("você está em uma batalha ou troca")
+)
+
+// errosFila são os erros q podem voltar pro cliente como estão (o resto é falha do Redis)
+var errosFila = []error{errModoFila, errJaNaFila, errForaDaFila, errSemCartasFila, errAcaoFila, errJaPareado, errOcupadoFila}
+
+// scriptTirarDaFila tira os dois jogadores da fila pra montar a partida (só o líder chama).
+// KEYS[1] = fila, KEYS[2] = termo atual
+// ARGV[1] = termo do líder, ARGV[2] = j1, ARGV[3] = entrada do j1 (JSON lido), ARGV[4] = j2, ARGV[5] = entrada do j2
+// Retorna 1 = ok, 0 = um dos dois saiu (ou entrou de novo) no meio, -1 = termo do líder velho
+var scriptTirarDaFila = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[2]) or '0') > tonumber(ARGV[1]) then return -1 end
+if redis.call('HGET', KEYS[1], ARGV[2]) ~= ARGV[3] or redis.call('HGET', KEYS[1], ARGV[4]) ~= ARGV[5] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[2], ARGV[4])
+return 1
+`)
+
+// entradaLida é a entrada da fila junto com o JSON como tava no Redis (pro script conferir q n mudou)
+type entradaLida struct {
+	models.EntradaFila
+	bruto string
+}
+
+// processFila atende um pedido do tópico 'fila_batalha'
+func (s *Server) processFila(req models.ReqFila) {
+	var err error
+	switch req.Acao {
+	case "Entrar":
+		err = s.entrarNaFila(req)
+	case "Sair":
+		err = s.sairDaFila(req)
+	default:
+		err = errAcaoFila
+	}
+	if err != nil {
+		s.responderErroFila(req, err)
+	}
+}
+
+// entrarNaFila coloca o jogador na fila com o rating de agora
+func (s *Server) entrarNaFila(req models.ReqFila) error {
+	modo := ""
+	switch strings.ToLower(req.Modo) {
+	case "ranqueada", "":
+		modo = ModoRanqueada
+	case "casual":
+		modo = ModoCasual
+	default:
+		return errModoFila
+	}
+	if s.parceiroDe(req.IdRemetente) != "" {
+		return errJaPareado
+	}
+	if s.jogadorOcupado(req.IdRemetente) { // (do ocupado.go)
+		return errOcupadoFila
+	}
+	cartas, err := s.listarInventario(req.IdRemetente)
+	if err != nil {
+		return err
+	}
+	if len(cartas) == 0 {
+		return errSemCartasFila
+	}
+
+	entrada := models.EntradaFila{
+		IdJogador: req.IdRemetente,
+		Modo:      modo,
+		Rating:    s.ratingDe(req.IdRemetente),
+		Desde:     time.Now().UnixMilli(),
+	}
+	dados, err := json.Marshal(entrada)
+	if err != nil {
+		return err
+	}
+	// HSETNX: entrar 2x n reseta a espera
+	novo, err := s.redisClient.HSetNX(s.ctx, ChaveFila, req.IdRemetente, dados).Result()
+	if err != nil {
+		return err
+	}
+	if !novo {
+		return errJaNaFila
+	}
+
+	color.Cyan("FILA: %s entrou na fila %s (rating %d)", req.IdRemetente, modo, entrada.Rating)
+	s.sendToClient(req.CanalResposta, "Fila", models.RespostaFila{
+		NaFila:   true,
+		Modo:     modo,
+		Rating:   entrada.Rating,
+		Mensagem: fmt.Sprintf("Na fila %s (rating %d). Procurando oponente...", strings.ToLower(modo), entrada.Rating),
+	})
+	return nil
+}
+
+// sairDaFila tira o jogador da fila
+func (s *Server) sairDaFila(req models.ReqFila) error {
+	apagados, err := s.redisClient.HDel(s.ctx, ChaveFila, req.IdRemetente).Result()
+	if err != nil {
+		return err
+	}
+	if apagados == 0 {
+		return errForaDaFila
+	}
+	color.Yellow("FILA: %s saiu da fila", req.IdRemetente)
+	s.sendToClient(req.CanalResposta, "Fila", models.RespostaFila{Mensagem: "Você saiu da fila"})
+	return nil
+}
+
+// tirarDaFilaAoParear tira da fila quem acabou de parear (o par batalha pelo "Batalhar")
+func (s *Server) tirarDaFilaAoParear(playerID, parceiro string) {
+	apagados, err := s.redisClient.HDel(s.ctx, ChaveFila, playerID).Result()
+	if err != nil || apagados == 0 {
+		return
+	}
+	if info, ok := s.infoJogador(playerID); ok {
+		s.sendToClient(info.ReplyChannel, "Fila", models.RespostaFila{
+			Mensagem: fmt.Sprintf("Você saiu da fila (pareado com %s)", parceiro),
+		})
+	}
+}
+
+// ratingDe devolve o rating do jogador (RatingInicial se ele nunca jogou ranqueada)
+func (s *Server) ratingDe(playerID string) int {
+	rating, err := s.redisClient.HGet(s.ctx, ChaveRating, playerID).Int()
+	if err != nil {
+		return RatingInicial
+	}
+	return rating
+}
+
+// janelaRating é a diferença de rating q o jogador aceita depois de esperar até 'agora' (unix ms)
+func janelaRating(e models.EntradaFila, agora int64) int {
+	janela := JanelaInicial + JanelaPorSegundo*int((agora-e.Desde)/1000)
+	return min(janela, JanelaMax)
+}
+
+// cabeNaJanela diz se os dois aceitam a diferença de rating entre eles (cada um com a janela q ja abriu)
+// e devolve essa diferença
+func cabeNaJanela(a, b models.EntradaFila, agora int64) (int, bool) {
+	dif := a.Rating - b.Rating
+	if dif < 0 {
+		dif = -dif
+	}
+	return dif, dif <= min(janelaRating(a, agora), janelaRating(b, agora))
+}
+
+// RunMatchmaking roda em todo servidor, mas só o líder monta as partidas
+func (s *Server) RunMatchmaking() {
+	ticker := time.NewTicker(IntervaloFila)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.foraDoCluster() {
+			return
+		}
+		if s.isLeader() {
+			s.montarPartidas()
+		}
+	}
+}
+
+// montarPartidas lê a fila, limpa quem saiu ou cansou de esperar e junta os pares
+func (s *Server) montarPartidas() {
+	campos, err := s.redisClient.HGetAll(s.ctx, ChaveFila).Result()
+	if err != nil {
+		color.Red("LÍDER: Falha ao ler a fila de batalha: %v", err)
+		return
+	}
+	if len(campos) == 0 {
+		return
+	}
+
+	agora := time.Now().UnixMilli()
+	maxEspera := envDuration("FILA_MAX_ESPERA", MaxEsperaFila).Milliseconds()
+	porModo := map[string][]entradaLida{}
+	for id, bruto := range campos {
+		var e models.EntradaFila
+		if err := json.Unmarshal([]byte(bruto), &e); err != nil {
+			color.Red("LÍDER: Entrada corrompida na fila (%s): %v", id, err)
+			s.redisClient.HDel(s.ctx, ChaveFila, id)
+			continue
+		}
+		info, online := s.infoJogador(id)
+		if !online {
+			// desconectou esperando: so limpa
+			s.redisClient.HDel(s.ctx, ChaveFila, id)
+			continue
+		}
+		if agora-e.Desde > maxEspera {
+			if s.redisClient.HDel(s.ctx, ChaveFila, id).Val() > 0 {
+				s.sendToClient(info.ReplyChannel, "Fila", models.RespostaFila{
+					Modo:     e.Modo,
+					Mensagem: fmt.Sprintf("Nenhum oponente encontrado em %v, tente de novo", time.Duration(maxEspera)*time.Millisecond),
+				})
+			}
+			continue
+		}
+		porModo[e.Modo] = append(porModo[e.Modo], entradaLida{EntradaFila: e, bruto: bruto})
+	}
+
+	for modo, fila := range porModo {
+		// quem ta esperando há mais tempo escolhe primeiro
+		sort.Slice(fila, func(i, j int) bool { return fila[i].Desde < fila[j].Desde })
+		usado := make([]bool, len(fila))
+
+		for i := range fila {
+			if usado[i] {
+				continue
+			}
+			melhor, menorDif := -1, 0
+			for j := i + 1; j < len(fila); j++ {
+				if usado[j] {
+					continue
+				}
+				dif, ok := cabeNaJanela(fila[i].EntradaFila, fila[j].EntradaFila, agora)
+				if !ok {
+					continue
+				}
+				if (melhor == -1 || dif < menorDif) && !s.algumBloqueou(fila[i].IdJogador, fila[j].IdJogador) {
+					melhor, menorDif = j, dif
+				}
+			}
+			if melhor == -1 {
+				continue
+			}
+
+			a, b := fila[i], fila[melhor]
+			res, err := scriptTirarDaFila.Run(s.ctx, s.redisClient, []string{ChaveFila, ChaveTermoLider},
+				s.termoAtual(), a.IdJogador, a.bruto, b.IdJogador, b.bruto).Int()
+			if err != nil {
+				color.Red("LÍDER: Falha ao tirar %s e %s da fila: %v", a.IdJogador, b.IdJogador, err)
+				return
+			}
+			if res == -1 {
+				s.deixarLideranca("termo recusado pelo Redis na fila de batalha")
+				return
+			}
+			usado[i], usado[melhor] = true, true
+			if res == 0 {
+				continue // um dos dois saiu no meio, na próxima rodada tenta de novo
+			}
+
+			color.Green("LÍDER: Partida %s: %s (%d) vs %s (%d), espera %ds", modo, a.IdJogador, a.Rating,
+				b.IdJogador, b.Rating, (agora-a.Desde)/1000)
+			go s.comecarPartida(a.EntradaFila, b.EntradaFila)
+		}
+	}
+}
+
+// algumBloqueou diz se um dos dois bloqueou o outro (ver convites.go)
+func (s *Server) algumBloqueou(j1, j2 string) bool {
+	if s.redisClient.SIsMember(s.ctx, chaveBloqueios(j1), j2).Val() {
+		return true
+	}
+	return s.redisClient.SIsMember(s.ctx, chaveBloqueios(j2), j1).Val()
+}
+
+// comecarPartida abre a batalha no server de um dos dois (o de quem esperou mais, senão o do outro).
+// Se nenhum dos dois conseguir, volta os dois pra fila.
+func (s *Server) comecarPartida(a, b models.EntradaFila) {
+	// confere de novo: alguém pode ter entrado numa batalha ou troca enquanto tava na fila.
+	// quem ta ocupado sai da fila, o outro volta pro lugar dele
+	if ocupadoA, ocupadoB := s.jogadorOcupado(a.IdJogador), s.jogadorOcupado(b.IdJogador); ocupadoA || ocupadoB {
+		for _, e := range []struct {
+			entrada models.EntradaFila
+			ocupado bool
+		}{{a, ocupadoA}, {b, ocupadoB}} {
+			if !e.ocupado {
+				s.voltarPraFila(e.entrada)
+			} else if info, ok := s.infoJogador(e.entrada.IdJogador); ok {
+				s.sendToClient(info.ReplyChannel, "Fila", models.RespostaFila{
+					Modo:     e.entrada.Modo,
+					Mensagem: "Você saiu da fila (está em uma batalha ou troca)",
+				})
+			}
+		}
+		return
+	}
+
+	ranqueada := a.Modo == ModoRanqueada
+	for _, par := range [][2]models.EntradaFila{{a, b}, {b, a}} {
+		j1, j2 := par[0].IdJogador, par[1].IdJogador
+		info, ok := s.infoJogador(j1)
+		if !ok {
+			continue
+		}
+
+		var err error
+		if info.ServerID == s.ID {
+			if s.drenando.Load() {
+				continue
+			}
+			err = s.abrirBatalha(j1, j2, ranqueada)
+		} else {
+			err = s.sendToHost(info.ServerHost, "/battle/start", models.BattleStartRequest{
+				IdJogador1: j1,
+				IdJogador2: j2,
+				Ranqueada:  ranqueada,
+			})
+		}
+		if err == nil {
+			return
+		}
+		color.Red("LÍDER: Falha ao abrir a partida %s vs %s em %s: %v", j1, j2, info.ServerID, err)
+	}
+
+	s.voltarPraFila(a)
+	s.voltarPraFila(b)
+}
+
+// voltarPraFila põe o jogador de volta com a hora de entrada original (se ele ainda tiver online e com cartas)
+func (s *Server) voltarPraFila(e models.EntradaFila) {
+	info, ok := s.infoJogador(e.IdJogador)
+	if !ok {
+		return
+	}
+	resp := models.RespostaFila{Modo: e.Modo, Rating: e.Rating}
+	cartas, err := s.listarInventario(e.IdJogador)
+	if err != nil || len(cartas) == 0 {
+		resp.Mensagem = "Falha ao iniciar a partida, você saiu da fila"
+		s.sendToClient(info.ReplyChannel, "Fila", resp)
+		return
+	}
+
+	dados, _ := json.Marshal(e)
+	if err := s.redisClient.HSetNX(s.ctx, ChaveFila, e.IdJogador, dados).Err(); err != nil {
+		color.Red("LÍDER: Falha ao devolver %s pra fila: %v", e.IdJogador, err)
+		resp.Mensagem = "Falha ao iniciar a partida, você saiu da fila"
+	} else {
+		resp.NaFila = true
+		resp.Mensagem = "Falha ao iniciar a partida, você continua na fila"
+	}
+	s.sendToClient(info.ReplyChannel, "Fila", resp)
+}
+
+// responderErroFila manda o erro de um pedido da fila pro cliente
+func (s *Server) responderErroFila(req models.ReqFila, err error) {
+	for _, conhecido := range errosFila {
+		if err == conhecido {
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: err.Error()})
+			return
+		}
+	}
+	color.Red("FILA: Falha no %s de %s: %v", req.Acao, req.IdRemetente, err)
+	s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao processar o pedido, tente de novo"})
+}
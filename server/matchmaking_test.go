@@ -0,0 +1,54 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"testing"
+)
+
+func TestJanelaRating(t *testing.T) {
+	const desde = 1_000_000
+	casos := []struct {
+		nome   string
+		espera int64 // ms na fila
+		janela int
+	}{
+		{"acabou de entrar", 0, JanelaInicial},
+		{"segundo incompleto n conta", 999, JanelaInicial},
+		{"1s", 1000, JanelaInicial + JanelaPorSegundo},
+		{"30s", 30_000, JanelaInicial + 30*JanelaPorSegundo},
+		{"bem no teto", int64(JanelaMax-JanelaInicial) / JanelaPorSegundo * 1000, JanelaMax},
+		{"passou do teto", 10 * 60 * 1000, JanelaMax},
+	}
+	for _, c := range casos {
+		e := models.EntradaFila{Desde: desde}
+		if got := janelaRating(e, desde+c.espera); got != c.janela {
+			t.Errorf("%s: janelaRating = %d, esperava %d", c.nome, got, c.janela)
+		}
+	}
+}
+
+func TestCabeNaJanela(t *testing.T) {
+	const agora = 1_000_000
+	novo := func(rating int) models.EntradaFila { return models.EntradaFila{Rating: rating, Desde: agora} }
+	velho := func(rating int) models.EntradaFila { return models.EntradaFila{Rating: rating, Desde: agora - 60_000} }
+
+	casos := []struct {
+		nome string
+		a, b models.EntradaFila
+		dif  int
+		cabe bool
+	}{
+		{"msm rating", novo(1000), novo(1000), 0, true},
+		{"na borda da janela inicial", novo(1000), novo(1000 + JanelaInicial), JanelaInicial, true},
+		{"fora da janela inicial", novo(1000 + JanelaInicial + 1), novo(1000), JanelaInicial + 1, false},
+		// os dois precisam aceitar: quem acabou de entrar segura a janela de quem ja esperou
+		{"so um esperou", velho(1000), novo(1200), 200, false},
+		{"os dois esperaram", velho(1000), velho(1200), 200, true},
+	}
+	for _, c := range casos {
+		dif, ok := cabeNaJanela(c.a, c.b, agora)
+		if dif != c.dif || ok != c.cabe {
+			t.Errorf("%s: cabeNaJanela = %d/%v, esperava %d/%v", c.nome, dif, ok, c.dif, c.cabe)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"PlanoZ/eleicao"
+	"PlanoZ/models"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// --- Membros do Cluster ---
+
+// Cada server se registra no hash ChaveMembros (id -> host da api) e mantém uma chave de heartbeat com TTL.
+// O SERVER_LIST do env virou só a lista inicial (seed): quem subir depois entra sozinho,
+// sem precisar reiniciar os outros.
+// Quem decide se um server ta vivo continua sendo o health check; o heartbeat só serve pra
+// tirar do hash quem sumiu sem avisar (crash).
+const (
+	ChaveMembros     = "cluster:membros"
+	PrefixoHeartbeat = "cluster:heartbeat:"
+	MembroTTL        = 3 * HealthCheckInterval // sem heartbeat por esse tempo = fora do cluster
+)
+
+func chaveHeartbeat(serverID string) string {
+	return PrefixoHeartbeat + serverID
+}
+
+// registrarMembro coloca este server no hash de membros e renova o heartbeat
+func (s *Server) registrarMembro() error {
+	if err := s.redisClient.HSet(s.ctx, ChaveMembros, s.ID, s.HostAPI).Err(); err != nil {
+		return err
+	}
+	return s.redisClient.Set(s.ctx, chaveHeartbeat(s.ID), s.HostAPI, MembroTTL).Err()
+}
+
+// entrarNoCluster registra no redis, carrega quem ja ta la e avisa os outros (pra nao esperar o proximo ciclo)
+func (s *Server) entrarNoCluster() error {
+	if err := s.registrarMembro(); err != nil {
+		return fmt.Errorf("falha ao registrar no cluster: %v", err)
+	}
+	s.adicionarMembro(s.ID, s.HostAPI) // eu mesmo posso nao estar no SERVER_LIST
+	s.atualizarMembros()
+
+	req := models.ClusterMembroRequest{ServerID: s.ID, Host: s.HostAPI}
+	for id, host := range s.membros() {
+		if id == s.ID {
+			continue
+		}
+		go func(h string) {
+			if err := s.sendToHost(h, "/cluster/join", req); err != nil {
+				color.Yellow("MEMBROS: %s não recebeu o join: %v", h, err)
+			}
+		}(host)
+	}
+	color.Green("MEMBROS: %s entrou no cluster. Membros: %v", s.ID, s.idsMembros())
+	return nil
+}
+
+// sairDoCluster é a saída limpa: tira do redis e avisa os outros antes de desligar
+func (s *Server) sairDoCluster() {
+	s.muMembros.Lock()
+	if s.saindo {
+		s.muMembros.Unlock()
+		return
+	}
+	s.saindo = true // para o heartbeat, o health check e a eleição
+	s.muMembros.Unlock()
+
+	color.Yellow("MEMBROS: %s saindo do cluster...", s.ID)
+
+	// se eu for o líder, solto a liderança antes (os outros reelegem quando receberem o leave)
+	if s.isLeader() {
+		s.muLeader.Lock()
+		s.currentLeader = ""
+		s.muLeader.Unlock()
+		if lease, ok := s.eleitor.(*eleicao.EleitorLease); ok {
+			lease.Liberar(s.ctx, s.ID)
+		}
+	}
+
+	s.redisClient.HDel(s.ctx, ChaveMembros, s.ID)
+	s.redisClient.Del(s.ctx, chaveHeartbeat(s.ID))
+
+	req := models.ClusterMembroRequest{ServerID: s.ID}
+	for id, host := range s.membros() {
+		if id == s.ID {
+			continue
+		}
+		// aqui espera a resposta, o processo vai acabar logo depois
+		if err := s.sendToHost(host, "/cluster/leave", req); err != nil {
+			color.Yellow("MEMBROS: %s não recebeu o leave: %v", host, err)
+		}
+	}
+}
+
+// RunMembership renova o heartbeat e sincroniza a lista de membros com o redis
+func (s *Server) RunMembership() {
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.foraDoCluster() {
+			return
+		}
+		if err := s.registrarMembro(); err != nil {
+			color.Red("MEMBROS: Falha ao renovar heartbeat: %v", err)
+			continue
+		}
+		s.atualizarMembros()
+	}
+}
+
+// atualizarMembros lê o hash de membros e atualiza o serverList.
+// Membro sem heartbeat só sai depois que o health check já marcou ele como morto,
+// assim a limpeza (cleanup.go) ainda acha o host dele.
+func (s *Server) atualizarMembros() {
+	registrados, err := s.redisClient.HGetAll(s.ctx, ChaveMembros).Result()
+	if err != nil {
+		color.Red("MEMBROS: Falha ao ler membros: %v", err)
+		return
+	}
+
+	for id, host := range registrados {
+		if id == s.ID {
+			continue
+		}
+		vivo, err := s.redisClient.Exists(s.ctx, chaveHeartbeat(id)).Result()
+		if err != nil {
+			continue
+		}
+		if vivo == 1 {
+			s.adicionarMembro(id, host)
+			continue
+		}
+
+		s.muLiveServers.RLock()
+		healthOK := s.liveServers[id]
+		s.muLiveServers.RUnlock()
+		if !healthOK {
+			s.redisClient.HDel(s.ctx, ChaveMembros, id)
+			s.removerMembro(id)
+		}
+	}
+}
+
+// adicionarMembro coloca (ou atualiza) um server no serverList
+func (s *Server) adicionarMembro(id, host string) {
+	s.muMembros.Lock()
+	defer s.muMembros.Unlock()
+	if atual, ok := s.serverList[id]; ok && atual == host {
+		return
+	}
+	s.serverList[id] = host
+	color.Green("MEMBROS: %s (%s) entrou no cluster", id, host)
+}
+
+// removerMembro tira um server do serverList (o health check para de olhar pra ele)
+func (s *Server) removerMembro(id string) {
+	s.muMembros.Lock()
+	defer s.muMembros.Unlock()
+	if _, ok := s.serverList[id]; !ok {
+		return
+	}
+	delete(s.serverList, id)
+	color.Yellow("MEMBROS: %s saiu do cluster", id)
+}
+
+// membros devolve uma cópia do serverList (pra iterar sem segurar o lock)
+func (s *Server) membros() map[string]string {
+	s.muMembros.RLock()
+	defer s.muMembros.RUnlock()
+	copia := make(map[string]string, len(s.serverList))
+	for id, host := range s.serverList {
+		copia[id] = host
+	}
+	return copia
+}
+
+// idsMembros é só pra log
+func (s *Server) idsMembros() []string {
+	ids := []string{}
+	for id := range s.membros() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// foraDoCluster diz se este server já saiu (ou está saindo) do cluster
+func (s *Server) foraDoCluster() bool {
+	s.muMembros.RLock()
+	defer s.muMembros.RUnlock()
+	return s.saindo
+}
+
+// hostDe devolve o host da api de um server
+func (s *Server) hostDe(id string) (string, bool) {
+	s.muMembros.RLock()
+	defer s.muMembros.RUnlock()
+	host, ok := s.serverList[id]
+	return host, ok
+}
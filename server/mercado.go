@@ -0,0 +1,681 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Mercado e Casa de Leilões (Redis) ---
+
+// O mercado é do cluster todo e não precisa dos dois jogadores online: o vendedor anuncia uma carta
+// por preço fixo ou em leilão com prazo, e qualquer um compra ou dá lance pelo tópico 'mercado'.
+// Tudo fica no Redis (anúncio + índice por vencimento), então sobrevive à queda de qualquer servidor.
+//   - A carta anunciada fica presa no escrow (o mesmo da troca, ver escrow.go) até o anúncio acabar,
+//     então não entra em troca nem em outro anúncio. O prazo do escrow é o vencimento + FolgaLiquidacao.
+//   - Compra, lance e retirada são scripts atômicos: qualquer servidor atende.
+//   - O lance já sai da carteira na hora (os créditos ficam presos no leilão) e quem foi superado
+//     recebe de volta no mesmo script.
+//   - Quem fecha os anúncios vencidos é o líder (RunMercado), com o termo como fencing token igual
+//     na venda de pacotes: se o líder cair, o próximo pega de onde parou.
+const (
+	PrefixoAnuncio = "{inventario}:mercado:anuncio:" // hash com os dados do anúncio
+	ChaveMercado   = "{inventario}:mercado"          // zset id do anúncio -> vencimento (unix ms)
+
+	DuracaoFixoPadrao   = 24 * time.Hour   // anúncio de preço fixo sem duração
+	DuracaoLeilaoPadrao = 10 * time.Minute // leilão sem duração
+	DuracaoMinAnuncio   = 30 * time.Second
+	DuracaoMaxAnuncio   = 72 * time.Hour
+	FolgaLiquidacao     = 10 * time.Minute // a carta continua presa esse tempo dps do vencimento, pro líder fechar
+	ProrrogacaoLeilao   = 30 * time.Second // lance no finalzinho empurra o fim do leilão (sem "snipe")
+	IncrementoLance     = 5                // quanto um lance tem q passar o anterior
+	PrecoMaxAnuncio     = 1000000
+	LimiteBusca         = 20              // anúncios por resposta do "Listar"
+	IntervaloMercado    = 1 * time.Second // de quanto em quanto tempo o líder procura anúncio vencido
+	LoteLiquidacao      = 50              // anúncios fechados por rodada
+)
+
+var (
+	errAnuncioInexistente = errors.New("anúncio não encontrado ou já encerrado")
+	errAnuncioProprio     = errors.New("o anúncio é seu")
+	errAnuncioExpirado    = errors.New("anúncio vencido")
+	errAnuncioOutroTipo   = errors.New("esse anúncio não aceita essa ação (compra é no preço fixo, lance é no leilão)")
+	errAnuncioNaoEhSeu    = errors.New("o anúncio não é seu")
+	errAnuncioComLance    = errors.New("o leilão já tem lance, não dá pra retirar")
+	errAnuncioMudou       = errors.New("o anúncio mudou, tente de novo")
+	errLanceBaixo         = errors.New("lance abaixo do mínimo")
+	errTipoAnuncio        = errors.New("tipo de anúncio inválido (use Fixo ou Leilao)")
+	errPrecoInvalido      = fmt.Errorf("preço inválido (de 1 a %d)", PrecoMaxAnuncio)
+	errDuracaoInvalida    = fmt.Errorf("duração inválida (de %v a %v)", DuracaoMinAnuncio, DuracaoMaxAnuncio)
+	errAcaoMercado        = errors.New("ação de mercado inválida")
+)
+
+// errosMercado são os erros q podem voltar pro cliente como estão (o resto é falha do Redis)
+var errosMercado = []error{errAnuncioInexistente, errAnuncioProprio, errAnuncioExpirado, errAnuncioOutroTipo,
+	errAnuncioNaoEhSeu, errAnuncioComLance, errAnuncioMudou, errLanceBaixo, errTipoAnuncio, errPrecoInvalido,
+	errDuracaoInvalida, errAcaoMercado, errCartaNaoEhSua, errCartaEmEscrow, errSaldoInsuficiente}
+
+func chaveAnuncio(id string) string { return PrefixoAnuncio + id }
+
+// donoEscrowAnuncio é o "dono" da carta no escrow enquanto ela ta anunciada (no lugar do id da troca)
+func donoEscrowAnuncio(id string) string { return "mercado:" + id }
+
+// luaEscrowDoAnuncio diz se a carta ainda ta presa nesse anúncio (sem olhar o prazo: depois do
+// vencimento ela continua dele até o líder fechar, a não ser q outra troca/anúncio tenha pego)
+const luaEscrowDoAnuncio = `
+local function escrowDoAnuncio(valor, dono)
+	if not valor then return false end
+	return string.sub(valor, 1, string.find(valor, '|', 1, true) - 1) == dono
+end
+`
+
+// scriptAnunciar prende a carta no escrow e cria o anúncio.
+// KEYS[1] = hash de donos, KEYS[2] = escrow, KEYS[3] = anúncio, KEYS[4] = índice do mercado
+// ARGV[1] = vendedor, ARGV[2] = id da carta, ARGV[3] = dono do escrow, ARGV[4] = prazo do escrow (unix ms),
+// ARGV[5] = agora (unix ms), ARGV[6] = tipo, ARGV[7] = preço, ARGV[8] = vencimento (unix ms),
+// ARGV[9] = carta (JSON), ARGV[10] = id do anúncio
+// Retorna 1 = ok, -1 = carta n é do vendedor, -2 = carta presa em troca/outro anúncio
+var scriptAnunciar = redis.NewScript(luaEscrowDaTroca + `
+if redis.call('HGET', KEYS[1], ARGV[2]) ~= ARGV[1] then return -1 end
+if not escrowLivre(redis.call('HGET', KEYS[2], ARGV[2]), tonumber(ARGV[5])) then return -2 end
+redis.call('HSET', KEYS[2], ARGV[2], ARGV[3] .. '|' .. ARGV[4])
+redis.call('HSET', KEYS[3], 'vendedor', ARGV[1], 'id_carta', ARGV[2], 'carta', ARGV[9],
+	'tipo', ARGV[6], 'preco', ARGV[7], 'expira', ARGV[8])
+redis.call('ZADD', KEYS[4], ARGV[8], ARGV[10])
+return 1
+`)
+
+// scriptComprarAnuncio compra um anúncio de preço fixo: move a carta, cobra o comprador e paga o vendedor.
+// KEYS[1] = anúncio, KEYS[2] = índice, KEYS[3] = escrow, KEYS[4] = hash de donos, KEYS[5] = inventário do vendedor,
+// KEYS[6] = inventário do comprador, KEYS[7] = saldo do comprador, KEYS[8] = extrato do comprador,
+// KEYS[9] = saldo do vendedor, KEYS[10] = extrato do vendedor, KEYS[11] = transações
+// ARGV[1] = comprador, ARGV[2] = vendedor, ARGV[3] = id do anúncio, ARGV[4] = dono do escrow,
+// ARGV[5] = agora (unix ms), ARGV[6] = quando (unix s), ARGV[7] = carta já com o novo dono (JSON)
+// Retorna {status, saldo do comprador}: 1 = ok, -1 = n existe, -2 = anúncio próprio, -3 = vencido,
+// -4 = é leilão, -5 = saldo insuficiente, -6 = o anúncio mudou (vendedor diferente)
+var scriptComprarAnuncio = redis.NewScript(luaEscrowDoAnuncio + luaLancar + `
+if redis.call('EXISTS', KEYS[1]) == 0 then return {-1, 0} end
+local a = redis.call('HMGET', KEYS[1], 'vendedor', 'id_carta', 'tipo', 'preco', 'expira')
+if a[1] ~= ARGV[2] then return {-6, 0} end
+if a[1] == ARGV[1] then return {-2, 0} end
+if a[3] ~= 'Fixo' then return {-4, 0} end
+if tonumber(a[5]) <= tonumber(ARGV[5]) then return {-3, 0} end
+if not escrowDoAnuncio(redis.call('HGET', KEYS[3], a[2]), ARGV[4]) or redis.call('HGET', KEYS[4], a[2]) ~= a[1] then
+	-- a carta saiu do anúncio (n devia acontecer): o anúncio n vale mais
+	redis.call('DEL', KEYS[1])
+	redis.call('ZREM', KEYS[2], ARGV[3])
+	return {-1, 0}
+end
+local preco = tonumber(a[4])
+local saldo = tonumber(redis.call('GET', KEYS[7]) or '0')
+if saldo < preco then return {-5, saldo} end
+redis.call('HDEL', KEYS[5], a[2])
+redis.call('HSET', KEYS[6], a[2], ARGV[7])
+redis.call('HSET', KEYS[4], a[2], ARGV[1])
+redis.call('HDEL', KEYS[3], a[2])
+local motivo = 'Mercado ' .. ARGV[3]
+saldo = lancar(KEYS[7], KEYS[8], KEYS[11], 'mercado:' .. ARGV[3] .. ':compra', ARGV[1], -preco, motivo, tonumber(ARGV[6]))
+lancar(KEYS[9], KEYS[10], KEYS[11], 'mercado:' .. ARGV[3] .. ':venda', a[1], preco, motivo, tonumber(ARGV[6]))
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[3])
+return {1, saldo}
+`)
+
+// scriptLance registra um lance: devolve os créditos de quem tinha o maior lance e prende os do novo.
+// KEYS[1] = anúncio, KEYS[2] = índice, KEYS[3] = escrow, KEYS[4] = saldo do licitante, KEYS[5] = extrato do licitante,
+// KEYS[6] = saldo do licitante anterior, KEYS[7] = extrato do licitante anterior, KEYS[8] = transações
+// ARGV[1] = licitante, ARGV[2] = licitante anterior ("" se n tinha), ARGV[3] = id do anúncio, ARGV[4] = dono do escrow,
+// ARGV[5] = valor, ARGV[6] = agora (unix ms), ARGV[7] = quando (unix s), ARGV[8] = incremento mínimo,
+// ARGV[9] = prorrogação (ms), ARGV[10] = folga do escrow depois do vencimento (ms)
+// Retorna {status, saldo (ou o lance mínimo, no -7), vencimento}: 1 = ok, -1 = n existe, -2 = anúncio próprio,
+// -3 = vencido, -4 = é preço fixo, -5 = saldo insuficiente, -6 = o maior lance mudou, -7 = lance baixo
+var scriptLance = redis.NewScript(luaLancar + `
+if redis.call('EXISTS', KEYS[1]) == 0 then return {-1, 0, 0} end
+local a = redis.call('HMGET', KEYS[1], 'vendedor', 'id_carta', 'tipo', 'preco', 'expira', 'lance', 'licitante')
+local anterior = a[7] or ''
+if anterior ~= ARGV[2] then return {-6, 0, 0} end
+if a[1] == ARGV[1] then return {-2, 0, 0} end
+if a[3] ~= 'Leilao' then return {-4, 0, 0} end
+local agora = tonumber(ARGV[6])
+local expira = tonumber(a[5])
+if expira <= agora then return {-3, 0, 0} end
+local valor = tonumber(ARGV[5])
+local lance = tonumber(a[6] or '0')
+local minimo = tonumber(a[4])
+if anterior ~= '' then minimo = lance + tonumber(ARGV[8]) end
+if valor < minimo then return {-7, minimo, 0} end
+local saldo = tonumber(redis.call('GET', KEYS[4]) or '0')
+local devolve = 0
+if anterior == ARGV[1] then devolve = lance end
+if saldo + devolve < valor then return {-5, saldo, 0} end
+
+local motivo = 'Leilão ' .. ARGV[3]
+if anterior ~= '' then
+	lancar(KEYS[6], KEYS[7], KEYS[8], 'mercado:' .. ARGV[3] .. ':estorno:' .. anterior .. ':' .. lance, anterior, lance, motivo, tonumber(ARGV[7]))
+end
+saldo = lancar(KEYS[4], KEYS[5], KEYS[8], 'mercado:' .. ARGV[3] .. ':lance:' .. ARGV[1] .. ':' .. valor, ARGV[1], -valor, motivo, tonumber(ARGV[7]))
+redis.call('HSET', KEYS[1], 'lance', valor, 'licitante', ARGV[1])
+if expira - agora < tonumber(ARGV[9]) then
+	expira = agora + tonumber(ARGV[9])
+	redis.call('HSET', KEYS[1], 'expira', expira)
+	redis.call('ZADD', KEYS[2], expira, ARGV[3])
+	redis.call('HSET', KEYS[3], a[2], ARGV[4] .. '|' .. (expira + tonumber(ARGV[10])))
+end
+return {1, saldo, expira}
+`)
+
+// scriptRetirarAnuncio tira o anúncio do mercado e solta a carta (leilão com lance n sai mais).
+// KEYS[1] = anúncio, KEYS[2] = índice, KEYS[3] = escrow
+// ARGV[1] = vendedor, ARGV[2] = id do anúncio, ARGV[3] = dono do escrow
+// Retorna 1 = ok, -1 = n existe, -2 = n é do vendedor, -3 = tem lance
+var scriptRetirarAnuncio = redis.NewScript(luaEscrowDoAnuncio + `
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+local a = redis.call('HMGET', KEYS[1], 'vendedor', 'id_carta', 'licitante')
+if a[1] ~= ARGV[1] then return -2 end
+if a[3] then return -3 end
+if escrowDoAnuncio(redis.call('HGET', KEYS[3], a[2]), ARGV[3]) then
+	redis.call('HDEL', KEYS[3], a[2])
+end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[2])
+return 1
+`)
+
+// scriptLiquidarAnuncio fecha um anúncio vencido (só o líder chama). Leilão com lance: a carta vai pro
+// vencedor e o lance (q ja tava preso) pro vendedor. Sem lance: a carta só é solta.
+// Se a carta n tiver mais no anúncio (ficou sem líder além da folga e foi parar em outra troca), o lance volta.
+// KEYS[1] = anúncio, KEYS[2] = índice, KEYS[3] = escrow, KEYS[4] = hash de donos, KEYS[5] = inventário do vendedor,
+// KEYS[6] = inventário do vencedor, KEYS[7] = saldo do vendedor, KEYS[8] = extrato do vendedor,
+// KEYS[9] = saldo do vencedor, KEYS[10] = extrato do vencedor, KEYS[11] = transações, KEYS[12] = termo atual
+// ARGV[1] = id do anúncio, ARGV[2] = dono do escrow, ARGV[3] = vendedor, ARGV[4] = vencedor ("" se n teve lance),
+// ARGV[5] = agora (unix ms), ARGV[6] = quando (unix s), ARGV[7] = termo do líder, ARGV[8] = carta já com o novo dono (JSON)
+// Retorna 1 = vendido, 2 = vencido sem lance, 3 = cancelado (lance devolvido), 0 = nada a fazer (ainda n venceu
+// ou ja foi fechado), -1 = o anúncio mudou (tenta na próxima rodada), -2 = termo do líder velho
+var scriptLiquidarAnuncio = redis.NewScript(luaEscrowDoAnuncio + luaLancar + `
+if tonumber(redis.call('GET', KEYS[12]) or '0') > tonumber(ARGV[7]) then return -2 end
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	return 0
+end
+local a = redis.call('HMGET', KEYS[1], 'vendedor', 'id_carta', 'expira', 'lance', 'licitante')
+if tonumber(a[3]) > tonumber(ARGV[5]) then return 0 end
+if a[1] ~= ARGV[3] or (a[5] or '') ~= ARGV[4] then return -1 end
+
+local valido = escrowDoAnuncio(redis.call('HGET', KEYS[3], a[2]), ARGV[2]) and redis.call('HGET', KEYS[4], a[2]) == a[1]
+local status = 2
+if a[5] then
+	local lance = tonumber(a[4])
+	local motivo = 'Leilão ' .. ARGV[1]
+	if valido then
+		redis.call('HDEL', KEYS[5], a[2])
+		redis.call('HSET', KEYS[6], a[2], ARGV[8])
+		redis.call('HSET', KEYS[4], a[2], a[5])
+		lancar(KEYS[7], KEYS[8], KEYS[11], 'mercado:' .. ARGV[1] .. ':venda', a[1], lance, motivo, tonumber(ARGV[6]))
+		status = 1
+	else
+		lancar(KEYS[9], KEYS[10], KEYS[11], 'mercado:' .. ARGV[1] .. ':estorno:' .. a[5] .. ':' .. lance, a[5], lance, motivo, tonumber(ARGV[6]))
+		status = 3
+	end
+end
+if valido then
+	redis.call('HDEL', KEYS[3], a[2])
+end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return status
+`)
+
+// processMercado atende um pedido do tópico 'mercado' (qualquer servidor atende, os scripts são atômicos)
+func (s *Server) processMercado(req models.ReqMercado) {
+	var err error
+	switch req.Acao {
+	case "Listar":
+		var anuncios []models.Anuncio
+		var total int
+		anuncios, total, err = s.buscarAnuncios(req.Filtro)
+		if err == nil {
+			s.sendToClient(req.CanalResposta, "Mercado", models.RespostaMercado{Anuncios: anuncios, Total: total})
+		}
+	case "Anunciar":
+		err = s.anunciarCarta(req)
+	case "Comprar":
+		err = s.comprarAnuncio(req)
+	case "Lance":
+		err = s.darLance(req)
+	case "Retirar":
+		err = s.retirarAnuncio(req)
+	default:
+		err = errAcaoMercado
+	}
+	if err != nil {
+		s.responderErroMercado(req, err)
+	}
+}
+
+// anunciarCarta coloca a carta do jogador no mercado (preço fixo ou leilão)
+func (s *Server) anunciarCarta(req models.ReqMercado) error {
+	tipo, duracaoPadrao := "", time.Duration(0)
+	switch strings.ToLower(req.Tipo) {
+	case "fixo", "":
+		tipo, duracaoPadrao = "Fixo", DuracaoFixoPadrao
+	case "leilao", "leilão":
+		tipo, duracaoPadrao = "Leilao", DuracaoLeilaoPadrao
+	default:
+		return errTipoAnuncio
+	}
+	if req.Preco < 1 || req.Preco > PrecoMaxAnuncio {
+		return errPrecoInvalido
+	}
+	duracao := time.Duration(req.Duracao) * time.Second
+	if req.Duracao == 0 {
+		duracao = duracaoPadrao
+	}
+	if duracao < DuracaoMinAnuncio || duracao > DuracaoMaxAnuncio {
+		return errDuracaoInvalida
+	}
+
+	carta, ok, err := s.buscarCartaJogador(req.IdRemetente, req.IdCarta)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errCartaNaoEhSua
+	}
+	dados, err := json.Marshal(carta)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.New().String()[:8]
+	agora := time.Now()
+	expira := agora.Add(duracao)
+	res, err := scriptAnunciar.Run(s.ctx, s.redisClient,
+		[]string{ChaveDonoCartas, ChaveEscrow, chaveAnuncio(id), ChaveMercado},
+		req.IdRemetente, carta.Id, donoEscrowAnuncio(id), expira.Add(FolgaLiquidacao).UnixMilli(), agora.UnixMilli(),
+		tipo, req.Preco, expira.UnixMilli(), dados, id).Int()
+	if err != nil {
+		return fmt.Errorf("falha no script de anúncio: %v", err)
+	}
+	switch res {
+	case -1:
+		return errCartaNaoEhSua
+	case -2:
+		return errCartaEmEscrow
+	}
+
+	anuncio := models.Anuncio{Id: id, Vendedor: req.IdRemetente, Carta: carta, Tipo: tipo, Preco: req.Preco, ExpiraEm: expira.Unix()}
+	color.Cyan("MERCADO: %s anunciou %s (%s, %d créditos) no anúncio %s", req.IdRemetente, carta.Modelo, tipo, req.Preco, id)
+	s.sendToClient(req.CanalResposta, "Mercado_Evento", models.RespostaEventoMercado{
+		Evento:   "Anunciado",
+		Mensagem: fmt.Sprintf("%s anunciado (%s) por %d créditos, vence em %v", carta.Modelo, tipo, req.Preco, duracao),
+		Anuncio:  anuncio,
+	})
+	return nil
+}
+
+// comprarAnuncio compra um anúncio de preço fixo
+func (s *Server) comprarAnuncio(req models.ReqMercado) error {
+	anuncio, ok, err := s.lerAnuncio(req.IdAnuncio)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errAnuncioInexistente
+	}
+	carta := anuncio.Carta
+	carta.Id_jogador = req.IdRemetente
+	dados, err := json.Marshal(carta)
+	if err != nil {
+		return err
+	}
+
+	res, err := scriptComprarAnuncio.Run(s.ctx, s.redisClient,
+		[]string{chaveAnuncio(anuncio.Id), ChaveMercado, ChaveEscrow, ChaveDonoCartas,
+			chaveInventario(anuncio.Vendedor), chaveInventario(req.IdRemetente),
+			chaveCarteira(req.IdRemetente), chaveExtrato(req.IdRemetente),
+			chaveCarteira(anuncio.Vendedor), chaveExtrato(anuncio.Vendedor), ChaveTransacoes},
+		req.IdRemetente, anuncio.Vendedor, anuncio.Id, donoEscrowAnuncio(anuncio.Id),
+		time.Now().UnixMilli(), time.Now().Unix(), dados).Int64Slice()
+	if err != nil {
+		return fmt.Errorf("falha no script de compra: %v", err)
+	}
+	saldo := int(res[1])
+	switch res[0] {
+	case -1:
+		return errAnuncioInexistente
+	case -2:
+		return errAnuncioProprio
+	case -3:
+		return errAnuncioExpirado
+	case -4:
+		return errAnuncioOutroTipo
+	case -5:
+		return fmt.Errorf("%w (você tem %d créditos)", errSaldoInsuficiente, saldo)
+	case -6:
+		return errAnuncioMudou
+	}
+
+	color.Green("MERCADO: %s comprou o anúncio %s de %s por %d", req.IdRemetente, anuncio.Id, anuncio.Vendedor, anuncio.Preco)
+	anuncio.Carta = carta
+	s.sendToClient(req.CanalResposta, "Mercado_Evento", models.RespostaEventoMercado{
+		Evento:   "Comprado",
+		Mensagem: fmt.Sprintf("Você comprou %s por %d créditos (saldo: %d)", carta.Modelo, anuncio.Preco, saldo),
+		Anuncio:  anuncio,
+	})
+	s.avisarMercado(anuncio.Vendedor, "Vendido", fmt.Sprintf("%s comprou seu %s por %d créditos", req.IdRemetente, carta.Modelo, anuncio.Preco), anuncio)
+	return nil
+}
+
+// darLance dá um lance num leilão. Se outro lance entrar no meio (o anúncio mudou), tenta de novo com o estado novo.
+func (s *Server) darLance(req models.ReqMercado) error {
+	for tentativa := 0; tentativa < 3; tentativa++ {
+		anuncio, ok, err := s.lerAnuncio(req.IdAnuncio)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errAnuncioInexistente
+		}
+		// a carteira do anterior vai nas KEYS mesmo se n tiver anterior (aí n é usada)
+		anterior := anuncio.Licitante
+		chaveAnterior := anterior
+		if chaveAnterior == "" {
+			chaveAnterior = req.IdRemetente
+		}
+
+		res, err := scriptLance.Run(s.ctx, s.redisClient,
+			[]string{chaveAnuncio(anuncio.Id), ChaveMercado, ChaveEscrow,
+				chaveCarteira(req.IdRemetente), chaveExtrato(req.IdRemetente),
+				chaveCarteira(chaveAnterior), chaveExtrato(chaveAnterior), ChaveTransacoes},
+			req.IdRemetente, anterior, anuncio.Id, donoEscrowAnuncio(anuncio.Id), req.Valor,
+			time.Now().UnixMilli(), time.Now().Unix(), IncrementoLance,
+			ProrrogacaoLeilao.Milliseconds(), FolgaLiquidacao.Milliseconds()).Int64Slice()
+		if err != nil {
+			return fmt.Errorf("falha no script de lance: %v", err)
+		}
+		switch res[0] {
+		case -1:
+			return errAnuncioInexistente
+		case -2:
+			return errAnuncioProprio
+		case -3:
+			return errAnuncioExpirado
+		case -4:
+			return errAnuncioOutroTipo
+		case -5:
+			return fmt.Errorf("%w (você tem %d créditos)", errSaldoInsuficiente, res[1])
+		case -6:
+			continue // entrou outro lance, lê de novo
+		case -7:
+			return fmt.Errorf("%w: o lance agora tem q ser de pelo menos %d", errLanceBaixo, res[1])
+		}
+
+		anuncio.Lance, anuncio.Licitante, anuncio.ExpiraEm = req.Valor, req.IdRemetente, res[2]/1000
+		color.Cyan("MERCADO: Lance de %d de %s no leilão %s", req.Valor, req.IdRemetente, anuncio.Id)
+		s.sendToClient(req.CanalResposta, "Mercado_Evento", models.RespostaEventoMercado{
+			Evento:   "Lance",
+			Mensagem: fmt.Sprintf("Seu lance de %d em %s é o maior agora (créditos presos até o fim, saldo: %d)", req.Valor, anuncio.Carta.Modelo, res[1]),
+			Anuncio:  anuncio,
+		})
+		if anterior != "" && anterior != req.IdRemetente {
+			s.avisarMercado(anterior, "Superado", fmt.Sprintf("Seu lance em %s foi superado (%d créditos). Os créditos voltaram pra você", anuncio.Carta.Modelo, req.Valor), anuncio)
+		}
+		s.avisarMercado(anuncio.Vendedor, "Lance", fmt.Sprintf("Novo lance de %d no seu leilão de %s", req.Valor, anuncio.Carta.Modelo), anuncio)
+		return nil
+	}
+	return errAnuncioMudou
+}
+
+// retirarAnuncio tira o anúncio do vendedor do mercado e solta a carta
+func (s *Server) retirarAnuncio(req models.ReqMercado) error {
+	anuncio, ok, err := s.lerAnuncio(req.IdAnuncio)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errAnuncioInexistente
+	}
+	res, err := scriptRetirarAnuncio.Run(s.ctx, s.redisClient,
+		[]string{chaveAnuncio(anuncio.Id), ChaveMercado, ChaveEscrow},
+		req.IdRemetente, anuncio.Id, donoEscrowAnuncio(anuncio.Id)).Int()
+	if err != nil {
+		return fmt.Errorf("falha no script de retirada: %v", err)
+	}
+	switch res {
+	case -1:
+		return errAnuncioInexistente
+	case -2:
+		return errAnuncioNaoEhSeu
+	case -3:
+		return errAnuncioComLance
+	}
+
+	color.Yellow("MERCADO: %s retirou o anúncio %s", req.IdRemetente, anuncio.Id)
+	s.sendToClient(req.CanalResposta, "Mercado_Evento", models.RespostaEventoMercado{
+		Evento:   "Retirado",
+		Mensagem: fmt.Sprintf("Anúncio de %s retirado", anuncio.Carta.Modelo),
+		Anuncio:  anuncio,
+	})
+	return nil
+}
+
+// buscarAnuncios devolve os anúncios abertos q batem com o filtro (os q vencem primeiro na frente)
+// e quantos bateram no total
+func (s *Server) buscarAnuncios(filtro models.FiltroMercado) ([]models.Anuncio, int, error) {
+	ids, err := s.redisClient.ZRangeByScore(s.ctx, ChaveMercado, &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().UnixMilli(), 10), // os vencidos tão esperando o líder fechar
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err = s.redisClient.Pipelined(s.ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = p.HGetAll(s.ctx, chaveAnuncio(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	anuncios := make([]models.Anuncio, 0, LimiteBusca)
+	total := 0
+	for i, cmd := range cmds {
+		anuncio, ok := anuncioDoHash(ids[i], cmd.Val())
+		if !ok || !anuncioBateFiltro(anuncio, filtro) {
+			continue
+		}
+		total++
+		if len(anuncios) < LimiteBusca {
+			anuncios = append(anuncios, anuncio)
+		}
+	}
+	return anuncios, total, nil
+}
+
+// lerAnuncio lê um anúncio do Redis
+func (s *Server) lerAnuncio(id string) (models.Anuncio, bool, error) {
+	if id == "" {
+		return models.Anuncio{}, false, nil
+	}
+	campos, err := s.redisClient.HGetAll(s.ctx, chaveAnuncio(id)).Result()
+	if err != nil {
+		return models.Anuncio{}, false, err
+	}
+	anuncio, ok := anuncioDoHash(id, campos)
+	return anuncio, ok, nil
+}
+
+// anuncioDoHash monta o anúncio a partir do hash do Redis (false se o anúncio n existe)
+func anuncioDoHash(id string, campos map[string]string) (models.Anuncio, bool) {
+	if len(campos) == 0 {
+		return models.Anuncio{}, false
+	}
+	var carta models.Tanque
+	if err := json.Unmarshal([]byte(campos["carta"]), &carta); err != nil {
+		color.Red("MERCADO: Carta corrompida no anúncio %s: %v", id, err)
+		return models.Anuncio{}, false
+	}
+	preco, _ := strconv.Atoi(campos["preco"])
+	lance, _ := strconv.Atoi(campos["lance"])
+	expira, _ := strconv.ParseInt(campos["expira"], 10, 64)
+	return models.Anuncio{
+		Id:        id,
+		Vendedor:  campos["vendedor"],
+		Carta:     carta,
+		Tipo:      campos["tipo"],
+		Preco:     preco,
+		Lance:     lance,
+		Licitante: campos["licitante"],
+		ExpiraEm:  expira / 1000,
+	}, true
+}
+
+// anuncioBateFiltro confere o anúncio contra o filtro da busca
+func anuncioBateFiltro(a models.Anuncio, f models.FiltroMercado) bool {
+	precoAtual := a.Preco
+	if a.Licitante != "" {
+		precoAtual = a.Lance
+	}
+	switch {
+	case f.Classe != "" && !strings.EqualFold(a.Carta.Classe, f.Classe):
+		return false
+	case f.Raridade != "" && !strings.EqualFold(a.Carta.Raridade, f.Raridade):
+		return false
+	case f.Tipo != "" && !strings.EqualFold(a.Tipo, f.Tipo):
+		return false
+	case f.Vendedor != "" && a.Vendedor != f.Vendedor:
+		return false
+	case a.Carta.Vida < f.VidaMin || a.Carta.Ataque < f.AtaqueMin:
+		return false
+	case f.PrecoMax > 0 && precoAtual > f.PrecoMax:
+		return false
+	}
+	return true
+}
+
+// RunMercado roda em todo servidor, mas só o líder fecha os anúncios vencidos
+func (s *Server) RunMercado() {
+	ticker := time.NewTicker(IntervaloMercado)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.foraDoCluster() {
+			return
+		}
+		if s.isLeader() {
+			s.liquidarVencidos()
+		}
+	}
+}
+
+// liquidarVencidos fecha um lote de anúncios vencidos
+func (s *Server) liquidarVencidos() {
+	ids, err := s.redisClient.ZRangeByScore(s.ctx, ChaveMercado, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: LoteLiquidacao,
+	}).Result()
+	if err != nil {
+		color.Red("LÍDER: Falha ao procurar anúncios vencidos: %v", err)
+		return
+	}
+	for _, id := range ids {
+		err := s.liquidarAnuncio(id)
+		if err == errLiderObsoleto {
+			s.deixarLideranca("termo recusado pelo Redis no mercado")
+			return
+		}
+		if err != nil {
+			color.Red("LÍDER: Falha ao fechar o anúncio %s: %v", id, err)
+		}
+	}
+}
+
+// liquidarAnuncio fecha um anúncio vencido e avisa o vendedor (e o vencedor, se teve lance)
+func (s *Server) liquidarAnuncio(id string) error {
+	anuncio, ok, err := s.lerAnuncio(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.redisClient.ZRem(s.ctx, ChaveMercado, id).Err() // o hash sumiu, so limpa o índice
+	}
+
+	vencedor := anuncio.Licitante
+	chaveVencedor := vencedor
+	if chaveVencedor == "" {
+		chaveVencedor = anuncio.Vendedor // n é usada, mas tem q ir nas KEYS
+	}
+	carta := anuncio.Carta
+	carta.Id_jogador = vencedor
+	dados, err := json.Marshal(carta)
+	if err != nil {
+		return err
+	}
+
+	res, err := scriptLiquidarAnuncio.Run(s.ctx, s.redisClient,
+		[]string{chaveAnuncio(id), ChaveMercado, ChaveEscrow, ChaveDonoCartas,
+			chaveInventario(anuncio.Vendedor), chaveInventario(chaveVencedor),
+			chaveCarteira(anuncio.Vendedor), chaveExtrato(anuncio.Vendedor),
+			chaveCarteira(chaveVencedor), chaveExtrato(chaveVencedor), ChaveTransacoes, ChaveTermoLider},
+		id, donoEscrowAnuncio(id), anuncio.Vendedor, vencedor, time.Now().UnixMilli(), time.Now().Unix(),
+		s.termoAtual(), dados).Int()
+	if err != nil {
+		return fmt.Errorf("falha no script de liquidação: %v", err)
+	}
+
+	modelo := anuncio.Carta.Modelo
+	switch res {
+	case -2:
+		return errLiderObsoleto
+	case 1:
+		color.Green("LÍDER: Leilão %s fechado: %s arrematou %s por %d", id, vencedor, modelo, anuncio.Lance)
+		s.avisarMercado(anuncio.Vendedor, "Vendido", fmt.Sprintf("Leilão encerrado: %s arrematou seu %s por %d créditos", vencedor, modelo, anuncio.Lance), anuncio)
+		anuncio.Carta = carta
+		s.avisarMercado(vencedor, "Arrematado", fmt.Sprintf("Você arrematou %s por %d créditos!", modelo, anuncio.Lance), anuncio)
+	case 2:
+		color.Yellow("LÍDER: Anúncio %s venceu sem comprador", id)
+		s.avisarMercado(anuncio.Vendedor, "Expirado", fmt.Sprintf("Seu anúncio de %s venceu sem comprador, a carta está livre", modelo), anuncio)
+	case 3:
+		color.Red("LÍDER: Anúncio %s cancelado (a carta saiu do escrow), lance devolvido a %s", id, vencedor)
+		s.avisarMercado(anuncio.Vendedor, "Expirado", fmt.Sprintf("Seu leilão de %s foi cancelado", modelo), anuncio)
+		s.avisarMercado(vencedor, "Expirado", fmt.Sprintf("O leilão de %s foi cancelado, seu lance de %d voltou pra você", modelo, anuncio.Lance), anuncio)
+	}
+	return nil
+}
+
+// avisarMercado manda um evento do mercado pro jogador, se ele tiver online (o canal dele é
+// uma lista no Redis, então qualquer servidor consegue mandar)
+func (s *Server) avisarMercado(playerID, evento, mensagem string, anuncio models.Anuncio) {
+	if info, ok := s.infoJogador(playerID); ok {
+		s.sendToClient(info.ReplyChannel, "Mercado_Evento", models.RespostaEventoMercado{
+			Evento:   evento,
+			Mensagem: mensagem,
+			Anuncio:  anuncio,
+		})
+	}
+}
+
+// responderErroMercado manda o erro de um pedido do mercado pro cliente
+func (s *Server) responderErroMercado(req models.ReqMercado, err error) {
+	for _, conhecido := range errosMercado {
+		if errors.Is(err, conhecido) {
+			s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Mercado: " + err.Error()})
+			return
+		}
+	}
+	color.Red("MERCADO: Falha no %s de %s: %v", req.Acao, req.IdRemetente, err)
+	s.sendToClient(req.CanalResposta, "Erro", models.RespostaErro{Erro: "Falha ao processar o pedido no mercado, tente de novo"})
+}
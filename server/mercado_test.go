@@ -0,0 +1,44 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"testing"
+)
+
+func TestAnuncioBateFiltro(t *testing.T) {
+	carta := models.Tanque{Modelo: "Tiger II", Classe: models.ClassePesado, Raridade: "Epico", Vida: 200, Ataque: 53}
+	fixo := models.Anuncio{Vendedor: "ana", Carta: carta, Tipo: "Fixo", Preco: 100}
+	semLance := models.Anuncio{Vendedor: "ana", Carta: carta, Tipo: "Leilao", Preco: 50}
+	comLance := models.Anuncio{Vendedor: "ana", Carta: carta, Tipo: "Leilao", Preco: 50, Lance: 120, Licitante: "bia"}
+
+	casos := []struct {
+		nome    string
+		anuncio models.Anuncio
+		filtro  models.FiltroMercado
+		bate    bool
+	}{
+		{"sem filtro", fixo, models.FiltroMercado{}, true},
+		{"classe em outra caixa", fixo, models.FiltroMercado{Classe: "pesado"}, true},
+		{"classe errada", fixo, models.FiltroMercado{Classe: "Leve"}, false},
+		{"raridade em outra caixa", fixo, models.FiltroMercado{Raridade: "EPICO"}, true},
+		{"raridade errada", fixo, models.FiltroMercado{Raridade: "Lendario"}, false},
+		{"tipo em outra caixa", semLance, models.FiltroMercado{Tipo: "leilao"}, true},
+		{"tipo errado", fixo, models.FiltroMercado{Tipo: "Leilao"}, false},
+		{"vendedor", fixo, models.FiltroMercado{Vendedor: "ana"}, true},
+		{"vendedor errado", fixo, models.FiltroMercado{Vendedor: "bia"}, false},
+		{"vida e ataque no limite", fixo, models.FiltroMercado{VidaMin: 200, AtaqueMin: 53}, true},
+		{"vida abaixo", fixo, models.FiltroMercado{VidaMin: 201}, false},
+		{"ataque abaixo", fixo, models.FiltroMercado{AtaqueMin: 54}, false},
+		{"preço fixo no limite", fixo, models.FiltroMercado{PrecoMax: 100}, true},
+		{"preço fixo acima", fixo, models.FiltroMercado{PrecoMax: 99}, false},
+		// no leilão vale o lance atual, ou o mínimo se ninguém deu lance
+		{"leilão sem lance usa o mínimo", semLance, models.FiltroMercado{PrecoMax: 50}, true},
+		{"leilão com lance usa o lance", comLance, models.FiltroMercado{PrecoMax: 100}, false},
+		{"leilão com lance dentro", comLance, models.FiltroMercado{PrecoMax: 120}, true},
+	}
+	for _, c := range casos {
+		if got := anuncioBateFiltro(c.anuncio, c.filtro); got != c.bate {
+			t.Errorf("%s: anuncioBateFiltro = %v, esperava %v", c.nome, got, c.bate)
+		}
+	}
+}
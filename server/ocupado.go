@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Jogador Ocupado (batalha ou troca) ---
+
+// A batalha e a troca rodam na memória do server host, então os outros servers (e o líder montando a
+// fila) n sabem quem ta ocupado. Por isso cada uma marca os dois jogadores no Redis
+// (ocupado:<jogador> -> id da batalha/troca) quando abre e desmarca quando fecha. A marca tem TTL:
+// a batalha renova a cada checkpoint e a troca n dura mais q o escrow, então se o host morrer ela some sozinha.
+const (
+	PrefixoOcupado = "ocupado:" // + id do jogador -> id da batalha ou da troca
+)
+
+var errJogadorOcupado = errors.New("Um dos jogadores já está em uma batalha ou troca")
+
+// scriptLiberarOcupado apaga a marca so se ela ainda for dessa atividade
+// (uma batalha velha terminando n pode soltar o jogador de uma troca nova).
+// KEYS[1] = marca do jogador
+// ARGV[1] = id da atividade
+// Retorna 1 = apagou, 0 = a marca é de outra atividade (ou n existe)
+var scriptLiberarOcupado = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('DEL', KEYS[1]) end
+return 0
+`)
+
+// scriptRenovarOcupado renova a marca da atividade (e marca de novo se ela venceu no meio, ex: na adoção)
+// KEYS[1] = marca do jogador
+// ARGV[1] = id da atividade, ARGV[2] = TTL (ms)
+// Retorna 1 = renovou, 0 = o jogador ta em outra atividade
+var scriptRenovarOcupado = redis.NewScript(`
+local atual = redis.call('GET', KEYS[1])
+if atual and atual ~= ARGV[1] then return 0 end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+func chaveOcupado(playerID string) string { return PrefixoOcupado + playerID }
+
+// ocuparJogadores marca os jogadores na atividade, tudo ou nada: se um ja ta ocupado, solta os q marcou
+func (s *Server) ocuparJogadores(atividade string, ttl time.Duration, jogadores ...string) error {
+	for i, id := range jogadores {
+		ok, err := s.redisClient.SetNX(s.ctx, chaveOcupado(id), atividade, ttl).Result()
+		if err != nil || !ok {
+			s.liberarJogadores(atividade, jogadores[:i]...)
+			if err != nil {
+				return err
+			}
+			return errJogadorOcupado
+		}
+	}
+	return nil
+}
+
+// renovarOcupado estica a marca dos jogadores enquanto a atividade continua
+func (s *Server) renovarOcupado(atividade string, ttl time.Duration, jogadores ...string) {
+	for _, id := range jogadores {
+		if err := scriptRenovarOcupado.Run(s.ctx, s.redisClient, []string{chaveOcupado(id)}, atividade, ttl.Milliseconds()).Err(); err != nil {
+			color.Red("OCUPADO: Falha ao renovar %s em %s: %v", id, atividade, err)
+		}
+	}
+}
+
+// liberarJogadores tira a marca da atividade dos jogadores
+func (s *Server) liberarJogadores(atividade string, jogadores ...string) {
+	for _, id := range jogadores {
+		if err := scriptLiberarOcupado.Run(s.ctx, s.redisClient, []string{chaveOcupado(id)}, atividade).Err(); err != nil {
+			color.Red("OCUPADO: Falha ao liberar %s de %s: %v (solta sozinho no TTL)", id, atividade, err)
+		}
+	}
+}
+
+// jogadorOcupado diz se o jogador ta numa batalha ou troca (em qualquer server)
+func (s *Server) jogadorOcupado(playerID string) bool {
+	return s.redisClient.Exists(s.ctx, chaveOcupado(playerID)).Val() > 0
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"errors"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Pares (Redis) ---
+
+// Quem ta pareado com quem fica num hash no Redis (jogador -> parceiro, nos dois sentidos), então
+// qualquer server confere se o "Batalhar"/"Trocar"/"Mensagem" é mesmo pro parceiro atual.
+// O par se desfaz com o "Desparear", qnd um dos dois conecta de novo (login ou reconexão, o cliente
+// começa sem parceiro) ou qnd o server de um deles morre. O outro lado sempre fica sabendo.
+const ChavePares = "pares"
+
+var (
+	errJaPareado       = errors.New("você já está pareado, use Desparear antes")
+	errParceiroOcupado = errors.New("esse jogador já está pareado com outro")
+	errNaoPareado      = errors.New("você não está pareado com esse jogador")
+)
+
+// scriptParear junta os dois se nenhum deles tiver outro parceiro.
+// KEYS[1] = pares, ARGV[1] = quem aceitou, ARGV[2] = quem convidou
+// Retorna 1 = ok, -1 = quem aceitou ja ta pareado, -2 = quem convidou ja ta pareado
+var scriptParear = redis.NewScript(`
+local a = redis.call('HGET', KEYS[1], ARGV[1])
+if a and a ~= ARGV[2] then return -1 end
+local b = redis.call('HGET', KEYS[1], ARGV[2])
+if b and b ~= ARGV[1] then return -2 end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// scriptDesparear desfaz o par do jogador e devolve o ex-parceiro (nil se n tinha)
+// KEYS[1] = pares, ARGV[1] = jogador
+var scriptDesparear = redis.NewScript(`
+local p = redis.call('HGET', KEYS[1], ARGV[1])
+if not p then return false end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if redis.call('HGET', KEYS[1], p) == ARGV[1] then
+	redis.call('HDEL', KEYS[1], p)
+end
+return p
+`)
+
+// parear grava o par (os dois lados)
+func (s *Server) parear(quemAceitou, quemConvidou string) error {
+	status, err := scriptParear.Run(s.ctx, s.redisClient, []string{ChavePares}, quemAceitou, quemConvidou).Int()
+	if err != nil {
+		return err
+	}
+	switch status {
+	case -1:
+		return errJaPareado
+	case -2:
+		return errParceiroOcupado
+	}
+	return nil
+}
+
+// parceiroDe devolve o parceiro atual do jogador ("" se n tiver)
+func (s *Server) parceiroDe(playerID string) string {
+	parceiro, _ := s.redisClient.HGet(s.ctx, ChavePares, playerID).Result()
+	return parceiro
+}
+
+// checarParceiro confere se o destinatário é o parceiro atual do remetente
+func (s *Server) checarParceiro(req models.ReqPessoalServidor) error {
+	if req.IdDestinatario == "" || s.parceiroDe(req.IdRemetente) != req.IdDestinatario {
+		return errNaoPareado
+	}
+	return nil
+}
+
+// desparear desfaz o par do jogador e avisa o ex-parceiro (com o 'tipo' e a msg dados).
+// Devolve o ex-parceiro ("" se n tinha).
+func (s *Server) desparear(playerID, tipo, mensagem string) string {
+	parceiro, err := scriptDesparear.Run(s.ctx, s.redisClient, []string{ChavePares}, playerID).Text()
+	if err != nil {
+		if err != redis.Nil {
+			color.Red("PARES: Falha ao desparear %s: %v", playerID, err)
+		}
+		return ""
+	}
+
+	color.Yellow("PARES: %s e %s despareados", playerID, parceiro)
+	if info, ok := s.infoJogador(parceiro); ok {
+		s.sendToClient(info.ReplyChannel, tipo, models.RespostaPareamento{
+			Mensagem:   fmt.Sprintf(mensagem, playerID),
+			IdParceiro: playerID,
+		})
+	}
+	return parceiro
+}
@@ -9,9 +9,31 @@ func (s *Server) setupRouter() *gin.Engine {
 	// gin.SetMode(gin.ReleaseMode) // Descomente para produção
 	r := gin.Default()
 
+	// toda rota exige a assinatura de outro servidor (ou o segredo, nas de admin). ver autenticacao.go
+	r.Use(s.autenticarCluster)
+
 	// Rota para eleição de líder e verificação de saúde
 	r.GET("/health", s.handleHealthCheck)
 
+	// Líder recém-eleito -> Todos: anúncio do coordenador (com o termo)
+	r.POST("/election/coordinator", s.handleLeaderAnnounce)
+
+	// Entrada e saída de servidores do cluster (ver membership.go)
+	clusterGroup := r.Group("/cluster")
+	{
+		// Servidor novo -> Todos: "acabei de entrar"
+		clusterGroup.POST("/join", s.handleClusterJoin)
+
+		// Servidor saindo -> Todos: "to saindo" (ou Admin -> Servidor: "saia")
+		clusterGroup.POST("/leave", s.handleClusterLeave)
+	}
+
+	// Catálogo de cartas (ver catalogo.go)
+	// Admin -> Servidor: relê o CATALOGO_PATH e publica pro cluster
+	r.POST("/admin/catalogo/reload", s.handleCatalogoReload)
+	// Servidor -> Todos: tem catálogo novo no Redis
+	r.POST("/catalogo/update", s.handleCatalogoUpdate)
+
 	// #################################################
 	// # Rotas de Sincronização (Líder e Seguidores)
 	// #################################################
@@ -24,8 +46,15 @@ func (s *Server) setupRouter() *gin.Engine {
 
 		// Líder -> Seguidor: Notifica seguidores sobre a lista atualizada
 		playerGroup.POST("/update", s.handlePlayerUpdate)
+
+		// Admin/Servidor -> Servidor: Rating e histórico de partidas (ver historico.go)
+		playerGroup.GET("/:id/rating", s.handlePlayerRating)
+		playerGroup.GET("/:id/history", s.handlePlayerHistory)
 	}
 
+	// Admin/Servidor -> Servidor: Uma partida do histórico (decks e turnos)
+	r.GET("/matches/:id", s.handleMatch)
+
 	// Rotas para gerenciamento de cartas (compra)
 	cardGroup := r.Group("/cards")
 	{
@@ -47,6 +76,9 @@ func (s *Server) setupRouter() *gin.Engine {
 	// Estas rotas são usadas para a comunicação entre S1 (Host) e S2 (Peer)
 	battleGroup := r.Group("/battle")
 	{
+		// Líder -> S1 (Host): Começa uma partida montada pela fila (o J1 ta no S1)
+		battleGroup.POST("/start", s.handleBattleStart)
+
 		// S1 (Host) -> S2 (Peer): Inicia uma batalha
 		battleGroup.POST("/initiate", s.handleBattleInitiate)
 
@@ -73,14 +105,14 @@ func (s *Server) setupRouter() *gin.Engine {
 		// S1 (Host) -> S2 (Peer): Inicia uma troca
 		tradeGroup.POST("/initiate", s.handleTradeInitiate)
 
-		// S1 (Host) -> S2 (Peer): Pede a carta do J2
-		tradeGroup.POST("/request_card", s.handleTradeRequestCard)
+		// S1 (Host) -> S2 (Peer): Manda o estado da negociação (ou um erro) pro J2
+		tradeGroup.POST("/update", s.handleTradeUpdate)
 
 		// S1 (Host) -> S2 (Peer): Informa o resultado da troca
-		tradeGroup.POST("/trade_result", s.handleTradeResult)
+		tradeGroup.POST("/result", s.handleTradeResult)
 
-		// S2 (Peer) -> S1 (Host): Envia a carta do J2
-		tradeGroup.POST("/submit_card", s.handleTradeSubmitCard)
+		// S2 (Peer) -> S1 (Host): Envia a ação do J2 (ofertar, confirmar, cancelar)
+		tradeGroup.POST("/action", s.handleTradeAction)
 	}
 
 	return r
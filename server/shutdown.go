@@ -0,0 +1,112 @@
+package main
+
+import (
+	"PlanoZ/models"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// --- Desligamento Limpo (SIGTERM) ---
+
+const (
+	ShutdownTimeoutPadrao = 30 * time.Second // SHUTDOWN_TIMEOUT
+	IntervaloBLPop        = 1 * time.Second  // timeout do blpop dos topicos globais
+)
+
+// esperarSinalDesligar trava ate o SIGTERM (docker stop / systemd) ou SIGINT (ctrl+c)
+// e ai desliga o server de forma limpa
+func (s *Server) esperarSinalDesligar() {
+	sinais := make(chan os.Signal, 1)
+	signal.Notify(sinais, syscall.SIGTERM, syscall.SIGINT)
+	sinal := <-sinais
+
+	color.Yellow("\nSinal %v recebido. Desligando %s...", sinal, s.ID)
+	s.desligar(envDuration("SHUTDOWN_TIMEOUT", ShutdownTimeoutPadrao))
+	os.Exit(0)
+}
+
+// desligar segue a ordem:
+//  1. para de pegar 'conectar' e recusa batalha/troca nova
+//  2. espera as batalhas e trocas em andamento acabarem (ate o timeout)
+//  3. sai do cluster (avisa os outros servers, solta a liderança)
+//  4. manda os clientes conectados aqui se reconectarem em outro server
+func (s *Server) desligar(timeout time.Duration) {
+	s.drenando.Store(true)
+
+	if !s.esperarPartidasTerminarem(timeout) {
+		color.Red("Timeout de %v: encerrando com batalhas/trocas ainda abertas", timeout)
+	}
+
+	s.sairDoCluster() // (do membership.go)
+	s.avisarClientesReconectar()
+
+	color.Green("Servidor %s desligado.", s.ID)
+}
+
+// esperarPartidasTerminarem espera os mapas de batalha/troca (host e peer) esvaziarem.
+// retorna false se o tempo acabou antes
+func (s *Server) esperarPartidasTerminarem(timeout time.Duration) bool {
+	limite := time.Now().Add(timeout)
+	ultimoLog := time.Time{}
+
+	for {
+		abertas := s.partidasEmAndamento()
+		if abertas == 0 {
+			return true
+		}
+		if time.Now().After(limite) {
+			return false
+		}
+		if time.Since(ultimoLog) >= 5*time.Second {
+			color.Yellow("Aguardando %d batalha(s)/troca(s) terminarem...", abertas)
+			ultimoLog = time.Now()
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// partidasEmAndamento conta as batalhas e trocas em q este server ta envolvido
+func (s *Server) partidasEmAndamento() int {
+	total := 0
+
+	s.muBatalhas.RLock()
+	total += len(s.batalhas)
+	s.muBatalhas.RUnlock()
+
+	s.muBatalhasPeer.RLock()
+	total += len(s.batalhasPeer)
+	s.muBatalhasPeer.RUnlock()
+
+	s.muTrades.RLock()
+	total += len(s.trades)
+	s.muTrades.RUnlock()
+
+	s.muTradesPeer.RLock()
+	total += len(s.tradesPeer)
+	s.muTradesPeer.RUnlock()
+
+	return total
+}
+
+// avisarClientesReconectar manda "Reconectar" pra todo jogador conectado neste server
+func (s *Server) avisarClientesReconectar() {
+	s.muPlayers.RLock()
+	canais := []string{}
+	for _, info := range s.playerList {
+		if info.ServerID == s.ID {
+			canais = append(canais, info.ReplyChannel)
+		}
+	}
+	s.muPlayers.RUnlock()
+
+	for _, canal := range canais {
+		s.sendToClient(canal, "Reconectar", models.RespostaReconectar{
+			Mensagem: "O servidor " + s.ID + " está desligando. Conectando em outro servidor...",
+		})
+	}
+	color.Yellow("%d cliente(s) avisados para reconectar", len(canais))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// --- Inicialização (prontidão do Redis e quórum) ---
+
+// configs padrao da inicializacao (podem ser trocadas por env)
+const (
+	RedisProntoTimeoutPadrao = 60 * time.Second // REDIS_READY_TIMEOUT
+	QuorumTimeoutPadrao      = 30 * time.Second // QUORUM_TIMEOUT
+	IntervaloProntidao       = 1 * time.Second
+)
+
+// esperarRedisPronto espera o cluster redis responder e ficar com cluster_state:ok.
+// Substitui a pausa fixa: o 'redis-cluster-init' pode demorar mais (ou menos) pra montar o cluster,
+// e se a gnt tentar ler (blpop) antes disso, da o erro 'clusterdown'.
+func (s *Server) esperarRedisPronto(timeout time.Duration) error {
+	color.Yellow("Aguardando o cluster Redis ficar pronto (timeout %v)...", timeout)
+	limite := time.Now().Add(timeout)
+
+	var ultimoErro error
+	for time.Now().Before(limite) {
+		info, err := s.redisClient.ClusterInfo(s.ctx).Result()
+		if err == nil && strings.Contains(info, "cluster_state:ok") {
+			color.Green("Cluster Redis pronto (cluster_state:ok)")
+			return nil
+		}
+		if err != nil {
+			ultimoErro = err
+		} else {
+			ultimoErro = fmt.Errorf("cluster_state ainda não está ok")
+		}
+		time.Sleep(IntervaloProntidao)
+	}
+	return fmt.Errorf("cluster Redis não ficou pronto em %v: %v", timeout, ultimoErro)
+}
+
+// esperarQuorum espera até 'minimo' servidores (contando este) responderem ao /health.
+// Se o tempo acabar, segue assim mesmo: um server sozinho ainda tem q conseguir subir,
+// e quem chegar depois entra pelo membership.
+func (s *Server) esperarQuorum(minimo int, timeout time.Duration) {
+	color.Yellow("Aguardando quórum de %d servidor(es) (timeout %v)...", minimo, timeout)
+	limite := time.Now().Add(timeout)
+
+	for {
+		vivos := s.contarServidoresVivos()
+		if vivos >= minimo {
+			color.Green("Quórum atingido: %d/%d servidores respondendo", vivos, len(s.membros()))
+			return
+		}
+		if time.Now().After(limite) {
+			color.Red("Quórum NÃO atingido (%d de %d). Seguindo assim mesmo.", vivos, minimo)
+			return
+		}
+		time.Sleep(IntervaloProntidao)
+	}
+}
+
+// contarServidoresVivos faz um health check em todos os membros conhecidos
+func (s *Server) contarServidoresVivos() int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	vivos := 0
+
+	for _, host := range s.membros() {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			if _, ok := s.checkServerHealth(host); ok {
+				mu.Lock()
+				vivos++
+				mu.Unlock()
+			}
+		}(host)
+	}
+	wg.Wait()
+	return vivos
+}
+
+// esperarLiberacao decide quando comecar a eleicao.
+// No modo interativo espera o admin dar ENTER; com HEADLESS=true (systemd/compose sem tty)
+// espera o quórum de servidores.
+func (s *Server) esperarLiberacao() {
+	if !envBool("HEADLESS") {
+		color.Cyan("Pressione ENTER para iniciar a eleição de líder e os health checks...")
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		return
+	}
+
+	// padrao: maioria dos servers do SERVER_LIST
+	minimo := len(s.membros())/2 + 1
+	if v := getEnv("QUORUM_MIN", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			panic(fmt.Sprintf("QUORUM_MIN inválido: %s", v))
+		}
+		minimo = n
+	}
+	s.esperarQuorum(minimo, envDuration("QUORUM_TIMEOUT", QuorumTimeoutPadrao))
+}
+
+// (Helper: Ler Env Var como bool, ex: HEADLESS=true / 1)
+func envBool(key string) bool {
+	v, err := strconv.ParseBool(getEnv(key, "false"))
+	return err == nil && v
+}
+
+// (Helper: Ler Env Var como duração, ex: 30s, 2m)
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := getEnv(key, "")
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		panic(fmt.Sprintf("%s inválido: %s", key, v))
+	}
+	return d
+}
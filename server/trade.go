@@ -2,25 +2,51 @@ package main
 
 import (
 	"PlanoZ/models"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/redis/go-redis/v9"
 )
 
 // --- Lógica da Troca (Distribuída) ---
 
+// A troca é uma negociação: os dois veem as duas ofertas ("Oferta_Troca") e cada um muda a sua quantas
+// vezes quiser (várias cartas e/ou créditos). Toda mudança sobe a versão, então a troca só sai qnd os dois
+// mandam "Confirmar" pra mesma versão, ou seja, pro q os dois viram por último.
+// Quem processa as ações é a goroutine da troca no host, uma de cada vez (pelo t.Acoes).
+const (
+	PrazoNegociacao = 90 * time.Second // tempo total pra negociar, dps disso a troca cai
+	MaxCartasOferta = 10               // cartas por oferta
+)
+
+var (
+	errOfertaMudou       = errors.New("a oferta mudou, confira a versão nova antes de confirmar")
+	errOfertaVazia       = errors.New("as duas ofertas estão vazias")
+	errCreditosInvalidos = errors.New("quantidade de créditos inválida")
+	errCartaRepetida     = errors.New("carta repetida na oferta")
+	errMuitasCartas      = fmt.Errorf("no máximo %d cartas por oferta", MaxCartasOferta)
+	errAcaoTroca         = errors.New("ação de troca inválida")
+)
+
+// errosTroca são os erros de uma ação q voltam pro jogador como estão (o resto é falha do Redis)
+var errosTroca = []error{errOfertaMudou, errOfertaVazia, errCreditosInvalidos, errCartaRepetida, errMuitasCartas, errAcaoTroca,
+	errCartaNaoEhSua, errCartaEmEscrow, errTrocaEncerrada, errSaldoInsuficiente}
+
+// negociacao é o estado da troca. So a goroutine da troca mexe nela, então n precisa de lock.
+type negociacao struct {
+	versao    int
+	ofertas   map[string]*models.OfertaTroca // jogador -> o q ele ta dando
+	confirmou map[string]int                 // jogador -> versão q ele confirmou
+}
+
 // iniciarTroca é a goroutine principal que gerencia o estado de uma única troca.
 // Ela é o "Host" (S1).
 func (s *Server) iniciarTroca(tradeID string, t *models.Troca, canalRespostaJ1 string) {
 	color.Yellow("TROCA (Host J1): Iniciando goroutine da troca %s (%s vs %s)", tradeID, t.Jogador1, t.Jogador2)
 
-	// Pegar informações do J2 (necessário para enviar respostas)
-	s.muPlayers.RLock()
-	infoJ2, okJ2 := s.playerList[t.Jogador2]
-	s.muPlayers.RUnlock()
-
-	if !okJ2 {
+	if _, okJ2 := s.infoJogador(t.Jogador2); !okJ2 {
 		s.encerrarTroca(tradeID, "J2 desconectou antes do início")
 		return
 	}
@@ -32,103 +58,209 @@ func (s *Server) iniciarTroca(tradeID string, t *models.Troca, canalRespostaJ1 s
 	}
 	s.sendToClient(canalRespostaJ1, "Inicio_Troca", respInicioJ1)
 
-	isSelfTest := t.ServidorJ1 == t.ServidorJ2
-
-	// Verifica se a goroutine foi instruída a parar (ex: por desconexão)
-	select {
-	case <-t.CanalEncerra:
-		color.Red("TROCA %s: Encerrada à força (via CanalEncerra)", tradeID)
-		return // limparTroca já foi (ou será) chamado
-	default:
+	// Começa com as duas ofertas vazias (versão 1) e manda pros dois
+	neg := &negociacao{
+		versao: 1,
+		ofertas: map[string]*models.OfertaTroca{
+			t.Jogador1: {IdJogador: t.Jogador1, Cartas: []models.Tanque{}},
+			t.Jogador2: {IdJogador: t.Jogador2, Cartas: []models.Tanque{}},
+		},
+		confirmou: make(map[string]int),
 	}
+	s.avisarTroca(tradeID, t, neg, "Troca aberta: ofertem cartas e/ou créditos e confirmem")
 
-	// --- Pedir Cartas (Simultaneamente) ---
-
-	// 1. Pede a carta ao J1 (via Redis)
-	s.sendToClient(canalRespostaJ1, "Pedir_Carta_Troca", models.RespostaPedirCartaTroca{IdTroca: tradeID})
+	prazo := time.After(PrazoNegociacao)
+	for {
+		select {
+		case <-t.CanalEncerra:
+			color.Red("TROCA %s: Encerrada à força (via CanalEncerra)", tradeID)
+			return // limparTroca já foi (ou será) chamado
 
-	// 2. Pede a carta ao J2 (Remoto ou Local)
-	if isSelfTest {
-		// Pede a carta ao J2 localmente (via Redis)
-		s.sendToClient(infoJ2.ReplyChannel, "Pedir_Carta_Troca", models.RespostaPedirCartaTroca{IdTroca: tradeID})
-	} else {
-		// Pede a carta ao J2 remotamente (via API para S2)
-		reqCard := models.TradeRequestCardRequest{IdTroca: tradeID}
-		if err := s.sendToHost(infoJ2.ServerHost, "/trade/request_card", reqCard); err != nil {
-			s.encerrarTroca(tradeID, "Falha de rede ao pedir carta J2")
+		case <-prazo:
+			s.encerrarTroca(tradeID, "Tempo de negociação esgotado")
 			return
+
+		case acao := <-t.Acoes:
+			if _, ok := neg.ofertas[acao.IdJogador]; !ok {
+				continue // n é nenhum dos dois (os handlers ja filtram, mas vai q)
+			}
+
+			switch acao.Acao {
+			case "Ofertar":
+				if err := s.mudarOferta(tradeID, neg, acao); err != nil {
+					s.recusarAcaoTroca(tradeID, t, acao, err)
+					continue
+				}
+				color.Cyan("TROCA %s: %s mudou a oferta (versão %d)", tradeID, acao.IdJogador, neg.versao)
+				s.avisarTroca(tradeID, t, neg, fmt.Sprintf("%s mudou a oferta", acao.IdJogador))
+
+			case "Confirmar":
+				if acao.Versao != neg.versao {
+					s.recusarAcaoTroca(tradeID, t, acao, errOfertaMudou)
+					continue
+				}
+				if ofertaVazia(neg.ofertas[t.Jogador1]) && ofertaVazia(neg.ofertas[t.Jogador2]) {
+					s.recusarAcaoTroca(tradeID, t, acao, errOfertaVazia)
+					continue
+				}
+				neg.confirmou[acao.IdJogador] = neg.versao
+				if neg.confirmou[t.Jogador1] == neg.versao && neg.confirmou[t.Jogador2] == neg.versao {
+					s.consumarTroca(tradeID, t, neg)
+					return
+				}
+				s.avisarTroca(tradeID, t, neg, fmt.Sprintf("%s confirmou a versão %d", acao.IdJogador, neg.versao))
+
+			case "Cancelar":
+				s.encerrarTroca(tradeID, fmt.Sprintf("%s desistiu", acao.IdJogador))
+				return
+
+			default:
+				s.recusarAcaoTroca(tradeID, t, acao, errAcaoTroca)
+			}
 		}
 	}
+}
 
-	// --- Esperar Cartas ---
+// mudarOferta troca a oferta do jogador pela nova: confere as cartas e o saldo, prende as cartas
+// no escrow (e solta as q saíram) e sobe a versão. Se algo n bater, a oferta anterior continua valendo.
+func (s *Server) mudarOferta(tradeID string, neg *negociacao, acao models.AcaoTroca) error {
+	if acao.Creditos < 0 {
+		return errCreditosInvalidos
+	}
+	if len(acao.IdsCartas) > MaxCartasOferta {
+		return errMuitasCartas
+	}
 
-	// 3. Espera a carta de J1
-	// (enviada por processReqCartaTroca)
-	carta1, ok1 := s.esperarCartaTroca(t.CanalJ1, 30*time.Second) // Timeout de 30s
-	if !ok1 {
-		s.encerrarTroca(tradeID, "Timeout J1 (jogador demorou a escolher a carta)")
-		return
+	cartas := make([]models.Tanque, 0, len(acao.IdsCartas))
+	vistas := make(map[string]bool)
+	for _, id := range acao.IdsCartas {
+		if vistas[id] {
+			return errCartaRepetida
+		}
+		vistas[id] = true
+		carta, ok, err := s.buscarCartaJogador(acao.IdJogador, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errCartaNaoEhSua
+		}
+		cartas = append(cartas, carta)
+	}
+
+	// o saldo so é conferido de vdd no commit (escrow.go), aqui é pra n deixar ofertar o q n tem
+	if acao.Creditos > 0 {
+		saldo, err := s.redisClient.Get(s.ctx, chaveCarteira(acao.IdJogador)).Int()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if saldo < acao.Creditos {
+			return errSaldoInsuficiente
+		}
+	}
+
+	if err := s.reservarCartasTroca(tradeID, acao.IdJogador, acao.IdsCartas); err != nil {
+		return err
+	}
+	neg.ofertas[acao.IdJogador] = &models.OfertaTroca{
+		IdJogador: acao.IdJogador,
+		Cartas:    cartas,
+		Creditos:  acao.Creditos,
 	}
+	neg.versao++
+	return nil
+}
 
-	// 4. Espera a carta de J2
-	// (enviada por handleTradeSubmitCard ou processReqCartaTroca)
-	carta2, ok2 := s.esperarCartaTroca(t.CanalJ2, 30*time.Second) // Timeout de 30s
-	if !ok2 {
-		s.encerrarTroca(tradeID, "Timeout J2 (jogador demorou a escolher a carta)")
+// consumarTroca fecha a troca com as ofertas q os dois confirmaram (fase 2, escrow.go)
+func (s *Server) consumarTroca(tradeID string, t *models.Troca, neg *negociacao) {
+	oferta1, oferta2 := *neg.ofertas[t.Jogador1], *neg.ofertas[t.Jogador2]
+	color.Green("TROCA (Host J1): %s confirmada na versão %d. J1 dá %d carta(s) + %d créditos, J2 dá %d carta(s) + %d créditos",
+		tradeID, neg.versao, len(oferta1.Cartas), oferta1.Creditos, len(oferta2.Cartas), oferta2.Creditos)
+
+	// Move tudo num script só. Se algo n bater (escrow vencido, carta sumiu, saldo), nada é alterado e a troca é cancelada.
+	resJ1, resJ2, err := s.concluirTroca(tradeID, oferta1, oferta2)
+	if err != nil {
+		s.encerrarTroca(tradeID, fmt.Sprintf("Falha ao concluir (%v)", err))
 		return
 	}
 
-	// --- Consumar a Troca ---
-	color.Green("TROCA (Host J1): Cartas recebidas para %s. J1 enviou '%s', J2 enviou '%s'", tradeID, carta1.Modelo, carta2.Modelo)
+	// 1 e 2. Notifica J1 e J2 (o cliente tira as cartas q deu e bota as q recebeu).
+	// Os resultados ja tão pendentes no Redis, entao quem n receber agora recebe do server dele (escrow.go)
+	s.avisarResultadoTroca(t.Jogador1, resJ1)
+	go s.avisarResultadoTroca(t.Jogador2, resJ2)
 
-	// 1. Notifica J1 (Local) sobre a carta que ele recebeu (Carta de J2)
-	// O cliente J1, ao receber isso, deve atualizar seu inventário:
-	// REMOVE carta1, ADICIONA carta2
-	respJ1 := models.RespostaResultadoTroca{
-		Mensagem:      fmt.Sprintf("Troca com %s concluída!", t.Jogador2),
-		CartaRecebida: *carta2, // J1 recebe a carta de J2
+	// 3. Limpa a troca do Host (S1)
+	s.limparTroca(tradeID)
+}
+
+// avisarTroca manda o estado atual da negociação pros dois jogadores
+func (s *Server) avisarTroca(tradeID string, t *models.Troca, neg *negociacao, mensagem string) {
+	estado := models.RespostaOfertaTroca{
+		IdTroca:     tradeID,
+		Versao:      neg.versao,
+		Ofertas:     []models.OfertaTroca{*neg.ofertas[t.Jogador1], *neg.ofertas[t.Jogador2]},
+		Confirmados: []string{},
+		Mensagem:    mensagem,
 	}
-	s.sendToClient(canalRespostaJ1, "Resultado_Troca", respJ1)
+	for _, jogador := range []string{t.Jogador1, t.Jogador2} {
+		if neg.confirmou[jogador] == neg.versao {
+			estado.Confirmados = append(estado.Confirmados, jogador)
+		}
+	}
+	s.avisarJogadorTroca(tradeID, t, t.Jogador1, estado, "")
+	s.avisarJogadorTroca(tradeID, t, t.Jogador2, estado, "")
+}
 
-	// 2. Notifica J2 (Remoto ou Local) sobre a carta que ele recebeu (Carta de J1)
-	// O cliente J2, ao receber isso, deve atualizar seu inventário:
-	// REMOVE carta2, ADICIONA carta1
-	if isSelfTest {
-		respJ2 := models.RespostaResultadoTroca{
-			Mensagem:      fmt.Sprintf("Troca com %s concluída!", t.Jogador1),
-			CartaRecebida: *carta1, // J2 recebe a carta de J1
+// recusarAcaoTroca avisa so quem mandou a ação q ela n valeu (a negociação continua)
+func (s *Server) recusarAcaoTroca(tradeID string, t *models.Troca, acao models.AcaoTroca, err error) {
+	mensagem := "Falha ao processar a ação, tente de novo"
+	for _, conhecido := range errosTroca {
+		if err == conhecido {
+			mensagem = err.Error()
+			break
 		}
-		s.sendToClient(infoJ2.ReplyChannel, "Resultado_Troca", respJ2)
-	} else {
-		// Envia para o Servidor S2, que chama o handleTradeResult
-		reqResult := models.TradeResultRequest{
-			IdTroca:       tradeID,
-			CartaRecebida: *carta1, // S2/J2 recebe a carta de J1
+	}
+	color.Yellow("TROCA %s: %s de %s recusado: %v", tradeID, acao.Acao, acao.IdJogador, err)
+	s.avisarJogadorTroca(tradeID, t, acao.IdJogador, models.RespostaOfertaTroca{IdTroca: tradeID}, mensagem)
+}
+
+// avisarJogadorTroca manda o estado da negociação (ou so um erro, se 'erro' != "") pra um dos jogadores.
+// O J1 é sempre daqui; o J2 pode tar em outro server (aí vai pelo /trade/update).
+func (s *Server) avisarJogadorTroca(tradeID string, t *models.Troca, playerID string, estado models.RespostaOfertaTroca, erro string) {
+	if playerID == t.Jogador2 && t.ServidorJ1 != t.ServidorJ2 {
+		req := models.TradeUpdateRequest{IdTroca: tradeID, IdJogador: playerID, Oferta: estado, Erro: erro}
+		if err := s.sendToHost(t.ServidorJ2, "/trade/update", req); err != nil {
+			color.Red("TROCA %s: Falha ao avisar J2 (%s): %v", tradeID, playerID, err)
 		}
-		// O handleTradeResult em S2 irá notificar o cliente J2 e limpará a troca em S2
-		s.sendToHost(infoJ2.ServerHost, "/trade/result", reqResult)
+		return
 	}
+	if info, ok := s.infoJogador(playerID); ok {
+		s.mostrarTroca(info.ReplyChannel, estado, erro)
+	}
+}
 
-	// 3. Limpa a troca do Host (S1)
-	s.limparTroca(tradeID)
+// mostrarTroca entrega o estado da negociação (ou o erro) pro cliente
+func (s *Server) mostrarTroca(canal string, estado models.RespostaOfertaTroca, erro string) {
+	if erro != "" {
+		s.sendToClient(canal, "Erro", models.RespostaErro{Erro: erro})
+		return
+	}
+	s.sendToClient(canal, "Oferta_Troca", estado)
 }
 
-// esperarCarta bloqueia até uma carta chegar no canal ou o timeout estourar.
-// (Esta função é idêntica à de battle.go)
-func (s *Server) esperarCartaTroca(canal chan models.Tanque, tempo time.Duration) (*models.Tanque, bool) {
-	timeout := time.After(tempo)
+// entregarAcaoTroca joga a ação na fila da goroutine da troca (se ela ainda tiver rodando)
+func (s *Server) entregarAcaoTroca(t *models.Troca, acao models.AcaoTroca, espera time.Duration) error {
 	select {
-	case c, ok := <-canal:
-		if !ok {
-			return nil, false // Canal foi fechado
-		}
-		return &c, true
-	case <-timeout:
-		return nil, false
+	case t.Acoes <- acao:
+		return nil
+	case <-t.CanalEncerra:
+		return errTrocaEncerrada
+	case <-time.After(espera):
+		return fmt.Errorf("timeout ao entregar a ação (fila cheia?)")
 	}
 }
 
-// encerrarTroca é chamada APENAS EM CASO DE FALHA (ex: timeout, desconexão).
+// encerrarTroca é chamada APENAS EM CASO DE FALHA (ex: timeout, desistência, desconexão).
 // Ela limpa a troca e notifica os jogadores sobre o cancelamento.
 func (s *Server) encerrarTroca(tradeID string, motivo string) {
 	// 1. Remove a troca do mapa (impede novas ações)
@@ -139,41 +271,23 @@ func (s *Server) encerrarTroca(tradeID string, motivo string) {
 
 	color.Red("TROCA %s: Encerrada por FALHA. Motivo: %s", tradeID, motivo)
 
-	// 2. Prepara a notificação de Erro
-	respErro := models.RespostaErro{
-		Erro: fmt.Sprintf("Troca cancelada: %s", motivo),
-	}
-
-	// 3. Notifica os jogadores
-	s.muPlayers.RLock()
-	infoJ1, okJ1 := s.playerList[t.Jogador1]
-	infoJ2, okJ2 := s.playerList[t.Jogador2]
-	s.muPlayers.RUnlock()
-
-	// 4. Notifica J1 (Local)
-	if okJ1 {
-		s.sendToClient(infoJ1.ReplyChannel, "Erro", respErro)
-	}
-
-	// 5. Notifica J2 (Remoto ou Local)
-	if okJ2 {
-		if t.ServidorJ1 == t.ServidorJ2 { // Self-test
-			s.sendToClient(infoJ2.ReplyChannel, "Erro", respErro)
-		} else {
-			// Notifica o Servidor J2 para ele notificar o J2
-			// Usamos o handleTradeResult, mas enviamos uma carta "vazia"
-			// O handler S2 (handleTradeResult) deve limpar sua referência 'tradesPeer'
-			// e notificar seu cliente J2 sobre o erro.
-			reqEnd := models.TradeResultRequest{
-				IdTroca:       tradeID,
-				CartaRecebida: models.Tanque{}, // Envia uma carta vazia para indicar falha
-			}
-			s.sendToHost(infoJ2.ServerHost, "/trade/result", reqEnd) // Ignora erro aqui
-		}
+	// Rollback (escrow.go): solta as cartas reservadas e deixa o cancelamento pendente pros dois.
+	// Se a troca ja tinha sido concluída no Redis, n tem o q desfazer nem cancelamento pra avisar.
+	cancelamento := resultadoCancelamento(tradeID, motivo)
+	cancelada, err := s.cancelarTroca(tradeID, t.Jogador1, t.Jogador2, cancelamento)
+	if err != nil {
+		color.Red("TROCA %s: Falha no rollback do escrow (as cartas soltam no fim do prazo): %v", tradeID, err)
+	} else if !cancelada {
+		color.Yellow("TROCA %s: Já estava concluída no Redis, nada a desfazer", tradeID)
+		return
 	}
+
+	// 2 e 3. Notifica J1 (Local) e J2 (Remoto ou Local)
+	s.avisarResultadoTroca(t.Jogador1, cancelamento)
+	go s.avisarResultadoTroca(t.Jogador2, cancelamento)
 }
 
-// limparTroca remove a troca do mapa e fecha os canais.
+// limparTroca remove a troca do mapa e avisa a goroutine pra parar.
 // Esta é a função de limpeza interna do Host (S1).
 func (s *Server) limparTroca(tradeID string) (*models.Troca, bool) {
 	// 1. Remove a troca do mapa
@@ -186,15 +300,25 @@ func (s *Server) limparTroca(tradeID string) (*models.Troca, bool) {
 	delete(s.trades, tradeID)
 	s.muTrades.Unlock()
 
-	// 2. Fecha os canais (sinaliza para a goroutine parar, se estiver presa)
+	// 2. Fecha o CanalEncerra (sinaliza para a goroutine parar, se estiver presa).
+	// O t.Acoes fica aberto: algum handler pode tar mandando uma ação agora (ele vê o CanalEncerra fechado).
 	select {
 	case troca.CanalEncerra <- true:
 	default:
 	}
 	close(troca.CanalEncerra)
-	close(troca.CanalJ1)
-	close(troca.CanalJ2)
+	s.liberarJogadores(tradeID, troca.Jogador1, troca.Jogador2) // (do ocupado.go)
 
 	color.Yellow("TROCA (Host J1): Troca %s limpa do mapa e canais fechados.", tradeID)
 	return troca, true
 }
+
+func ofertaVazia(o *models.OfertaTroca) bool { return len(o.Cartas) == 0 && o.Creditos == 0 }
+
+func idsDasCartas(cartas []models.Tanque) []string {
+	ids := make([]string, 0, len(cartas))
+	for _, c := range cartas {
+		ids = append(ids, c.Id)
+	}
+	return ids
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
+	"PlanoZ/assinatura"
 	"PlanoZ/models"
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
@@ -37,31 +37,27 @@ func (s *Server) lidarPing(conn *net.UDPConn) {
 	}
 }
 
-// sortearCartas (Original)
-func (s *Server) sortearCartas(playerID string) []models.Tanque {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	n := len(pacote_1)
-	indices := r.Perm(n)[:5]
-	cartasSorteadas := make([]models.Tanque, 0, 5)
-
-	for _, i := range indices {
-		cartasSorteadas = append(cartasSorteadas, pacote_1[i])
-	}
-	for i := range cartasSorteadas {
-		cartasSorteadas[i].Id_jogador = playerID
-	}
-	return cartasSorteadas
-}
-
 // --- Helpers de Comunicação ---
 
 // (Helper: Enviar para Cliente via Redis)
 func (s *Server) sendToClient(replyChannel, tipo string, data interface{}) {
+	// assina com a chave da sessão do canal, pro cliente saber q veio mesmo de um servidor
+	s.sendToClientComChave(replyChannel, s.chaveDoCanal(replyChannel), tipo, data)
+}
+
+// sendToClientComChave é o sendToClient com a chave escolhida por quem chama
+// (resposta do login, q vai antes do cliente ter a chave da sessão)
+func (s *Server) sendToClientComChave(replyChannel, chave, tipo string, data interface{}) {
 	resp := models.RespostaGenericaCliente{
 		Tipo: tipo,
 		Data: data,
 	}
-	respBytes, err := json.Marshal(resp)
+	env, err := assinatura.Envelopar(chave, "", resp, time.Now())
+	if err != nil {
+		color.Red("Erro ao montar envelope para %s: %v", replyChannel, err)
+		return
+	}
+	respBytes, err := json.Marshal(env)
 	if err != nil {
 		color.Red("Erro ao serializar resposta para %s: %v", replyChannel, err)
 		return
@@ -71,6 +67,10 @@ func (s *Server) sendToClient(replyChannel, tipo string, data interface{}) {
 	}
 }
 
+// errClienteAvisado indica q o outro server recusou o pedido mas ja mandou o erro pro cliente
+// (ele responde com "cliente_avisado": true), então quem pediu n deve avisar de novo
+var errClienteAvisado = errors.New("o servidor já avisou o cliente")
+
 // (Helper: Enviar para outro Servidor via API)
 func (s *Server) sendToHost(host, endpoint string, payload interface{}) error {
 	// Não envia para si mesmo (evita deadlock)
@@ -84,8 +84,8 @@ func (s *Server) sendToHost(host, endpoint string, payload interface{}) error {
 		return err
 	}
 
-	url := fmt.Sprintf("http://%s%s", host, endpoint)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	// vai assinado com o CLUSTER_SECRET (ver autenticacao.go)
+	req, nonce, err := s.novaRequisicao("POST", host, endpoint, jsonData)
 	if err != nil {
 		return err
 	}
@@ -97,7 +97,17 @@ func (s *Server) sendToHost(host, endpoint string, payload interface{}) error {
 	}
 	defer resp.Body.Close()
 
+	corpo, err := s.conferirResposta(resp, nonce)
+	if err != nil {
+		return fmt.Errorf("servidor %s: %v", host, err)
+	}
 	if resp.StatusCode != http.StatusOK {
+		var recusa struct {
+			ClienteAvisado bool `json:"cliente_avisado"`
+		}
+		if json.Unmarshal(corpo, &recusa) == nil && recusa.ClienteAvisado {
+			return fmt.Errorf("servidor %s respondeu com status %d: %w", host, resp.StatusCode, errClienteAvisado)
+		}
 		return fmt.Errorf("servidor %s respondeu com status %d", host, resp.StatusCode)
 	}
 	return nil
@@ -113,7 +123,7 @@ func (s *Server) sendToLeader(endpoint string, payload interface{}) error {
 		return fmt.Errorf("líder ainda não eleito")
 	}
 
-	leaderHost, ok := s.serverList[leaderID]
+	leaderHost, ok := s.hostDe(leaderID)
 	if !ok {
 		return fmt.Errorf("líder %s desconhecido ou offline", leaderID)
 	}
@@ -142,7 +152,10 @@ func (s *Server) broadcastToServers(endpoint string, payload interface{}) {
 		if id == s.ID { // Não envia para si mesmo
 			continue
 		}
-		host := s.serverList[id]
+		host, ok := s.hostDe(id)
+		if !ok {
+			continue // saiu do cluster
+		}
 
 		go func(h, e string, p interface{}) {
 			if err := s.sendToHost(h, e, p); err != nil {